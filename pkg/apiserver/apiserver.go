@@ -25,6 +25,7 @@ import (
 	cors "github.com/rs/cors/wrapper/gin"
 	"go.uber.org/fx"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/clusterinfo"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/configuration"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/debugapi"
@@ -34,8 +35,13 @@ import (
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/metrics"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/profiling"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/queryeditor"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/apitoken"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/code"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/code/codeauth"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/ldap"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/ldap/ldapauth"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/saml"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/saml/samlauth"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/sqlauth"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/sso"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/sso/ssoauth"
@@ -136,11 +142,18 @@ func (s *Service) Start(ctx context.Context) error {
 			// __APP_NAME__.NewService,
 			// NOTE: Don't remove above comment line, it is a placeholder for code generator
 		),
+		// Must be before all modules and routers registering routes
+		audit.Module,
 		codeauth.Module,
 		sqlauth.Module,
 		ssoauth.Module,
+		ldapauth.Module,
+		samlauth.Module,
 		code.Module,
 		sso.Module,
+		ldap.Module,
+		saml.Module,
+		apitoken.Module,
 		profiling.Module,
 		statement.Module,
 		slowquery.Module,
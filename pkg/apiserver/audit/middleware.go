@@ -0,0 +1,283 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser"
+	_ "github.com/pingcap/parser/test_driver" // required by the parser to build value expressions
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+)
+
+const (
+	// The key that attached audit targets in the gin Context.
+	targetsKey = "audit_targets"
+
+	maxPayloadLength = 4096
+	redactedValue    = "******"
+)
+
+// Fields whose name contains any of these words are redacted in the recorded payload.
+var sensitiveFieldWords = []string{"password", "secret", "token", "credential"}
+
+// Fields containing SQL statements are recorded as statement types and normalized statements, i.e. literals like
+// `IDENTIFIED BY '...'` are replaced by `?`, see summarizeStatements.
+var statementFields = map[string]struct{}{
+	"statement":  {},
+	"statements": {},
+	"query":      {},
+}
+
+// AddTargets annotates target instances or resources of the current request in the audit log.
+func AddTargets(c *gin.Context, targets ...string) {
+	var existing []string
+	if v, ok := c.Get(targetsKey); ok {
+		existing = v.([]string)
+	}
+	c.Set(targetsKey, append(existing, targets...))
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+// MWAudit creates a middleware that records all mutating requests in the audit log. It must be used before
+// registering any routes.
+func (s *Service) MWAudit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isMutating(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		// Only the beginning of the body is buffered for the payload summary, and the handler still reads the whole
+		// body, so that limits applied by handlers, e.g. http.MaxBytesReader, are not bypassed.
+		var body []byte
+		counter := &countingReader{}
+		isMultipart := strings.HasPrefix(c.ContentType(), "multipart/")
+		if c.Request.Body != nil {
+			counter.r = c.Request.Body
+			var rest io.Reader = counter
+			if !isMultipart {
+				body, _ = ioutil.ReadAll(io.LimitReader(counter, maxPayloadLength+1))
+				rest = io.MultiReader(bytes.NewReader(body), counter)
+			}
+			c.Request.Body = readCloser{Reader: rest, Closer: c.Request.Body}
+		}
+
+		c.Next()
+
+		payload := ""
+		size := c.Request.ContentLength
+		if size < 0 {
+			size = counter.n
+		}
+		switch {
+		case isMultipart:
+			payload = fmt.Sprintf("(multipart payload, %d bytes)", size)
+		case len(body) > maxPayloadLength:
+			payload = fmt.Sprintf("(payload too large to record, %d bytes)", size)
+		default:
+			payload = summarizePayload(body)
+		}
+
+		rec := &LogModel{
+			CreatedAt:  time.Now(),
+			Method:     c.Request.Method,
+			Route:      c.FullPath(),
+			Path:       truncate(c.Request.URL.Path, 512),
+			Payload:    payload,
+			StatusCode: c.Writer.Status(),
+			Result:     ResultSuccess,
+		}
+		if u := utils.GetSession(c); u != nil {
+			rec.Actor = u.DisplayName
+			rec.AuthType = u.AuthFrom
+		}
+		if v, ok := c.Get(targetsKey); ok {
+			rec.Targets = strings.Join(v.([]string), ",")
+		}
+		if len(c.Errors) > 0 {
+			rec.Error = truncate(c.Errors.Last().Error(), maxPayloadLength)
+			// Keep consistent with the status code written by MWHandleErrors.
+			if rec.StatusCode == http.StatusOK {
+				rec.StatusCode = http.StatusInternalServerError
+			}
+		}
+		if rec.StatusCode >= http.StatusBadRequest {
+			rec.Result = ResultFailure
+		}
+		if err := s.params.LocalStore.Create(rec).Error; err != nil {
+			log.Warn("Failed to save audit log", zap.String("route", rec.Route), zap.Error(err))
+		}
+	}
+}
+
+// recordLockout records a sign in lockout as a separate event, in addition to the failed sign in request.
+func (s *Service) recordLockout(c *gin.Context, kind user.LockKind, value string, until time.Time) {
+	rec := &LogModel{
+		CreatedAt:  time.Now(),
+		Method:     MethodEvent,
+		Route:      EventSignInLocked,
+		Path:       truncate(c.Request.URL.Path, 512),
+		Targets:    fmt.Sprintf("%s:%s", kind, value),
+		StatusCode: http.StatusTooManyRequests,
+		Result:     ResultFailure,
+		Error:      fmt.Sprintf("Sign in is locked until %s", until.Format(time.RFC3339)),
+	}
+	if err := s.params.LocalStore.Create(rec).Error; err != nil {
+		log.Warn("Failed to save audit log", zap.String("route", rec.Route), zap.Error(err))
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func truncate(s string, maxLength int) string {
+	if len(s) <= maxLength {
+		return s
+	}
+	return s[:maxLength] + "...(truncated)"
+}
+
+// summarizePayload redacts sensitive fields and literals of statements in the JSON payload. Payloads that are not JSON are
+// not recorded to avoid leaking sensitive information.
+func summarizePayload(body []byte) string {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return ""
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return fmt.Sprintf("(non-JSON payload, %d bytes)", len(body))
+	}
+	b, err := json.Marshal(redact(v))
+	if err != nil {
+		return fmt.Sprintf("(payload, %d bytes)", len(body))
+	}
+	return truncate(string(b), maxPayloadLength)
+}
+
+func isSensitiveField(name string) bool {
+	name = strings.ToLower(name)
+	for _, w := range sensitiveFieldWords {
+		if strings.Contains(name, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func redact(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			if isSensitiveField(k) {
+				val[k] = redactedValue
+			} else if _, ok := statementFields[strings.ToLower(k)]; ok {
+				val[k] = summarizeStatementsValue(item)
+			} else {
+				val[k] = redact(item)
+			}
+		}
+		return val
+	case []interface{}:
+		for i, item := range val {
+			val[i] = redact(item)
+		}
+		return val
+	default:
+		return v
+	}
+}
+
+// StatementSummary describes a recorded statement without its literal values.
+type StatementSummary struct {
+	// The kind of the statement, e.g. `Select`, `Delete`, `DropTable`, or `Unknown` if it can not be parsed.
+	Type       string `json:"type"`
+	Normalized string `json:"normalized"`
+	Digest     string `json:"digest"`
+}
+
+// summarizeStatements parses the SQL into statements, and summarizes each of them.
+func summarizeStatements(sql string) []StatementSummary {
+	stmts, _, err := parser.New().Parse(sql, "", "")
+	if err != nil || len(stmts) == 0 {
+		normalized, digest := parser.NormalizeDigest(sql)
+		return []StatementSummary{{Type: "Unknown", Normalized: normalized, Digest: digest}}
+	}
+	result := make([]StatementSummary, 0, len(stmts))
+	for _, stmt := range stmts {
+		normalized, digest := parser.NormalizeDigest(strings.TrimRight(stmt.Text(), "; \t\r\n"))
+		result = append(result, StatementSummary{
+			Type:       strings.TrimSuffix(reflect.TypeOf(stmt).Elem().Name(), "Stmt"),
+			Normalized: normalized,
+			Digest:     digest,
+		})
+	}
+	return result
+}
+
+// summarizeStatementsValue summarizes the statement field. Values that are not strings are hashed, since they can
+// not be normalized.
+func summarizeStatementsValue(v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		return hashValue(v)
+	}
+	return summarizeStatements(s)
+}
+
+func hashValue(v interface{}) string {
+	s, ok := v.(string)
+	if !ok {
+		b, _ := json.Marshal(v)
+		s = string(b)
+	}
+	sum := sha256.Sum256([]byte(s))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
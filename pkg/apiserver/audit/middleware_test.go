@@ -0,0 +1,201 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore/dbstoretest"
+)
+
+func TestT(t *testing.T) {
+	CustomVerboseFlag = true
+	TestingT(t)
+}
+
+var _ = Suite(&testMiddlewareSuite{})
+
+type testMiddlewareSuite struct{}
+
+func (t *testMiddlewareSuite) SetUpSuite(c *C) {
+	gin.SetMode(gin.TestMode)
+}
+
+func (t *testMiddlewareSuite) newService(c *C) *Service {
+	return &Service{params: ServiceParams{LocalStore: dbstoretest.NewDB(c, autoMigrate)}}
+}
+
+func (t *testMiddlewareSuite) newEngine(s *Service) *gin.Engine {
+	r := gin.New()
+	r.Use(s.MWAudit())
+	withSession := func(c *gin.Context) {
+		c.Set(utils.SessionUserKey, &utils.SessionUser{DisplayName: "alice", AuthFrom: 3})
+	}
+	r.GET("/api/topology/tidb", withSession, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.DELETE("/api/topology/tidb/:address", withSession, func(c *gin.Context) {
+		AddTargets(c, c.Param("address"))
+		c.Status(http.StatusOK)
+	})
+	r.POST("/api/user/login", func(c *gin.Context) {
+		b, _ := ioutil.ReadAll(c.Request.Body)
+		// The body must still be readable by handlers
+		if !strings.Contains(string(b), "secret") {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		_ = c.Error(utils.ErrUnauthorized.New("bad password"))
+		c.Status(http.StatusUnauthorized)
+	})
+	r.POST("/api/upload", withSession, func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, 2*maxPayloadLength)
+		b, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.Status(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.String(http.StatusOK, "%d", len(b))
+	})
+	return r
+}
+
+func serve(r *gin.Engine, method string, url string, body string) int {
+	return serveWithContentType(r, method, url, body, "application/json")
+}
+
+func serveWithContentType(r *gin.Engine, method string, url string, body string, contentType string) int {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func (t *testMiddlewareSuite) Test_MWAudit(c *C) {
+	s := t.newService(c)
+	r := t.newEngine(s)
+
+	c.Assert(serve(r, http.MethodGet, "/api/topology/tidb", ""), Equals, http.StatusOK)
+	c.Assert(serve(r, http.MethodDelete, "/api/topology/tidb/10.0.0.1:4000", ""), Equals, http.StatusOK)
+	c.Assert(serve(r, http.MethodPost, "/api/user/login", `{"username":"root","password":"secret"}`), Equals, http.StatusUnauthorized)
+
+	logs, total, err := s.query(&QueryRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(total, Equals, int64(2))
+
+	login := logs[0]
+	c.Assert(login.Route, Equals, "/api/user/login")
+	c.Assert(login.Actor, Equals, "")
+	c.Assert(login.Result, Equals, ResultFailure)
+	c.Assert(login.StatusCode, Equals, http.StatusUnauthorized)
+	c.Assert(strings.Contains(login.Payload, "secret"), IsFalse)
+	c.Assert(strings.Contains(login.Payload, `"username":"root"`), IsTrue)
+	c.Assert(strings.Contains(login.Error, "bad password"), IsTrue)
+
+	del := logs[1]
+	c.Assert(del.Method, Equals, http.MethodDelete)
+	c.Assert(del.Route, Equals, "/api/topology/tidb/:address")
+	c.Assert(del.Actor, Equals, "alice")
+	c.Assert(del.AuthType, Equals, utils.AuthType(3))
+	c.Assert(del.Targets, Equals, "10.0.0.1:4000")
+	c.Assert(del.Result, Equals, ResultSuccess)
+
+	logs, total, err = s.query(&QueryRequest{Actor: "alice", Route: "/api/topology", Page: 1, PageSize: 10})
+	c.Assert(err, IsNil)
+	c.Assert(total, Equals, int64(1))
+	c.Assert(logs, HasLen, 1)
+
+	csv := generateCSV(logs)
+	c.Assert(csv, HasLen, 2)
+	c.Assert(csv[1][2], Equals, "alice")
+}
+
+func (t *testMiddlewareSuite) Test_MWAuditLargeBody(c *C) {
+	s := t.newService(c)
+	r := t.newEngine(s)
+
+	// Handlers still read the whole body, and their limits still apply
+	body := `{"statements":"` + strings.Repeat("a", maxPayloadLength) + `"}`
+	c.Assert(serve(r, http.MethodPost, "/api/upload", body), Equals, http.StatusOK)
+	c.Assert(serve(r, http.MethodPost, "/api/upload", strings.Repeat("a", 3*maxPayloadLength)), Equals,
+		http.StatusRequestEntityTooLarge)
+	c.Assert(serveWithContentType(r, http.MethodPost, "/api/upload", "--b\r\n", "multipart/form-data; boundary=b"),
+		Equals, http.StatusOK)
+
+	logs, total, err := s.query(&QueryRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(total, Equals, int64(3))
+	c.Assert(logs[0].Payload, Equals, "(multipart payload, 5 bytes)")
+	c.Assert(logs[1].Payload, Equals, fmt.Sprintf("(payload too large to record, %d bytes)", 3*maxPayloadLength))
+	c.Assert(logs[1].Result, Equals, ResultFailure)
+	c.Assert(logs[2].Payload, Equals, fmt.Sprintf("(payload too large to record, %d bytes)", len(body)))
+}
+
+func (t *testMiddlewareSuite) Test_recordLockout(c *C) {
+	s := t.newService(c)
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	ctx.Request, _ = http.NewRequest(http.MethodPost, "/api/user/login", nil)
+	s.recordLockout(ctx, user.LockKindUser, "root", time.Now().Add(time.Minute))
+
+	logs, total, err := s.query(&QueryRequest{Route: EventSignInLocked})
+	c.Assert(err, IsNil)
+	c.Assert(total, Equals, int64(1))
+	c.Assert(logs[0].Method, Equals, MethodEvent)
+	c.Assert(logs[0].Targets, Equals, "user:root")
+	c.Assert(logs[0].Result, Equals, ResultFailure)
+}
+
+func (t *testMiddlewareSuite) Test_summarizePayload(c *C) {
+	c.Assert(summarizePayload(nil), Equals, "")
+	c.Assert(summarizePayload([]byte("not json")), Equals, "(non-JSON payload, 8 bytes)")
+	c.Assert(
+		summarizePayload([]byte(`{"config":{"bind_password":"x","url":"ldap://a"},"items":[{"api_token":"y"}]}`)),
+		Equals,
+		`{"config":{"bind_password":"******","url":"ldap://a"},"items":[{"api_token":"******"}]}`)
+	payload := summarizePayload([]byte(`{"statements":"create user u identified by 'p'; DELETE FROM t WHERE a = 'secret'","max_rows":10}`))
+	c.Assert(strings.Contains(payload, "'p'"), IsFalse)
+	c.Assert(strings.Contains(payload, "secret"), IsFalse)
+	var v struct {
+		Statements []StatementSummary `json:"statements"`
+	}
+	c.Assert(json.Unmarshal([]byte(payload), &v), IsNil)
+	c.Assert(v.Statements, HasLen, 2)
+	c.Assert(v.Statements[0].Type, Equals, "CreateUser")
+	c.Assert(v.Statements[0].Normalized, Equals, "create user `u` identified by ?")
+	c.Assert(v.Statements[1].Type, Equals, "Delete")
+	c.Assert(v.Statements[1].Normalized, Equals, "delete from `t` where `a` = ?")
+	// Statements with different literals share the same digest
+	c.Assert(v.Statements[1].Digest, Equals, summarizeStatements("delete from t where a = 'other'")[0].Digest)
+
+	unknown := summarizeStatements("DELET FROM t WHERE a = 'secret'")
+	c.Assert(unknown, HasLen, 1)
+	c.Assert(unknown[0].Type, Equals, "Unknown")
+	c.Assert(strings.Contains(unknown[0].Normalized, "secret"), IsFalse)
+
+	c.Assert(strings.HasPrefix(hashValue("x"), "sha256:"), IsTrue)
+	c.Assert(hashValue("x"), Not(Equals), hashValue("y"))
+}
@@ -0,0 +1,62 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"time"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+type ActionResult string
+
+const (
+	ResultSuccess ActionResult = "success"
+	ResultFailure ActionResult = "failure"
+)
+
+const (
+	// Audit logs not generated by a request use this method, with the event name as the route.
+	MethodEvent = "EVENT"
+
+	EventSignInLocked = "signin.locked"
+)
+
+type LogModel struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	// The display name of the session. Empty for requests that are not signed in, e.g. sign in requests.
+	Actor    string         `gorm:"size:256;index" json:"actor"`
+	AuthType utils.AuthType `json:"auth_type"`
+	Method   string         `gorm:"size:8" json:"method"`
+	// The route of the request, e.g. `/dashboard/api/topology/tidb/:address`.
+	Route string `gorm:"size:256;index" json:"route"`
+	Path  string `gorm:"size:512" json:"path"`
+	// The request body with sensitive fields redacted. It is truncated when it is too large.
+	Payload string `gorm:"type:text" json:"payload"`
+	// Comma separated target instances or resources of the action, annotated by handlers.
+	Targets    string       `gorm:"type:text" json:"targets"`
+	StatusCode int          `json:"status_code"`
+	Result     ActionResult `gorm:"size:16;index" json:"result"`
+	Error      string       `gorm:"type:text" json:"error"`
+}
+
+func (LogModel) TableName() string {
+	return "audit_logs"
+}
+
+func autoMigrate(db *dbstore.DB) error {
+	return db.AutoMigrate(&LogModel{})
+}
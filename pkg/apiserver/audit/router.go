@@ -0,0 +1,193 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+)
+
+const downloadTokenNamespace = "audit/download"
+
+func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
+	endpoint := r.Group("/audit")
+	endpoint.GET("/download", s.downloadHandler)
+	endpoint.Use(auth.MWAuthRequired())
+	endpoint.GET("/list", auth.MWRequirePermission(utils.PermAuditView), s.listHandler)
+	endpoint.POST("/download/token", auth.MWRequirePermission(utils.PermAuditView), s.downloadTokenHandler)
+	endpoint.GET("/config", s.getConfigHandler)
+	// Shortening the retention removes audit logs, so that it is a settings change rather than a read.
+	endpoint.PUT("/config", auth.MWRequirePermission(utils.PermSettingsEdit), s.setConfigHandler)
+}
+
+type ListResponse struct {
+	Items []LogModel `json:"items"`
+	Total int64      `json:"total"`
+}
+
+// @ID auditList
+// @Summary List audit logs
+// @Param q query QueryRequest true "Query"
+// @Success 200 {object} ListResponse
+// @Router /audit/list [get]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+func (s *Service) listHandler(c *gin.Context) {
+	var req QueryRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	if req.PageSize <= 0 {
+		req.PageSize = defaultPageSize
+	}
+	if req.PageSize > maxPageSize {
+		req.PageSize = maxPageSize
+	}
+	logs, total, err := s.query(&req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, ListResponse{
+		Items: logs,
+		Total: total,
+	})
+}
+
+var csvHeader = []string{
+	"id", "created_at", "actor", "auth_type", "method", "route", "path", "payload", "targets", "status_code", "result", "error",
+}
+
+func generateCSV(logs []LogModel) [][]string {
+	data := make([][]string, 0, len(logs)+1)
+	data = append(data, csvHeader)
+	for _, l := range logs {
+		data = append(data, []string{
+			strconv.FormatUint(uint64(l.ID), 10),
+			l.CreatedAt.Format(time.RFC3339),
+			l.Actor,
+			strconv.Itoa(int(l.AuthType)),
+			l.Method,
+			l.Route,
+			l.Path,
+			l.Payload,
+			l.Targets,
+			strconv.Itoa(l.StatusCode),
+			string(l.Result),
+			l.Error,
+		})
+	}
+	return data
+}
+
+// @ID auditGetDownloadToken
+// @Summary Generate a download token for exporting audit logs as CSV
+// @Param request body QueryRequest true "Query, pagination is ignored"
+// @Success 200 {string} string "xxx"
+// @Router /audit/download/token [post]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+func (s *Service) downloadTokenHandler(c *gin.Context) {
+	var req QueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	req.Page = 0
+	req.PageSize = 0
+	logs, _, err := s.query(&req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	token, err := utils.ExportCSV(generateCSV(logs),
+		fmt.Sprintf("audit_logs_%d_%d_*.csv", req.BeginTime, req.EndTime),
+		downloadTokenNamespace)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.String(http.StatusOK, token)
+}
+
+// @ID auditDownload
+// @Summary Download audit logs as CSV
+// @Produce text/csv
+// @Param token query string true "download token"
+// @Router /audit/download [get]
+// @Failure 400 {object} utils.APIError
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+func (s *Service) downloadHandler(c *gin.Context) {
+	token := c.Query("token")
+	utils.DownloadByToken(token, downloadTokenNamespace, c)
+}
+
+// @ID auditGetConfig
+// @Summary Get audit log config
+// @Success 200 {object} config.AuditConfig
+// @Router /audit/config [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 500 {object} utils.APIError
+func (s *Service) getConfigHandler(c *gin.Context) {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, dc.Audit)
+}
+
+// @ID auditSetConfig
+// @Summary Set audit log config
+// @Param request body config.AuditConfig true "Request body"
+// @Success 200 {object} config.AuditConfig
+// @Router /audit/config [put]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 500 {object} utils.APIError "Internal error"
+func (s *Service) setConfigHandler(c *gin.Context) {
+	var req config.AuditConfig
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	var opt config.DynamicConfigOption = func(dc *config.DynamicConfig) {
+		dc.Audit = req
+	}
+	if err := s.params.ConfigManager.Modify(opt); err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, config.ErrVerificationFailed) {
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap/log"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+const (
+	cleanupInterval = time.Hour
+)
+
+type ServiceParams struct {
+	fx.In
+	LocalStore    *dbstore.DB
+	ConfigManager *config.DynamicConfigManager
+}
+
+type Service struct {
+	params       ServiceParams
+	lifecycleCtx context.Context
+}
+
+func newService(p ServiceParams, lc fx.Lifecycle) (*Service, error) {
+	if err := autoMigrate(p.LocalStore); err != nil {
+		return nil, err
+	}
+	s := &Service{params: p}
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			s.lifecycleCtx = ctx
+			go s.cleanupLoop()
+			return nil
+		},
+	})
+	return s, nil
+}
+
+func registerMiddleware(r *gin.RouterGroup, s *Service) {
+	r.Use(s.MWAudit())
+}
+
+func registerLockoutListener(auth *user.AuthService, s *Service) {
+	auth.RegisterLockoutListener(s.recordLockout)
+}
+
+// Module must be placed before modules registering routes, so that the audit middleware applies to all routes.
+var Module = fx.Options(
+	fx.Provide(newService),
+	fx.Invoke(registerMiddleware, registerLockoutListener, registerRouter),
+)
+
+func (s *Service) cleanupLoop() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+	for {
+		s.cleanup()
+		select {
+		case <-s.lifecycleCtx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) cleanup() {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		// Dynamic config may be not ready yet, try again in the next round.
+		return
+	}
+	expireBefore := time.Now().AddDate(0, 0, -dc.Audit.RetentionDays)
+	result := s.params.LocalStore.
+		Where("created_at < ?", expireBefore).
+		Delete(&LogModel{})
+	if result.Error != nil {
+		log.Warn("Failed to remove expired audit logs", zap.Error(result.Error))
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Info("Removed expired audit logs", zap.Int64("count", result.RowsAffected))
+	}
+}
+
+type QueryRequest struct {
+	BeginTime int          `json:"begin_time" form:"begin_time"` // Unix timestamp in seconds
+	EndTime   int          `json:"end_time" form:"end_time"`     // Unix timestamp in seconds
+	Actor     string       `json:"actor" form:"actor"`
+	Route     string       `json:"route" form:"route"` // Prefix match
+	Result    ActionResult `json:"result" form:"result"`
+	Page      int          `json:"page" form:"page"` // Starts from 1
+	PageSize  int          `json:"page_size" form:"page_size"`
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+func (s *Service) query(req *QueryRequest) ([]LogModel, int64, error) {
+	db := s.params.LocalStore.Model(&LogModel{})
+	if req.BeginTime > 0 {
+		db = db.Where("created_at >= ?", time.Unix(int64(req.BeginTime), 0))
+	}
+	if req.EndTime > 0 {
+		db = db.Where("created_at <= ?", time.Unix(int64(req.EndTime), 0))
+	}
+	if req.Actor != "" {
+		db = db.Where("actor = ?", req.Actor)
+	}
+	if req.Route != "" {
+		db = db.Where("route LIKE ?", req.Route+"%")
+	}
+	if req.Result != "" {
+		db = db.Where("result = ?", req.Result)
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var logs []LogModel
+	db = db.Order("created_at DESC, id DESC")
+	if req.PageSize > 0 {
+		page := req.Page
+		if page < 1 {
+			page = 1
+		}
+		db = db.Offset((page - 1) * req.PageSize).Limit(req.PageSize)
+	}
+	if err := db.Find(&logs).Error; err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
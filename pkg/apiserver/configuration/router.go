@@ -18,6 +18,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 )
@@ -28,7 +29,7 @@ func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint.Use(utils.MWConnectTiDB(s.params.TiDBClient))
 	endpoint.Use(utils.MWForbidByExperimentalFlag(s.params.Config.EnableExperimental))
 	endpoint.GET("/all", s.getHandler)
-	endpoint.POST("/edit", auth.MWRequireWritePriv(), s.editHandler)
+	endpoint.POST("/edit", auth.MWRequirePermission(utils.PermConfigEdit), s.editHandler)
 }
 
 // @ID configurationGetAll
@@ -77,7 +78,8 @@ func (s *Service) editHandler(c *gin.Context) {
 	}
 
 	db := utils.GetTiDBConnection(c)
-	warnings, err := s.editConfig(db, req.Kind, req.ID, req.NewValue)
+	targets, warnings, err := s.editConfig(db, req.Kind, req.ID, req.NewValue)
+	audit.AddTargets(c, targets...)
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -319,30 +319,34 @@ func (s *Service) getAllConfigItems(db *gorm.DB) (*AllConfigItems, error) {
 	}, nil
 }
 
-func (s *Service) editConfig(db *gorm.DB, kind ItemKind, id string, newValue interface{}) ([]*utils.APIError, error) {
+// editConfig edits the configuration and returns instances that the edit is applied to.
+func (s *Service) editConfig(db *gorm.DB, kind ItemKind, id string, newValue interface{}) ([]string, []*utils.APIError, error) {
 	if !isConfigItemEditable(kind, id) {
-		return nil, ErrNotEditable.New("Configuration `%s` is not editable", id)
+		return nil, nil, ErrNotEditable.New("Configuration `%s` is not editable", id)
 	}
 	body := make(map[string]interface{})
 	body[id] = newValue
 	bodyJSON, err := json.Marshal(&body)
 	if err != nil {
-		return nil, ErrEditFailed.WrapWithNoMessage(err)
+		return nil, nil, ErrEditFailed.WrapWithNoMessage(err)
 	}
 
 	switch kind {
 	case ItemKindPDConfig:
 		_, err := s.params.PDClient.SendPostRequest("/config", bytes.NewBuffer(bodyJSON))
 		if err != nil {
-			return nil, ErrEditFailed.WrapWithNoMessage(err)
+			return nil, nil, ErrEditFailed.WrapWithNoMessage(err)
 		}
+		return []string{"pd"}, nil, nil
 	case ItemKindTiKVConfig:
 		tikvInfo, _, err := topology.FetchStoreTopology(s.params.PDClient)
 		if err != nil {
-			return nil, ErrEditFailed.WrapWithNoMessage(ErrListTopologyFailed.WrapWithNoMessage(err))
+			return nil, nil, ErrEditFailed.WrapWithNoMessage(ErrListTopologyFailed.WrapWithNoMessage(err))
 		}
 		failures := make([]error, 0)
+		targets := make([]string, 0, len(tikvInfo))
 		for _, kvStore := range tikvInfo {
+			targets = append(targets, fmt.Sprintf("tikv(%s:%d)", kvStore.IP, kvStore.Port))
 			// TODO: What about tombstone stores?
 			_, err := s.params.TiKVClient.SendPostRequest(kvStore.IP, int(kvStore.StatusPort), "/config", bytes.NewBuffer(bodyJSON))
 			if err != nil {
@@ -351,23 +355,22 @@ func (s *Service) editConfig(db *gorm.DB, kind ItemKind, id string, newValue int
 		}
 		if len(failures) == len(tikvInfo) {
 			if len(failures) > 0 {
-				return nil, failures[0]
+				return targets, nil, failures[0]
 			}
-			return nil, nil
+			return targets, nil, nil
 		}
 		warnings := make([]*utils.APIError, 0)
 		for _, err := range failures {
 			warnings = append(warnings, utils.NewAPIError(err))
 		}
-		return warnings, nil
+		return targets, warnings, nil
 	case ItemKindTiDBVariable:
 		// We have checked the correctness of id, so no need to worry about injections
 		if err := db.Exec(fmt.Sprintf("SET GLOBAL %s = ?", id), newValue).Error; err != nil {
-			return nil, ErrEditFailed.WrapWithNoMessage(err)
+			return nil, nil, ErrEditFailed.WrapWithNoMessage(err)
 		}
+		return []string{"tidb"}, nil, nil
 	default:
-		return nil, ErrEditFailed.New("Edit failed, not implemented")
+		return nil, nil, ErrEditFailed.New("Edit failed, not implemented")
 	}
-
-	return nil, nil
 }
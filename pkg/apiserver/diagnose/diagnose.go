@@ -64,6 +64,7 @@ func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 		s.reportsHandler)
 	endpoint.POST("/reports",
 		auth.MWAuthRequired(),
+		auth.MWRequirePermission(utils.PermDiagnoseGenerate),
 		utils.MWConnectTiDB(s.tidbClient),
 		s.genReportHandler)
 	endpoint.GET("/reports/:id/detail", s.reportHTMLHandler)
@@ -74,6 +75,7 @@ func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 
 	endpoint.POST("/diagnosis",
 		auth.MWAuthRequired(),
+		auth.MWRequirePermission(utils.PermDiagnoseGenerate),
 		utils.MWConnectTiDB((s.tidbClient)),
 		s.genDiagnosisHandler)
 }
@@ -81,6 +81,8 @@ type WhoAmIResponse struct {
 	DisplayName string `json:"display_name"`
 	IsShareable bool   `json:"is_shareable"`
 	IsWriteable bool   `json:"is_writeable"`
+	// Permissions granted to current session, see `utils.Permission`.
+	Permissions []utils.Permission `json:"permissions"`
 }
 
 // @ID infoWhoami
@@ -93,8 +95,9 @@ func (s *Service) whoamiHandler(c *gin.Context) {
 	sessionUser := utils.GetSession(c)
 	resp := WhoAmIResponse{
 		DisplayName: sessionUser.DisplayName,
-		IsShareable: sessionUser.IsShareable,
-		IsWriteable: sessionUser.IsWriteable,
+		IsShareable: sessionUser.HasPermission(utils.PermSessionShare),
+		IsWriteable: sessionUser.HasWritePermission(),
+		Permissions: sessionUser.Permissions,
 	}
 	c.JSON(http.StatusOK, resp)
 }
@@ -82,7 +82,7 @@ func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 		endpoint.GET("/download", s.DownloadLogs)
 		endpoint.Use(auth.MWAuthRequired())
 		{
-			endpoint.GET("/download/acquire_token", s.GetDownloadToken)
+			endpoint.GET("/download/acquire_token", auth.MWRequirePermission(utils.PermLogSearchDownload), s.GetDownloadToken)
 			endpoint.PUT("/taskgroup", s.CreateTaskGroup)
 			endpoint.GET("/taskgroups", s.GetAllTaskGroups)
 			endpoint.GET("/taskgroups/:id", s.GetTaskGroup)
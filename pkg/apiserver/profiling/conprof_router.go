@@ -49,7 +49,7 @@ func RegisterConprofRouter(r *gin.RouterGroup, auth *user.AuthService, s *Servic
 	conprofEndpoint := r.Group("/continuous_profiling")
 
 	conprofEndpoint.GET("/config", auth.MWAuthRequired(), s.reverseProxy("/config"), s.conprofConfig)
-	conprofEndpoint.POST("/config", auth.MWAuthRequired(), auth.MWRequireWritePriv(), s.reverseProxy("/config"), s.updateConprofConfig)
+	conprofEndpoint.POST("/config", auth.MWAuthRequired(), auth.MWRequirePermission(utils.PermSettingsEdit), s.reverseProxy("/config"), s.updateConprofConfig)
 	conprofEndpoint.GET("/components", auth.MWAuthRequired(), s.reverseProxy("/continuous_profiling/components"), s.conprofComponents)
 	conprofEndpoint.GET("/estimate_size", auth.MWAuthRequired(), s.reverseProxy("/continuous_profiling/estimate_size"), s.estimateSize)
 	conprofEndpoint.GET("/group_profiles", auth.MWAuthRequired(), s.reverseProxy("/continuous_profiling/group_profiles"), s.conprofGroupProfiles)
@@ -24,6 +24,7 @@ import (
 	"github.com/pingcap/log"
 	"go.uber.org/zap"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/pkg/config"
@@ -33,10 +34,10 @@ import (
 func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint := r.Group("/profiling")
 	endpoint.GET("/group/list", auth.MWAuthRequired(), s.getGroupList)
-	endpoint.POST("/group/start", auth.MWAuthRequired(), s.handleStartGroup)
+	endpoint.POST("/group/start", auth.MWAuthRequired(), auth.MWRequirePermission(utils.PermProfilingStart), s.handleStartGroup)
 	endpoint.GET("/group/detail/:groupId", auth.MWAuthRequired(), s.getGroupDetail)
-	endpoint.POST("/group/cancel/:groupId", auth.MWAuthRequired(), s.handleCancelGroup)
-	endpoint.DELETE("/group/delete/:groupId", auth.MWAuthRequired(), s.deleteGroup)
+	endpoint.POST("/group/cancel/:groupId", auth.MWAuthRequired(), auth.MWRequirePermission(utils.PermProfilingStart), s.handleCancelGroup)
+	endpoint.DELETE("/group/delete/:groupId", auth.MWAuthRequired(), auth.MWRequirePermission(utils.PermProfilingStart), s.deleteGroup)
 
 	endpoint.GET("/action_token", auth.MWAuthRequired(), s.getActionToken)
 	endpoint.GET("/group/download", s.downloadGroup)
@@ -44,7 +45,7 @@ func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint.GET("/single/view", s.viewSingle)
 
 	endpoint.GET("/config", auth.MWAuthRequired(), s.getDynamicConfig)
-	endpoint.PUT("/config", auth.MWAuthRequired(), auth.MWRequireWritePriv(), s.setDynamicConfig)
+	endpoint.PUT("/config", auth.MWAuthRequired(), auth.MWRequirePermission(utils.PermSettingsEdit), s.setDynamicConfig)
 }
 
 // @ID startProfiling
@@ -67,6 +68,9 @@ func (s *Service) handleStartGroup(c *gin.Context) {
 		utils.MakeInvalidRequestErrorWithMessage(c, "Expect at least 1 target")
 		return
 	}
+	for _, target := range req.Targets {
+		audit.AddTargets(c, target.String())
+	}
 
 	if req.DurationSecs == 0 {
 		req.DurationSecs = config.DefaultProfilingAutoCollectionDurationSecs
@@ -0,0 +1,303 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+)
+
+const (
+	defaultPageSize = 1000
+	maxPageSize     = 10000
+
+	// A page is ended early when the size of its values exceeds this limit, so that wide rows do not blow up memory.
+	maxPageBytes = 8 * 1024 * 1024
+
+	// Hard ceilings of a single result set. The cursor is closed after reaching any of them.
+	maxCursorRows  = 1000000
+	maxCursorBytes = 512 * 1024 * 1024
+
+	// Each session can only keep a limited number of open cursors, since each of them holds a TiDB connection.
+	maxCursorsPerOwner = 4
+
+	cursorIdleTimeout   = 5 * time.Minute
+	cursorCheckInterval = 30 * time.Second
+)
+
+type cursor struct {
+	id      string
+	owner   string
+	columns []ColumnInfo
+
+	// mu guards all fields below. It is held during fetching, so that pages of the same cursor are read in order.
+	mu           sync.Mutex
+	db           *sql.DB
+	conn         *sql.Conn
+	rows         *sql.Rows
+	cancel       context.CancelFunc
+	scanArgs     []interface{}
+	values       []sql.RawBytes
+	fetchedRows  int
+	fetchedBytes int
+	lastActiveAt time.Time
+	closed       bool
+}
+
+type CursorPage struct {
+	ErrorMsg    string          `json:"error_msg"`
+	ResultID    string          `json:"result_id"`
+	ColumnNames []string        `json:"column_names"`
+	Columns     []ColumnInfo    `json:"columns"`
+	Rows        [][]interface{} `json:"rows"`
+	// Whether there are more rows to fetch. The cursor is closed automatically when there are no more rows.
+	HasMore bool `json:"has_more"`
+	// Whether the result set is cut off because it exceeds the row or byte ceiling.
+	Truncated   bool  `json:"truncated"`
+	FetchedRows int   `json:"fetched_rows"`
+	ExecutionMs int64 `json:"execution_ms"`
+}
+
+// openCursor runs the statement in a pinned connection of db. The connection is made read-only if readOnly is true.
+// Only a single statement is accepted, since results of other statements would be silently discarded.
+func openCursor(parentCtx context.Context, db *sql.DB, owner string, statements string, readOnly bool) (*cursor, error) {
+	if len(splitStatements(statements)) != 1 {
+		return nil, utils.ErrInvalidRequest.New("Exactly one valid statement is expected")
+	}
+	ctx, cancel := context.WithCancel(parentCtx)
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if readOnly {
+		if err := enforceReadOnlyConn(ctx, conn); err != nil {
+			_ = conn.Close()
+			cancel()
+			return nil, err
+		}
+	}
+	rows, err := conn.QueryContext(ctx, statements)
+	if err != nil {
+		_ = conn.Close()
+		cancel()
+		return nil, err
+	}
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		_ = rows.Close()
+		_ = conn.Close()
+		cancel()
+		return nil, err
+	}
+	columns := make([]ColumnInfo, 0, len(colTypes))
+	for _, ct := range colTypes {
+		nullable, _ := ct.Nullable()
+		columns = append(columns, ColumnInfo{
+			Name:         ct.Name(),
+			DatabaseType: ct.DatabaseTypeName(),
+			Nullable:     nullable,
+		})
+	}
+	values := make([]sql.RawBytes, len(colTypes))
+	scanArgs := make([]interface{}, len(values))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	return &cursor{
+		id:           uuid.New().String(),
+		owner:        owner,
+		columns:      columns,
+		db:           db,
+		conn:         conn,
+		rows:         rows,
+		cancel:       cancel,
+		scanArgs:     scanArgs,
+		values:       values,
+		lastActiveAt: time.Now(),
+	}, nil
+}
+
+// fetch reads at most pageSize rows from the cursor. The cursor is closed when all rows are read, or when any
+// ceiling is reached.
+func (cur *cursor) fetch(pageSize int) (*CursorPage, error) {
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+
+	if cur.closed {
+		return nil, ErrResultNotFound.New("Result %s is closed", cur.id)
+	}
+	cur.lastActiveAt = time.Now()
+
+	page := &CursorPage{
+		ResultID: cur.id,
+		Rows:     make([][]interface{}, 0),
+	}
+	pageBytes := 0
+	drained := false
+	for len(page.Rows) < pageSize && pageBytes < maxPageBytes {
+		if cur.fetchedRows >= maxCursorRows || cur.fetchedBytes >= maxCursorBytes {
+			page.Truncated = true
+			break
+		}
+		if !cur.rows.Next() {
+			drained = true
+			break
+		}
+		if err := cur.rows.Scan(cur.scanArgs...); err != nil {
+			cur.closeLocked()
+			return nil, err
+		}
+		for _, col := range cur.values {
+			pageBytes += len(col)
+		}
+		page.Rows = append(page.Rows, encodeRow(cur.columns, cur.values))
+		cur.fetchedRows++
+	}
+	cur.fetchedBytes += pageBytes
+
+	if page.Truncated {
+		cur.closeLocked()
+	} else if drained {
+		err := cur.rows.Err()
+		cur.closeLocked()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		page.HasMore = true
+	}
+	page.FetchedRows = cur.fetchedRows
+	cur.lastActiveAt = time.Now()
+	return page, nil
+}
+
+// isExpired returns whether the cursor is closed or has been idle for too long.
+func (cur *cursor) isExpired(now time.Time) bool {
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+	return cur.closed || now.Sub(cur.lastActiveAt) > cursorIdleTimeout
+}
+
+func (cur *cursor) close() {
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+	cur.closeLocked()
+}
+
+func (cur *cursor) closeLocked() {
+	if cur.closed {
+		return
+	}
+	cur.closed = true
+	// Cancel first so that closing rows does not need to drain the remaining rows.
+	cur.cancel()
+	_ = cur.rows.Close()
+	_ = cur.conn.Close()
+	_ = cur.db.Close()
+}
+
+type cursorManager struct {
+	mu      sync.Mutex
+	cursors map[string]*cursor
+}
+
+func newCursorManager() *cursorManager {
+	return &cursorManager{cursors: make(map[string]*cursor)}
+}
+
+// add registers the cursor. It fails if the owner has too many open cursors, in which case the caller should close
+// the cursor.
+func (m *cursorManager) add(cur *cursor) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, c := range m.cursors {
+		if c.owner == cur.owner {
+			n++
+		}
+	}
+	if n >= maxCursorsPerOwner {
+		return ErrTooManyResults.New("Too many open results, close some of them and retry")
+	}
+	m.cursors[cur.id] = cur
+	return nil
+}
+
+// get returns the cursor only if it is owned by the owner.
+func (m *cursorManager) get(id string, owner string) (*cursor, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.cursors[id]
+	if !ok || cur.owner != owner {
+		return nil, ErrResultNotFound.New("Result %s does not exist or has expired", id)
+	}
+	return cur, nil
+}
+
+func (m *cursorManager) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.cursors, id)
+}
+
+// gc closes idle cursors and forgets closed cursors.
+func (m *cursorManager) gc(now time.Time) {
+	m.mu.Lock()
+	cursors := make([]*cursor, 0, len(m.cursors))
+	for _, cur := range m.cursors {
+		cursors = append(cursors, cur)
+	}
+	m.mu.Unlock()
+
+	// Cursors are checked without holding the manager lock, since a cursor may be locked by a slow fetch.
+	for _, cur := range cursors {
+		if !cur.isExpired(now) {
+			continue
+		}
+		m.remove(cur.id)
+		cur.close()
+	}
+}
+
+func (m *cursorManager) closeAll() {
+	m.mu.Lock()
+	cursors := m.cursors
+	m.cursors = make(map[string]*cursor)
+	m.mu.Unlock()
+
+	for _, cur := range cursors {
+		cur.close()
+	}
+}
+
+func (m *cursorManager) gcLoop(ctx context.Context) {
+	ticker := time.NewTicker(cursorCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.closeAll()
+			return
+		case <-ticker.C:
+			m.gc(time.Now())
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore/dbstoretest"
+)
+
+func TestT(t *testing.T) {
+	CustomVerboseFlag = true
+	TestingT(t)
+}
+
+var _ = Suite(&testCursorSuite{})
+
+type testCursorSuite struct{}
+
+// openTestDB opens a database containing table `t` with rows 1..n.
+func openTestDB(c *C, n int) *sql.DB {
+	gormDB := dbstoretest.NewDB(c).DB
+	c.Assert(gormDB.Exec("CREATE TABLE IF NOT EXISTS t (id INTEGER, name TEXT)").Error, IsNil)
+	for i := 1; i <= n; i++ {
+		c.Assert(gormDB.Exec("INSERT INTO t VALUES (?, NULL)", i).Error, IsNil)
+	}
+	db, err := gormDB.DB()
+	c.Assert(err, IsNil)
+	return db
+}
+
+func (t *testCursorSuite) Test_openCursorSingleStatement(c *C) {
+	db := openTestDB(c, 1)
+	for _, input := range []string{"", "SELECT id FROM t; SELECT name FROM t", "SELEC id FROM t"} {
+		_, err := openCursor(context.Background(), db, "alice", input, false)
+		c.Assert(errorx.IsOfType(err, utils.ErrInvalidRequest), IsTrue, Commentf("input %q", input))
+	}
+}
+
+func (t *testCursorSuite) Test_fetch(c *C) {
+	db := openTestDB(c, 5)
+	cur, err := openCursor(context.Background(), db, "alice", "SELECT id, name FROM t ORDER BY id", false)
+	c.Assert(err, IsNil)
+	c.Assert(columnNames(cur.columns), DeepEquals, []string{"id", "name"})
+
+	page, err := cur.fetch(2)
+	c.Assert(err, IsNil)
+	c.Assert(page.Rows, DeepEquals, [][]interface{}{{"1", nil}, {"2", nil}})
+	c.Assert(page.HasMore, IsTrue)
+
+	page, err = cur.fetch(2)
+	c.Assert(err, IsNil)
+	c.Assert(page.Rows, HasLen, 2)
+	c.Assert(page.HasMore, IsTrue)
+
+	page, err = cur.fetch(2)
+	c.Assert(err, IsNil)
+	c.Assert(page.Rows, DeepEquals, [][]interface{}{{"5", nil}})
+	c.Assert(page.HasMore, IsFalse)
+	c.Assert(page.FetchedRows, Equals, 5)
+
+	// Drained cursors are closed
+	_, err = cur.fetch(2)
+	c.Assert(errorx.IsOfType(err, ErrResultNotFound), IsTrue)
+}
+
+func (t *testCursorSuite) Test_manager(c *C) {
+	db := openTestDB(c, 1)
+	m := newCursorManager()
+
+	var cursors []*cursor
+	for i := 0; i < maxCursorsPerOwner; i++ {
+		cur, err := openCursor(context.Background(), db, "alice", "SELECT id FROM t", false)
+		c.Assert(err, IsNil)
+		c.Assert(m.add(cur), IsNil)
+		cursors = append(cursors, cur)
+	}
+	cur, err := openCursor(context.Background(), db, "alice", "SELECT id FROM t", false)
+	c.Assert(err, IsNil)
+	c.Assert(errorx.IsOfType(m.add(cur), ErrTooManyResults), IsTrue)
+	_ = cur.rows.Close()
+	_ = cur.conn.Close()
+	cur.cancel()
+
+	// Cursors are only visible to their owners
+	_, err = m.get(cursors[0].id, "alice")
+	c.Assert(err, IsNil)
+	_, err = m.get(cursors[0].id, "bob")
+	c.Assert(errorx.IsOfType(err, ErrResultNotFound), IsTrue)
+
+	// Idle cursors are closed
+	cursors[0].lastActiveAt = time.Now().Add(-2 * cursorIdleTimeout)
+	m.gc(time.Now())
+	_, err = m.get(cursors[0].id, "alice")
+	c.Assert(errorx.IsOfType(err, ErrResultNotFound), IsTrue)
+	c.Assert(cursors[0].closed, IsTrue)
+	c.Assert(m.cursors, HasLen, maxCursorsPerOwner-1)
+
+	m.closeAll()
+	c.Assert(m.cursors, HasLen, 0)
+	c.Assert(cursors[1].closed, IsTrue)
+}
@@ -0,0 +1,217 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+const killTimeout = 10 * time.Second
+
+// execution is a running `/run` request. The statements run in a pinned connection, and a control connection to
+// the same TiDB instance is kept aside, so that the statements can be killed by connection ID.
+type execution struct {
+	id           string
+	owner        string
+	statements   string
+	startedAt    time.Time
+	connectionID int64
+	conn         *sql.Conn
+	cancel       context.CancelFunc
+
+	// mu guards ctrlConn and cancelled.
+	mu        sync.Mutex
+	ctrlConn  *sql.Conn // nil if the control connection is not available
+	cancelled bool
+}
+
+type ExecutionInfo struct {
+	ID           string    `json:"id"`
+	Statements   string    `json:"statements"`
+	ConnectionID int64     `json:"connection_id"`
+	StartedAt    time.Time `json:"started_at"`
+	ElapsedMs    int64     `json:"elapsed_ms"`
+	Cancelled    bool      `json:"cancelled"`
+}
+
+func (e *execution) info(now time.Time) ExecutionInfo {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return ExecutionInfo{
+		ID:           e.id,
+		Statements:   e.statements,
+		ConnectionID: e.connectionID,
+		StartedAt:    e.startedAt,
+		ElapsedMs:    now.Sub(e.startedAt).Milliseconds(),
+		Cancelled:    e.cancelled,
+	}
+}
+
+// prepareExecution pins a connection for running statements and resolves its connection ID. The connection is made
+// read-only if readOnly is true.
+func prepareExecution(ctx context.Context, db *sql.DB, id string, owner string, statements string, readOnly bool) (*execution, error) {
+	if id == "" {
+		id = uuid.New().String()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	var connID int64
+	if err := conn.QueryRowContext(ctx, "SELECT CONNECTION_ID()").Scan(&connID); err != nil {
+		_ = conn.Close()
+		cancel()
+		return nil, err
+	}
+	if readOnly {
+		if err := enforceReadOnlyConn(ctx, conn); err != nil {
+			_ = conn.Close()
+			cancel()
+			return nil, err
+		}
+	}
+	e := &execution{
+		id:           id,
+		owner:        owner,
+		statements:   statements,
+		startedAt:    time.Now(),
+		connectionID: connID,
+		conn:         conn,
+		cancel:       cancel,
+	}
+	e.ctrlConn = openControlConn(ctx, db, connID)
+	return e, nil
+}
+
+// openControlConn opens another connection to the same TiDB instance as the connection `connID`. New connections
+// may be routed to other TiDB instances, and `KILL QUERY` only works in the instance owning the connection, so
+// the instance is verified via the instance-local process list. Returns nil if it cannot be verified.
+func openControlConn(ctx context.Context, db *sql.DB, connID int64) *sql.Conn {
+	const maxAttempts = 3
+	for i := 0; i < maxAttempts; i++ {
+		ctrl, err := db.Conn(ctx)
+		if err != nil {
+			break
+		}
+		var n int
+		err = ctrl.QueryRowContext(ctx, "SELECT COUNT(*) FROM INFORMATION_SCHEMA.PROCESSLIST WHERE ID = ?", connID).Scan(&n)
+		if err == nil && n > 0 {
+			return ctrl
+		}
+		// Do not return the connection to the pool, otherwise it will be picked again.
+		_ = ctrl.Raw(func(driverConn interface{}) error {
+			return driver.ErrBadConn
+		})
+		_ = ctrl.Close()
+	}
+	log.Warn("Cannot open a control connection to the TiDB instance running statements",
+		zap.Int64("connectionID", connID))
+	return nil
+}
+
+// kill stops the running statements. The context is always cancelled, in case the query cannot be killed.
+func (e *execution) kill() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cancelled = true
+	var err error
+	if e.ctrlConn != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), killTimeout)
+		_, err = e.ctrlConn.ExecContext(ctx, fmt.Sprintf("KILL QUERY %d", e.connectionID))
+		cancel()
+	}
+	e.cancel()
+	return err
+}
+
+func (e *execution) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ctrlConn != nil {
+		_ = e.ctrlConn.Close()
+		e.ctrlConn = nil
+	}
+	e.cancel()
+	_ = e.conn.Close()
+}
+
+type executionRegistry struct {
+	mu         sync.Mutex
+	executions map[string]*execution
+}
+
+func newExecutionRegistry() *executionRegistry {
+	return &executionRegistry{executions: make(map[string]*execution)}
+}
+
+func (r *executionRegistry) add(e *execution) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.executions[e.id]; ok {
+		return ErrExecutionConflict.New("Execution %s already exists", e.id)
+	}
+	r.executions[e.id] = e
+	return nil
+}
+
+func (r *executionRegistry) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.executions, id)
+}
+
+// get returns the execution only if it is owned by the owner.
+func (r *executionRegistry) get(id string, owner string) (*execution, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.executions[id]
+	if !ok || e.owner != owner {
+		return nil, ErrExecutionNotFound.New("Execution %s does not exist or has finished", id)
+	}
+	return e, nil
+}
+
+// list returns running executions of the owner, in the order of start time.
+func (r *executionRegistry) list(owner string) []ExecutionInfo {
+	r.mu.Lock()
+	var executions []*execution
+	for _, e := range r.executions {
+		if e.owner == owner {
+			executions = append(executions, e)
+		}
+	}
+	r.mu.Unlock()
+
+	now := time.Now()
+	infos := make([]ExecutionInfo, 0, len(executions))
+	for _, e := range executions {
+		infos = append(infos, e.info(now))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartedAt.Before(infos[j].StartedAt)
+	})
+	return infos
+}
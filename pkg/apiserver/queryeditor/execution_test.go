@@ -0,0 +1,62 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"context"
+	"time"
+
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testExecutionSuite{})
+
+type testExecutionSuite struct{}
+
+func newTestExecution(id string, owner string, startedAt time.Time) *execution {
+	return &execution{id: id, owner: owner, statements: "select sleep(100)", startedAt: startedAt, cancel: func() {}}
+}
+
+func (t *testExecutionSuite) Test_registry(c *C) {
+	r := newExecutionRegistry()
+	now := time.Now()
+	c.Assert(r.add(newTestExecution("b", "alice", now)), IsNil)
+	c.Assert(r.add(newTestExecution("a", "alice", now.Add(-time.Second))), IsNil)
+	ctx, cancel := context.WithCancel(context.Background())
+	bobExec := newTestExecution("c", "bob", now)
+	bobExec.cancel = cancel
+	c.Assert(r.add(bobExec), IsNil)
+	c.Assert(errorx.IsOfType(r.add(newTestExecution("c", "alice", now)), ErrExecutionConflict), IsTrue)
+
+	infos := r.list("alice")
+	c.Assert(infos, HasLen, 2)
+	c.Assert(infos[0].ID, Equals, "a")
+	c.Assert(infos[1].ID, Equals, "b")
+	c.Assert(infos[0].ElapsedMs >= 1000, IsTrue)
+
+	// Executions can only be cancelled by their owners
+	_, err := r.get("c", "alice")
+	c.Assert(errorx.IsOfType(err, ErrExecutionNotFound), IsTrue)
+	e, err := r.get("c", "bob")
+	c.Assert(err, IsNil)
+
+	// Without a control connection, the context is still cancelled
+	c.Assert(e.kill(), IsNil)
+	c.Assert(ctx.Err(), NotNil)
+	c.Assert(r.list("bob")[0].Cancelled, IsTrue)
+
+	r.remove("c")
+	c.Assert(r.list("bob"), HasLen, 0)
+}
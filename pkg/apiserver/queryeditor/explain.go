@@ -0,0 +1,159 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap/parser/ast"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/tidb/plan"
+)
+
+type ExplainRequest struct {
+	Statement string `json:"statement" example:"select * from mysql.user where user = 'root'"`
+	// Whether to run `EXPLAIN ANALYZE`, which actually executes the statement to collect runtime statistics.
+	Analyze bool `json:"analyze"`
+	// Optional. The execution can be cancelled via this ID, like `/run`.
+	ExecutionID string `json:"execution_id"`
+}
+
+type ExplainResponse struct {
+	ErrorMsg    string     `json:"error_msg"`
+	ExecutionID string     `json:"execution_id"`
+	Plan        *plan.Tree `json:"plan"`
+	ExecutionMs int64      `json:"execution_ms"`
+}
+
+// buildExplainStatement returns the EXPLAIN statement for the input, which must be a single statement other than
+// EXPLAIN itself.
+func buildExplainStatement(input string, analyze bool) (string, error) {
+	stmts := splitStatements(input)
+	if len(stmts) != 1 {
+		return "", utils.ErrInvalidRequest.New("Exactly one valid statement is expected")
+	}
+	switch stmts[0].(type) {
+	case *ast.ExplainStmt, *ast.ExplainForStmt:
+		return "", utils.ErrInvalidRequest.New("The statement is already an EXPLAIN statement")
+	}
+	text := strings.TrimSpace(strings.TrimRight(stmts[0].Text(), "; \t\r\n"))
+	if analyze {
+		return "EXPLAIN ANALYZE " + text, nil
+	}
+	return "EXPLAIN " + text, nil
+}
+
+// explain runs the EXPLAIN statement and parses the result into a plan tree.
+func explain(ctx context.Context, db queryer, stmt string) (*plan.Tree, error) {
+	rows, err := db.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]sql.NullString, len(columns))
+	scanArgs := make([]interface{}, len(values))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	var planRows [][]string
+	for rows.Next() {
+		if err := rows.Scan(scanArgs...); err != nil {
+			return nil, err
+		}
+		row := make([]string, 0, len(values))
+		for _, v := range values {
+			row = append(row, v.String)
+		}
+		planRows = append(planRows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return plan.ParseRows(columns, planRows)
+}
+
+// @ID queryEditorExplain
+// @Summary Explain a statement and return the execution plan as a tree of operators
+// @Param request body ExplainRequest true "Request body"
+// @Success 200 {object} ExplainResponse
+// @Router /query_editor/explain [post]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled or statement not allowed"
+func (s *Service) explainHandler(c *gin.Context) {
+	var req ExplainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	stmt, err := buildExplainStatement(req.Statement, req.Analyze)
+	if err != nil {
+		_ = c.Error(err)
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	// The statement itself is checked, since EXPLAIN ANALYZE executes it.
+	readOnly := isReadOnlySession(c)
+	if readOnly {
+		if err := checkReadOnlyStatements(req.Statement); err != nil {
+			_ = c.Error(err)
+			c.Status(http.StatusForbidden)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(s.lifecycleCtx, time.Minute*5)
+	defer cancel()
+	startTime := time.Now()
+	sqlDB, err := utils.GetTiDBConnection(c).DB()
+	if err != nil {
+		panic(err)
+	}
+	exec, err := prepareExecution(ctx, sqlDB, req.ExecutionID, sessionOwner(c), stmt, readOnly)
+	if err != nil {
+		c.JSON(http.StatusOK, ExplainResponse{
+			ErrorMsg:    err.Error(),
+			ExecutionID: req.ExecutionID,
+			ExecutionMs: time.Since(startTime).Milliseconds(),
+		})
+		return
+	}
+	defer exec.close()
+	if err := s.executions.add(exec); err != nil {
+		_ = c.Error(err)
+		c.Status(http.StatusConflict)
+		return
+	}
+	defer s.executions.remove(exec.id)
+
+	resp := ExplainResponse{ExecutionID: exec.id}
+	resp.Plan, err = explain(ctx, exec.conn, stmt)
+	if err != nil {
+		resp.ErrorMsg = err.Error()
+	}
+	resp.ExecutionMs = time.Since(startTime).Milliseconds()
+	c.JSON(http.StatusOK, resp)
+}
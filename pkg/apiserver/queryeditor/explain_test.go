@@ -0,0 +1,36 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testExplainSuite{})
+
+type testExplainSuite struct{}
+
+func (t *testExplainSuite) Test_buildExplainStatement(c *C) {
+	stmt, err := buildExplainStatement(" select * from t where a = 1; ", false)
+	c.Assert(err, IsNil)
+	c.Assert(stmt, Equals, "EXPLAIN select * from t where a = 1")
+	stmt, err = buildExplainStatement("update t set a = 1", true)
+	c.Assert(err, IsNil)
+	c.Assert(stmt, Equals, "EXPLAIN ANALYZE update t set a = 1")
+
+	for _, input := range []string{"select 1; select 2", "", "not a statement", "explain select 1", "explain for connection 1"} {
+		_, err = buildExplainStatement(input, false)
+		c.Assert(err, NotNil, Commentf("%s", input))
+	}
+}
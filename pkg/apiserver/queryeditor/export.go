@@ -0,0 +1,301 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+)
+
+const (
+	exportTokenNamespace = "queryeditor/download"
+
+	// Exported files are written to the local disk, so that their size is limited.
+	maxExportBytes = 1024 * 1024 * 1024
+
+	exportTimeout = 30 * time.Minute
+)
+
+var ErrExportFailed = ErrNS.NewType("export_failed")
+
+// rowWriter writes a result set in a specific file format.
+type rowWriter interface {
+	writeHeader(cols []ColumnInfo) error
+	writeRow(row []interface{}) error
+	flush() error
+}
+
+func newRowWriter(format string, w io.Writer) rowWriter {
+	switch format {
+	case "csv":
+		return &delimitedRowWriter{w: csv.NewWriter(w)}
+	case "tsv":
+		// Fields containing tabs or line breaks are quoted, which can be opened by spreadsheet applications.
+		cw := csv.NewWriter(w)
+		cw.Comma = '\t'
+		return &delimitedRowWriter{w: cw}
+	case "jsonl":
+		return &jsonlRowWriter{w: bufio.NewWriter(w)}
+	}
+	return nil
+}
+
+type delimitedRowWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+func (d *delimitedRowWriter) writeHeader(cols []ColumnInfo) error {
+	return d.w.Write(columnNames(cols))
+}
+
+func (d *delimitedRowWriter) writeRow(row []interface{}) error {
+	d.record = d.record[:0]
+	for _, v := range row {
+		switch v := v.(type) {
+		case nil:
+			d.record = append(d.record, "")
+		case json.Number:
+			d.record = append(d.record, string(v))
+		case json.RawMessage:
+			d.record = append(d.record, string(v))
+		default:
+			d.record = append(d.record, fmt.Sprint(v))
+		}
+	}
+	return d.w.Write(d.record)
+}
+
+func (d *delimitedRowWriter) flush() error {
+	d.w.Flush()
+	return d.w.Error()
+}
+
+// jsonlRowWriter writes each row as a JSON object in a line. Keys are kept in the column order.
+type jsonlRowWriter struct {
+	w    *bufio.Writer
+	keys [][]byte
+}
+
+func (j *jsonlRowWriter) writeHeader(cols []ColumnInfo) error {
+	j.keys = make([][]byte, 0, len(cols))
+	for _, col := range cols {
+		key, err := json.Marshal(col.Name)
+		if err != nil {
+			return err
+		}
+		j.keys = append(j.keys, key)
+	}
+	return nil
+}
+
+func (j *jsonlRowWriter) writeRow(row []interface{}) error {
+	_ = j.w.WriteByte('{')
+	for i, v := range row {
+		if i > 0 {
+			_ = j.w.WriteByte(',')
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, _ = j.w.Write(j.keys[i])
+		_ = j.w.WriteByte(':')
+		_, _ = j.w.Write(value)
+	}
+	_, err := j.w.WriteString("}\n")
+	return err
+}
+
+func (j *jsonlRowWriter) flush() error {
+	return j.w.Flush()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// exportResult executes the statements and writes all rows of the last statement to w. Statements before the last
+// one are executed as usual, so that they can prepare the session, e.g. `USE db`.
+func exportResult(ctx context.Context, db queryer, input string, format string, w io.Writer) error {
+	var texts []string
+	var withRows []bool
+	if stmts := splitStatements(input); stmts != nil {
+		for _, stmt := range stmts {
+			// The text may include the trailing delimiter
+			texts = append(texts, strings.TrimSpace(strings.TrimRight(stmt.Text(), "; \t\r\n")))
+			withRows = append(withRows, returnsRows(stmt))
+		}
+	} else {
+		texts = []string{strings.TrimSpace(input)}
+		withRows = []bool{true}
+	}
+
+	n := len(texts) - 1
+	last := texts[n]
+	if !withRows[n] {
+		return ErrExportFailed.New("Statement `%s` does not return a result set", quoteStatement(last))
+	}
+	for i := 0; i < n; i++ {
+		r := executeStatement(ctx, db, texts[i], withRows[i], 0)
+		if r.ErrorMsg != "" {
+			return ErrExportFailed.New("Statement `%s` failed: %s", quoteStatement(texts[i]), r.ErrorMsg)
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, last)
+	if err != nil {
+		return ErrExportFailed.Wrap(err, "Statement `%s` failed", quoteStatement(last))
+	}
+	defer rows.Close()
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return ErrExportFailed.WrapWithNoMessage(err)
+	}
+	cols := make([]ColumnInfo, 0, len(colTypes))
+	for _, ct := range colTypes {
+		cols = append(cols, ColumnInfo{Name: ct.Name(), DatabaseType: ct.DatabaseTypeName()})
+	}
+
+	cw := &countingWriter{w: w}
+	rw := newRowWriter(format, cw)
+	if err := rw.writeHeader(cols); err != nil {
+		return err
+	}
+	values := make([]sql.RawBytes, len(cols))
+	scanArgs := make([]interface{}, len(values))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(scanArgs...); err != nil {
+			return ErrExportFailed.WrapWithNoMessage(err)
+		}
+		if err := rw.writeRow(encodeRow(cols, values)); err != nil {
+			return err
+		}
+		if cw.n > maxExportBytes {
+			return ErrExportFailed.New("The result exceeds the export size limit of %d MiB", maxExportBytes/1024/1024)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return ErrExportFailed.WrapWithNoMessage(err)
+	}
+	return rw.flush()
+}
+
+type ExportRequest struct {
+	Statements string `json:"statements" example:"select * from mysql.user;"`
+	// One of `csv`, `tsv` and `jsonl`.
+	Format string `json:"format" example:"csv"`
+	// Optional. The export can be cancelled via this execution ID, like `/run`.
+	ExecutionID string `json:"execution_id"`
+}
+
+// @ID queryEditorGetExportToken
+// @Summary Run statements and export all rows of the last result set. Returns a token for downloading the file.
+// @Description Statements before the last one are executed but their results are not exported.
+// @Param request body ExportRequest true "Request body"
+// @Success 200 {string} string "xxx"
+// @Router /query_editor/export/token [post]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request or failed to execute statements"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled or statement not allowed"
+func (s *Service) exportTokenHandler(c *gin.Context) {
+	var req ExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	if newRowWriter(req.Format, ioutil.Discard) == nil {
+		utils.MakeInvalidRequestErrorWithMessage(c, "Unsupported export format %s", req.Format)
+		return
+	}
+
+	readOnly := isReadOnlySession(c)
+	if readOnly {
+		if err := checkReadOnlyStatements(req.Statements); err != nil {
+			_ = c.Error(err)
+			c.Status(http.StatusForbidden)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(s.lifecycleCtx, exportTimeout)
+	defer cancel()
+	sqlDB, err := utils.GetTiDBConnection(c).DB()
+	if err != nil {
+		panic(err)
+	}
+	exec, err := prepareExecution(ctx, sqlDB, req.ExecutionID, sessionOwner(c), req.Statements, readOnly)
+	if err != nil {
+		_ = c.Error(ErrExportFailed.WrapWithNoMessage(err))
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	defer exec.close()
+	if err := s.executions.add(exec); err != nil {
+		_ = c.Error(err)
+		c.Status(http.StatusConflict)
+		return
+	}
+	defer s.executions.remove(exec.id)
+
+	filename := fmt.Sprintf("query_result_%s_*.%s", time.Now().Format("20060102_150405"), req.Format)
+	token, err := utils.ExportFile(filename, exportTokenNamespace, func(w io.Writer) error {
+		return exportResult(ctx, exec.conn, req.Statements, req.Format, w)
+	})
+	if err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrExportFailed) {
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+	c.String(http.StatusOK, token)
+}
+
+// @ID queryEditorExportDownload
+// @Summary Download an exported result
+// @Produce text/csv
+// @Param token query string true "download token"
+// @Router /query_editor/export/download [get]
+// @Failure 400 {object} utils.APIError
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+func (s *Service) exportDownloadHandler(c *gin.Context) {
+	token := c.Query("token")
+	utils.DownloadByToken(token, exportTokenNamespace, c)
+}
@@ -0,0 +1,50 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testExportSuite{})
+
+type testExportSuite struct{}
+
+func (t *testExportSuite) Test_exportResult(c *C) {
+	db := openTestDB(c, 3)
+	defer db.Close()
+	const input = "UPDATE t SET name = 'a,\"b\"\tc' WHERE id = 2; SELECT id, name FROM t ORDER BY id"
+
+	cases := map[string]string{
+		"csv":   "id,name\n1,\n2,\"a,\"\"b\"\"\tc\"\n3,\n",
+		"tsv":   "id\tname\n1\t\n2\t\"a,\"\"b\"\"\tc\"\n3\t\n",
+		"jsonl": "{\"id\":\"1\",\"name\":null}\n{\"id\":\"2\",\"name\":\"a,\\\"b\\\"\\tc\"}\n{\"id\":\"3\",\"name\":null}\n",
+	}
+	for format, expected := range cases {
+		var buf bytes.Buffer
+		c.Assert(exportResult(context.Background(), db, input, format, &buf), IsNil)
+		c.Assert(buf.String(), Equals, expected, Commentf("%s", format))
+	}
+
+	var buf bytes.Buffer
+	err := exportResult(context.Background(), db, "SELECT 1; UPDATE t SET name = 'x'", "csv", &buf)
+	c.Assert(errorx.IsOfType(err, ErrExportFailed), IsTrue)
+	err = exportResult(context.Background(), db, "SELECT * FROM not_exist; SELECT 1", "csv", &buf)
+	c.Assert(errorx.IsOfType(err, ErrExportFailed), IsTrue)
+	c.Assert(buf.Len(), Equals, 0)
+}
@@ -0,0 +1,450 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+var (
+	ErrSavedQueryNotFound = ErrNS.NewType("saved_query_not_found")
+	ErrInvalidSavedQuery  = ErrNS.NewType("invalid_saved_query")
+)
+
+const (
+	// Only the latest entries of each user are kept in the history.
+	maxHistoryPerUser = 500
+
+	defaultHistoryLimit = 100
+)
+
+type HistoryModel struct {
+	ID         uint      `gorm:"primary_key" json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Owner      string    `gorm:"size:512;index" json:"-"`
+	Statements string    `gorm:"type:text" json:"statements"`
+	DurationMs int64     `json:"duration_ms"`
+	RowCount   int       `json:"row_count"`
+	Error      string    `gorm:"type:text" json:"error"`
+}
+
+func (HistoryModel) TableName() string {
+	return "query_editor_history"
+}
+
+type SavedQueryModel struct {
+	ID          uint      `gorm:"primary_key" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Owner       string    `gorm:"size:512;uniqueIndex:idx_saved_query_owner_name" json:"-"`
+	OwnerName   string    `gorm:"size:256" json:"owner"` // The display name of the owner
+	Name        string    `gorm:"size:128;uniqueIndex:idx_saved_query_owner_name" json:"name"`
+	Description string    `gorm:"type:text" json:"description"`
+	Statements  string    `gorm:"type:text" json:"statements"`
+	// Shared queries are visible to and runnable by all users, but can only be modified by the owner.
+	IsShared bool `json:"is_shared"`
+}
+
+func (SavedQueryModel) TableName() string {
+	return "query_editor_saved_queries"
+}
+
+func autoMigrate(db *dbstore.DB) error {
+	return db.AutoMigrate(&HistoryModel{}, &SavedQueryModel{})
+}
+
+// userOwner identifies the user owning history entries and saved queries, which are kept across sessions.
+func userOwner(c *gin.Context) string {
+	return utils.GetSession(c).Owner
+}
+
+func likePattern(keyword string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(keyword) + "%"
+}
+
+func (s *Service) recordHistory(c *gin.Context, statements string, resp *RunResponse) {
+	owner := userOwner(c)
+	rec := &HistoryModel{
+		Owner:      owner,
+		Statements: statements,
+		DurationMs: resp.ExecutionMs,
+		RowCount:   resp.ActualRows,
+		Error:      resp.ErrorMsg,
+	}
+	if err := s.params.LocalStore.Create(rec).Error; err != nil {
+		log.Warn("Failed to record query history", zap.Error(err))
+		return
+	}
+	// Remove entries beyond the limit
+	var oldest []HistoryModel
+	err := s.params.LocalStore.
+		Select("id").
+		Where("owner = ?", owner).
+		Order("id DESC").
+		Offset(maxHistoryPerUser - 1).
+		Limit(1).
+		Find(&oldest).Error
+	if err == nil && len(oldest) > 0 {
+		err = s.params.LocalStore.
+			Where("owner = ? AND id < ?", owner, oldest[0].ID).
+			Delete(&HistoryModel{}).Error
+	}
+	if err != nil {
+		log.Warn("Failed to remove outdated query history", zap.Error(err))
+	}
+}
+
+type ListHistoryRequest struct {
+	Keyword string `json:"keyword" form:"keyword"`
+	Limit   int    `json:"limit" form:"limit"`
+}
+
+func (s *Service) listHistory(owner string, req *ListHistoryRequest) ([]HistoryModel, error) {
+	limit := req.Limit
+	if limit <= 0 || limit > maxHistoryPerUser {
+		limit = defaultHistoryLimit
+	}
+	query := s.params.LocalStore.Where("owner = ?", owner)
+	if req.Keyword != "" {
+		query = query.Where(`statements LIKE ? ESCAPE '\'`, likePattern(req.Keyword))
+	}
+	var recs []HistoryModel
+	if err := query.Order("id DESC").Limit(limit).Find(&recs).Error; err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// @ID queryEditorListHistory
+// @Summary List query history of the current user, latest first
+// @Param q query ListHistoryRequest true "Query"
+// @Success 200 {array} HistoryModel
+// @Router /query_editor/history [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled"
+func (s *Service) listHistoryHandler(c *gin.Context) {
+	var req ListHistoryRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	recs, err := s.listHistory(userOwner(c), &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, recs)
+}
+
+// @ID queryEditorDeleteHistory
+// @Summary Delete a query history entry of the current user
+// @Param id path string true "History ID"
+// @Success 200 {string} string
+// @Router /query_editor/history/{id} [delete]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled"
+func (s *Service) deleteHistoryHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	err = s.params.LocalStore.
+		Where("id = ? AND owner = ?", id, userOwner(c)).
+		Delete(&HistoryModel{}).Error
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, "success")
+}
+
+// @ID queryEditorClearHistory
+// @Summary Delete all query history of the current user
+// @Success 200 {string} string
+// @Router /query_editor/history [delete]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled"
+func (s *Service) clearHistoryHandler(c *gin.Context) {
+	err := s.params.LocalStore.
+		Where("owner = ?", userOwner(c)).
+		Delete(&HistoryModel{}).Error
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, "success")
+}
+
+type SavedQuery struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Statements  string `json:"statements"`
+	IsShared    bool   `json:"is_shared"`
+}
+
+func (q *SavedQuery) validate() error {
+	if strings.TrimSpace(q.Name) == "" {
+		return ErrInvalidSavedQuery.New("Name must not be empty")
+	}
+	if len(q.Name) > 128 {
+		return ErrInvalidSavedQuery.New("Name must not exceed 128 characters")
+	}
+	if strings.TrimSpace(q.Statements) == "" {
+		return ErrInvalidSavedQuery.New("Statements must not be empty")
+	}
+	return nil
+}
+
+type ListSavedQueriesRequest struct {
+	Keyword string `json:"keyword" form:"keyword"`
+}
+
+// listSavedQueries returns queries owned by the user and queries shared by other users.
+func (s *Service) listSavedQueries(owner string, keyword string) ([]SavedQueryModel, error) {
+	query := s.params.LocalStore.Where("(owner = ? OR is_shared = ?)", owner, true)
+	if keyword != "" {
+		p := likePattern(keyword)
+		query = query.Where(`(name LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\' OR statements LIKE ? ESCAPE '\')`, p, p, p)
+	}
+	var recs []SavedQueryModel
+	if err := query.Order("name").Find(&recs).Error; err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// getSavedQuery returns the query if it is visible to the user. When forUpdate is true, only queries owned by the
+// user are returned.
+func (s *Service) getSavedQuery(owner string, id int, forUpdate bool) (*SavedQueryModel, error) {
+	var rec SavedQueryModel
+	err := s.params.LocalStore.Where("id = ?", id).First(&rec).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSavedQueryNotFound.New("Saved query %d does not exist", id)
+		}
+		return nil, err
+	}
+	if rec.Owner != owner && (forUpdate || !rec.IsShared) {
+		return nil, ErrSavedQueryNotFound.New("Saved query %d does not exist", id)
+	}
+	return &rec, nil
+}
+
+func (s *Service) saveQuery(rec *SavedQueryModel) error {
+	var n int64
+	err := s.params.LocalStore.
+		Model(&SavedQueryModel{}).
+		Where("owner = ? AND name = ? AND id <> ?", rec.Owner, rec.Name, rec.ID).
+		Count(&n).Error
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrInvalidSavedQuery.New("A saved query named %s already exists", rec.Name)
+	}
+	return s.params.LocalStore.Save(rec).Error
+}
+
+func handleSavedQueryError(c *gin.Context, err error) {
+	_ = c.Error(err)
+	if errorx.IsOfType(err, ErrSavedQueryNotFound) {
+		c.Status(http.StatusNotFound)
+	} else if errorx.IsOfType(err, ErrInvalidSavedQuery) {
+		c.Status(http.StatusBadRequest)
+	}
+}
+
+// @ID queryEditorListSavedQueries
+// @Summary List saved queries owned by the current user or shared by others
+// @Param q query ListSavedQueriesRequest true "Query"
+// @Success 200 {array} SavedQueryModel
+// @Router /query_editor/saved_queries [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled"
+func (s *Service) listSavedQueriesHandler(c *gin.Context) {
+	var req ListSavedQueriesRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	recs, err := s.listSavedQueries(userOwner(c), req.Keyword)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, recs)
+}
+
+// @ID queryEditorCreateSavedQuery
+// @Summary Save a query
+// @Param request body SavedQuery true "Request body"
+// @Success 200 {object} SavedQueryModel
+// @Router /query_editor/saved_queries [post]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled"
+func (s *Service) createSavedQueryHandler(c *gin.Context) {
+	var req SavedQuery
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	if err := req.validate(); err != nil {
+		handleSavedQueryError(c, err)
+		return
+	}
+	rec := &SavedQueryModel{
+		Owner:       userOwner(c),
+		OwnerName:   utils.GetSession(c).DisplayName,
+		Name:        req.Name,
+		Description: req.Description,
+		Statements:  req.Statements,
+		IsShared:    req.IsShared,
+	}
+	if err := s.saveQuery(rec); err != nil {
+		handleSavedQueryError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rec)
+}
+
+// @ID queryEditorUpdateSavedQuery
+// @Summary Update a saved query owned by the current user
+// @Param id path string true "Saved query ID"
+// @Param request body SavedQuery true "Request body"
+// @Success 200 {object} SavedQueryModel
+// @Router /query_editor/saved_queries/{id} [put]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled"
+// @Failure 404 {object} utils.APIError "Saved query not found"
+func (s *Service) updateSavedQueryHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	var req SavedQuery
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	if err := req.validate(); err != nil {
+		handleSavedQueryError(c, err)
+		return
+	}
+	rec, err := s.getSavedQuery(userOwner(c), id, true)
+	if err != nil {
+		handleSavedQueryError(c, err)
+		return
+	}
+	rec.Name = req.Name
+	rec.Description = req.Description
+	rec.Statements = req.Statements
+	rec.IsShared = req.IsShared
+	if err := s.saveQuery(rec); err != nil {
+		handleSavedQueryError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rec)
+}
+
+// @ID queryEditorDeleteSavedQuery
+// @Summary Delete a saved query owned by the current user
+// @Param id path string true "Saved query ID"
+// @Success 200 {string} string
+// @Router /query_editor/saved_queries/{id} [delete]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled"
+// @Failure 404 {object} utils.APIError "Saved query not found"
+func (s *Service) deleteSavedQueryHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	rec, err := s.getSavedQuery(userOwner(c), id, true)
+	if err != nil {
+		handleSavedQueryError(c, err)
+		return
+	}
+	if err := s.params.LocalStore.Delete(rec).Error; err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, "success")
+}
+
+type RunSavedQueryRequest struct {
+	MaxRows     int    `json:"max_rows" example:"1000"`
+	ExecutionID string `json:"execution_id"`
+}
+
+// @ID queryEditorRunSavedQuery
+// @Summary Run a saved query owned by the current user or shared by others
+// @Param id path string true "Saved query ID"
+// @Param request body RunSavedQueryRequest true "Request body"
+// @Success 200 {object} RunResponse
+// @Router /query_editor/saved_queries/{id}/run [post]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled"
+// @Failure 404 {object} utils.APIError "Saved query not found"
+func (s *Service) runSavedQueryHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	var req RunSavedQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	rec, err := s.getSavedQuery(userOwner(c), id, false)
+	if err != nil {
+		handleSavedQueryError(c, err)
+		return
+	}
+	s.run(c, &RunRequest{
+		Statements:  rec.Statements,
+		MaxRows:     req.MaxRows,
+		ExecutionID: req.ExecutionID,
+	})
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore/dbstoretest"
+)
+
+var _ = Suite(&testHistorySuite{})
+
+type testHistorySuite struct{}
+
+func (t *testHistorySuite) SetUpSuite(c *C) {
+	gin.SetMode(gin.TestMode)
+}
+
+func (t *testHistorySuite) newService(c *C) *Service {
+	return &Service{params: ServiceParams{LocalStore: dbstoretest.NewDB(c, autoMigrate)}}
+}
+
+func newUserContext(displayName string, owner string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(utils.SessionUserKey, &utils.SessionUser{DisplayName: displayName, Owner: owner})
+	return c
+}
+
+func (t *testHistorySuite) Test_history(c *C) {
+	s := t.newService(c)
+	alice := newUserContext("alice", "0:alice")
+	s.recordHistory(alice, "select 1", &RunResponse{ActualRows: 1, ExecutionMs: 3})
+	s.recordHistory(alice, "select 100%", &RunResponse{ErrorMsg: "syntax error"})
+	s.recordHistory(newUserContext("bob", "0:bob"), "select 1", &RunResponse{ActualRows: 1})
+	// A user of another authentication type with the same display name
+	s.recordHistory(newUserContext("alice", "3:alice"), "select 2", &RunResponse{ActualRows: 1})
+
+	recs, err := s.listHistory("0:alice", &ListHistoryRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(recs, HasLen, 2)
+	c.Assert(recs[0].Statements, Equals, "select 100%")
+	c.Assert(recs[0].Error, Equals, "syntax error")
+	c.Assert(recs[1].RowCount, Equals, 1)
+
+	// Wildcards in the keyword are matched literally
+	recs, err = s.listHistory("0:alice", &ListHistoryRequest{Keyword: "%"})
+	c.Assert(err, IsNil)
+	c.Assert(recs, HasLen, 1)
+	c.Assert(recs[0].Statements, Equals, "select 100%")
+}
+
+func (t *testHistorySuite) Test_savedQueries(c *C) {
+	s := t.newService(c)
+	c.Assert(s.saveQuery(&SavedQueryModel{Owner: "alice", Name: "slow", Statements: "select 1"}), IsNil)
+	c.Assert(s.saveQuery(&SavedQueryModel{Owner: "alice", Name: "regions", Description: "hot regions", Statements: "select 2", IsShared: true}), IsNil)
+	c.Assert(s.saveQuery(&SavedQueryModel{Owner: "bob", Name: "slow", Statements: "select 3"}), IsNil)
+	err := s.saveQuery(&SavedQueryModel{Owner: "bob", Name: "slow", Statements: "select 4"})
+	c.Assert(errorx.IsOfType(err, ErrInvalidSavedQuery), IsTrue)
+
+	recs, err := s.listSavedQueries("bob", "")
+	c.Assert(err, IsNil)
+	c.Assert(recs, HasLen, 2)
+	c.Assert(recs[0].Name, Equals, "regions")
+	c.Assert(recs[1].Owner, Equals, "bob")
+
+	recs, err = s.listSavedQueries("bob", "hot")
+	c.Assert(err, IsNil)
+	c.Assert(recs, HasLen, 1)
+	c.Assert(recs[0].Name, Equals, "regions")
+
+	// Shared queries can be run but not modified by others
+	shared := recs[0]
+	_, err = s.getSavedQuery("bob", int(shared.ID), false)
+	c.Assert(err, IsNil)
+	_, err = s.getSavedQuery("bob", int(shared.ID), true)
+	c.Assert(errorx.IsOfType(err, ErrSavedQueryNotFound), IsTrue)
+
+	recs, err = s.listSavedQueries("alice", "slow")
+	c.Assert(err, IsNil)
+	c.Assert(recs, HasLen, 1)
+	_, err = s.getSavedQuery("bob", int(recs[0].ID), false)
+	c.Assert(errorx.IsOfType(err, ErrSavedQueryNotFound), IsTrue)
+}
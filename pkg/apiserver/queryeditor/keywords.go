@@ -0,0 +1,56 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+// keywords are SQL keywords supported by TiDB, for autocompletion only. The parser does not expose its keyword list,
+// so that it is maintained here.
+var keywords = []string{
+	"ACCOUNT", "ACTION", "ADD", "ADMIN", "AFTER", "AGAINST", "ALGORITHM", "ALL", "ALTER", "ALWAYS", "ANALYZE", "AND",
+	"ANY", "AS", "ASC", "AUTO_INCREMENT", "AUTO_RANDOM", "AUTO_RANDOM_BASE", "AVG_ROW_LENGTH", "BEGIN", "BETWEEN",
+	"BIGINT", "BINARY", "BINDING", "BINDINGS", "BINLOG", "BIT", "BLOB", "BOOL", "BOOLEAN", "BOTH", "BTREE", "BUCKETS",
+	"BUILTINS", "BY", "BYTE", "CACHE", "CANCEL", "CASCADE", "CASE", "CAST", "CHANGE", "CHAR", "CHARACTER", "CHARSET",
+	"CHECK", "CHECKSUM", "CLEANUP", "CLIENT", "COALESCE", "COLLATE", "COLLATION", "COLUMN", "COLUMNS", "COMMENT",
+	"COMMIT", "COMMITTED", "COMPACT", "COMPRESSED", "COMPRESSION", "CONNECTION", "CONSISTENT", "CONSTRAINT",
+	"CONVERT", "CREATE", "CROSS", "CUME_DIST", "CURRENT", "CURRENT_DATE", "CURRENT_ROLE", "CURRENT_TIME",
+	"CURRENT_TIMESTAMP", "CURRENT_USER", "CYCLE", "DATA", "DATABASE", "DATABASES", "DATE", "DATETIME", "DAY", "DDL",
+	"DEALLOCATE", "DECIMAL", "DEFAULT", "DEFINER", "DELAYED", "DELETE", "DENSE_RANK", "DESC", "DESCRIBE", "DISABLE",
+	"DISTINCT", "DISTINCTROW", "DIV", "DO", "DOUBLE", "DRAINER", "DROP", "DUAL", "DUPLICATE", "DYNAMIC", "ELSE",
+	"ENABLE", "ENCLOSED", "END", "ENGINE", "ENGINES", "ENUM", "ESCAPE", "ESCAPED", "EVENT", "EVENTS", "EXCEPT",
+	"EXCHANGE", "EXECUTE", "EXISTS", "EXPLAIN", "EXTENDED", "FALSE", "FIELDS", "FIRST", "FIRST_VALUE", "FLASHBACK",
+	"FLOAT", "FLUSH", "FOLLOWING", "FOR", "FORCE", "FOREIGN", "FORMAT", "FROM", "FULL", "FULLTEXT", "FUNCTION",
+	"GENERATED", "GLOBAL", "GRANT", "GRANTS", "GROUP", "GROUPS", "HASH", "HAVING", "HIGH_PRIORITY", "HISTOGRAM",
+	"HOUR", "IDENTIFIED", "IF", "IGNORE", "IMPORT", "IN", "INCREMENT", "INDEX", "INDEXES", "INFILE", "INNER",
+	"INSERT", "INT", "INTEGER", "INTERSECT", "INTERVAL", "INTO", "INVISIBLE", "IS", "ISOLATION", "JOB", "JOBS",
+	"JOIN", "JSON", "KEY", "KEYS", "KILL", "LAG", "LAST", "LAST_VALUE", "LEAD", "LEADING", "LEFT", "LESS", "LEVEL",
+	"LIKE", "LIMIT", "LINEAR", "LINES", "LIST", "LOAD", "LOCAL", "LOCALTIME", "LOCALTIMESTAMP", "LOCATION", "LOCK",
+	"LONGBLOB", "LONGTEXT", "LOW_PRIORITY", "MASTER", "MATCH", "MAXVALUE", "MEDIUMBLOB", "MEDIUMINT", "MEDIUMTEXT",
+	"MINUTE", "MOD", "MODE", "MODIFY", "MONTH", "NAMES", "NATURAL", "NEXT", "NO", "NOCACHE", "NOCYCLE", "NOT",
+	"NTH_VALUE", "NTILE", "NULL", "NULLS", "NUMERIC", "OFFSET", "ON", "ONLY", "OPTIMISTIC", "OPTIMIZE", "OPTION",
+	"OPTIONALLY", "OR", "ORDER", "OUTER", "OUTFILE", "OVER", "PARTITION", "PARTITIONS", "PERCENT_RANK", "PESSIMISTIC",
+	"PLACEMENT", "PLUGINS", "PRECEDING", "PRECISION", "PREPARE", "PRIMARY", "PRIVILEGES", "PROCEDURE", "PROCESS",
+	"PROCESSLIST", "PROFILE", "PROFILES", "PUMP", "QUARTER", "QUERY", "QUICK", "RANGE", "RANK", "READ", "REAL",
+	"RECOVER", "REDUNDANT", "REFERENCES", "REGEXP", "REGION", "REGIONS", "RELOAD", "REMOVE", "RENAME", "REORGANIZE",
+	"REPAIR", "REPEAT", "REPEATABLE", "REPLACE", "REPLICA", "RESTRICT", "REVOKE", "RIGHT", "RLIKE", "ROLE",
+	"ROLLBACK", "ROUTINE", "ROW", "ROWS", "ROW_FORMAT", "ROW_NUMBER", "SAVEPOINT", "SCHEMA", "SCHEMAS", "SECOND",
+	"SELECT", "SEQUENCE", "SERIALIZABLE", "SESSION", "SET", "SHARD_ROW_ID_BITS", "SHARE", "SHOW", "SIGNED", "SLAVE",
+	"SMALLINT", "SNAPSHOT", "SOME", "SPLIT", "SQL_BIG_RESULT", "SQL_BUFFER_RESULT", "SQL_CACHE",
+	"SQL_CALC_FOUND_ROWS", "SQL_NO_CACHE", "SQL_SMALL_RESULT", "START", "STATS", "STATS_BUCKETS", "STATS_HEALTHY",
+	"STATS_HISTOGRAMS", "STATS_META", "STATUS", "STORED", "STRAIGHT_JOIN", "SUBPARTITION", "SUPER", "TABLE", "TABLES",
+	"TABLESAMPLE", "TEMPORARY", "TERMINATED", "TEXT", "THAN", "THEN", "TIDB", "TIFLASH", "TIME", "TIMESTAMP",
+	"TINYBLOB", "TINYINT", "TINYTEXT", "TO", "TOPN", "TRACE", "TRAILING", "TRANSACTION", "TRIGGER", "TRIGGERS",
+	"TRUE", "TRUNCATE", "TYPE", "UNBOUNDED", "UNCOMMITTED", "UNION", "UNIQUE", "UNLOCK", "UNSIGNED", "UPDATE",
+	"USAGE", "USE", "USER", "USING", "UTC_DATE", "UTC_TIME", "UTC_TIMESTAMP", "VALUE", "VALUES", "VARBINARY",
+	"VARCHAR", "VARIABLES", "VIEW", "VIRTUAL", "VISIBLE", "WARNINGS", "WEEK", "WHEN", "WHERE", "WINDOW", "WITH",
+	"WITHOUT", "WRITE", "XOR", "YEAR", "ZEROFILL",
+}
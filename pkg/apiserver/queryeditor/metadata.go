@@ -0,0 +1,372 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+)
+
+const (
+	// The schema version does not change when privileges are changed, so that cached metadata also expires after a
+	// while, since it only contains schemas visible to the user.
+	metadataCacheTTL = 10 * time.Minute
+
+	// unknownSchemaVersion is used when the schema version is not available. Cached metadata is then only
+	// invalidated by the TTL.
+	unknownSchemaVersion = -1
+
+	// Metadata of large clusters is truncated at these limits, to keep the response size reasonable.
+	maxMetadataTables       = 5000
+	maxMetadataColumns      = 100000
+	maxMetadataIndexColumns = 50000
+)
+
+// systemSchemas are not loaded into metadata, since they contain many tables and are rarely queried in the editor.
+var systemSchemas = []string{"information_schema", "metrics_schema", "performance_schema", "mysql"}
+
+type ColumnMetadata struct {
+	Name string `json:"name"`
+	// The full column type, e.g. `varchar(64)`, `bigint(20) unsigned`.
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+	Comment  string `json:"comment"`
+}
+
+type IndexMetadata struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Unique  bool     `json:"unique"`
+	Primary bool     `json:"primary"`
+}
+
+type TableMetadata struct {
+	Name string `json:"name"`
+	// e.g. `BASE TABLE`, `VIEW`, `SYSTEM VIEW`.
+	Type    string           `json:"type"`
+	Comment string           `json:"comment"`
+	Columns []ColumnMetadata `json:"columns"`
+	Indexes []IndexMetadata  `json:"indexes"`
+}
+
+type SchemaMetadata struct {
+	Name   string          `json:"name"`
+	Tables []TableMetadata `json:"tables"`
+}
+
+type Metadata struct {
+	// The schema version the metadata is loaded at, or -1 if not available.
+	SchemaVersion int64            `json:"schema_version"`
+	LoadedAt      time.Time        `json:"loaded_at"`
+	Schemas       []SchemaMetadata `json:"schemas"`
+	// Whether some tables, columns or indexes are not loaded due to the limits.
+	Truncated bool `json:"truncated"`
+	// Built-in function names, in lower case.
+	Functions []string `json:"functions"`
+	// Keywords, in upper case.
+	Keywords []string `json:"keywords"`
+}
+
+type tableRow struct {
+	Schema  string `gorm:"column:TABLE_SCHEMA"`
+	Table   string `gorm:"column:TABLE_NAME"`
+	Type    string `gorm:"column:TABLE_TYPE"`
+	Comment string `gorm:"column:TABLE_COMMENT"`
+}
+
+type columnRow struct {
+	Schema     string `gorm:"column:TABLE_SCHEMA"`
+	Table      string `gorm:"column:TABLE_NAME"`
+	Column     string `gorm:"column:COLUMN_NAME"`
+	ColumnType string `gorm:"column:COLUMN_TYPE"`
+	IsNullable string `gorm:"column:IS_NULLABLE"`
+	Comment    string `gorm:"column:COLUMN_COMMENT"`
+}
+
+type indexRow struct {
+	Schema    string `gorm:"column:TABLE_SCHEMA"`
+	Table     string `gorm:"column:TABLE_NAME"`
+	Index     string `gorm:"column:INDEX_NAME"`
+	NonUnique int    `gorm:"column:NON_UNIQUE"`
+	Column    string `gorm:"column:COLUMN_NAME"`
+}
+
+// querySchemaVersion returns the current schema version, which is changed by every DDL.
+func querySchemaVersion(db *gorm.DB) int64 {
+	var result struct {
+		Value string `gorm:"column:Value"`
+	}
+	err := db.Raw("SHOW STATUS LIKE 'ddl_schema_version'").Scan(&result).Error
+	if err != nil {
+		log.Debug("Failed to query schema version", zap.Error(err))
+		return unknownSchemaVersion
+	}
+	version, err := strconv.ParseInt(result.Value, 10, 64)
+	if err != nil {
+		return unknownSchemaVersion
+	}
+	return version
+}
+
+// loadMetadata loads metadata of schemas visible to the user of the connection, except system schemas.
+func loadMetadata(db *gorm.DB, schemaVersion int64) (*Metadata, error) {
+	var databases []struct {
+		Database string `gorm:"column:Database"`
+	}
+	if err := db.Raw("SHOW DATABASES").Scan(&databases).Error; err != nil {
+		return nil, err
+	}
+	// Rows one more than the limit are queried to know whether they are truncated
+	var tables []tableRow
+	err := db.
+		Select("TABLE_SCHEMA, TABLE_NAME, TABLE_TYPE, TABLE_COMMENT").
+		Table("INFORMATION_SCHEMA.TABLES").
+		Where("LOWER(TABLE_SCHEMA) NOT IN (?)", systemSchemas).
+		Order("TABLE_SCHEMA, TABLE_NAME").
+		Limit(maxMetadataTables + 1).
+		Scan(&tables).Error
+	if err != nil {
+		return nil, err
+	}
+	var columns []columnRow
+	err = db.
+		Select("TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_COMMENT").
+		Table("INFORMATION_SCHEMA.COLUMNS").
+		Where("LOWER(TABLE_SCHEMA) NOT IN (?)", systemSchemas).
+		Order("TABLE_SCHEMA, TABLE_NAME, ORDINAL_POSITION").
+		Limit(maxMetadataColumns + 1).
+		Scan(&columns).Error
+	if err != nil {
+		return nil, err
+	}
+	var indexes []indexRow
+	err = db.
+		Select("TABLE_SCHEMA, TABLE_NAME, INDEX_NAME, NON_UNIQUE, COLUMN_NAME").
+		Table("INFORMATION_SCHEMA.STATISTICS").
+		Where("LOWER(TABLE_SCHEMA) NOT IN (?)", systemSchemas).
+		Order("TABLE_SCHEMA, TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX").
+		Limit(maxMetadataIndexColumns + 1).
+		Scan(&indexes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	truncated := false
+	if len(tables) > maxMetadataTables {
+		tables, truncated = tables[:maxMetadataTables], true
+	}
+	if len(columns) > maxMetadataColumns {
+		columns, truncated = columns[:maxMetadataColumns], true
+	}
+	if len(indexes) > maxMetadataIndexColumns {
+		indexes, truncated = indexes[:maxMetadataIndexColumns], true
+	}
+
+	schemaNames := make([]string, 0, len(databases))
+	for _, d := range databases {
+		if !isSystemSchema(d.Database) {
+			schemaNames = append(schemaNames, d.Database)
+		}
+	}
+	return &Metadata{
+		SchemaVersion: schemaVersion,
+		LoadedAt:      time.Now(),
+		Schemas:       assembleSchemas(schemaNames, tables, columns, indexes),
+		Truncated:     truncated,
+		Functions:     queryBuiltinFunctions(db),
+		Keywords:      keywords,
+	}, nil
+}
+
+func isSystemSchema(name string) bool {
+	for _, s := range systemSchemas {
+		if strings.EqualFold(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryBuiltinFunctions returns names of built-in functions supported by TiDB. It is best-effort since
+// `SHOW BUILTINS` is not available in old TiDB versions.
+func queryBuiltinFunctions(db *gorm.DB) []string {
+	var builtins []struct {
+		Name string `gorm:"column:Supported_builtin_functions"`
+	}
+	if err := db.Raw("SHOW BUILTINS").Scan(&builtins).Error; err != nil {
+		log.Debug("Failed to list built-in functions", zap.Error(err))
+		return []string{}
+	}
+	functions := make([]string, 0, len(builtins))
+	for _, b := range builtins {
+		functions = append(functions, strings.ToLower(b.Name))
+	}
+	sort.Strings(functions)
+	return functions
+}
+
+type tableKey struct {
+	schema string
+	table  string
+}
+
+// assembleSchemas groups tables, columns and indexes by schemas. Rows should be ordered, see loadMetadata.
+func assembleSchemas(schemaNames []string, tables []tableRow, columns []columnRow, indexes []indexRow) []SchemaMetadata {
+	tableMap := make(map[tableKey]*TableMetadata, len(tables))
+	tablesBySchema := make(map[string][]*TableMetadata)
+	for _, t := range tables {
+		key := tableKey{strings.ToLower(t.Schema), t.Table}
+		tm := &TableMetadata{
+			Name:    t.Table,
+			Type:    t.Type,
+			Comment: t.Comment,
+			Columns: make([]ColumnMetadata, 0),
+			Indexes: make([]IndexMetadata, 0),
+		}
+		tableMap[key] = tm
+		tablesBySchema[key.schema] = append(tablesBySchema[key.schema], tm)
+	}
+	for _, col := range columns {
+		tm, ok := tableMap[tableKey{strings.ToLower(col.Schema), col.Table}]
+		if !ok {
+			continue
+		}
+		tm.Columns = append(tm.Columns, ColumnMetadata{
+			Name:     col.Column,
+			Type:     col.ColumnType,
+			Nullable: strings.EqualFold(col.IsNullable, "YES"),
+			Comment:  col.Comment,
+		})
+	}
+	for _, idx := range indexes {
+		tm, ok := tableMap[tableKey{strings.ToLower(idx.Schema), idx.Table}]
+		if !ok {
+			continue
+		}
+		n := len(tm.Indexes)
+		if n == 0 || tm.Indexes[n-1].Name != idx.Index {
+			tm.Indexes = append(tm.Indexes, IndexMetadata{
+				Name:    idx.Index,
+				Columns: make([]string, 0, 1),
+				Unique:  idx.NonUnique == 0,
+				Primary: strings.EqualFold(idx.Index, "PRIMARY"),
+			})
+			n++
+		}
+		tm.Indexes[n-1].Columns = append(tm.Indexes[n-1].Columns, idx.Column)
+	}
+
+	schemas := make([]SchemaMetadata, 0, len(schemaNames))
+	for _, name := range schemaNames {
+		sm := SchemaMetadata{Name: name, Tables: make([]TableMetadata, 0)}
+		for _, tm := range tablesBySchema[strings.ToLower(name)] {
+			sm.Tables = append(sm.Tables, *tm)
+		}
+		schemas = append(schemas, sm)
+	}
+	sort.Slice(schemas, func(i, j int) bool {
+		return schemas[i].Name < schemas[j].Name
+	})
+	return schemas
+}
+
+type metadataCacheEntry struct {
+	metadata *Metadata
+	expireAt time.Time
+}
+
+// metadataCache keeps metadata per TiDB user, since users may see different schemas.
+type metadataCache struct {
+	mu      sync.Mutex
+	entries map[string]*metadataCacheEntry
+}
+
+func newMetadataCache() *metadataCache {
+	return &metadataCache{entries: make(map[string]*metadataCacheEntry)}
+}
+
+// get returns the cached metadata if it is loaded at the same schema version and not expired.
+func (m *metadataCache) get(user string, schemaVersion int64, now time.Time) *Metadata {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[user]
+	if !ok {
+		return nil
+	}
+	if entry.metadata.SchemaVersion != schemaVersion || now.After(entry.expireAt) {
+		delete(m.entries, user)
+		return nil
+	}
+	return entry.metadata
+}
+
+func (m *metadataCache) put(user string, metadata *Metadata, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for u, entry := range m.entries {
+		if now.After(entry.expireAt) {
+			delete(m.entries, u)
+		}
+	}
+	m.entries[user] = &metadataCacheEntry{metadata: metadata, expireAt: now.Add(metadataCacheTTL)}
+}
+
+type GetMetadataRequest struct {
+	// Reload the metadata even if it is cached.
+	Refresh bool `json:"refresh" form:"refresh"`
+}
+
+// @ID queryEditorGetMetadata
+// @Summary Get metadata for autocompletion, including schemas visible to the current user, built-in functions and keywords
+// @Description System schemas are not included. Large metadata is truncated, indicated by the `truncated` field.
+// @Param q query GetMetadataRequest true "Query"
+// @Success 200 {object} Metadata
+// @Router /query_editor/metadata [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled"
+func (s *Service) getMetadataHandler(c *gin.Context) {
+	var req GetMetadataRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	db := utils.GetTiDBConnection(c)
+	user := utils.GetSession(c).TiDBUsername
+	schemaVersion := querySchemaVersion(db)
+	if !req.Refresh {
+		if md := s.metadata.get(user, schemaVersion, time.Now()); md != nil {
+			c.JSON(http.StatusOK, md)
+			return
+		}
+	}
+	md, err := loadMetadata(db, schemaVersion)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	s.metadata.put(user, md, time.Now())
+	c.JSON(http.StatusOK, md)
+}
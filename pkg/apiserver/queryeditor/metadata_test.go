@@ -0,0 +1,87 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"sort"
+	"time"
+
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testMetadataSuite{})
+
+type testMetadataSuite struct{}
+
+func (t *testMetadataSuite) Test_assembleSchemas(c *C) {
+	tables := []tableRow{
+		{Schema: "test", Table: "t", Type: "BASE TABLE", Comment: "orders"},
+		{Schema: "test", Table: "v", Type: "VIEW"},
+	}
+	columns := []columnRow{
+		{Schema: "test", Table: "t", Column: "id", ColumnType: "bigint(20)", IsNullable: "NO"},
+		{Schema: "test", Table: "t", Column: "a", ColumnType: "varchar(64)", IsNullable: "YES", Comment: "name"},
+		{Schema: "test", Table: "v", Column: "id", ColumnType: "bigint(20)", IsNullable: "NO"},
+		{Schema: "hidden", Table: "x", Column: "id", ColumnType: "int(11)", IsNullable: "NO"},
+	}
+	indexes := []indexRow{
+		{Schema: "test", Table: "t", Index: "PRIMARY", NonUnique: 0, Column: "id"},
+		{Schema: "test", Table: "t", Index: "idx_a_id", NonUnique: 1, Column: "a"},
+		{Schema: "test", Table: "t", Index: "idx_a_id", NonUnique: 1, Column: "id"},
+	}
+	schemas := assembleSchemas([]string{"test", "mysql"}, tables, columns, indexes)
+	c.Assert(schemas, HasLen, 2)
+	c.Assert(schemas[0].Name, Equals, "mysql")
+	c.Assert(schemas[0].Tables, HasLen, 0)
+
+	c.Assert(schemas[1].Tables, HasLen, 2)
+	tbl := schemas[1].Tables[0]
+	c.Assert(tbl.Comment, Equals, "orders")
+	c.Assert(tbl.Columns, DeepEquals, []ColumnMetadata{
+		{Name: "id", Type: "bigint(20)", Nullable: false},
+		{Name: "a", Type: "varchar(64)", Nullable: true, Comment: "name"},
+	})
+	c.Assert(tbl.Indexes, DeepEquals, []IndexMetadata{
+		{Name: "PRIMARY", Columns: []string{"id"}, Unique: true, Primary: true},
+		{Name: "idx_a_id", Columns: []string{"a", "id"}},
+	})
+	c.Assert(schemas[1].Tables[1].Indexes, HasLen, 0)
+}
+
+func (t *testMetadataSuite) Test_isSystemSchema(c *C) {
+	c.Assert(isSystemSchema("INFORMATION_SCHEMA"), IsTrue)
+	c.Assert(isSystemSchema("mysql"), IsTrue)
+	c.Assert(isSystemSchema("METRICS_SCHEMA"), IsTrue)
+	c.Assert(isSystemSchema("test"), IsFalse)
+}
+
+func (t *testMetadataSuite) Test_metadataCache(c *C) {
+	cache := newMetadataCache()
+	now := time.Now()
+	md := &Metadata{SchemaVersion: 10}
+	cache.put("root", md, now)
+
+	c.Assert(cache.get("root", 10, now.Add(time.Minute)), Equals, md)
+	c.Assert(cache.get("alice", 10, now), IsNil)
+	c.Assert(cache.get("root", 10, now.Add(metadataCacheTTL+time.Second)), IsNil)
+
+	cache.put("root", md, now)
+	// Invalidated by DDL
+	c.Assert(cache.get("root", 11, now), IsNil)
+	c.Assert(cache.get("root", 10, now), IsNil)
+}
+
+func (t *testMetadataSuite) Test_keywords(c *C) {
+	c.Assert(sort.StringsAreSorted(keywords), IsTrue)
+}
@@ -0,0 +1,155 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+)
+
+var (
+	ErrStatementNotReadOnly = ErrNS.NewType("statement_not_read_only")
+	ErrReadOnlyNotEnforced  = ErrNS.NewType("read_only_not_enforced")
+)
+
+// sideEffectFuncs are functions that modify states or hold resources even when called in read-only statements.
+var sideEffectFuncs = map[string]struct{}{
+	ast.GetLock:         {},
+	ast.ReleaseLock:     {},
+	ast.ReleaseAllLocks: {},
+	ast.Sleep:           {},
+	ast.Benchmark:       {},
+	ast.NextVal:         {},
+	ast.SetVal:          {},
+}
+
+// maxStatementTextInError limits the length of statements quoted in errors.
+const maxStatementTextInError = 100
+
+// isReadOnlySession returns whether the session can only run read-only statements.
+func isReadOnlySession(c *gin.Context) bool {
+	return !utils.GetSession(c).HasPermission(utils.PermQueryEditorRun)
+}
+
+// isReadOnlyStatement returns whether the statement does not modify any data or session state.
+func isReadOnlyStatement(stmt ast.StmtNode) bool {
+	switch s := stmt.(type) {
+	case *ast.SelectStmt:
+		return isReadOnlySelect(s) && !hasSideEffects(s)
+	case *ast.SetOprStmt:
+		if s.SelectList == nil {
+			return false
+		}
+		for _, sel := range s.SelectList.Selects {
+			if sub, ok := sel.(ast.StmtNode); !ok || !isReadOnlyStatement(sub) {
+				return false
+			}
+		}
+		return true
+	case *ast.ExplainStmt:
+		// EXPLAIN ANALYZE actually executes the statement
+		return !s.Analyze
+	case *ast.ShowStmt:
+		// e.g. SHOW TABLES WHERE SLEEP(10)
+		return !hasSideEffects(s)
+	case *ast.ExplainForStmt, *ast.UseStmt:
+		return true
+	}
+	return false
+}
+
+// sideEffectDetector finds calls of sideEffectFuncs and user variable assignments like `@a := 1`.
+type sideEffectDetector struct {
+	found bool
+}
+
+func (d *sideEffectDetector) Enter(n ast.Node) (ast.Node, bool) {
+	switch v := n.(type) {
+	case *ast.FuncCallExpr:
+		if _, ok := sideEffectFuncs[v.FnName.L]; ok {
+			d.found = true
+		}
+	case *ast.VariableExpr:
+		if v.Value != nil {
+			d.found = true
+		}
+	}
+	return n, d.found
+}
+
+func (d *sideEffectDetector) Leave(n ast.Node) (ast.Node, bool) {
+	return n, !d.found
+}
+
+func hasSideEffects(node ast.Node) bool {
+	d := &sideEffectDetector{}
+	node.Accept(d)
+	return d.found
+}
+
+func isReadOnlySelect(s *ast.SelectStmt) bool {
+	if s.SelectIntoOpt != nil {
+		return false
+	}
+	if s.LockInfo != nil && s.LockInfo.LockType != ast.SelectLockNone {
+		return false
+	}
+	return true
+}
+
+func quoteStatement(text string) string {
+	text = strings.TrimRight(strings.TrimSpace(text), "; \t\r\n")
+	if len(text) > maxStatementTextInError {
+		text = text[:maxStatementTextInError] + "..."
+	}
+	return text
+}
+
+// checkReadOnlyStatements returns an error naming the first statement that is not read-only. Input that cannot be
+// parsed is rejected, since it cannot be classified.
+func checkReadOnlyStatements(input string) error {
+	stmts, _, err := parser.New().Parse(input, "", "")
+	if err != nil {
+		return ErrStatementNotReadOnly.Wrap(err, "Statements cannot be verified as read-only")
+	}
+	for _, stmt := range stmts {
+		if !isReadOnlyStatement(stmt) {
+			return ErrStatementNotReadOnly.New("Statement `%s` is not allowed in read-only sessions", quoteStatement(stmt.Text()))
+		}
+	}
+	return nil
+}
+
+// enforceReadOnlyConn makes the connection read-only on the TiDB side, as a second layer of protection besides
+// statement classification. Stale reads are always read-only, which is available since TiDB 5.4. An error is
+// returned if it cannot be enforced, since it is not known whether statements can modify data in this case.
+func enforceReadOnlyConn(ctx context.Context, conn *sql.Conn) error {
+	// Only a no-op when `tidb_enable_noop_functions` is on, and rejected otherwise, so that it is not relied on.
+	if _, err := conn.ExecContext(ctx, "SET SESSION transaction_read_only = 1"); err != nil {
+		log.Debug("Unable to set transaction_read_only on the TiDB connection", zap.Error(err))
+	}
+	if _, err := conn.ExecContext(ctx, "SET SESSION tidb_read_staleness = -1"); err != nil {
+		return ErrReadOnlyNotEnforced.Wrap(err, "Read-only sessions are not supported by the TiDB version")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"strings"
+
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testReadOnlySuite{})
+
+type testReadOnlySuite struct{}
+
+func (t *testReadOnlySuite) Test_checkReadOnlyStatements(c *C) {
+	allowed := []string{
+		"select * from mysql.user",
+		"select 1 union select 2",
+		"show processlist; show variables like 'tidb%'",
+		"explain select * from t",
+		"desc t",
+		"explain format = 'brief' delete from t",
+		"use test; select 1",
+		"select @a, @@tidb_mem_quota_query, lower('SLEEP')",
+		"explain select sleep(1)",
+	}
+	for _, input := range allowed {
+		c.Assert(checkReadOnlyStatements(input), IsNil, Commentf("%s", input))
+	}
+
+	rejected := map[string]string{
+		"select 1; delete from t where id = 1":             "delete from t where id = 1",
+		"insert into t values (1)":                         "insert into t values (1)",
+		"create table t2 (id int)":                         "create table t2 (id int)",
+		"explain analyze update t set a = 1":               "explain analyze update t set a = 1",
+		"select * from t for update":                       "select * from t for update",
+		"select * from t into outfile '/tmp/t'":            "select * from t into outfile '/tmp/t'",
+		"set session transaction_read_only = 0":            "set session transaction_read_only = 0",
+		"analyze table t":                                  "analyze table t",
+		"select 1 union select * from t for update":        "select 1 union select * from t for update",
+		"begin; select 1; commit":                          "begin",
+		"select get_lock('a', 10)":                         "select get_lock('a', 10)",
+		"select * from t where id in (select sleep(10))":   "select * from t where id in (select sleep(10))",
+		"select nextval(s)":                                "select nextval(s)",
+		"select 1 union select release_all_locks()":        "select 1 union select release_all_locks()",
+		"select @a := 1":                                   "select @a := 1",
+		"show tables where sleep(10)":                      "show tables where sleep(10)",
+		"not a valid statement":                            "cannot be verified",
+		"select 1; " + strings.Repeat("drop table t;", 20): "drop table t",
+	}
+	for input, msg := range rejected {
+		err := checkReadOnlyStatements(input)
+		c.Assert(errorx.IsOfType(err, ErrStatementNotReadOnly), IsTrue, Commentf("%s", input))
+		c.Assert(strings.Contains(err.Error(), msg), IsTrue, Commentf("%v", err))
+	}
+}
@@ -15,11 +15,11 @@ package queryeditor
 
 import (
 	"context"
-	"database/sql"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
 	"github.com/pingcap/log"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
@@ -27,148 +27,358 @@ import (
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
 	"github.com/pingcap/tidb-dashboard/pkg/tidb"
 )
 
+var (
+	ErrNS             = errorx.NewNamespace("error.api.query_editor")
+	ErrResultNotFound = ErrNS.NewType("result_not_found")
+	ErrTooManyResults = ErrNS.NewType("too_many_results")
+
+	ErrExecutionNotFound = ErrNS.NewType("execution_not_found")
+	ErrExecutionConflict = ErrNS.NewType("execution_conflict")
+)
+
 type ServiceParams struct {
 	fx.In
 	Config     *config.Config
 	TiDBClient *tidb.Client
+	LocalStore *dbstore.DB
 }
 
 type Service struct {
 	params       ServiceParams
 	lifecycleCtx context.Context
+	cursors      *cursorManager
+	executions   *executionRegistry
+	metadata     *metadataCache
 }
 
-func NewService(lc fx.Lifecycle, p ServiceParams) *Service {
-	service := &Service{params: p}
+func NewService(lc fx.Lifecycle, p ServiceParams) (*Service, error) {
+	if err := autoMigrate(p.LocalStore); err != nil {
+		return nil, err
+	}
+	service := &Service{params: p, cursors: newCursorManager(), executions: newExecutionRegistry(), metadata: newMetadataCache()}
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
 			service.lifecycleCtx = ctx
+			go service.cursors.gcLoop(ctx)
 			return nil
 		},
 	})
 
-	return service
+	return service, nil
 }
 
 func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint := r.Group("/query_editor")
+	endpoint.GET("/export/download", s.exportDownloadHandler)
 	endpoint.Use(auth.MWAuthRequired())
-	endpoint.Use(utils.MWConnectTiDB(s.params.TiDBClient))
 	endpoint.Use(utils.MWForbidByExperimentalFlag(s.params.Config.EnableExperimental))
-	endpoint.POST("/run", auth.MWRequireWritePriv(), s.runHandler)
+	// Open results hold their own connections, so that fetching pages does not need a new connection.
+	endpoint.GET("/results/:id", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.fetchResultHandler)
+	endpoint.DELETE("/results/:id", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.closeResultHandler)
+	endpoint.GET("/executions", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.listExecutionsHandler)
+	endpoint.POST("/executions/:id/cancel", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.cancelExecutionHandler)
+	endpoint.GET("/history", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.listHistoryHandler)
+	endpoint.DELETE("/history", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.clearHistoryHandler)
+	endpoint.DELETE("/history/:id", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.deleteHistoryHandler)
+	endpoint.GET("/saved_queries", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.listSavedQueriesHandler)
+	endpoint.POST("/saved_queries", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.createSavedQueryHandler)
+	endpoint.PUT("/saved_queries/:id", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.updateSavedQueryHandler)
+	endpoint.DELETE("/saved_queries/:id", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.deleteSavedQueryHandler)
+	endpoint.Use(utils.MWConnectTiDB(s.params.TiDBClient))
+	endpoint.POST("/run", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.runHandler)
+	endpoint.POST("/results", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.openResultHandler)
+	endpoint.POST("/saved_queries/:id/run", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.runSavedQueryHandler)
+	endpoint.GET("/metadata", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.getMetadataHandler)
+	endpoint.POST("/explain", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.explainHandler)
+	endpoint.POST("/export/token", auth.MWRequireAnyPermission(utils.PermQueryEditorRun, utils.PermQueryEditorRead), s.exportTokenHandler)
 }
 
 type RunRequest struct {
 	Statements string `json:"statements" example:"show databases;"`
 	MaxRows    int    `json:"max_rows" example:"1000"`
+	// Optional. The client may generate the execution ID, so that the execution can be cancelled before the response
+	// arrives. A random ID is generated if not specified.
+	ExecutionID string `json:"execution_id"`
 }
 
 type RunResponse struct {
-	ErrorMsg    string          `json:"error_msg"`
+	// The error of the first failed statement. Statements after it are not executed.
+	ErrorMsg    string `json:"error_msg"`
+	ExecutionID string `json:"execution_id"`
+	// The last result set, kept for clients not reading `results`.
 	ColumnNames []string        `json:"column_names"`
 	Rows        [][]interface{} `json:"rows"`
 	ExecutionMs int64           `json:"execution_ms"`
 	ActualRows  int             `json:"actual_rows"`
+	// Results of each executed statement.
+	Results []StatementResult `json:"results"`
 }
 
-func executeStatements(context context.Context, db *sql.DB, statements string) ([]string, [][]interface{}, error) {
-	rows, err := db.QueryContext(context, statements)
-	if err != nil {
-		return nil, nil, err
+// @ID queryEditorRun
+// @Summary Run statements
+// @Param request body RunRequest true "Request body"
+// @Success 200 {object} RunResponse
+// @Router /query_editor/run [post]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled"
+func (s *Service) runHandler(c *gin.Context) {
+	var req RunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
 	}
+	s.run(c, &req)
+}
 
-	defer rows.Close()
+// run executes the statements and writes the response. The execution is recorded in the query history.
+func (s *Service) run(c *gin.Context, req *RunRequest) {
+	ctx, cancel := context.WithTimeout(s.lifecycleCtx, time.Minute*5)
+	defer cancel()
 
-	colNames, err := rows.Columns()
-	if err != nil {
-		return nil, nil, err
+	readOnly := isReadOnlySession(c)
+	if readOnly {
+		if err := checkReadOnlyStatements(req.Statements); err != nil {
+			_ = c.Error(err)
+			c.Status(http.StatusForbidden)
+			return
+		}
 	}
 
-	retRows := make([][]interface{}, 0)
-
-	values := make([]sql.RawBytes, len(colNames))
-	scanArgs := make([]interface{}, len(values))
-	for i := range values {
-		scanArgs[i] = &values[i]
+	startTime := time.Now()
+	sqlDB, err := utils.GetTiDBConnection(c).DB()
+	if err != nil {
+		panic(err)
 	}
-
-	for rows.Next() {
-		err = rows.Scan(scanArgs...)
-		if err != nil {
-			return nil, nil, err
+	exec, err := prepareExecution(ctx, sqlDB, req.ExecutionID, sessionOwner(c), req.Statements, readOnly)
+	if err != nil {
+		resp := RunResponse{
+			ErrorMsg:    err.Error(),
+			ExecutionID: req.ExecutionID,
+			ExecutionMs: time.Since(startTime).Milliseconds(),
 		}
+		s.recordHistory(c, req.Statements, &resp)
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	defer exec.close()
+	if err := s.executions.add(exec); err != nil {
+		_ = c.Error(err)
+		c.Status(http.StatusConflict)
+		return
+	}
+	defer s.executions.remove(exec.id)
 
-		retRow := make([]interface{}, 0, len(values))
-		var value interface{}
-		for _, col := range values {
-			if col == nil {
-				value = nil
-			} else {
-				value = string(col)
-			}
-			retRow = append(retRow, value)
+	results := executeStatements(ctx, exec.conn, req.Statements, req.MaxRows)
+	resp := RunResponse{
+		ExecutionID: exec.id,
+		ExecutionMs: time.Since(startTime).Milliseconds(),
+		Results:     results,
+	}
+	for _, r := range results {
+		if r.ErrorMsg != "" {
+			log.Warn("Failed to execute user input statements", zap.String("statements", r.Statement), zap.String("error", r.ErrorMsg))
+			resp.ErrorMsg = r.ErrorMsg
+		}
+		if r.Columns != nil {
+			resp.ColumnNames = columnNames(r.Columns)
+			resp.Rows = r.Rows
+			resp.ActualRows = r.ActualRows
 		}
-		retRows = append(retRows, retRow)
 	}
+	s.recordHistory(c, req.Statements, &resp)
+	c.JSON(http.StatusOK, resp)
+}
 
-	if err = rows.Err(); err != nil {
-		return nil, nil, err
+// @ID queryEditorListExecutions
+// @Summary List running executions of the current session
+// @Success 200 {array} ExecutionInfo
+// @Router /query_editor/executions [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled"
+func (s *Service) listExecutionsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, s.executions.list(sessionOwner(c)))
+}
+
+// @ID queryEditorCancelExecution
+// @Summary Cancel a running execution by killing its query
+// @Param id path string true "Execution ID"
+// @Success 200 {string} string
+// @Router /query_editor/executions/{id}/cancel [post]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled"
+// @Failure 404 {object} utils.APIError "Execution not found"
+func (s *Service) cancelExecutionHandler(c *gin.Context) {
+	exec, err := s.executions.get(c.Param("id"), sessionOwner(c))
+	if err != nil {
+		_ = c.Error(err)
+		c.Status(http.StatusNotFound)
+		return
+	}
+	if err := exec.kill(); err != nil {
+		// The context is cancelled anyway, so the request returns soon.
+		log.Warn("Failed to kill query", zap.Int64("connectionID", exec.connectionID), zap.Error(err))
 	}
+	c.JSON(http.StatusOK, "success")
+}
 
-	return colNames, retRows, nil
+// sessionOwner identifies the session owning a cursor or an execution. They can only be accessed by the same session.
+func sessionOwner(c *gin.Context) string {
+	sessionUser := utils.GetSession(c)
+	if sessionUser.SessionID != "" {
+		return sessionUser.SessionID
+	}
+	return sessionUser.Owner
 }
 
-// @ID queryEditorRun
-// @Summary Run statements
-// @Param request body RunRequest true "Request body"
-// @Success 200 {object} RunResponse
-// @Router /query_editor/run [post]
+func normalizePageSize(pageSize int) int {
+	if pageSize <= 0 {
+		return defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		return maxPageSize
+	}
+	return pageSize
+}
+
+type OpenResultRequest struct {
+	Statements string `json:"statements" example:"select * from mysql.user;"`
+	PageSize   int    `json:"page_size" example:"1000"`
+}
+
+// @ID queryEditorOpenResult
+// @Summary Run a statement and keep the result open for paginated fetching. The first page is returned.
+// @Description Exactly one statement is expected. The result is closed automatically after all rows are fetched, or after being idle for a while.
+// @Param request body OpenResultRequest true "Request body"
+// @Success 200 {object} CursorPage
+// @Router /query_editor/results [post]
 // @Security JwtAuth
 // @Failure 400 {object} utils.APIError "Bad request"
 // @Failure 401 {object} utils.APIError "Unauthorized failure"
 // @Failure 403 {object} utils.APIError "Experimental feature not enabled"
-func (s *Service) runHandler(c *gin.Context) {
-	var req RunRequest
+// @Failure 429 {object} utils.APIError "Too many open results"
+func (s *Service) openResultHandler(c *gin.Context) {
+	var req OpenResultRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		utils.MakeInvalidRequestErrorFromError(c, err)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(s.lifecycleCtx, time.Minute*5)
-	defer cancel()
+	readOnly := isReadOnlySession(c)
+	if readOnly {
+		if err := checkReadOnlyStatements(req.Statements); err != nil {
+			_ = c.Error(err)
+			c.Status(http.StatusForbidden)
+			return
+		}
+	}
 
-	startTime := time.Now()
-	sqlDB, err := utils.GetTiDBConnection(c).DB()
+	// The connection is owned by the cursor from now on, and is closed when the cursor is closed.
+	db := utils.TakeTiDBConnection(c)
+	sqlDB, err := db.DB()
 	if err != nil {
 		panic(err)
 	}
-	colNames, rows, err := executeStatements(ctx, sqlDB, req.Statements)
-	elapsedTime := time.Since(startTime)
 
+	startTime := time.Now()
+	cur, err := openCursor(s.lifecycleCtx, sqlDB, sessionOwner(c), req.Statements, readOnly)
 	if err != nil {
+		_ = sqlDB.Close()
 		log.Warn("Failed to execute user input statements", zap.String("statements", req.Statements), zap.Error(err))
-		c.JSON(http.StatusOK, RunResponse{
+		c.JSON(http.StatusOK, CursorPage{
 			ErrorMsg:    err.Error(),
-			ColumnNames: nil,
-			Rows:        nil,
-			ExecutionMs: elapsedTime.Milliseconds(),
-			ActualRows:  0,
+			ExecutionMs: time.Since(startTime).Milliseconds(),
 		})
 		return
 	}
+	if err := s.cursors.add(cur); err != nil {
+		cur.close()
+		_ = c.Error(err)
+		c.Status(http.StatusTooManyRequests)
+		return
+	}
+
+	s.respondPage(c, cur, req.PageSize, startTime)
+}
+
+type FetchResultRequest struct {
+	PageSize int `json:"page_size" form:"page_size"`
+}
+
+// @ID queryEditorFetchResult
+// @Summary Fetch the next page of an open result
+// @Param id path string true "Result ID"
+// @Param q query FetchResultRequest true "Query"
+// @Success 200 {object} CursorPage
+// @Router /query_editor/results/{id} [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled"
+// @Failure 404 {object} utils.APIError "Result not found"
+func (s *Service) fetchResultHandler(c *gin.Context) {
+	var req FetchResultRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	cur, err := s.cursors.get(c.Param("id"), sessionOwner(c))
+	if err != nil {
+		_ = c.Error(err)
+		c.Status(http.StatusNotFound)
+		return
+	}
+	s.respondPage(c, cur, req.PageSize, time.Now())
+}
 
-	truncatedRows := rows
-	if len(truncatedRows) > req.MaxRows {
-		truncatedRows = truncatedRows[:req.MaxRows]
+func (s *Service) respondPage(c *gin.Context, cur *cursor, pageSize int, startTime time.Time) {
+	page, err := cur.fetch(normalizePageSize(pageSize))
+	if err != nil {
+		s.cursors.remove(cur.id)
+		if errorx.IsOfType(err, ErrResultNotFound) {
+			_ = c.Error(err)
+			c.Status(http.StatusNotFound)
+			return
+		}
+		c.JSON(http.StatusOK, CursorPage{
+			ResultID:    cur.id,
+			ErrorMsg:    err.Error(),
+			ExecutionMs: time.Since(startTime).Milliseconds(),
+		})
+		return
 	}
+	if !page.HasMore {
+		s.cursors.remove(cur.id)
+	}
+	page.Columns = cur.columns
+	page.ColumnNames = columnNames(cur.columns)
+	page.ExecutionMs = time.Since(startTime).Milliseconds()
+	c.JSON(http.StatusOK, page)
+}
 
-	c.JSON(http.StatusOK, RunResponse{
-		ColumnNames: colNames,
-		Rows:        truncatedRows,
-		ExecutionMs: elapsedTime.Milliseconds(),
-		ActualRows:  len(rows),
-	})
+// @ID queryEditorCloseResult
+// @Summary Close an open result and release its resources
+// @Param id path string true "Result ID"
+// @Success 200 {string} string
+// @Router /query_editor/results/{id} [delete]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Experimental feature not enabled"
+// @Failure 404 {object} utils.APIError "Result not found"
+func (s *Service) closeResultHandler(c *gin.Context) {
+	cur, err := s.cursors.get(c.Param("id"), sessionOwner(c))
+	if err != nil {
+		_ = c.Error(err)
+		c.Status(http.StatusNotFound)
+		return
+	}
+	s.cursors.remove(cur.id)
+	cur.close()
+	c.JSON(http.StatusOK, "success")
 }
@@ -0,0 +1,232 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/log"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	_ "github.com/pingcap/parser/test_driver" // required by the parser to build value expressions
+	"go.uber.org/zap"
+)
+
+// queryer is implemented by both *sql.DB and *sql.Conn.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type ColumnInfo struct {
+	Name string `json:"name"`
+	// The database type name reported by the driver, e.g. `BIGINT`, `UNSIGNED INT`, `VARCHAR`, `JSON`.
+	DatabaseType string `json:"database_type"`
+	Nullable     bool   `json:"nullable"`
+}
+
+func columnNames(cols []ColumnInfo) []string {
+	names := make([]string, 0, len(cols))
+	for _, col := range cols {
+		names = append(names, col.Name)
+	}
+	return names
+}
+
+type Warning struct {
+	Level   string `json:"level"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type StatementResult struct {
+	Statement string `json:"statement"`
+	ErrorMsg  string `json:"error_msg"`
+	// Only available for statements returning rows.
+	Columns []ColumnInfo    `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+	// Number of rows returned. Only the first `max_rows` rows are included in `rows`.
+	ActualRows int `json:"actual_rows"`
+	// Only available for statements not returning rows.
+	AffectedRows int64     `json:"affected_rows"`
+	Warnings     []Warning `json:"warnings"`
+	ExecutionMs  int64     `json:"execution_ms"`
+}
+
+// splitStatements splits the input into statements using the TiDB parser. If the input cannot be parsed, it is
+// returned as a single statement, so that the error is reported by TiDB itself.
+func splitStatements(input string) []ast.StmtNode {
+	stmts, _, err := parser.New().Parse(input, "", "")
+	if err != nil || len(stmts) == 0 {
+		return nil
+	}
+	return stmts
+}
+
+// returnsRows returns whether the statement produces a result set.
+func returnsRows(stmt ast.StmtNode) bool {
+	switch stmt.(type) {
+	case *ast.SelectStmt, *ast.SetOprStmt, *ast.ShowStmt, *ast.ExplainStmt, *ast.ExplainForStmt, *ast.TraceStmt,
+		*ast.AdminStmt, *ast.ExecuteStmt:
+		return true
+	}
+	return false
+}
+
+// executeStatements executes statements one by one. Execution stops at the first failed statement. At most maxRows
+// rows of each result set are kept in memory, remaining rows are only counted.
+func executeStatements(ctx context.Context, db queryer, input string, maxRows int) []StatementResult {
+	stmts := splitStatements(input)
+	if stmts == nil {
+		return []StatementResult{executeStatement(ctx, db, strings.TrimSpace(input), true, maxRows)}
+	}
+	results := make([]StatementResult, 0, len(stmts))
+	for _, stmt := range stmts {
+		// The text may include the trailing delimiter
+		text := strings.TrimRight(stmt.Text(), "; \t\r\n")
+		r := executeStatement(ctx, db, strings.TrimSpace(text), returnsRows(stmt), maxRows)
+		results = append(results, r)
+		if r.ErrorMsg != "" {
+			break
+		}
+	}
+	return results
+}
+
+func executeStatement(ctx context.Context, db queryer, stmt string, withRows bool, maxRows int) StatementResult {
+	startTime := time.Now()
+	r := StatementResult{Statement: stmt}
+	var err error
+	if withRows {
+		err = queryRows(ctx, db, stmt, maxRows, &r)
+	} else {
+		var result sql.Result
+		result, err = db.ExecContext(ctx, stmt)
+		if err == nil {
+			r.AffectedRows, _ = result.RowsAffected()
+		}
+	}
+	r.ExecutionMs = time.Since(startTime).Milliseconds()
+	if err != nil {
+		r.ErrorMsg = err.Error()
+		r.Columns = nil
+		r.Rows = nil
+		return r
+	}
+	r.Warnings = showWarnings(ctx, db)
+	return r
+}
+
+func queryRows(ctx context.Context, db queryer, stmt string, maxRows int, r *StatementResult) error {
+	rows, err := db.QueryContext(ctx, stmt)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	r.Columns = make([]ColumnInfo, 0, len(colTypes))
+	for _, ct := range colTypes {
+		nullable, _ := ct.Nullable()
+		r.Columns = append(r.Columns, ColumnInfo{
+			Name:         ct.Name(),
+			DatabaseType: ct.DatabaseTypeName(),
+			Nullable:     nullable,
+		})
+	}
+
+	values := make([]sql.RawBytes, len(colTypes))
+	scanArgs := make([]interface{}, len(values))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	r.Rows = make([][]interface{}, 0)
+	for rows.Next() {
+		r.ActualRows++
+		if len(r.Rows) >= maxRows {
+			continue
+		}
+		if err := rows.Scan(scanArgs...); err != nil {
+			return err
+		}
+		r.Rows = append(r.Rows, encodeRow(r.Columns, values))
+	}
+	return rows.Err()
+}
+
+func showWarnings(ctx context.Context, db queryer) []Warning {
+	rows, err := db.QueryContext(ctx, "SHOW WARNINGS")
+	if err != nil {
+		log.Debug("Failed to show warnings", zap.Error(err))
+		return nil
+	}
+	defer rows.Close()
+	warnings := make([]Warning, 0)
+	for rows.Next() {
+		var w Warning
+		if err := rows.Scan(&w.Level, &w.Code, &w.Message); err != nil {
+			log.Debug("Failed to read warnings", zap.Error(err))
+			return nil
+		}
+		warnings = append(warnings, w)
+	}
+	return warnings
+}
+
+func encodeRow(cols []ColumnInfo, values []sql.RawBytes) []interface{} {
+	row := make([]interface{}, 0, len(values))
+	for i, v := range values {
+		row = append(row, encodeValue(cols[i].DatabaseType, v))
+	}
+	return row
+}
+
+// encodeValue converts a raw value according to the column type. Integers and floats are encoded as JSON numbers,
+// binary values as hex strings with the `0x` prefix, and JSON values as is. Other values, including decimals which
+// may not be precisely represented by JSON numbers, are encoded as strings.
+func encodeValue(databaseType string, v sql.RawBytes) interface{} {
+	if v == nil {
+		return nil
+	}
+	s := string(v)
+	switch strings.TrimPrefix(databaseType, "UNSIGNED ") {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "YEAR":
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return json.Number(s)
+		}
+		if _, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return json.Number(s)
+		}
+	case "FLOAT", "DOUBLE":
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			return json.Number(s)
+		}
+	case "BINARY", "VARBINARY", "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BIT", "GEOMETRY":
+		return "0x" + hex.EncodeToString(v)
+	case "JSON":
+		if json.Valid(v) {
+			return json.RawMessage(s)
+		}
+	}
+	return s
+}
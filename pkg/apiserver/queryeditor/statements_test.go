@@ -0,0 +1,70 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package queryeditor
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testStatementsSuite{})
+
+type testStatementsSuite struct{}
+
+func (t *testStatementsSuite) Test_splitStatements(c *C) {
+	stmts := splitStatements("use test; select 1, ';' ;\n insert into t values (1)")
+	c.Assert(stmts, HasLen, 3)
+	c.Assert(strings.TrimSpace(stmts[1].Text()), Equals, "select 1, ';' ;")
+	c.Assert(returnsRows(stmts[0]), IsFalse)
+	c.Assert(returnsRows(stmts[1]), IsTrue)
+	c.Assert(returnsRows(stmts[2]), IsFalse)
+
+	c.Assert(splitStatements("select from where"), IsNil)
+}
+
+func (t *testStatementsSuite) Test_encodeValue(c *C) {
+	c.Assert(encodeValue("BIGINT", nil), IsNil)
+	c.Assert(encodeValue("BIGINT", []byte("-42")), Equals, json.Number("-42"))
+	c.Assert(encodeValue("UNSIGNED BIGINT", []byte("18446744073709551615")), Equals, json.Number("18446744073709551615"))
+	c.Assert(encodeValue("DOUBLE", []byte("1.5e-3")), Equals, json.Number("1.5e-3"))
+	c.Assert(encodeValue("DECIMAL", []byte("1.10")), Equals, "1.10")
+	c.Assert(encodeValue("VARBINARY", []byte{0x01, 0xab}), Equals, "0x01ab")
+	c.Assert(encodeValue("JSON", []byte(`{"a": [1]}`)), DeepEquals, json.RawMessage(`{"a": [1]}`))
+	c.Assert(encodeValue("VARCHAR", []byte("abc")), Equals, "abc")
+}
+
+func (t *testStatementsSuite) Test_executeStatements(c *C) {
+	db := openTestDB(c, 5)
+	defer db.Close()
+
+	results := executeStatements(context.Background(), db, "UPDATE t SET name = 'x' WHERE id <= 2; SELECT id, name FROM t ORDER BY id", 3)
+	c.Assert(results, HasLen, 2)
+	c.Assert(results[0].ErrorMsg, Equals, "")
+	c.Assert(results[0].AffectedRows, Equals, int64(2))
+	c.Assert(results[0].Columns, IsNil)
+	c.Assert(results[1].Statement, Equals, "SELECT id, name FROM t ORDER BY id")
+	c.Assert(columnNames(results[1].Columns), DeepEquals, []string{"id", "name"})
+	c.Assert(results[1].Rows, HasLen, 3)
+	c.Assert(results[1].Rows[0][1], Equals, "x")
+	c.Assert(results[1].Rows[2][1], IsNil)
+	c.Assert(results[1].ActualRows, Equals, 5)
+
+	// Execution stops at the first failed statement
+	results = executeStatements(context.Background(), db, "SELECT * FROM not_exist; SELECT 1", 3)
+	c.Assert(results, HasLen, 1)
+	c.Assert(results[0].ErrorMsg, Not(Equals), "")
+}
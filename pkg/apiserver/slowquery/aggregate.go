@@ -0,0 +1,212 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slowquery
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/thoas/go-funk"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultAggregationLimit = 100
+	maxAggregationLimit     = 1000
+)
+
+var ErrUnknownGroupBy = ErrNS.NewType("unknown_group_by")
+
+// aggregationGroups maps group by names to columns of the slow query table.
+var aggregationGroups = map[string]string{
+	"digest":   "Digest",
+	"user":     "User",
+	"db":       "DB",
+	"instance": "INSTANCE",
+	"host":     "Host",
+}
+
+// aggregationMetrics maps metric names to columns of the slow query table. Each metric is aggregated into sum, avg,
+// max, p95 and p99, e.g. `sum_query_time`.
+var aggregationMetrics = []struct {
+	name   string
+	column string
+}{
+	{"query_time", "Query_time"},
+	{"process_time", "Process_time"},
+	{"wait_time", "Wait_time"},
+	{"process_keys", "Process_keys"},
+	{"memory_max", "Mem_max"},
+}
+
+type GetAggregationRequest struct {
+	BeginTime int      `json:"begin_time" form:"begin_time"`
+	EndTime   int      `json:"end_time" form:"end_time"`
+	DB        []string `json:"db" form:"db"`
+	// One of `digest`, `user`, `db`, `instance` and `host`.
+	GroupBy string `json:"group_by" form:"group_by"`
+	// One of `count` and aggregated fields, e.g. `sum_query_time`, `p99_process_time`. Default to `sum_query_time`.
+	OrderBy string `json:"orderBy" form:"orderBy"`
+	IsDesc  bool   `json:"desc" form:"desc"`
+	// Default to 100, at most 1000.
+	Limit int `json:"limit" form:"limit"`
+}
+
+type AggregationItem struct {
+	GroupKey string `gorm:"column:group_key" json:"group_key"`
+	// A sample query of the digest, only available when grouped by digest.
+	SampleQuery string `gorm:"column:sample_query" json:"sample_query"`
+	Count       int    `gorm:"column:count" json:"count"`
+
+	SumQueryTime float64  `gorm:"column:sum_query_time" json:"sum_query_time"`
+	AvgQueryTime float64  `gorm:"column:avg_query_time" json:"avg_query_time"`
+	MaxQueryTime float64  `gorm:"column:max_query_time" json:"max_query_time"`
+	P95QueryTime *float64 `gorm:"column:p95_query_time" json:"p95_query_time"`
+	P99QueryTime *float64 `gorm:"column:p99_query_time" json:"p99_query_time"`
+
+	SumProcessTime float64  `gorm:"column:sum_process_time" json:"sum_process_time"`
+	AvgProcessTime float64  `gorm:"column:avg_process_time" json:"avg_process_time"`
+	MaxProcessTime float64  `gorm:"column:max_process_time" json:"max_process_time"`
+	P95ProcessTime *float64 `gorm:"column:p95_process_time" json:"p95_process_time"`
+	P99ProcessTime *float64 `gorm:"column:p99_process_time" json:"p99_process_time"`
+
+	SumWaitTime float64  `gorm:"column:sum_wait_time" json:"sum_wait_time"`
+	AvgWaitTime float64  `gorm:"column:avg_wait_time" json:"avg_wait_time"`
+	MaxWaitTime float64  `gorm:"column:max_wait_time" json:"max_wait_time"`
+	P95WaitTime *float64 `gorm:"column:p95_wait_time" json:"p95_wait_time"`
+	P99WaitTime *float64 `gorm:"column:p99_wait_time" json:"p99_wait_time"`
+
+	SumProcessKeys float64  `gorm:"column:sum_process_keys" json:"sum_process_keys"`
+	AvgProcessKeys float64  `gorm:"column:avg_process_keys" json:"avg_process_keys"`
+	MaxProcessKeys float64  `gorm:"column:max_process_keys" json:"max_process_keys"`
+	P95ProcessKeys *float64 `gorm:"column:p95_process_keys" json:"p95_process_keys"`
+	P99ProcessKeys *float64 `gorm:"column:p99_process_keys" json:"p99_process_keys"`
+
+	SumMemoryMax float64  `gorm:"column:sum_memory_max" json:"sum_memory_max"`
+	AvgMemoryMax float64  `gorm:"column:avg_memory_max" json:"avg_memory_max"`
+	MaxMemoryMax float64  `gorm:"column:max_memory_max" json:"max_memory_max"`
+	P95MemoryMax *float64 `gorm:"column:p95_memory_max" json:"p95_memory_max"`
+	P99MemoryMax *float64 `gorm:"column:p99_memory_max" json:"p99_memory_max"`
+}
+
+// genAggregationSelectStmt generates the select list of the aggregation. Metrics whose columns do not exist in the
+// current TiDB version are selected as NULL. Percentiles rely on `APPROX_PERCENTILE`, which is only available since
+// TiDB 5.0, so that they can be excluded.
+func genAggregationSelectStmt(tableColumns []string, groupBy string, withPercentiles bool) (string, error) {
+	groupColumn, ok := aggregationGroups[groupBy]
+	if !ok {
+		return "", ErrUnknownGroupBy.New("unknown group by %s", groupBy)
+	}
+	fields := []string{
+		fmt.Sprintf("%s AS group_key", groupColumn),
+		"COUNT(*) AS count",
+	}
+	if groupBy == "digest" {
+		fields = append(fields, "ANY_VALUE(Query) AS sample_query")
+	}
+	for _, m := range aggregationMetrics {
+		if !funk.Contains(tableColumns, m.column) {
+			for _, agg := range []string{"sum", "avg", "max", "p95", "p99"} {
+				fields = append(fields, fmt.Sprintf("NULL AS %s_%s", agg, m.name))
+			}
+			continue
+		}
+		fields = append(fields,
+			fmt.Sprintf("SUM(%s) AS sum_%s", m.column, m.name),
+			fmt.Sprintf("AVG(%s) AS avg_%s", m.column, m.name),
+			fmt.Sprintf("MAX(%s) AS max_%s", m.column, m.name))
+		for _, p := range []int{95, 99} {
+			if withPercentiles {
+				fields = append(fields, fmt.Sprintf("APPROX_PERCENTILE(%s, %d) AS p%d_%s", m.column, p, p, m.name))
+			} else {
+				fields = append(fields, fmt.Sprintf("NULL AS p%d_%s", p, m.name))
+			}
+		}
+	}
+	return strings.Join(fields, ", "), nil
+}
+
+// genAggregationOrderStmt only allows ordering by aggregated fields, which are selected with known aliases.
+func genAggregationOrderStmt(orderBy string, isDesc bool) (string, error) {
+	if orderBy == "" {
+		orderBy = "sum_query_time"
+	}
+	valid := orderBy == "count"
+	for _, m := range aggregationMetrics {
+		for _, agg := range []string{"sum", "avg", "max", "p95", "p99"} {
+			if orderBy == fmt.Sprintf("%s_%s", agg, m.name) {
+				valid = true
+			}
+		}
+	}
+	if !valid {
+		return "", ErrUnknownColumn.New("unknown order by %s", orderBy)
+	}
+	if isDesc {
+		return fmt.Sprintf("%s DESC", orderBy), nil
+	}
+	return fmt.Sprintf("%s ASC", orderBy), nil
+}
+
+// isPercentileUnsupported returns whether the error is caused by `APPROX_PERCENTILE` not supported in TiDB.
+func isPercentileUnsupported(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "approx_percentile")
+}
+
+func (s *Service) querySlowLogAggregation(db *gorm.DB, req *GetAggregationRequest) ([]AggregationItem, error) {
+	tableColumns, err := s.params.SysSchema.GetTableColumnNames(db, slowQueryTable)
+	if err != nil {
+		return nil, err
+	}
+	orderStmt, err := genAggregationOrderStmt(req.OrderBy, req.IsDesc)
+	if err != nil {
+		return nil, err
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultAggregationLimit
+	} else if limit > maxAggregationLimit {
+		limit = maxAggregationLimit
+	}
+
+	query := func(withPercentiles bool) ([]AggregationItem, error) {
+		selectStmt, err := genAggregationSelectStmt(tableColumns, req.GroupBy, withPercentiles)
+		if err != nil {
+			return nil, err
+		}
+		tx := db.
+			Table(slowQueryTable).
+			Select(selectStmt).
+			Where("Time BETWEEN FROM_UNIXTIME(?) AND FROM_UNIXTIME(?)", req.BeginTime, req.EndTime)
+		if len(req.DB) > 0 {
+			tx = tx.Where("DB IN (?)", req.DB)
+		}
+		var results []AggregationItem
+		err = tx.
+			Group(aggregationGroups[req.GroupBy]).
+			Order(orderStmt).
+			Limit(limit).
+			Scan(&results).Error
+		return results, err
+	}
+
+	results, err := query(true)
+	if isPercentileUnsupported(err) {
+		results, err = query(false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
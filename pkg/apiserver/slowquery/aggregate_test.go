@@ -0,0 +1,60 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slowquery
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+)
+
+func TestT(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&testAggregateSuite{})
+
+type testAggregateSuite struct{}
+
+func (t *testAggregateSuite) Test_genAggregationSelectStmt(c *C) {
+	columns := []string{"Digest", "Query", "Query_time", "Process_time", "Wait_time", "Process_keys"}
+	stmt, err := genAggregationSelectStmt(columns, "digest", true)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(stmt, "Digest AS group_key, COUNT(*) AS count, ANY_VALUE(Query) AS sample_query, "), IsTrue)
+	c.Assert(strings.Contains(stmt, "APPROX_PERCENTILE(Query_time, 99) AS p99_query_time"), IsTrue)
+	// Mem_max does not exist
+	c.Assert(strings.Contains(stmt, "NULL AS max_memory_max"), IsTrue)
+
+	stmt, err = genAggregationSelectStmt(columns, "user", false)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(stmt, "sample_query"), IsFalse)
+	c.Assert(strings.Contains(stmt, "APPROX_PERCENTILE"), IsFalse)
+	c.Assert(strings.Contains(stmt, "NULL AS p95_wait_time"), IsTrue)
+
+	_, err = genAggregationSelectStmt(columns, "Query", true)
+	c.Assert(errorx.IsOfType(err, ErrUnknownGroupBy), IsTrue)
+}
+
+func (t *testAggregateSuite) Test_genAggregationOrderStmt(c *C) {
+	order, err := genAggregationOrderStmt("", true)
+	c.Assert(err, IsNil)
+	c.Assert(order, Equals, "sum_query_time DESC")
+	order, err = genAggregationOrderStmt("p99_memory_max", false)
+	c.Assert(err, IsNil)
+	c.Assert(order, Equals, "p99_memory_max ASC")
+	_, err = genAggregationOrderStmt("Query_time; DROP TABLE t", false)
+	c.Assert(errorx.IsOfType(err, ErrUnknownColumn), IsTrue)
+}
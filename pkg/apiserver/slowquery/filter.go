@@ -0,0 +1,243 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slowquery
+
+import (
+	"strings"
+
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/opcode"
+	"github.com/pingcap/parser/test_driver"
+)
+
+const maxFilterLength = 4096
+
+var ErrInvalidFilter = ErrNS.NewType("invalid_filter")
+
+// filterOperators are binary operators allowed in filters.
+var filterOperators = map[opcode.Op]string{
+	opcode.LogicAnd: "AND",
+	opcode.LogicOr:  "OR",
+	opcode.EQ:       "=",
+	opcode.NE:       "!=",
+	opcode.LT:       "<",
+	opcode.LE:       "<=",
+	opcode.GT:       ">",
+	opcode.GE:       ">=",
+}
+
+// filterCompiler compiles a filter expression into a WHERE clause. Only a safe subset of SQL expressions is
+// allowed. Field names are resolved against the model, and all values are passed as parameters.
+type filterCompiler struct {
+	// fields maps lower case column names and JSON names to expressions of the field.
+	fields map[string]string
+	sb     strings.Builder
+	args   []interface{}
+}
+
+// tidbFilterFields returns expressions of fields available in the slow query table of the current TiDB version.
+func tidbFilterFields(tableColumns []string) map[string]string {
+	fields := make(map[string]string)
+	for _, f := range getFieldsAndTags() {
+		expr := f.ColumnName
+		if f.Projection != "" {
+			expr = f.Projection
+		} else if !containsFold(tableColumns, f.ColumnName) {
+			// Not available in the current TiDB version
+			continue
+		}
+		fields[strings.ToLower(f.ColumnName)] = expr
+		fields[strings.ToLower(f.JSONName)] = expr
+	}
+	return fields
+}
+
+// compileFilter compiles a filter like `Query_time > 2 AND User = 'app'` into a parameterized WHERE clause. Fields
+// can be referenced by either the column name or the JSON name of the model, which are resolved by the fields map,
+// see tidbFilterFields.
+func compileFilter(filter string, fields map[string]string) (string, []interface{}, error) {
+	if len(filter) > maxFilterLength {
+		return "", nil, ErrInvalidFilter.New("filter is longer than %d characters", maxFilterLength)
+	}
+	stmt, err := parser.New().ParseOneStmt("SELECT 1 FROM t WHERE "+filter, "", "")
+	if err != nil {
+		return "", nil, ErrInvalidFilter.Wrap(err, "invalid filter")
+	}
+	sel, ok := stmt.(*ast.SelectStmt)
+	// Reject input that closes the WHERE clause, e.g. `1 ORDER BY ...`, or `1 UNION SELECT ...`
+	if !ok || sel.Where == nil || sel.OrderBy != nil || sel.Limit != nil || sel.GroupBy != nil ||
+		sel.Having != nil || sel.LockInfo != nil || sel.SelectIntoOpt != nil || sel.WindowSpecs != nil {
+		return "", nil, ErrInvalidFilter.New("filter should be a boolean expression")
+	}
+
+	c := &filterCompiler{fields: fields}
+	if err := c.compile(sel.Where); err != nil {
+		return "", nil, err
+	}
+	return c.sb.String(), c.args, nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *filterCompiler) compile(node ast.ExprNode) error {
+	switch n := node.(type) {
+	case *ast.BinaryOperationExpr:
+		op, ok := filterOperators[n.Op]
+		if !ok {
+			return ErrInvalidFilter.New("operator %s is not supported", n.Op)
+		}
+		c.sb.WriteString("(")
+		if err := c.compile(n.L); err != nil {
+			return err
+		}
+		c.sb.WriteString(" " + op + " ")
+		if err := c.compile(n.R); err != nil {
+			return err
+		}
+		c.sb.WriteString(")")
+	case *ast.UnaryOperationExpr:
+		switch n.Op {
+		case opcode.Not:
+			c.sb.WriteString("NOT ")
+		case opcode.Minus:
+			c.sb.WriteString("-")
+		default:
+			return ErrInvalidFilter.New("operator %s is not supported", n.Op)
+		}
+		c.sb.WriteString("(")
+		if err := c.compile(n.V); err != nil {
+			return err
+		}
+		c.sb.WriteString(")")
+	case *ast.ParenthesesExpr:
+		return c.compile(n.Expr)
+	case *ast.PatternInExpr:
+		if n.Sel != nil {
+			return ErrInvalidFilter.New("subqueries are not supported")
+		}
+		c.sb.WriteString("(")
+		if err := c.compile(n.Expr); err != nil {
+			return err
+		}
+		if n.Not {
+			c.sb.WriteString(" NOT")
+		}
+		c.sb.WriteString(" IN (")
+		for i, item := range n.List {
+			if i > 0 {
+				c.sb.WriteString(", ")
+			}
+			if err := c.compile(item); err != nil {
+				return err
+			}
+		}
+		c.sb.WriteString("))")
+	case *ast.BetweenExpr:
+		c.sb.WriteString("(")
+		if err := c.compile(n.Expr); err != nil {
+			return err
+		}
+		if n.Not {
+			c.sb.WriteString(" NOT")
+		}
+		c.sb.WriteString(" BETWEEN ")
+		if err := c.compile(n.Left); err != nil {
+			return err
+		}
+		c.sb.WriteString(" AND ")
+		if err := c.compile(n.Right); err != nil {
+			return err
+		}
+		c.sb.WriteString(")")
+	case *ast.PatternLikeExpr:
+		c.sb.WriteString("(")
+		if err := c.compile(n.Expr); err != nil {
+			return err
+		}
+		if n.Not {
+			c.sb.WriteString(" NOT")
+		}
+		c.sb.WriteString(" LIKE ")
+		if err := c.compile(n.Pattern); err != nil {
+			return err
+		}
+		// TiDB only accepts a string literal as the escape character, so the
+		// character is validated and inlined instead of bound as an argument.
+		if n.Escape != '\\' {
+			if n.Escape < '!' || n.Escape > '~' || n.Escape == '\'' {
+				return ErrInvalidFilter.New("unsupported escape character %q", n.Escape)
+			}
+			c.sb.WriteString(" ESCAPE '")
+			c.sb.WriteByte(n.Escape)
+			c.sb.WriteString("'")
+		}
+		c.sb.WriteString(")")
+	case *ast.IsNullExpr:
+		c.sb.WriteString("(")
+		if err := c.compile(n.Expr); err != nil {
+			return err
+		}
+		if n.Not {
+			c.sb.WriteString(" IS NOT NULL)")
+		} else {
+			c.sb.WriteString(" IS NULL)")
+		}
+	case *ast.ColumnNameExpr:
+		if n.Name.Schema.O != "" || n.Name.Table.O != "" {
+			return ErrInvalidFilter.New("qualified field %s is not supported", n.Name.OrigColName())
+		}
+		expr, ok := c.fields[n.Name.Name.L]
+		if !ok {
+			return ErrInvalidFilter.New("unknown field %s", n.Name.Name.O)
+		}
+		c.sb.WriteString(expr)
+	case *test_driver.ValueExpr:
+		v, err := filterValue(n)
+		if err != nil {
+			return err
+		}
+		c.sb.WriteString("?")
+		c.args = append(c.args, v)
+	default:
+		return ErrInvalidFilter.New("unsupported expression %T", node)
+	}
+	return nil
+}
+
+func filterValue(n *test_driver.ValueExpr) (interface{}, error) {
+	switch n.Kind() {
+	case test_driver.KindNull:
+		return nil, nil
+	case test_driver.KindInt64:
+		return n.GetInt64(), nil
+	case test_driver.KindUint64:
+		return n.GetUint64(), nil
+	case test_driver.KindFloat32, test_driver.KindFloat64:
+		return n.GetFloat64(), nil
+	case test_driver.KindString:
+		return n.GetString(), nil
+	case test_driver.KindMysqlDecimal:
+		// Passed as a string to keep the precision
+		return n.GetMysqlDecimal().String(), nil
+	}
+	return nil, ErrInvalidFilter.New("unsupported value %s", n.GetDatumString())
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slowquery
+
+import (
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testFilterSuite{})
+
+type testFilterSuite struct{}
+
+var testTableColumns = []string{"Query_time", "Process_keys", "User", "DB", "Query", "Digest", "Mem_max", "INSTANCE"}
+
+func (t *testFilterSuite) Test_compileFilter(c *C) {
+	cases := []struct {
+		filter string
+		where  string
+		args   []interface{}
+	}{
+		{
+			"Query_time > 2 and process_keys > 1e6 and User = 'app'",
+			"(((Query_time > ?) AND (Process_keys > ?)) AND (User = ?))",
+			[]interface{}{int64(2), float64(1e6), "app"},
+		},
+		{
+			"db in ('a', 'b') or (query_time between 0.5 and 1 and not Query like '%select%')",
+			"((DB IN (?, ?)) OR ((Query_time BETWEEN ? AND ?) AND NOT ((Query LIKE ?))))",
+			[]interface{}{"a", "b", "0.5", int64(1), "%select%"},
+		},
+		{
+			"digest is not null and instance not in ('tidb-0') and timestamp >= 1600000000",
+			"(((Digest IS NOT NULL) AND (INSTANCE NOT IN (?))) AND ((UNIX_TIMESTAMP(Time) + 0E0) >= ?))",
+			[]interface{}{"tidb-0", int64(1600000000)},
+		},
+		{
+			"query like '%|%%' escape '|'",
+			"(Query LIKE ? ESCAPE '|')",
+			[]interface{}{"%|%%"},
+		},
+		{
+			"memory_max > -1",
+			"(Mem_max > -(?))",
+			[]interface{}{int64(1)},
+		},
+	}
+	for _, cs := range cases {
+		where, args, err := compileFilter(cs.filter, tidbFilterFields(testTableColumns))
+		c.Assert(err, IsNil, Commentf("%s", cs.filter))
+		c.Assert(where, Equals, cs.where)
+		c.Assert(args, DeepEquals, cs.args)
+	}
+
+	invalid := []string{
+		"",
+		"Query_time >",
+		"Unknown_field = 1",
+		"Wait_time > 1", // not in the table columns
+		"t.Query_time > 1",
+		"Query_time > 1 ORDER BY Query_time",
+		"Query_time > 1 LIMIT 1",
+		"1 UNION SELECT 1",
+		"Query_time > 1; DROP TABLE t",
+		"DB IN (SELECT 1)",
+		"sleep(10) = 0",
+		"Query_time + 1 > 2",
+		"Query_time > (SELECT 1)",
+		"Query LIKE 'a' ESCAPE ''''",
+		"Query LIKE 'a' ESCAPE ' '",
+	}
+	for _, filter := range invalid {
+		_, _, err := compileFilter(filter, tidbFilterFields(testTableColumns))
+		c.Assert(errorx.IsOfType(err, ErrInvalidFilter), IsTrue, Commentf("%s: %v", filter, err))
+	}
+}
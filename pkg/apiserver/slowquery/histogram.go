@@ -0,0 +1,223 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slowquery
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// The step is chosen automatically to produce at most this number of buckets, if not specified.
+	autoHistogramBuckets = 200
+	maxHistogramBuckets  = 1000
+
+	defaultHistogramSeries = 10
+	maxHistogramSeries     = 50
+)
+
+var ErrTooManyBuckets = ErrNS.NewType("too_many_buckets")
+
+// histogramSteps are candidates of automatically chosen steps, in seconds.
+var histogramSteps = []int{1, 5, 10, 30, 60, 300, 600, 1800, 3600, 3 * 3600, 6 * 3600, 12 * 3600, 24 * 3600}
+
+// histogramSplits maps split by names to columns of the slow query table.
+var histogramSplits = map[string]string{
+	"instance": "INSTANCE",
+	"digest":   "Digest",
+	"db":       "DB",
+}
+
+type GetHistogramRequest struct {
+	BeginTime int      `json:"begin_time" form:"begin_time"`
+	EndTime   int      `json:"end_time" form:"end_time"`
+	DB        []string `json:"db" form:"db"`
+	// The bucket size in seconds. Chosen automatically if not specified.
+	Step int `json:"step" form:"step"`
+	// Optional. One of `instance`, `digest` and `db`, to return a series for each of them.
+	SplitBy string `json:"split_by" form:"split_by"`
+	// Number of series to return when split, series with most slow queries are returned. Default to 10, at most 50.
+	SeriesLimit int `json:"series_limit" form:"series_limit"`
+}
+
+type HistogramBucket struct {
+	// The bucket covers slow queries finished in [begin_time, end_time).
+	BeginTime    int      `json:"begin_time"`
+	EndTime      int      `json:"end_time"`
+	Count        int      `json:"count"`
+	AvgQueryTime float64  `json:"avg_query_time"`
+	MaxQueryTime float64  `json:"max_query_time"`
+	P95QueryTime *float64 `json:"p95_query_time"`
+	P99QueryTime *float64 `json:"p99_query_time"`
+}
+
+type HistogramSeries struct {
+	// The value of the split by field, which can be used as the filter of the list API. Empty if not split.
+	Key     string            `json:"key"`
+	Buckets []HistogramBucket `json:"buckets"`
+}
+
+type HistogramResponse struct {
+	Step   int               `json:"step"`
+	Series []HistogramSeries `json:"series"`
+}
+
+type histogramRow struct {
+	BucketTime   int      `gorm:"column:bucket_time"`
+	SeriesKey    string   `gorm:"column:series_key"`
+	Count        int      `gorm:"column:count"`
+	AvgQueryTime float64  `gorm:"column:avg_query_time"`
+	MaxQueryTime float64  `gorm:"column:max_query_time"`
+	P95QueryTime *float64 `gorm:"column:p95_query_time"`
+	P99QueryTime *float64 `gorm:"column:p99_query_time"`
+}
+
+// histogramStep returns the step to use for the time range. A step producing too many buckets is rejected.
+func histogramStep(beginTime, endTime, step int) (int, error) {
+	duration := endTime - beginTime
+	if duration <= 0 {
+		duration = 1
+	}
+	if step > 0 {
+		if duration/step > maxHistogramBuckets {
+			return 0, ErrTooManyBuckets.New("step %ds produces more than %d buckets", step, maxHistogramBuckets)
+		}
+		return step, nil
+	}
+	for _, s := range histogramSteps {
+		if duration/s <= autoHistogramBuckets {
+			return s, nil
+		}
+	}
+	return (duration + autoHistogramBuckets - 1) / autoHistogramBuckets, nil
+}
+
+// fillHistogram converts rows into series with all buckets in the time range, so that empty buckets are included.
+func fillHistogram(beginTime, endTime, step int, keys []string, rows []histogramRow) []HistogramSeries {
+	first := beginTime / step * step
+	n := 0
+	for t := first; t <= endTime; t += step {
+		n++
+	}
+	seriesIndex := make(map[string]int, len(keys))
+	series := make([]HistogramSeries, 0, len(keys))
+	for i, key := range keys {
+		seriesIndex[key] = i
+		buckets := make([]HistogramBucket, n)
+		for j := range buckets {
+			buckets[j].BeginTime = first + j*step
+			buckets[j].EndTime = buckets[j].BeginTime + step
+		}
+		series = append(series, HistogramSeries{Key: key, Buckets: buckets})
+	}
+	for _, row := range rows {
+		i, ok := seriesIndex[row.SeriesKey]
+		j := (row.BucketTime - first) / step
+		if !ok || j < 0 || j >= n {
+			continue
+		}
+		b := &series[i].Buckets[j]
+		b.Count = row.Count
+		b.AvgQueryTime = row.AvgQueryTime
+		b.MaxQueryTime = row.MaxQueryTime
+		b.P95QueryTime = row.P95QueryTime
+		b.P99QueryTime = row.P99QueryTime
+	}
+	return series
+}
+
+func (s *Service) querySlowLogHistogram(db *gorm.DB, req *GetHistogramRequest) (*HistogramResponse, error) {
+	step, err := histogramStep(req.BeginTime, req.EndTime, req.Step)
+	if err != nil {
+		return nil, err
+	}
+	splitColumn := ""
+	if req.SplitBy != "" {
+		var ok bool
+		if splitColumn, ok = histogramSplits[req.SplitBy]; !ok {
+			return nil, ErrUnknownGroupBy.New("unknown split by %s", req.SplitBy)
+		}
+	}
+
+	baseQuery := func() *gorm.DB {
+		tx := db.
+			Table(slowQueryTable).
+			Where("Time BETWEEN FROM_UNIXTIME(?) AND FROM_UNIXTIME(?)", req.BeginTime, req.EndTime)
+		if len(req.DB) > 0 {
+			tx = tx.Where("DB IN (?)", req.DB)
+		}
+		return tx
+	}
+
+	// Only series with most slow queries are returned
+	keys := []string{""}
+	if splitColumn != "" {
+		limit := req.SeriesLimit
+		if limit <= 0 {
+			limit = defaultHistogramSeries
+		} else if limit > maxHistogramSeries {
+			limit = maxHistogramSeries
+		}
+		var topKeys []struct {
+			Key string `gorm:"column:series_key"`
+		}
+		err := baseQuery().
+			Select(fmt.Sprintf("%s AS series_key, COUNT(*) AS count", splitColumn)).
+			Group(splitColumn).
+			Order("count DESC").
+			Limit(limit).
+			Scan(&topKeys).Error
+		if err != nil {
+			return nil, err
+		}
+		keys = make([]string, 0, len(topKeys))
+		for _, k := range topKeys {
+			keys = append(keys, k.Key)
+		}
+		if len(keys) == 0 {
+			return &HistogramResponse{Step: step, Series: []HistogramSeries{}}, nil
+		}
+	}
+
+	query := func(withPercentiles bool) ([]histogramRow, error) {
+		bucketExpr := fmt.Sprintf("CAST(FLOOR(UNIX_TIMESTAMP(Time) / %d) AS SIGNED) * %d", step, step)
+		fields := fmt.Sprintf("%s AS bucket_time, COUNT(*) AS count, AVG(Query_time) AS avg_query_time, MAX(Query_time) AS max_query_time", bucketExpr)
+		if withPercentiles {
+			fields += ", APPROX_PERCENTILE(Query_time, 95) AS p95_query_time, APPROX_PERCENTILE(Query_time, 99) AS p99_query_time"
+		}
+		groupBy := bucketExpr
+		tx := baseQuery()
+		if splitColumn != "" {
+			fields += fmt.Sprintf(", %s AS series_key", splitColumn)
+			groupBy += ", " + splitColumn
+			tx = tx.Where(fmt.Sprintf("%s IN (?)", splitColumn), keys)
+		}
+		var rows []histogramRow
+		err := tx.Select(fields).Group(groupBy).Scan(&rows).Error
+		return rows, err
+	}
+
+	rows, err := query(true)
+	if isPercentileUnsupported(err) {
+		rows, err = query(false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &HistogramResponse{
+		Step:   step,
+		Series: fillHistogram(req.BeginTime, req.EndTime, step, keys, rows),
+	}, nil
+}
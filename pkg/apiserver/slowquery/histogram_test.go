@@ -0,0 +1,58 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slowquery
+
+import (
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testHistogramSuite{})
+
+type testHistogramSuite struct{}
+
+func (t *testHistogramSuite) Test_histogramStep(c *C) {
+	step, err := histogramStep(0, 100, 0)
+	c.Assert(err, IsNil)
+	c.Assert(step, Equals, 1)
+	step, err = histogramStep(0, 3600, 0)
+	c.Assert(err, IsNil)
+	c.Assert(step, Equals, 30)
+	step, err = histogramStep(0, 7*24*3600, 0)
+	c.Assert(err, IsNil)
+	c.Assert(step, Equals, 3600)
+	step, err = histogramStep(0, 3600, 60)
+	c.Assert(err, IsNil)
+	c.Assert(step, Equals, 60)
+	_, err = histogramStep(0, 24*3600, 1)
+	c.Assert(errorx.IsOfType(err, ErrTooManyBuckets), IsTrue)
+}
+
+func (t *testHistogramSuite) Test_fillHistogram(c *C) {
+	p99 := 2.5
+	rows := []histogramRow{
+		{BucketTime: 60, SeriesKey: "tidb-1", Count: 3, AvgQueryTime: 1, MaxQueryTime: 2, P99QueryTime: &p99},
+		{BucketTime: 120, SeriesKey: "tidb-2", Count: 1, AvgQueryTime: 1, MaxQueryTime: 1},
+		{BucketTime: 120, SeriesKey: "not-in-top", Count: 1},
+	}
+	series := fillHistogram(70, 180, 60, []string{"tidb-1", "tidb-2"}, rows)
+	c.Assert(series, HasLen, 2)
+	c.Assert(series[0].Key, Equals, "tidb-1")
+	c.Assert(series[0].Buckets, HasLen, 3)
+	c.Assert(series[0].Buckets[0], DeepEquals, HistogramBucket{BeginTime: 60, EndTime: 120, Count: 3, AvgQueryTime: 1, MaxQueryTime: 2, P99QueryTime: &p99})
+	c.Assert(series[0].Buckets[1].Count, Equals, 0)
+	c.Assert(series[1].Buckets[1].BeginTime, Equals, 120)
+	c.Assert(series[1].Buckets[1].Count, Equals, 1)
+	c.Assert(series[1].Buckets[2].EndTime, Equals, 240)
+}
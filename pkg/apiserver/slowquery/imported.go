@@ -0,0 +1,532 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slowquery
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pingcap/log"
+	"github.com/thoas/go-funk"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+const (
+	maxImportFileBytes = 256 * 1024 * 1024
+	// Imported datasets are kept in the local store, so that the number of them is limited for each user.
+	maxImportedDatasets = 10
+	maxImportedRecords  = 500000
+
+	// Each record takes about 60 variables, which should be kept below the default limit of SQLite, i.e. 999.
+	importBatchSize = 15
+	// Records are committed in chunks, so that other writers of the local store are not blocked for long.
+	importChunkSize = 1500
+
+	defaultImportedListLimit = 100
+	maxImportedListLimit     = 1000
+
+	importedRecordTable = "slow_query_imported_records"
+)
+
+var (
+	ErrImportTooLarge    = ErrNS.NewType("import_too_large")
+	ErrTooManyDatasets   = ErrNS.NewType("too_many_datasets")
+	ErrDatasetNotFound   = ErrNS.NewType("dataset_not_found")
+	ErrImportedNoRecords = ErrNS.NewType("imported_no_records")
+)
+
+// ImportedDatasetModel is a slow log file uploaded for offline analysis.
+type ImportedDatasetModel struct { //nolint
+	ID       uint   `gorm:"primary_key" json:"id"`
+	Name     string `gorm:"size:256" json:"name"`
+	FileName string `gorm:"size:256" json:"file_name"`
+	FileSize int64  `json:"file_size"`
+	// The instance assigned to all records, since it is not included in the slow log.
+	Instance    string `gorm:"size:256" json:"instance"`
+	RecordCount int    `json:"record_count"`
+	// The time range of records, in unix seconds.
+	BeginTime float64 `json:"begin_time"`
+	EndTime   float64 `json:"end_time"`
+	// The owner key of the user importing the dataset, see utils.OwnerKey.
+	Owner     string    `gorm:"size:512;index" json:"-"`
+	CreatedBy string    `gorm:"size:256" json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	// Datasets are hidden until all records are imported.
+	IsReady bool `json:"-"`
+}
+
+func (ImportedDatasetModel) TableName() string {
+	return "slow_query_imported_datasets"
+}
+
+type ImportedRecordModel struct { //nolint
+	ID        uint `gorm:"primary_key"`
+	DatasetID uint `gorm:"index"`
+	Model     `gorm:"embedded"`
+}
+
+func (ImportedRecordModel) TableName() string {
+	return importedRecordTable
+}
+
+func autoMigrate(db *dbstore.DB) error {
+	return db.AutoMigrate(&ImportedDatasetModel{}, &ImportedRecordModel{})
+}
+
+type ImportRequest struct {
+	// The display name of the dataset. Default to the file name.
+	Name string `json:"name" form:"name"`
+	// The instance the slow log comes from, e.g. `127.0.0.1:10080`.
+	Instance string `json:"instance" form:"instance"`
+}
+
+// importSlowLog parses the slow log into a new dataset. Either all records are imported or none.
+//
+// Records are committed in chunks, so that the local store is not locked during the whole import. The dataset is
+// hidden until all records are imported.
+func (s *Service) importSlowLog(r io.Reader, fileName string, fileSize int64, req *ImportRequest, owner string, createdBy string) (*ImportedDatasetModel, error) {
+	if fileSize > maxImportFileBytes {
+		return nil, ErrImportTooLarge.New("file size exceeds %d bytes", maxImportFileBytes)
+	}
+	name := req.Name
+	if name == "" {
+		name = fileName
+	}
+	dataset := &ImportedDatasetModel{
+		Name:      name,
+		FileName:  fileName,
+		FileSize:  fileSize,
+		Instance:  req.Instance,
+		Owner:     owner,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+	}
+
+	err := s.params.LocalStore.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&ImportedDatasetModel{}).Where("owner = ?", owner).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= maxImportedDatasets {
+			return ErrTooManyDatasets.New("at most %d datasets can be imported, delete some of them first", maxImportedDatasets)
+		}
+		return tx.Create(dataset).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.importRecords(r, dataset); err != nil {
+		if err := s.removeDataset(dataset.ID); err != nil {
+			log.Warn("Failed to remove the incomplete dataset", zap.Uint("id", dataset.ID), zap.Error(err))
+		}
+		return nil, err
+	}
+	return dataset, nil
+}
+
+// importRecords inserts records of the slow log in chunks, and marks the dataset as ready at the end.
+func (s *Service) importRecords(r io.Reader, dataset *ImportedDatasetModel) error {
+	chunk := make([]ImportedRecordModel, 0, importChunkSize)
+	insertChunk := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		err := s.params.LocalStore.CreateInBatches(&chunk, importBatchSize).Error
+		chunk = chunk[:0]
+		return err
+	}
+	err := parseSlowLog(r, dataset.Instance, func(m *Model) error {
+		if dataset.RecordCount >= maxImportedRecords {
+			return ErrImportTooLarge.New("number of records exceeds %d", maxImportedRecords)
+		}
+		if dataset.RecordCount == 0 || m.Timestamp < dataset.BeginTime {
+			dataset.BeginTime = m.Timestamp
+		}
+		if m.Timestamp > dataset.EndTime {
+			dataset.EndTime = m.Timestamp
+		}
+		dataset.RecordCount++
+		chunk = append(chunk, ImportedRecordModel{DatasetID: dataset.ID, Model: *m})
+		if len(chunk) >= importChunkSize {
+			return insertChunk()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := insertChunk(); err != nil {
+		return err
+	}
+	if dataset.RecordCount == 0 {
+		return ErrImportedNoRecords.New("no slow query is found in the file")
+	}
+	dataset.IsReady = true
+	return s.params.LocalStore.Save(dataset).Error
+}
+
+// removeDataset removes the dataset and its records.
+func (s *Service) removeDataset(id uint) error {
+	if err := s.params.LocalStore.Where("dataset_id = ?", id).Delete(&ImportedRecordModel{}).Error; err != nil {
+		return err
+	}
+	return s.params.LocalStore.Where("id = ?", id).Delete(&ImportedDatasetModel{}).Error
+}
+
+// removeIncompleteDatasets removes datasets left by imports interrupted by restarts.
+func (s *Service) removeIncompleteDatasets() error {
+	var datasets []ImportedDatasetModel
+	if err := s.params.LocalStore.Where("is_ready = ?", false).Find(&datasets).Error; err != nil {
+		return err
+	}
+	for _, dataset := range datasets {
+		if err := s.removeDataset(dataset.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listImportedDatasets lists datasets imported by the owner.
+func (s *Service) listImportedDatasets(owner string) ([]ImportedDatasetModel, error) {
+	var datasets []ImportedDatasetModel
+	err := s.params.LocalStore.
+		Where("owner = ? AND is_ready = ?", owner, true).
+		Order("id DESC").
+		Find(&datasets).Error
+	if err != nil {
+		return nil, err
+	}
+	return datasets, nil
+}
+
+// getImportedDataset returns the dataset only if it is imported by the owner and is ready.
+func (s *Service) getImportedDataset(owner string, id uint) (*ImportedDatasetModel, error) {
+	var dataset ImportedDatasetModel
+	err := s.params.LocalStore.
+		Where("id = ? AND owner = ? AND is_ready = ?", id, owner, true).
+		First(&dataset).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrDatasetNotFound.New("dataset %d is not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &dataset, nil
+}
+
+func (s *Service) deleteImportedDataset(owner string, id uint) error {
+	if _, err := s.getImportedDataset(owner, id); err != nil {
+		return err
+	}
+	return s.removeDataset(id)
+}
+
+// importedFilterFields returns expressions of fields in the imported record table, see tidbFilterFields.
+func importedFilterFields() map[string]string {
+	fields := make(map[string]string)
+	for _, f := range getFieldsAndTags() {
+		expr := fmt.Sprintf("`%s`", f.ColumnName)
+		fields[strings.ToLower(f.ColumnName)] = expr
+		fields[strings.ToLower(f.JSONName)] = expr
+	}
+	return fields
+}
+
+func genImportedOrderStmt(orderBy string, isDesc bool) (string, error) {
+	if orderBy == "" {
+		orderBy = "timestamp"
+	}
+	orderField := funk.Find(getFieldsAndTags(), func(f Field) bool {
+		return f.JSONName == orderBy
+	})
+	if orderField == nil {
+		return "", ErrUnknownColumn.New("unknown order by %s", orderBy)
+	}
+	if isDesc {
+		return fmt.Sprintf("`%s` DESC", orderField.(Field).ColumnName), nil
+	}
+	return fmt.Sprintf("`%s` ASC", orderField.(Field).ColumnName), nil
+}
+
+// escapeLike escapes wildcards in the LIKE pattern, using `\` as the escape character.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// importedBaseQuery applies filters shared by list and aggregation APIs. Time range is only applied when specified,
+// since imported slow logs are usually not recent.
+func (s *Service) importedBaseQuery(datasetID uint, beginTime, endTime int, dbs []string) *gorm.DB {
+	tx := s.params.LocalStore.
+		Table(importedRecordTable).
+		Where("dataset_id = ?", datasetID)
+	if endTime > 0 {
+		tx = tx.Where("timestamp BETWEEN ? AND ?", beginTime, endTime)
+	}
+	if len(dbs) > 0 {
+		tx = tx.Where("DB IN (?)", dbs)
+	}
+	return tx
+}
+
+func (s *Service) queryImportedList(owner string, datasetID uint, req *GetListRequest) ([]Model, error) {
+	if _, err := s.getImportedDataset(owner, datasetID); err != nil {
+		return nil, err
+	}
+	orderStmt, err := genImportedOrderStmt(req.OrderBy, req.IsDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultImportedListLimit
+	} else if limit > maxImportedListLimit {
+		limit = maxImportedListLimit
+	}
+	tx := s.importedBaseQuery(datasetID, req.BeginTime, req.EndTime, req.DB).Order(orderStmt).Limit(limit)
+	if req.Text != "" {
+		for _, v := range strings.Fields(strings.ToLower(req.Text)) {
+			pattern := "%" + escapeLike(v) + "%"
+			tx = tx.Where(
+				`LOWER(Txn_start_ts) LIKE ? ESCAPE '\'
+				 OR LOWER(Digest) LIKE ? ESCAPE '\'
+				 OR LOWER(Prev_stmt) LIKE ? ESCAPE '\'
+				 OR LOWER(Query) LIKE ? ESCAPE '\'`,
+				pattern, pattern, pattern, pattern,
+			)
+		}
+	}
+	// Plan digests are not available in slow log files, so that Plans are ignored.
+	if len(req.Digest) > 0 {
+		tx = tx.Where("Digest = ?", req.Digest)
+	}
+	if len(req.Instances) > 0 {
+		tx = tx.Where("INSTANCE IN (?)", req.Instances)
+	}
+	if req.Filter != "" {
+		where, args, err := compileFilter(req.Filter, importedFilterFields())
+		if err != nil {
+			return nil, err
+		}
+		tx = tx.Where(where, args...)
+	}
+
+	results := make([]Model, 0)
+	if err := tx.Find(&results).Error; err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *Service) queryImportedDetail(owner string, datasetID uint, req *GetDetailRequest) (*Model, error) {
+	if _, err := s.getImportedDataset(owner, datasetID); err != nil {
+		return nil, err
+	}
+	var rec ImportedRecordModel
+	err := s.params.LocalStore.
+		Where("dataset_id = ?", datasetID).
+		Where("Digest = ?", req.Digest).
+		Where("Conn_ID = ?", req.ConnectID).
+		// The timestamp is passed as a float, which may lose the precision
+		Where("ABS(timestamp - ?) < 1e-6", req.Timestamp).
+		First(&rec).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rec.Model, nil
+}
+
+// aggregationFieldIndexes maps JSON names to indexes of fields in AggregationItem.
+var aggregationFieldIndexes = func() map[string]int {
+	indexes := make(map[string]int)
+	t := reflect.TypeOf(AggregationItem{})
+	for i := 0; i < t.NumField(); i++ {
+		indexes[t.Field(i).Tag.Get("json")] = i
+	}
+	return indexes
+}()
+
+// percentile returns the p-th percentile of sorted values using the nearest-rank method.
+func percentile(sorted []float64, p int) float64 {
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+type aggregationGroup struct {
+	key    string
+	count  int
+	values [][]float64 // values of each metric in aggregationMetrics
+}
+
+// aggregateGroups computes aggregated fields of each group. Percentiles are exact, unlike APPROX_PERCENTILE in TiDB.
+func aggregateGroups(groups []*aggregationGroup) []AggregationItem {
+	items := make([]AggregationItem, 0, len(groups))
+	for _, g := range groups {
+		item := AggregationItem{GroupKey: g.key, Count: g.count}
+		v := reflect.ValueOf(&item).Elem()
+		for i, m := range aggregationMetrics {
+			values := g.values[i]
+			sort.Float64s(values)
+			sum := 0.0
+			for _, value := range values {
+				sum += value
+			}
+			p95 := percentile(values, 95)
+			p99 := percentile(values, 99)
+			v.Field(aggregationFieldIndexes["sum_"+m.name]).SetFloat(sum)
+			v.Field(aggregationFieldIndexes["avg_"+m.name]).SetFloat(sum / float64(len(values)))
+			v.Field(aggregationFieldIndexes["max_"+m.name]).SetFloat(values[len(values)-1])
+			v.Field(aggregationFieldIndexes["p95_"+m.name]).Set(reflect.ValueOf(&p95))
+			v.Field(aggregationFieldIndexes["p99_"+m.name]).Set(reflect.ValueOf(&p99))
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+func aggregationSortValue(item *AggregationItem, orderBy string) float64 {
+	v := reflect.ValueOf(item).Elem().Field(aggregationFieldIndexes[orderBy])
+	switch v.Kind() {
+	case reflect.Int:
+		return float64(v.Int())
+	case reflect.Ptr:
+		if v.IsNil() {
+			return math.Inf(-1)
+		}
+		return v.Elem().Float()
+	default:
+		return v.Float()
+	}
+}
+
+// sortAggregationItems sorts items by the aggregated field, which should be validated by genAggregationOrderStmt.
+func sortAggregationItems(items []AggregationItem, orderBy string, isDesc bool) {
+	sort.SliceStable(items, func(i, j int) bool {
+		a := aggregationSortValue(&items[i], orderBy)
+		b := aggregationSortValue(&items[j], orderBy)
+		if isDesc {
+			return a > b
+		}
+		return a < b
+	})
+}
+
+func (s *Service) queryImportedAggregation(owner string, datasetID uint, req *GetAggregationRequest) ([]AggregationItem, error) {
+	if _, err := s.getImportedDataset(owner, datasetID); err != nil {
+		return nil, err
+	}
+	groupColumn, ok := aggregationGroups[req.GroupBy]
+	if !ok {
+		return nil, ErrUnknownGroupBy.New("unknown group by %s", req.GroupBy)
+	}
+	orderBy := req.OrderBy
+	if orderBy == "" {
+		orderBy = "sum_query_time"
+	}
+	if _, err := genAggregationOrderStmt(orderBy, req.IsDesc); err != nil {
+		return nil, err
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultAggregationLimit
+	} else if limit > maxAggregationLimit {
+		limit = maxAggregationLimit
+	}
+
+	fields := []string{groupColumn}
+	for _, m := range aggregationMetrics {
+		fields = append(fields, m.column)
+	}
+	rows, err := s.importedBaseQuery(datasetID, req.BeginTime, req.EndTime, req.DB).
+		Select(strings.Join(fields, ", ")).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groupIndex := make(map[string]*aggregationGroup)
+	groups := make([]*aggregationGroup, 0)
+	var key string
+	values := make([]float64, len(aggregationMetrics))
+	dest := []interface{}{&key}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		g, ok := groupIndex[key]
+		if !ok {
+			g = &aggregationGroup{key: key, values: make([][]float64, len(aggregationMetrics))}
+			groupIndex[key] = g
+			groups = append(groups, g)
+		}
+		g.count++
+		for i, value := range values {
+			g.values[i] = append(g.values[i], value)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	items := aggregateGroups(groups)
+	sortAggregationItems(items, orderBy, req.IsDesc)
+	if len(items) > limit {
+		items = items[:limit]
+	}
+
+	if req.GroupBy == "digest" && len(items) > 0 {
+		keys := make([]string, 0, len(items))
+		for _, item := range items {
+			keys = append(keys, item.GroupKey)
+		}
+		var samples []struct {
+			Digest string `gorm:"column:Digest"`
+			Query  string `gorm:"column:sample_query"`
+		}
+		err := s.importedBaseQuery(datasetID, req.BeginTime, req.EndTime, req.DB).
+			Select("Digest, MIN(Query) AS sample_query").
+			Where("Digest IN (?)", keys).
+			Group("Digest").
+			Scan(&samples).Error
+		if err != nil {
+			return nil, err
+		}
+		sampleQueries := make(map[string]string, len(samples))
+		for _, sample := range samples {
+			sampleQueries[sample.Digest] = sample.Query
+		}
+		for i := range items {
+			items[i].SampleQuery = sampleQueries[items[i].GroupKey]
+		}
+	}
+	return items, nil
+}
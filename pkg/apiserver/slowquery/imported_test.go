@@ -0,0 +1,178 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slowquery
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore/dbstoretest"
+)
+
+var _ = Suite(&testImportedSuite{})
+
+type testImportedSuite struct{}
+
+func (t *testImportedSuite) newService(c *C) *Service {
+	s, err := newService(ServiceParams{LocalStore: dbstoretest.NewDB(c)})
+	c.Assert(err, IsNil)
+	return s
+}
+
+// genSlowLog generates a slow log with the query time of the i-th record being i+1 seconds.
+func genSlowLog(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "# Time: 2021-04-01T10:00:%02d+08:00\n", i)
+		fmt.Fprintf(&sb, "# Conn_ID: %d\n", i)
+		fmt.Fprintf(&sb, "# Query_time: %d\n", i+1)
+		fmt.Fprintf(&sb, "# DB: db%d\n", i%2)
+		fmt.Fprintf(&sb, "# Digest: digest%d\n", i%2)
+		fmt.Fprintf(&sb, "select %d;\n", i)
+	}
+	return sb.String()
+}
+
+func (t *testImportedSuite) Test_ImportAndQuery(c *C) {
+	s := t.newService(c)
+	log := genSlowLog(40)
+	dataset, err := s.importSlowLog(strings.NewReader(log), "tidb-slow.log", int64(len(log)), &ImportRequest{Instance: "tidb-0"}, "0:root", "root")
+	c.Assert(err, IsNil)
+	c.Assert(dataset.Name, Equals, "tidb-slow.log")
+	c.Assert(dataset.RecordCount, Equals, 40)
+	c.Assert(dataset.BeginTime, Equals, float64(1617242400))
+	c.Assert(dataset.EndTime, Equals, float64(1617242439))
+
+	list, err := s.queryImportedList("0:root", dataset.ID, &GetListRequest{OrderBy: "query_time", IsDesc: true, Limit: 5, DB: []string{"db1"}})
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 5)
+	c.Assert(list[0].QueryTime, Equals, 40.0)
+	c.Assert(list[0].Instance, Equals, "tidb-0")
+
+	list, err = s.queryImportedList("0:root", dataset.ID, &GetListRequest{Filter: "query_time > 35 and db = 'db0'", Text: "SELECT"})
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 2)
+	c.Assert(list[0].Query, Equals, "select 36;")
+
+	list, err = s.queryImportedList("0:root", dataset.ID, &GetListRequest{BeginTime: 1617242400, EndTime: 1617242409})
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, 10)
+
+	detail, err := s.queryImportedDetail("0:root", dataset.ID, &GetDetailRequest{Digest: "digest1", ConnectID: "3", Timestamp: list[3].Timestamp})
+	c.Assert(err, IsNil)
+	c.Assert(detail.Query, Equals, "select 3;")
+
+	items, err := s.queryImportedAggregation("0:root", dataset.ID, &GetAggregationRequest{GroupBy: "digest", IsDesc: true})
+	c.Assert(err, IsNil)
+	c.Assert(items, HasLen, 2)
+	c.Assert(items[0].GroupKey, Equals, "digest1")
+	c.Assert(items[0].Count, Equals, 20)
+	c.Assert(items[0].SumQueryTime, Equals, 420.0)
+	c.Assert(items[0].MaxQueryTime, Equals, 40.0)
+	c.Assert(*items[0].P95QueryTime, Equals, 38.0)
+	c.Assert(strings.HasPrefix(items[0].SampleQuery, "select "), IsTrue)
+
+	// Datasets are only visible to the user importing them
+	datasets, err := s.listImportedDatasets("3:root")
+	c.Assert(err, IsNil)
+	c.Assert(datasets, HasLen, 0)
+	_, err = s.queryImportedList("3:root", dataset.ID, &GetListRequest{})
+	c.Assert(errorx.IsOfType(err, ErrDatasetNotFound), IsTrue)
+	_, err = s.queryImportedDetail("3:root", dataset.ID, &GetDetailRequest{Digest: "digest1", ConnectID: "3", Timestamp: list[3].Timestamp})
+	c.Assert(errorx.IsOfType(err, ErrDatasetNotFound), IsTrue)
+	err = s.deleteImportedDataset("3:root", dataset.ID)
+	c.Assert(errorx.IsOfType(err, ErrDatasetNotFound), IsTrue)
+
+	_, err = s.queryImportedAggregation("0:root", dataset.ID, &GetAggregationRequest{GroupBy: "Query"})
+	c.Assert(errorx.IsOfType(err, ErrUnknownGroupBy), IsTrue)
+
+	c.Assert(s.deleteImportedDataset("0:root", dataset.ID), IsNil)
+	_, err = s.queryImportedList("0:root", dataset.ID, &GetListRequest{})
+	c.Assert(errorx.IsOfType(err, ErrDatasetNotFound), IsTrue)
+	err = s.deleteImportedDataset("0:root", dataset.ID)
+	c.Assert(errorx.IsOfType(err, ErrDatasetNotFound), IsTrue)
+}
+
+func (t *testImportedSuite) Test_ImportLimits(c *C) {
+	s := t.newService(c)
+	_, err := s.importSlowLog(strings.NewReader(""), "big.log", maxImportFileBytes+1, &ImportRequest{}, "0:root", "root")
+	c.Assert(errorx.IsOfType(err, ErrImportTooLarge), IsTrue)
+
+	_, err = s.importSlowLog(strings.NewReader("not a slow log"), "empty.log", 14, &ImportRequest{}, "0:root", "root")
+	c.Assert(errorx.IsOfType(err, ErrImportedNoRecords), IsTrue)
+
+	log := genSlowLog(1)
+	for i := 0; i < maxImportedDatasets; i++ {
+		_, err = s.importSlowLog(strings.NewReader(log), "slow.log", int64(len(log)), &ImportRequest{}, "0:root", "root")
+		c.Assert(err, IsNil)
+	}
+	_, err = s.importSlowLog(strings.NewReader(log), "slow.log", int64(len(log)), &ImportRequest{}, "0:root", "root")
+	c.Assert(errorx.IsOfType(err, ErrTooManyDatasets), IsTrue)
+
+	datasets, err := s.listImportedDatasets("0:root")
+	c.Assert(err, IsNil)
+	c.Assert(datasets, HasLen, maxImportedDatasets)
+
+	// Other users have their own limits
+	_, err = s.importSlowLog(strings.NewReader(log), "slow.log", int64(len(log)), &ImportRequest{}, "0:other", "other")
+	c.Assert(err, IsNil)
+}
+
+var errReadFailed = errors.New("read failed")
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errReadFailed
+}
+
+func (t *testImportedSuite) Test_ImportInChunks(c *C) {
+	s := t.newService(c)
+	n := importChunkSize*2 + 10
+	log := genSlowLog(n)
+	dataset, err := s.importSlowLog(strings.NewReader(log), "slow.log", int64(len(log)), &ImportRequest{}, "0:root", "root")
+	c.Assert(err, IsNil)
+	c.Assert(dataset.RecordCount, Equals, n)
+
+	list, err := s.queryImportedList("0:root", dataset.ID, &GetListRequest{})
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, defaultImportedListLimit)
+	list, err = s.queryImportedList("0:root", dataset.ID, &GetListRequest{Limit: n})
+	c.Assert(err, IsNil)
+	c.Assert(list, HasLen, maxImportedListLimit)
+
+	// Failed imports leave nothing
+	broken := io.MultiReader(strings.NewReader(genSlowLog(importChunkSize+1)), failingReader{})
+	_, err = s.importSlowLog(broken, "broken.log", int64(len(log)), &ImportRequest{}, "0:root", "root")
+	c.Assert(err, Equals, errReadFailed)
+	var count int64
+	c.Assert(s.params.LocalStore.Model(&ImportedRecordModel{}).Count(&count).Error, IsNil)
+	c.Assert(count, Equals, int64(n))
+
+	// Datasets interrupted by restarts are hidden and removed at start
+	incomplete := &ImportedDatasetModel{Name: "incomplete", Owner: "0:root"}
+	c.Assert(s.params.LocalStore.Create(incomplete).Error, IsNil)
+	c.Assert(s.params.LocalStore.Create(&ImportedRecordModel{DatasetID: incomplete.ID}).Error, IsNil)
+	datasets, err := s.listImportedDatasets("0:root")
+	c.Assert(err, IsNil)
+	c.Assert(datasets, HasLen, 1)
+	c.Assert(s.removeIncompleteDatasets(), IsNil)
+	c.Assert(s.params.LocalStore.Model(&ImportedRecordModel{}).Count(&count).Error, IsNil)
+	c.Assert(count, Equals, int64(n))
+}
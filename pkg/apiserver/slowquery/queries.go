@@ -36,6 +36,13 @@ type GetListRequest struct {
 	Plans  []string `json:"plans" form:"plans"`
 	Digest string   `json:"digest" form:"digest"`
 
+	// for drilling down from the histogram
+	Instances []string `json:"instances" form:"instances"`
+
+	// A boolean expression over fields of the model, e.g. `Query_time > 2 AND User IN ('app', 'batch')`.
+	// Comparisons, IN, BETWEEN, LIKE, IS NULL, AND, OR and NOT are supported.
+	Filter string `json:"filter" form:"filter"`
+
 	Fields string `json:"fields" form:"fields"` // example: "Query,Digest"
 }
 
@@ -105,6 +112,18 @@ func (s *Service) querySlowLogList(db *gorm.DB, req *GetListRequest) ([]Model, e
 		tx = tx.Where("Digest = ?", req.Digest)
 	}
 
+	if len(req.Instances) > 0 {
+		tx = tx.Where("INSTANCE IN (?)", req.Instances)
+	}
+
+	if req.Filter != "" {
+		where, args, err := compileFilter(req.Filter, tidbFilterFields(tableColumns))
+		if err != nil {
+			return nil, err
+		}
+		tx = tx.Where(where, args...)
+	}
+
 	var results []Model
 	err = tx.Find(&results).Error
 	if err != nil {
@@ -127,3 +146,32 @@ func (s *Service) querySlowLogDetail(db *gorm.DB, req *GetDetailRequest) (*Model
 	}
 	return &result, nil
 }
+
+const encodedPlanPrefix = "tidb_decode_plan('"
+
+// querySlowLogPlan returns the plan text of a slow query. Plans that are still encoded, i.e. in the form of
+// `tidb_decode_plan('...')`, are decoded by TiDB. An empty string is returned if the slow query is not found.
+func (s *Service) querySlowLogPlan(db *gorm.DB, req *GetDetailRequest) (string, error) {
+	var result struct {
+		Plan string `gorm:"column:Plan"`
+	}
+	err := db.
+		Table(slowQueryTable).
+		Select("Plan").
+		Where("Digest = ?", req.Digest).
+		Where("Time = FROM_UNIXTIME(?)", req.Timestamp).
+		Where("Conn_id = ?", req.ConnectID).
+		Limit(1).
+		Scan(&result).Error
+	if err != nil {
+		return "", err
+	}
+	text := strings.TrimSpace(result.Plan)
+	if strings.HasPrefix(text, encodedPlanPrefix) && strings.HasSuffix(text, "')") {
+		encoded := text[len(encodedPlanPrefix) : len(text)-2]
+		if err := db.Raw("SELECT tidb_decode_plan(?)", encoded).Row().Scan(&text); err != nil {
+			return "", err
+		}
+	}
+	return text, nil
+}
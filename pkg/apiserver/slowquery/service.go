@@ -14,8 +14,10 @@
 package slowquery
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,30 +26,43 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/fx"
+	"gorm.io/gorm"
 
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
 	"github.com/pingcap/tidb-dashboard/pkg/tidb"
+	"github.com/pingcap/tidb-dashboard/pkg/tidb/plan"
 	commonUtils "github.com/pingcap/tidb-dashboard/pkg/utils"
 )
 
 var (
 	ErrNS     = errorx.NewNamespace("error.api.slow_query")
 	ErrNoData = ErrNS.NewType("export_no_data")
+
+	ErrPlanNotFound = ErrNS.NewType("plan_not_found")
 )
 
 type ServiceParams struct {
 	fx.In
 	TiDBClient *tidb.Client
 	SysSchema  *commonUtils.SysSchema
+	LocalStore *dbstore.DB
 }
 
 type Service struct {
 	params ServiceParams
 }
 
-func newService(p ServiceParams) *Service {
-	return &Service{params: p}
+func newService(p ServiceParams) (*Service, error) {
+	if err := autoMigrate(p.LocalStore); err != nil {
+		return nil, err
+	}
+	s := &Service{params: p}
+	if err := s.removeIncompleteDatasets(); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
@@ -56,10 +71,25 @@ func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 		endpoint.GET("/download", s.downloadHandler)
 
 		endpoint.Use(auth.MWAuthRequired())
+
+		// Imported datasets are stored locally, so that the TiDB connection is not required.
+		imported := endpoint.Group("/imported")
+		{
+			imported.POST("", auth.MWRequirePermission(utils.PermSlowQueryImport), s.importHandler)
+			imported.GET("", s.listImportedHandler)
+			imported.DELETE("/:id", auth.MWRequirePermission(utils.PermSlowQueryImport), s.deleteImportedHandler)
+			imported.GET("/:id/list", s.getImportedList)
+			imported.GET("/:id/detail", s.getImportedDetail)
+			imported.GET("/:id/aggregation", s.getImportedAggregation)
+		}
+
 		endpoint.Use(utils.MWConnectTiDB(s.params.TiDBClient))
 		{
 			endpoint.GET("/list", s.getList)
 			endpoint.GET("/detail", s.getDetails)
+			endpoint.GET("/plan/tree", s.getPlanTree)
+			endpoint.GET("/aggregation", s.getAggregation)
+			endpoint.GET("/histogram", s.getHistogram)
 
 			endpoint.POST("/download/token", s.downloadTokenHandler)
 
@@ -113,6 +143,283 @@ func (s *Service) getDetails(c *gin.Context) {
 	c.JSON(http.StatusOK, *result)
 }
 
+// @Summary Get the execution plan of a slow query as a tree of operators
+// @Param q query GetDetailRequest true "Query"
+// @Success 200 {object} plan.Tree
+// @Router /slow_query/plan/tree [get]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 404 {object} utils.APIError "Plan not found"
+func (s *Service) getPlanTree(c *gin.Context) {
+	var req GetDetailRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+
+	db := utils.GetTiDBConnection(c)
+	text, err := s.querySlowLogPlan(db, &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if text == "" {
+		_ = c.Error(ErrPlanNotFound.New("Plan of the slow query is not found"))
+		c.Status(http.StatusNotFound)
+		return
+	}
+	tree, err := plan.ParseText(text)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, tree)
+}
+
+// @Summary Aggregate slow queries by digest, user, database, instance or client host
+// @Param q query GetAggregationRequest true "Query"
+// @Success 200 {array} AggregationItem
+// @Router /slow_query/aggregation [get]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+func (s *Service) getAggregation(c *gin.Context) {
+	var req GetAggregationRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+
+	db := utils.GetTiDBConnection(c)
+	results, err := s.querySlowLogAggregation(db, &req)
+	if err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrUnknownGroupBy) || errorx.IsOfType(err, ErrUnknownColumn) {
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// @Summary Count slow queries in time buckets, optionally split by instance, digest or database
+// @Description Each bucket can be drilled down via the list API with the same time range and filters.
+// @Param q query GetHistogramRequest true "Query"
+// @Success 200 {object} HistogramResponse
+// @Router /slow_query/histogram [get]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+func (s *Service) getHistogram(c *gin.Context) {
+	var req GetHistogramRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+
+	db := utils.GetTiDBConnection(c)
+	resp, err := s.querySlowLogHistogram(db, &req)
+	if err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrUnknownGroupBy) || errorx.IsOfType(err, ErrTooManyBuckets) {
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// @Summary Import a TiDB slow log file for offline analysis
+// @Description The imported dataset can be queried via the list, detail and aggregation APIs under `/slow_query/imported/{id}`.
+// @Accept multipart/form-data
+// @Param file formData file true "Slow log file"
+// @Param name formData string false "Name of the dataset"
+// @Param instance formData string false "Instance of the slow log"
+// @Success 200 {object} ImportedDatasetModel
+// @Router /slow_query/imported [post]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Permission denied"
+// @Failure 413 {object} utils.APIError "File too large"
+func (s *Service) importHandler(c *gin.Context) {
+	// Leave some room for other parts of the multipart body
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportFileBytes+1024*1024)
+	var req ImportRequest
+	if err := c.ShouldBind(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	defer file.Close() // #nosec
+
+	sessionUser := utils.GetSession(c)
+	dataset, err := s.importSlowLog(file, header.Filename, header.Size, &req, sessionUser.Owner, sessionUser.DisplayName)
+	if err != nil {
+		_ = c.Error(err)
+		switch {
+		case errorx.IsOfType(err, ErrImportTooLarge):
+			c.Status(http.StatusRequestEntityTooLarge)
+		case errorx.IsOfType(err, ErrTooManyDatasets), errorx.IsOfType(err, ErrImportedNoRecords):
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, dataset)
+}
+
+// @Summary List slow log datasets imported by current user
+// @Success 200 {array} ImportedDatasetModel
+// @Router /slow_query/imported [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+func (s *Service) listImportedHandler(c *gin.Context) {
+	datasets, err := s.listImportedDatasets(utils.GetSession(c).Owner)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, datasets)
+}
+
+// parseDatasetID parses the dataset ID in the path. An error response is written if it is invalid.
+func parseDatasetID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.MakeInvalidRequestErrorWithMessage(c, "Invalid dataset ID")
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// @Summary Delete an imported slow log dataset
+// @Param id path int true "Dataset ID"
+// @Success 200 {string} string "success"
+// @Router /slow_query/imported/{id} [delete]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Permission denied"
+// @Failure 404 {object} utils.APIError "Dataset not found"
+func (s *Service) deleteImportedHandler(c *gin.Context) {
+	id, ok := parseDatasetID(c)
+	if !ok {
+		return
+	}
+	if err := s.deleteImportedDataset(utils.GetSession(c).Owner, id); err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrDatasetNotFound) {
+			c.Status(http.StatusNotFound)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, "success")
+}
+
+// @Summary List slow queries in an imported dataset
+// @Description Time range is only applied when `end_time` is specified. `plans` is not supported. `limit` defaults to 100 and is at most 1000.
+// @Param id path int true "Dataset ID"
+// @Param q query GetListRequest true "Query"
+// @Success 200 {array} Model
+// @Router /slow_query/imported/{id}/list [get]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 404 {object} utils.APIError "Dataset not found"
+func (s *Service) getImportedList(c *gin.Context) {
+	id, ok := parseDatasetID(c)
+	if !ok {
+		return
+	}
+	var req GetListRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	results, err := s.queryImportedList(utils.GetSession(c).Owner, id, &req)
+	if err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrDatasetNotFound) {
+			c.Status(http.StatusNotFound)
+		} else if errorx.IsOfType(err, ErrUnknownColumn) || errorx.IsOfType(err, ErrInvalidFilter) {
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// @Summary Get details of a slow query in an imported dataset
+// @Param id path int true "Dataset ID"
+// @Param q query GetDetailRequest true "Query"
+// @Success 200 {object} Model
+// @Router /slow_query/imported/{id}/detail [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 404 {object} utils.APIError "Slow query not found"
+func (s *Service) getImportedDetail(c *gin.Context) {
+	id, ok := parseDatasetID(c)
+	if !ok {
+		return
+	}
+	var req GetDetailRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	result, err := s.queryImportedDetail(utils.GetSession(c).Owner, id, &req)
+	if err != nil {
+		_ = c.Error(err)
+		if errors.Is(err, gorm.ErrRecordNotFound) || errorx.IsOfType(err, ErrDatasetNotFound) {
+			c.Status(http.StatusNotFound)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, *result)
+}
+
+// @Summary Aggregate slow queries in an imported dataset
+// @Description Time range is only applied when `end_time` is specified. Percentiles are exact.
+// @Param id path int true "Dataset ID"
+// @Param q query GetAggregationRequest true "Query"
+// @Success 200 {array} AggregationItem
+// @Router /slow_query/imported/{id}/aggregation [get]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 404 {object} utils.APIError "Dataset not found"
+func (s *Service) getImportedAggregation(c *gin.Context) {
+	id, ok := parseDatasetID(c)
+	if !ok {
+		return
+	}
+	var req GetAggregationRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	results, err := s.queryImportedAggregation(utils.GetSession(c).Owner, id, &req)
+	if err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrDatasetNotFound) {
+			c.Status(http.StatusNotFound)
+		} else if errorx.IsOfType(err, ErrUnknownGroupBy) || errorx.IsOfType(err, ErrUnknownColumn) {
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
+
 // @Router /slow_query/download/token [post]
 // @Summary Generate a download token for exported slow query statements
 // @Produce plain
@@ -0,0 +1,219 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slowquery
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+)
+
+const (
+	slowLogRowPrefix  = "# "
+	slowLogTimeKey    = "Time"
+	slowLogUserKey    = "User@Host"
+	slowLogQueryKey   = "Query"
+	slowLogSQLSuffix  = ";"
+	slowLogLegacyTime = "2006-01-02-15:04:05.999999999 -0700"
+)
+
+// slowLogLineKeys are fields whose values take the rest of the line, since the values may contain spaces or colons.
+var slowLogLineKeys = map[string]struct{}{
+	"Prev_stmt":   {},
+	"Plan":        {},
+	"Binary_plan": {},
+	"User@Host":   {},
+}
+
+// slowLogFields maps lower case column names to indexes of fields in Model.
+var slowLogFields = func() map[string]int {
+	fields := make(map[string]int)
+	t := reflect.TypeOf(Model{})
+	for i := 0; i < t.NumField(); i++ {
+		column := utils.GetGormColumnName(t.Field(i).Tag.Get("gorm"))
+		if column != "" {
+			fields[strings.ToLower(column)] = i
+		}
+	}
+	return fields
+}()
+
+// slowLogParser parses the TiDB slow log format into records. Each record starts with the `# Time:` line, followed
+// by `# Key: value` lines and the query ended with `;`.
+type slowLogParser struct {
+	instance string
+	record   *Model
+	query    []string
+}
+
+// parseSlowLog parses records from the slow log and calls fn for each record. The instance is set as the
+// `INSTANCE` of all records, since it is not included in the slow log itself.
+func parseSlowLog(r io.Reader, instance string, fn func(*Model) error) error {
+	p := &slowLogParser{instance: instance}
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if perr := p.parseLine(strings.TrimRight(line, "\r\n"), fn); perr != nil {
+			return perr
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return p.flush(fn)
+}
+
+func (p *slowLogParser) parseLine(line string, fn func(*Model) error) error {
+	if strings.HasPrefix(line, slowLogRowPrefix+slowLogTimeKey+":") {
+		// A record without the query end is still kept
+		if err := p.flush(fn); err != nil {
+			return err
+		}
+		p.record = &Model{Instance: p.instance}
+	}
+	if p.record == nil {
+		return nil
+	}
+	if strings.HasPrefix(line, slowLogRowPrefix) {
+		if len(p.query) == 0 {
+			p.parseFields(line[len(slowLogRowPrefix):])
+		}
+		return nil
+	}
+	if strings.TrimSpace(line) == "" {
+		return nil
+	}
+	// The `use db;` line before the query is not a part of the query
+	lower := strings.ToLower(line)
+	if len(p.query) == 0 && strings.HasPrefix(lower, "use ") && strings.HasSuffix(line, slowLogSQLSuffix) {
+		return nil
+	}
+	p.query = append(p.query, line)
+	if strings.HasSuffix(line, slowLogSQLSuffix) {
+		return p.flush(fn)
+	}
+	return nil
+}
+
+func (p *slowLogParser) flush(fn func(*Model) error) error {
+	if p.record == nil {
+		return nil
+	}
+	p.record.Query = strings.Join(p.query, "\n")
+	record := p.record
+	p.record = nil
+	p.query = nil
+	return fn(record)
+}
+
+// parseFields parses a line like `Process_time: 0.07 Wait_time: 0.002 Backoff_types: [regionMiss tikvRPC]`.
+func (p *slowLogParser) parseFields(line string) {
+	tokens := strings.Split(line, " ")
+	key := ""
+	var value []string
+	for i, token := range tokens {
+		if isSlowLogKey(token) {
+			p.setField(key, strings.Join(value, " "))
+			key = token[:len(token)-1]
+			value = value[:0]
+			if _, ok := slowLogLineKeys[key]; ok {
+				p.setField(key, strings.Join(tokens[i+1:], " "))
+				return
+			}
+			continue
+		}
+		value = append(value, token)
+	}
+	p.setField(key, strings.Join(value, " "))
+}
+
+func isSlowLogKey(token string) bool {
+	if len(token) < 2 || !strings.HasSuffix(token, ":") {
+		return false
+	}
+	for _, r := range token[:len(token)-1] {
+		if !(r == '_' || r == '@' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *slowLogParser) setField(key, value string) {
+	if key == "" {
+		return
+	}
+	value = strings.TrimSpace(value)
+	switch key {
+	case slowLogTimeKey:
+		for _, layout := range []string{time.RFC3339Nano, slowLogLegacyTime} {
+			if t, err := time.Parse(layout, value); err == nil {
+				p.record.Timestamp = float64(t.Unix()) + float64(t.Nanosecond())/float64(time.Second)
+				return
+			}
+		}
+		return
+	case slowLogUserKey:
+		// e.g. `root[root] @ localhost [127.0.0.1]`
+		parts := strings.SplitN(value, "@", 2)
+		user := strings.TrimSpace(parts[0])
+		if i := strings.IndexByte(user, '['); i >= 0 {
+			user = user[:i]
+		}
+		p.record.User = user
+		if len(parts) == 2 {
+			host := strings.TrimSpace(parts[1])
+			if i := strings.IndexByte(host, '['); i >= 0 && strings.HasSuffix(host, "]") {
+				host = host[i+1 : len(host)-1]
+			}
+			p.record.Host = host
+		}
+		return
+	case slowLogQueryKey:
+		return
+	}
+
+	i, ok := slowLogFields[strings.ToLower(key)]
+	if !ok {
+		return
+	}
+	field := reflect.ValueOf(p.record).Elem().Field(i)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int, reflect.Int64:
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			field.SetInt(v)
+		} else if b, err := strconv.ParseBool(value); err == nil && b {
+			// e.g. Succ and Is_internal
+			field.SetInt(1)
+		}
+	case reflect.Uint, reflect.Uint64:
+		if v, err := strconv.ParseUint(value, 10, 64); err == nil {
+			field.SetUint(v)
+		}
+	case reflect.Float64:
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			field.SetFloat(v)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package slowquery
+
+import (
+	"strings"
+
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testSlowLogSuite{})
+
+type testSlowLogSuite struct{}
+
+const testSlowLog = `# Time: 2021-04-01T10:00:00.5+08:00
+# Txn_start_ts: 423942323874283521
+# User@Host: root[root] @ 127.0.0.1 [127.0.0.1]
+# Conn_ID: 5
+# Query_time: 1.527627037
+# Parse_time: 0.000054933 Compile_time: 0.000129729
+# Process_time: 0.07 Wait_time: 0.002 Process_keys: 131073 Backoff_types: [regionMiss tikvRPC]
+# DB: test
+# Is_internal: false
+# Digest: 42a1c8aae6f133e934d4bf0147491709a8812ea05ff8819ec522780fe657b772
+# Mem_max: 525211
+# Prepared: false
+# Succ: true
+# Plan: tidb_decode_plan('ZJAwCTMyXzcJMAkyMAlkYXRhOlRhYmxlU2Nhbl82CjEJMTdfNgkx')
+use test;
+insert into t select * from t;
+# Time: 2021-04-01-10:00:05.25 +0800
+# User@Host: app[app] @ 10.0.0.1 [10.0.0.1]
+# Query_time: 3
+# DB: app
+# Digest: abc
+# Succ: false
+select *
+from t
+where a = 'x;y';
+`
+
+func (t *testSlowLogSuite) Test_parseSlowLog(c *C) {
+	var records []*Model
+	err := parseSlowLog(strings.NewReader(testSlowLog), "tidb-0:10080", func(m *Model) error {
+		records = append(records, m)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 2)
+
+	r := records[0]
+	c.Assert(r.Instance, Equals, "tidb-0:10080")
+	c.Assert(r.Timestamp, Equals, 1617242400.5)
+	c.Assert(r.TxnStartTS, Equals, "423942323874283521")
+	c.Assert(r.User, Equals, "root")
+	c.Assert(r.Host, Equals, "127.0.0.1")
+	c.Assert(r.ConnectionID, Equals, "5")
+	c.Assert(r.QueryTime, Equals, 1.527627037)
+	c.Assert(r.CompileTime, Equals, 0.000129729)
+	c.Assert(r.ProcessTime, Equals, 0.07)
+	c.Assert(r.ProcessKeys, Equals, uint(131073))
+	c.Assert(r.BackoffTypes, Equals, "[regionMiss tikvRPC]")
+	c.Assert(r.DB, Equals, "test")
+	c.Assert(r.IsInternal, Equals, 0)
+	c.Assert(r.Success, Equals, 1)
+	c.Assert(r.MemoryMax, Equals, 525211)
+	c.Assert(r.Plan, Equals, "tidb_decode_plan('ZJAwCTMyXzcJMAkyMAlkYXRhOlRhYmxlU2Nhbl82CjEJMTdfNgkx')")
+	c.Assert(r.Query, Equals, "insert into t select * from t;")
+
+	r = records[1]
+	c.Assert(r.Timestamp, Equals, 1617242405.25)
+	c.Assert(r.User, Equals, "app")
+	c.Assert(r.QueryTime, Equals, 3.0)
+	c.Assert(r.Success, Equals, 0)
+	c.Assert(r.Query, Equals, "select *\nfrom t\nwhere a = 'x;y';")
+}
+
+func (t *testSlowLogSuite) Test_parseSlowLogUnterminated(c *C) {
+	log := "garbage before the first record\n# Time: 2021-04-01T10:00:00+08:00\n# Query_time: 1\nselect 1"
+	var records []*Model
+	err := parseSlowLog(strings.NewReader(log), "", func(m *Model) error {
+		records = append(records, m)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0].Query, Equals, "select 1")
+	c.Assert(records[0].QueryTime, Equals, 1.0)
+}
@@ -0,0 +1,493 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statement
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pingcap/log"
+	"github.com/thoas/go-funk"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+	"github.com/pingcap/tidb-dashboard/pkg/utils/keyring"
+)
+
+const (
+	archiveTable = "statement_archive"
+	// A temporary table holding windows kept by TiDB, when they are queried together with the archive.
+	liveWindowsTable = "statement_live_windows"
+
+	// The config is checked in this interval, while statements are archived in the configured interval.
+	archiveCheckInterval = time.Minute
+
+	// Each record takes about 80 variables, which should be kept below the default limit of SQLite, i.e. 999.
+	archiveBatchSize = 10
+)
+
+// ArchivedStatementModel is a statement summary window of an instance copied from TiDB. Columns are named the same as
+// the statement summary table, so that statements can be queried from the archive via the same select statement.
+type ArchivedStatementModel struct { //nolint
+	ID uint `gorm:"primary_key"`
+
+	Instance   string `gorm:"uniqueIndex:idx_statement_archive_key"`
+	SchemaName string `gorm:"uniqueIndex:idx_statement_archive_key"`
+	Digest     string `gorm:"uniqueIndex:idx_statement_archive_key"`
+	PlanDigest string `gorm:"uniqueIndex:idx_statement_archive_key"`
+	// Times are stored as unix seconds.
+	SummaryBeginTime int64 `gorm:"uniqueIndex:idx_statement_archive_key;index"`
+	SummaryEndTime   int64 `gorm:"index"`
+	FirstSeen        int64
+	LastSeen         int64
+
+	StmtType        string
+	DigestText      string
+	TableNames      string
+	IndexNames      string
+	SampleUser      string
+	QuerySampleText string
+	PrevSampleText  string
+	Plan            string
+
+	ExecCount             int64
+	SumErrors             int64
+	SumWarnings           int64
+	SumLatency            int64
+	MaxLatency            int64
+	MinLatency            int64
+	AvgLatency            int64
+	AvgParseLatency       int64
+	MaxParseLatency       int64
+	AvgCompileLatency     int64
+	MaxCompileLatency     int64
+	SumCopTaskNum         int64
+	MaxCopProcessTime     int64
+	MaxCopWaitTime        int64
+	AvgProcessTime        int64
+	MaxProcessTime        int64
+	AvgWaitTime           int64
+	MaxWaitTime           int64
+	AvgBackoffTime        int64
+	MaxBackoffTime        int64
+	AvgTotalKeys          int64
+	MaxTotalKeys          int64
+	AvgProcessedKeys      int64
+	MaxProcessedKeys      int64
+	AvgPrewriteTime       int64
+	MaxPrewriteTime       int64
+	AvgCommitTime         int64
+	MaxCommitTime         int64
+	AvgGetCommitTsTime    int64
+	MaxGetCommitTsTime    int64
+	AvgCommitBackoffTime  int64
+	MaxCommitBackoffTime  int64
+	AvgResolveLockTime    int64
+	MaxResolveLockTime    int64
+	AvgLocalLatchWaitTime int64
+	MaxLocalLatchWaitTime int64
+	AvgWriteKeys          float64
+	MaxWriteKeys          int64
+	AvgWriteSize          float64
+	MaxWriteSize          int64
+	AvgPrewriteRegions    float64
+	MaxPrewriteRegions    int64
+	AvgTxnRetry           float64
+	MaxTxnRetry           int64
+	SumBackoffTimes       int64
+	AvgMem                int64
+	MaxMem                int64
+	AvgDisk               int64
+	MaxDisk               int64
+	AvgAffectedRows       float64
+
+	MaxRocksdbDeleteSkippedCount uint64
+	AvgRocksdbDeleteSkippedCount float64
+	MaxRocksdbKeySkippedCount    uint64
+	AvgRocksdbKeySkippedCount    float64
+	MaxRocksdbBlockCacheHitCount uint64
+	AvgRocksdbBlockCacheHitCount float64
+	MaxRocksdbBlockReadCount     uint64
+	AvgRocksdbBlockReadCount     float64
+	MaxRocksdbBlockReadByte      uint64
+	AvgRocksdbBlockReadByte      float64
+}
+
+func (ArchivedStatementModel) TableName() string {
+	return archiveTable
+}
+
+type ArchiveSecretModel struct { //nolint
+	ID uint `gorm:"primary_key"`
+	// Encrypted by the key in `statement_archive_ek.bin`, see the keyring package.
+	EncryptedPassword string `gorm:"type:text"`
+}
+
+func (ArchiveSecretModel) TableName() string {
+	return "statement_archive_secrets"
+}
+
+func autoMigrate(db *dbstore.DB) error {
+	return db.AutoMigrate(&ArchivedStatementModel{}, &ArchiveSecretModel{})
+}
+
+// archiveColumns are columns of the archive table, except the ID.
+var archiveColumns = func() []string {
+	t := reflect.TypeOf(ArchivedStatementModel{})
+	columns := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Name == "ID" {
+			continue
+		}
+		columns = append(columns, gormDefaultNamingStrategy.ColumnName("", t.Field(i).Name))
+	}
+	return columns
+}()
+
+var archiveTimeColumns = []string{"summary_begin_time", "summary_end_time", "first_seen", "last_seen"}
+
+// genArchiveCopyStmt generates the select list to copy statements from TiDB into the archive. Columns not available
+// in the current TiDB version are left empty.
+func genArchiveCopyStmt(tableColumns []string) string {
+	fields := make([]string, 0, len(archiveColumns))
+	for _, column := range archiveColumns {
+		if !utils.IsSubsets(tableColumns, []string{column}) {
+			continue
+		}
+		if funk.ContainsString(archiveTimeColumns, column) {
+			fields = append(fields, fmt.Sprintf("FLOOR(UNIX_TIMESTAMP(%s)) AS %s", column, column))
+		} else {
+			fields = append(fields, column)
+		}
+	}
+	return strings.Join(fields, ", ")
+}
+
+// archiveSelectReplacer converts the select statement generated for TiDB into the SQLite dialect. Aggregations in the
+// model only use a few functions that are not available in SQLite. Times are already stored as unix seconds.
+var archiveSelectReplacer = strings.NewReplacer(
+	"ANY_VALUE(", "MAX(",
+	"AS SIGNED)", "AS INTEGER)",
+	"as SIGNED)", "AS INTEGER)",
+	"UNIX_TIMESTAMP(MIN(first_seen))", "MIN(first_seen)",
+	"UNIX_TIMESTAMP(MAX(last_seen))", "MAX(last_seen)",
+)
+
+func (s *Service) archiveLoop() {
+	ticker := time.NewTicker(archiveCheckInterval)
+	defer ticker.Stop()
+	var lastArchivedAt time.Time
+	for {
+		dc, err := s.params.ConfigManager.Get()
+		// Dynamic config may be not ready yet, try again in the next round.
+		if err == nil {
+			s.cleanupArchive(dc.StatementArchive.RetentionDays)
+			interval := time.Duration(dc.StatementArchive.IntervalSecs) * time.Second
+			if dc.StatementArchive.Enabled && time.Since(lastArchivedAt) >= interval {
+				lastArchivedAt = time.Now()
+				if err := s.archive(dc.StatementArchive.SQLUser); err != nil {
+					log.Warn("Failed to archive statements", zap.Error(err))
+				}
+			}
+		}
+		select {
+		case <-s.lifecycleCtx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// archive copies finished statement summary windows from TiDB into the archive.
+func (s *Service) archive(sqlUser string) error {
+	password, err := s.getArchivePassword()
+	if err != nil {
+		return err
+	}
+	db, err := s.params.TiDBClient.OpenSQLConn(sqlUser, password)
+	if err != nil {
+		return err
+	}
+	defer utils.CloseTiDBConnection(db) //nolint:errcheck
+
+	count, err := s.copyToArchive(db)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		log.Info("Archived statements", zap.Int("count", count))
+	}
+	return nil
+}
+
+// copyToArchive copies windows that are not archived yet, and returns the number of copied records. Windows are
+// copied again from the latest archived one, since instances may report the same window at different times.
+// Duplicated records are skipped.
+func (s *Service) copyToArchive(db *gorm.DB) (int, error) {
+	tableColumns, err := s.params.SysSchema.GetTableColumnNames(db, statementsTable)
+	if err != nil {
+		return 0, err
+	}
+	var latest struct {
+		BeginTime int64 `gorm:"column:begin_time"`
+	}
+	err = s.params.LocalStore.
+		Table(archiveTable).
+		Select("IFNULL(MAX(summary_begin_time), 0) AS begin_time").
+		Scan(&latest).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var records []ArchivedStatementModel
+	err = db.
+		Select(genArchiveCopyStmt(tableColumns)).
+		Table(statementsTable).
+		// Only finished windows are archived
+		Where("summary_begin_time >= FROM_UNIXTIME(?) AND summary_end_time <= NOW()", latest.BeginTime).
+		Find(&records).Error
+	if err != nil {
+		return 0, err
+	}
+	return len(records), s.saveToArchive(records)
+}
+
+func (s *Service) saveToArchive(records []ArchivedStatementModel) error {
+	if len(records) == 0 {
+		return nil
+	}
+	return s.params.LocalStore.
+		Clauses(clause.OnConflict{DoNothing: true}).
+		CreateInBatches(records, archiveBatchSize).Error
+}
+
+func (s *Service) cleanupArchive(retentionDays int) {
+	expireBefore := time.Now().AddDate(0, 0, -retentionDays).Unix()
+	result := s.params.LocalStore.
+		Where("summary_end_time < ?", expireBefore).
+		Delete(&ArchivedStatementModel{})
+	if result.Error != nil {
+		log.Warn("Failed to remove expired archived statements", zap.Error(result.Error))
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Info("Removed expired archived statements", zap.Int64("count", result.RowsAffected))
+	}
+}
+
+// canReadArchive returns whether the SQL user of the connection is allowed to read the archive. Archived statements
+// are copied by the archive SQL user, so that they are only served to users able to see statements of all users.
+func canReadArchive(db *gorm.DB) (bool, error) {
+	return user.VerifyProcessPriv(db)
+}
+
+// getArchiveSplitTime returns the begin time of the oldest window kept by TiDB, if statements in the time range
+// should be partially or fully queried from the archive, i.e. the range begins before the oldest window, the archive
+// contains windows before it, and the SQL user is allowed to read the archive. Windows since the split time are
+// queried from TiDB.
+func (s *Service) getArchiveSplitTime(db *gorm.DB, beginTime, endTime int) (int64, bool, error) {
+	var oldest struct {
+		BeginTime *int64 `gorm:"column:begin_time"`
+	}
+	err := db.
+		Select("FLOOR(UNIX_TIMESTAMP(MIN(summary_begin_time))) AS begin_time").
+		Table(statementsTable).
+		Scan(&oldest).Error
+	if err != nil {
+		return 0, false, err
+	}
+	// No windows may be visible to the SQL user due to insufficient privileges
+	if oldest.BeginTime == nil || int64(beginTime) >= *oldest.BeginTime {
+		return 0, false, nil
+	}
+	var archived int64
+	err = s.params.LocalStore.
+		Model(&ArchivedStatementModel{}).
+		Where("summary_begin_time >= ? AND summary_begin_time < ? AND summary_end_time <= ?", beginTime, *oldest.BeginTime, endTime).
+		Limit(1).
+		Count(&archived).Error
+	if err != nil || archived == 0 {
+		return 0, false, err
+	}
+	canRead, err := canReadArchive(db)
+	if err != nil || !canRead {
+		return 0, false, err
+	}
+	return *oldest.BeginTime, true, nil
+}
+
+// withStatementWindows calls fn with a query of statement windows in the time range, either from TiDB, from the
+// archive, or from both of them. Windows kept by TiDB are copied into a temporary table next to the archive when
+// both are needed, so that they are aggregated together. Filters which are not portable should be applied according
+// to the isArchived flag.
+func (s *Service) withStatementWindows(db *gorm.DB, beginTime, endTime int, fn func(query *gorm.DB, isArchived bool) error) error {
+	splitTime, isArchived, err := s.getArchiveSplitTime(db, beginTime, endTime)
+	if err != nil {
+		return err
+	}
+	if !isArchived {
+		return fn(db.
+			Table(statementsTable).
+			Where("summary_begin_time >= FROM_UNIXTIME(?) AND summary_end_time <= FROM_UNIXTIME(?)", beginTime, endTime), false)
+	}
+
+	var records []ArchivedStatementModel
+	if splitTime < int64(endTime) {
+		tableColumns, err := s.params.SysSchema.GetTableColumnNames(db, statementsTable)
+		if err != nil {
+			return err
+		}
+		err = db.
+			Select(genArchiveCopyStmt(tableColumns)).
+			Table(statementsTable).
+			Where("summary_begin_time >= FROM_UNIXTIME(?) AND summary_end_time <= FROM_UNIXTIME(?)", splitTime, endTime).
+			Find(&records).Error
+		if err != nil {
+			return err
+		}
+	}
+	return s.withArchivedWindows(beginTime, endTime, splitTime, records, fn)
+}
+
+// withArchivedWindows calls fn with a query of archived windows in the time range before the split time, together
+// with the given windows kept by TiDB.
+func (s *Service) withArchivedWindows(beginTime, endTime int, splitTime int64, liveRecords []ArchivedStatementModel, fn func(query *gorm.DB, isArchived bool) error) error {
+	if len(liveRecords) == 0 {
+		return fn(s.params.LocalStore.
+			Table(archiveTable).
+			Where("summary_begin_time >= ? AND summary_begin_time < ? AND summary_end_time <= ?", beginTime, splitTime, endTime), true)
+	}
+
+	tx := s.params.LocalStore.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	// The temporary table is only visible to the connection of the transaction, and is dropped by the rollback
+	defer tx.Rollback()
+	err := tx.Exec(fmt.Sprintf("CREATE TEMP TABLE %s AS SELECT * FROM %s WHERE 0", liveWindowsTable, archiveTable)).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Table(liveWindowsTable).CreateInBatches(liveRecords, archiveBatchSize).Error
+	if err != nil {
+		return err
+	}
+	query := tx.Table(
+		fmt.Sprintf("(SELECT * FROM %s WHERE summary_begin_time >= ? AND summary_begin_time < ? AND summary_end_time <= ? UNION ALL SELECT * FROM %s) AS %s",
+			archiveTable, liveWindowsTable, archiveTable),
+		beginTime, splitTime, endTime)
+	return fn(query, true)
+}
+
+// selectStatements calls fn with a query of statements in the time range with the requested fields, see
+// withStatementWindows.
+func (s *Service) selectStatements(db *gorm.DB, beginTime, endTime int, reqFields []string, fn func(query *gorm.DB, isArchived bool) error) error {
+	return s.withStatementWindows(db, beginTime, endTime, func(query *gorm.DB, isArchived bool) error {
+		var tableColumns []string
+		if isArchived {
+			tableColumns = archiveColumns
+		} else {
+			var err error
+			tableColumns, err = s.params.SysSchema.GetTableColumnNames(db, statementsTable)
+			if err != nil {
+				return err
+			}
+		}
+		selectStmt, err := s.genSelectStmt(tableColumns, reqFields)
+		if err != nil {
+			return err
+		}
+		if isArchived {
+			selectStmt = archiveSelectReplacer.Replace(selectStmt)
+		}
+		return fn(query.Select(selectStmt), isArchived)
+	})
+}
+
+func (s *Service) queryArchivedTimeRanges() (result []*TimeRange, err error) {
+	err = s.params.LocalStore.
+		Select("DISTINCT summary_begin_time AS begin_time, summary_end_time AS end_time").
+		Table(archiveTable).
+		Find(&result).Error
+	return
+}
+
+// mergeTimeRanges merges distinct time ranges, ordered by the begin time and then the end time, descending.
+func mergeTimeRanges(a, b []*TimeRange) []*TimeRange {
+	seen := make(map[TimeRange]struct{}, len(a)+len(b))
+	result := make([]*TimeRange, 0, len(a)+len(b))
+	for _, r := range append(a, b...) {
+		if _, ok := seen[*r]; ok {
+			continue
+		}
+		seen[*r] = struct{}{}
+		result = append(result, r)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].BeginTime != result[j].BeginTime {
+			return result[i].BeginTime > result[j].BeginTime
+		}
+		return result[i].EndTime > result[j].EndTime
+	})
+	return result
+}
+
+func (s *Service) getArchivePassword() (string, error) {
+	var rec ArchiveSecretModel
+	err := s.params.LocalStore.First(&rec).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", fmt.Errorf("bad record: %v", err)
+	}
+	key, err := s.encKey.Get()
+	if err != nil {
+		return "", fmt.Errorf("bad encryption key: %v", err)
+	}
+	decrypted, err := keyring.DecryptHex(rec.EncryptedPassword, key)
+	if err != nil {
+		return "", err
+	}
+	return string(decrypted), nil
+}
+
+func (s *Service) saveArchivePassword(password string) error {
+	key, err := s.encKey.GetOrCreate()
+	if err != nil {
+		return err
+	}
+	encrypted, err := keyring.EncryptToHex([]byte(password), key)
+	if err != nil {
+		return err
+	}
+	return s.params.LocalStore.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&ArchiveSecretModel{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&ArchiveSecretModel{EncryptedPassword: encrypted}).Error
+	})
+}
+
+func (s *Service) revokeArchivePassword() error {
+	return s.params.LocalStore.Where("1 = 1").Delete(&ArchiveSecretModel{}).Error
+}
@@ -0,0 +1,178 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statement
+
+import (
+	"path"
+	"strings"
+
+	. "github.com/pingcap/check"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore/dbstoretest"
+	"github.com/pingcap/tidb-dashboard/pkg/utils/keyring"
+)
+
+var _ = Suite(&testArchiveSuite{})
+
+type testArchiveSuite struct{}
+
+func (t *testArchiveSuite) newService(c *C) *Service {
+	return &Service{
+		params: ServiceParams{LocalStore: dbstoretest.NewDB(c, autoMigrate)},
+		encKey: keyring.NewKeyFile(path.Join(c.MkDir(), "statement_archive_ek.bin")),
+	}
+}
+
+func testArchivedStatement(instance, digest string, beginTime int64, execCount, avgLatency int64) ArchivedStatementModel {
+	return ArchivedStatementModel{
+		Instance:         instance,
+		SchemaName:       "test",
+		Digest:           digest,
+		PlanDigest:       "plan_" + digest,
+		SummaryBeginTime: beginTime,
+		SummaryEndTime:   beginTime + 1800,
+		FirstSeen:        beginTime + 10,
+		LastSeen:         beginTime + 20,
+		StmtType:         "Select",
+		DigestText:       "select * from t where a = ?",
+		TableNames:       "test.t",
+		ExecCount:        execCount,
+		SumLatency:       execCount * avgLatency,
+		MaxLatency:       avgLatency * 2,
+		MinLatency:       avgLatency / 2,
+		AvgLatency:       avgLatency,
+		Plan:             "TableReader_5",
+	}
+}
+
+// queryArchivedStatements queries statements of archived windows in the time range, as well as the given windows
+// kept by TiDB since the split time.
+func queryArchivedStatements(c *C, s *Service, beginTime, endTime int, splitTime int64, liveRecords []ArchivedStatementModel, reqFields []string, filter func(query *gorm.DB) *gorm.DB) []Model {
+	var result []Model
+	err := s.withArchivedWindows(beginTime, endTime, splitTime, liveRecords, func(query *gorm.DB, isArchived bool) error {
+		c.Assert(isArchived, IsTrue)
+		selectStmt, err := s.genSelectStmt(archiveColumns, reqFields)
+		c.Assert(err, IsNil)
+		return filter(query.Select(archiveSelectReplacer.Replace(selectStmt))).Find(&result).Error
+	})
+	c.Assert(err, IsNil)
+	return result
+}
+
+func (t *testArchiveSuite) Test_genArchiveCopyStmt(c *C) {
+	stmt := genArchiveCopyStmt([]string{"INSTANCE", "DIGEST", "SUMMARY_BEGIN_TIME", "EXEC_COUNT"})
+	c.Assert(stmt, Equals, "instance, digest, FLOOR(UNIX_TIMESTAMP(summary_begin_time)) AS summary_begin_time, exec_count")
+}
+
+func (t *testArchiveSuite) Test_QueryArchive(c *C) {
+	s := t.newService(c)
+	records := []ArchivedStatementModel{
+		testArchivedStatement("tidb-0", "d1", 1600000000, 10, 100),
+		testArchivedStatement("tidb-1", "d1", 1600000000, 30, 300),
+		testArchivedStatement("tidb-0", "d2", 1600001800, 5, 1000),
+		testArchivedStatement("tidb-0", "d1", 1600003600, 1, 100),
+	}
+	c.Assert(s.saveToArchive(records), IsNil)
+	// Duplicated windows are skipped
+	c.Assert(s.saveToArchive(records[:2]), IsNil)
+	var count int64
+	c.Assert(s.params.LocalStore.Model(&ArchivedStatementModel{}).Count(&count).Error, IsNil)
+	c.Assert(count, Equals, int64(4))
+
+	result := queryArchivedStatements(c, s, 1600000000, 1600003600, 1600005400, nil, []string{"digest_text", "exec_count", "avg_latency", "first_seen", "last_seen", "plan_count", "table_names"}, func(query *gorm.DB) *gorm.DB {
+		return filterArchivedStatements(query.Group("schema_name, digest").Order("agg_sum_latency DESC"), []string{"test"}, []string{"Select"}, "SELECT")
+	})
+	c.Assert(result, HasLen, 2)
+	c.Assert(result[0].AggDigest, Equals, "d1")
+	c.Assert(result[0].AggExecCount, Equals, 40)
+	c.Assert(result[0].AggAvgLatency, Equals, 250)
+	c.Assert(result[0].AggFirstSeen, Equals, 1600000010)
+	c.Assert(result[0].AggLastSeen, Equals, 1600000020)
+	c.Assert(result[0].AggPlanCount, Equals, 1)
+	c.Assert(result[0].RelatedSchemas, Equals, "test")
+	c.Assert(result[1].AggDigest, Equals, "d2")
+
+	result = queryArchivedStatements(c, s, 1600000000, 1600003600, 1600005400, nil, []string{"digest"}, func(query *gorm.DB) *gorm.DB {
+		return filterArchivedStatements(query.Group("schema_name, digest"), []string{"other"}, nil, "")
+	})
+	c.Assert(result, HasLen, 0)
+
+	ranges, err := s.queryArchivedTimeRanges()
+	c.Assert(err, IsNil)
+	ranges = mergeTimeRanges([]*TimeRange{{BeginTime: 1600003600, EndTime: 1600005400}, {BeginTime: 1600005400, EndTime: 1600007200}}, ranges)
+	c.Assert(ranges, DeepEquals, []*TimeRange{
+		{BeginTime: 1600005400, EndTime: 1600007200},
+		{BeginTime: 1600003600, EndTime: 1600005400},
+		{BeginTime: 1600001800, EndTime: 1600003600},
+		{BeginTime: 1600000000, EndTime: 1600001800},
+	})
+
+	// All records are older than the retention
+	s.cleanupArchive(1)
+	c.Assert(s.params.LocalStore.Model(&ArchivedStatementModel{}).Count(&count).Error, IsNil)
+	c.Assert(count, Equals, int64(0))
+}
+
+func (t *testArchiveSuite) Test_QueryArchiveWithLiveWindows(c *C) {
+	s := t.newService(c)
+	c.Assert(s.saveToArchive([]ArchivedStatementModel{
+		testArchivedStatement("tidb-0", "d1", 1600000000, 10, 100),
+		testArchivedStatement("tidb-0", "d1", 1600001800, 20, 100),
+	}), IsNil)
+	// The window since the split time is still kept by TiDB, and is only counted once
+	liveRecords := []ArchivedStatementModel{
+		testArchivedStatement("tidb-0", "d1", 1600001800, 20, 100),
+		testArchivedStatement("tidb-0", "d2", 1600001800, 5, 100),
+	}
+	// The temporary table is dropped after each query
+	for i := 0; i < 2; i++ {
+		result := queryArchivedStatements(c, s, 1600000000, 1600003600, 1600001800, liveRecords, []string{"digest", "exec_count"}, func(query *gorm.DB) *gorm.DB {
+			return query.Group("schema_name, digest").Order("agg_sum_latency DESC")
+		})
+		c.Assert(result, HasLen, 2)
+		c.Assert(result[0].AggDigest, Equals, "d1")
+		c.Assert(result[0].AggExecCount, Equals, 30)
+		c.Assert(result[1].AggDigest, Equals, "d2")
+		c.Assert(result[1].AggExecCount, Equals, 5)
+	}
+
+	var count int64
+	c.Assert(s.params.LocalStore.Model(&ArchivedStatementModel{}).Count(&count).Error, IsNil)
+	c.Assert(count, Equals, int64(2))
+}
+
+func (t *testArchiveSuite) Test_ArchivePassword(c *C) {
+	s := t.newService(c)
+	password, err := s.getArchivePassword()
+	c.Assert(err, IsNil)
+	c.Assert(password, Equals, "")
+
+	c.Assert(s.saveArchivePassword("secret"), IsNil)
+	c.Assert(s.saveArchivePassword("another secret"), IsNil)
+	password, err = s.getArchivePassword()
+	c.Assert(err, IsNil)
+	c.Assert(password, Equals, "another secret")
+
+	c.Assert(s.revokeArchivePassword(), IsNil)
+	password, err = s.getArchivePassword()
+	c.Assert(err, IsNil)
+	c.Assert(password, Equals, "")
+}
+
+func (t *testArchiveSuite) Test_archiveSelectReplacer(c *C) {
+	stmt := archiveSelectReplacer.Replace("ANY_VALUE(digest) AS agg_digest, CAST(SUM(exec_count * avg_mem) / SUM(exec_count) as SIGNED) AS agg_avg_mem, UNIX_TIMESTAMP(MIN(first_seen)) AS agg_first_seen")
+	c.Assert(stmt, Equals, "MAX(digest) AS agg_digest, CAST(SUM(exec_count * avg_mem) / SUM(exec_count) AS INTEGER) AS agg_avg_mem, MIN(first_seen) AS agg_first_seen")
+	c.Assert(strings.Contains(stmt, "UNIX_TIMESTAMP"), IsFalse)
+}
@@ -0,0 +1,233 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statement
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/joomcode/errorx"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	_ "github.com/pingcap/parser/test_driver" // required by the parser to build value expressions
+	"gorm.io/gorm"
+)
+
+// mysqlErrParse is the error code of syntax errors, returned by TiDB versions not supporting the binding statement.
+const mysqlErrParse = 1064
+
+var (
+	ErrInvalidDigest       = ErrNS.NewType("invalid_digest")
+	ErrBindingNotSupported = ErrNS.NewType("binding_not_supported")
+	ErrBindingNotFound     = ErrNS.NewType("binding_not_found")
+)
+
+var digestRegexp = regexp.MustCompile(`^[0-9a-fA-F]{1,128}$`)
+
+// hintableStmtRegexp matches the leading keyword of statements accepting optimizer hints right after it.
+var hintableStmtRegexp = regexp.MustCompile(`(?i)^\s*(SELECT|INSERT|UPDATE|DELETE|REPLACE)\b`)
+
+// Binding is a row of `SHOW GLOBAL BINDINGS`. SQL and plan digests are only available since TiDB 6.0.
+type Binding struct {
+	OriginalSQL string `json:"original_sql" gorm:"column:Original_sql"`
+	BindSQL     string `json:"bind_sql" gorm:"column:Bind_sql"`
+	DefaultDB   string `json:"default_db" gorm:"column:Default_db"`
+	// One of `enabled`, `using`, `disabled`, `deleted`, `invalid` and `rejected`.
+	Status     string `json:"status" gorm:"column:Status"`
+	CreateTime string `json:"create_time" gorm:"column:Create_time"`
+	UpdateTime string `json:"update_time" gorm:"column:Update_time"`
+	Charset    string `json:"charset" gorm:"column:Charset"`
+	Collation  string `json:"collation" gorm:"column:Collation"`
+	// How the binding is created, e.g. `manual`, `history` and `evolve`.
+	Source     string `json:"source" gorm:"column:Source"`
+	SQLDigest  string `json:"sql_digest" gorm:"column:Sql_digest"`
+	PlanDigest string `json:"plan_digest" gorm:"column:Plan_digest"`
+}
+
+type CreateBindingRequest struct {
+	// The plan to bind, usually picked from the plans of a statement.
+	PlanDigest string `json:"plan_digest" binding:"required"`
+}
+
+type SetBindingStatusRequest struct {
+	SQLDigest string `json:"sql_digest" binding:"required"`
+	Enabled   bool   `json:"enabled"`
+}
+
+// validateDigest checks that the digest is a hex string, so that it can be safely put into binding statements,
+// which do not accept parameters.
+func validateDigest(digest string) error {
+	if !digestRegexp.MatchString(digest) {
+		return ErrInvalidDigest.New("invalid digest %q", digest)
+	}
+	return nil
+}
+
+func genCreateBindingStmt(planDigest string) string {
+	return fmt.Sprintf("CREATE GLOBAL BINDING FROM HISTORY USING PLAN DIGEST '%s'", planDigest)
+}
+
+// parseBindableSQL returns the text of the statement if the SQL is exactly one complete statement accepting plan
+// hints. Samples in the statement history are written by any SQL user and may be truncated, so that they are never
+// put into binding statements without being parsed.
+func parseBindableSQL(sql string) (string, bool) {
+	stmts, _, err := parser.New().Parse(sql, "", "")
+	if err != nil || len(stmts) != 1 {
+		return "", false
+	}
+	switch stmts[0].(type) {
+	case *ast.SelectStmt, *ast.InsertStmt, *ast.UpdateStmt, *ast.DeleteStmt:
+	default:
+		return "", false
+	}
+	return strings.TrimRight(stmts[0].Text(), "; \t\r\n"), true
+}
+
+// genHintedSQL puts the plan hint into the SQL after its leading keyword. False is returned if the statement does
+// not accept hints there.
+func genHintedSQL(sql, planHint string) (string, bool) {
+	loc := hintableStmtRegexp.FindStringSubmatchIndex(sql)
+	if loc == nil || strings.Contains(planHint, "*/") {
+		return "", false
+	}
+	return sql[:loc[3]] + " /*+ " + planHint + " */" + sql[loc[3]:], true
+}
+
+func genCreateBindingForStmt(originalSQL, hintedSQL string) string {
+	return fmt.Sprintf("CREATE GLOBAL BINDING FOR %s USING %s", originalSQL, hintedSQL)
+}
+
+func genDropBindingStmt(sqlDigest string) string {
+	return fmt.Sprintf("DROP GLOBAL BINDING FOR SQL DIGEST '%s'", sqlDigest)
+}
+
+func genSetBindingStatusStmt(sqlDigest string, enabled bool) string {
+	status := "DISABLED"
+	if enabled {
+		status = "ENABLED"
+	}
+	return fmt.Sprintf("SET BINDING %s FOR SQL DIGEST '%s'", status, sqlDigest)
+}
+
+// wrapBindingError turns syntax errors into ErrBindingNotSupported, since the statement is always valid when the
+// TiDB version supports it.
+func wrapBindingError(err error, feature string) error {
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == mysqlErrParse {
+		return ErrBindingNotSupported.Wrap(err, "%s is not supported by the TiDB version", feature)
+	}
+	return err
+}
+
+func queryBindings(db *gorm.DB) ([]Binding, error) {
+	bindings := make([]Binding, 0)
+	err := db.Raw("SHOW GLOBAL BINDINGS").Scan(&bindings).Error
+	if err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
+
+// createBinding binds the statement to the plan. TiDB versions not supporting bindings from plan digests fall back
+// to a binding built from the plan hint of the plan.
+func createBinding(db *gorm.DB, planDigest string) error {
+	if err := validateDigest(planDigest); err != nil {
+		return err
+	}
+	err := wrapBindingError(db.Exec(genCreateBindingStmt(planDigest)).Error, "Creating bindings from plan digests")
+	if !errorx.IsOfType(err, ErrBindingNotSupported) {
+		return err
+	}
+	return createBindingFromPlanHint(db, planDigest)
+}
+
+// createBindingFromPlanHint binds the latest sample of the plan in the statement history to the plan hint. The
+// sample is used as the original SQL instead of the digest text, since placeholders in the digest text are not
+// accepted by binding statements.
+func createBindingFromPlanHint(db *gorm.DB, planDigest string) error {
+	var plan struct {
+		SchemaName      string `gorm:"column:schema_name"`
+		QuerySampleText string `gorm:"column:query_sample_text"`
+		PlanHint        string `gorm:"column:plan_hint"`
+	}
+	err := db.
+		Select("schema_name, query_sample_text, plan_hint").
+		Table(statementsTable).
+		Where("plan_digest = ?", planDigest).
+		Order("summary_begin_time DESC").
+		Limit(1).
+		Scan(&plan).Error
+	if err != nil {
+		return err
+	}
+	if plan.QuerySampleText == "" {
+		return ErrPlanNotFound.New("plan %s is not found in the statement history", planDigest)
+	}
+	originalSQL, ok := parseBindableSQL(plan.QuerySampleText)
+	if !ok {
+		return ErrBindingNotSupported.New("the sample of plan %s is not a complete statement accepting plan hints", planDigest)
+	}
+	hintedSQL, ok := genHintedSQL(originalSQL, plan.PlanHint)
+	if plan.PlanHint == "" || !ok {
+		return ErrBindingNotSupported.New("plan %s can not be bound by plan hints", planDigest)
+	}
+	// Tables in the sample may be not qualified, so that the binding is created in the schema of the statement
+	return db.Transaction(func(tx *gorm.DB) error {
+		if plan.SchemaName != "" {
+			err := tx.Exec(fmt.Sprintf("USE `%s`", strings.ReplaceAll(plan.SchemaName, "`", "``"))).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Exec(genCreateBindingForStmt(originalSQL, hintedSQL)).Error
+	})
+}
+
+// findBinding returns the binding of the SQL digest. Bindings already deleted are ignored.
+func findBinding(db *gorm.DB, sqlDigest string) (*Binding, error) {
+	bindings, err := queryBindings(db)
+	if err != nil {
+		return nil, err
+	}
+	for i := range bindings {
+		if bindings[i].SQLDigest == sqlDigest && bindings[i].Status != "deleted" {
+			return &bindings[i], nil
+		}
+	}
+	return nil, ErrBindingNotFound.New("binding for SQL digest %s is not found", sqlDigest)
+}
+
+func dropBinding(db *gorm.DB, sqlDigest string) error {
+	if err := validateDigest(sqlDigest); err != nil {
+		return err
+	}
+	// Dropping a binding that does not exist succeeds silently, so that it is checked first
+	if _, err := findBinding(db, sqlDigest); err != nil {
+		return err
+	}
+	err := db.Exec(genDropBindingStmt(sqlDigest)).Error
+	return wrapBindingError(err, "Dropping bindings by SQL digests")
+}
+
+func setBindingStatus(db *gorm.DB, sqlDigest string, enabled bool) error {
+	if err := validateDigest(sqlDigest); err != nil {
+		return err
+	}
+	if _, err := findBinding(db, sqlDigest); err != nil {
+		return err
+	}
+	err := db.Exec(genSetBindingStatusStmt(sqlDigest, enabled)).Error
+	return wrapBindingError(err, "Enabling or disabling bindings")
+}
@@ -0,0 +1,96 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statement
+
+import (
+	"errors"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testBindingSuite{})
+
+type testBindingSuite struct{}
+
+func (t *testBindingSuite) Test_validateDigest(c *C) {
+	c.Assert(validateDigest("e5796985ccafe2f71126ed6c0ac939ffa015a8c0744a24b7aee6d587103fd2f7"), IsNil)
+	c.Assert(validateDigest("ABCdef0123"), IsNil)
+
+	for _, digest := range []string{"", "xyz", "abc' OR '1", "abc\\", "abc def"} {
+		err := validateDigest(digest)
+		c.Assert(errorx.IsOfType(err, ErrInvalidDigest), IsTrue, Commentf("digest %q", digest))
+	}
+}
+
+func (t *testBindingSuite) Test_genBindingStmts(c *C) {
+	c.Assert(genCreateBindingStmt("abc"), Equals, "CREATE GLOBAL BINDING FROM HISTORY USING PLAN DIGEST 'abc'")
+	c.Assert(genCreateBindingForStmt("select * from t", "select /*+ use_index(t, a) */ * from t"), Equals, "CREATE GLOBAL BINDING FOR select * from t USING select /*+ use_index(t, a) */ * from t")
+	c.Assert(genDropBindingStmt("abc"), Equals, "DROP GLOBAL BINDING FOR SQL DIGEST 'abc'")
+	c.Assert(genSetBindingStatusStmt("abc", true), Equals, "SET BINDING ENABLED FOR SQL DIGEST 'abc'")
+	c.Assert(genSetBindingStatusStmt("abc", false), Equals, "SET BINDING DISABLED FOR SQL DIGEST 'abc'")
+}
+
+func (t *testBindingSuite) Test_genHintedSQL(c *C) {
+	sql, ok := genHintedSQL("  select * from t where a = 1", "use_index(@`sel_1` `test`.`t` `a`)")
+	c.Assert(ok, IsTrue)
+	c.Assert(sql, Equals, "  select /*+ use_index(@`sel_1` `test`.`t` `a`) */ * from t where a = 1")
+
+	sql, ok = genHintedSQL("UPDATE t SET a = 1", "use_index(t, a)")
+	c.Assert(ok, IsTrue)
+	c.Assert(sql, Equals, "UPDATE /*+ use_index(t, a) */ t SET a = 1")
+
+	_, ok = genHintedSQL("with c as (select 1) select * from c", "use_index(t, a)")
+	c.Assert(ok, IsFalse)
+	_, ok = genHintedSQL("selection", "use_index(t, a)")
+	c.Assert(ok, IsFalse)
+	_, ok = genHintedSQL("select 1", "*/ drop table t")
+	c.Assert(ok, IsFalse)
+}
+
+func (t *testBindingSuite) Test_parseBindableSQL(c *C) {
+	sql, ok := parseBindableSQL("select * from t where a = 'x;y';")
+	c.Assert(ok, IsTrue)
+	c.Assert(sql, Equals, "select * from t where a = 'x;y'")
+
+	sql, ok = parseBindableSQL("REPLACE INTO t VALUES (1)")
+	c.Assert(ok, IsTrue)
+	c.Assert(sql, Equals, "REPLACE INTO t VALUES (1)")
+
+	for _, sample := range []string{
+		"",
+		"select * from t where a in (1, 2(len:40)",
+		"select 1; drop table t",
+		"select 1 using select 1",
+		"drop table t",
+		"set @a = 1",
+	} {
+		_, ok := parseBindableSQL(sample)
+		c.Assert(ok, IsFalse, Commentf("sample %q", sample))
+	}
+}
+
+func (t *testBindingSuite) Test_wrapBindingError(c *C) {
+	c.Assert(wrapBindingError(nil, "x"), IsNil)
+
+	err := wrapBindingError(&mysql.MySQLError{Number: mysqlErrParse, Message: "syntax error"}, "x")
+	c.Assert(errorx.IsOfType(err, ErrBindingNotSupported), IsTrue)
+
+	other := &mysql.MySQLError{Number: 1105, Message: "can't find any plans"}
+	c.Assert(wrapBindingError(other, "x"), Equals, other)
+
+	plain := errors.New("connection lost")
+	c.Assert(wrapBindingError(plain, "x"), Equals, plain)
+}
@@ -25,8 +25,11 @@ const (
 	statementsTable = "INFORMATION_SCHEMA.CLUSTER_STATEMENTS_SUMMARY_HISTORY"
 )
 
-func queryTimeRanges(db *gorm.DB) (result []*TimeRange, err error) {
-	err = db.
+// queryTimeRanges returns time ranges kept by TiDB, as well as those in the archive if the SQL user is allowed to
+// read it.
+func (s *Service) queryTimeRanges(db *gorm.DB) ([]*TimeRange, error) {
+	var result []*TimeRange
+	err := db.
 		Select(`
 			DISTINCT
 			FLOOR(UNIX_TIMESTAMP(summary_begin_time)) AS begin_time,
@@ -35,7 +38,18 @@ func queryTimeRanges(db *gorm.DB) (result []*TimeRange, err error) {
 		Table(statementsTable).
 		Order("begin_time DESC, end_time DESC").
 		Find(&result).Error
-	return
+	if err != nil {
+		return nil, err
+	}
+	canRead, err := canReadArchive(db)
+	if err != nil || !canRead {
+		return result, err
+	}
+	archived, err := s.queryArchivedTimeRanges()
+	if err != nil {
+		return nil, err
+	}
+	return mergeTimeRanges(result, archived), nil
 }
 
 func queryStmtTypes(db *gorm.DB) (result []string, err error) {
@@ -62,52 +76,46 @@ func (s *Service) queryStatements(
 	text string,
 	reqFields []string,
 ) (result []Model, err error) {
-	tableColumns, err := s.params.SysSchema.GetTableColumnNames(db, statementsTable)
-	if err != nil {
-		return nil, err
-	}
-
-	selectStmt, err := s.genSelectStmt(tableColumns, reqFields)
-	if err != nil {
-		return nil, err
-	}
-
-	query := db.
-		Select(selectStmt).
-		Table(statementsTable).
-		Where("summary_begin_time >= FROM_UNIXTIME(?) AND summary_end_time <= FROM_UNIXTIME(?)", beginTime, endTime).
-		Group("schema_name, digest").
-		Order("agg_sum_latency DESC")
+	err = s.selectStatements(db, beginTime, endTime, reqFields, func(query *gorm.DB, isArchived bool) error {
+		query = query.
+			Group("schema_name, digest").
+			Order("agg_sum_latency DESC")
+
+		// REGEXP is not available in the archive
+		if isArchived {
+			return filterArchivedStatements(query, schemas, stmtTypes, text).Find(&result).Error
+		}
 
-	if len(schemas) > 0 {
-		regex := make([]string, 0, len(schemas))
-		for _, schema := range schemas {
-			regex = append(regex, fmt.Sprintf("\\b%s\\.", regexp.QuoteMeta(schema)))
+		if len(schemas) > 0 {
+			regex := make([]string, 0, len(schemas))
+			for _, schema := range schemas {
+				regex = append(regex, fmt.Sprintf("\\b%s\\.", regexp.QuoteMeta(schema)))
+			}
+			regexAll := strings.Join(regex, "|")
+			query = query.Where("table_names REGEXP ?", regexAll)
 		}
-		regexAll := strings.Join(regex, "|")
-		query = query.Where("table_names REGEXP ?", regexAll)
-	}
 
-	if len(stmtTypes) > 0 {
-		query = query.Where("stmt_type in (?)", stmtTypes)
-	}
+		if len(stmtTypes) > 0 {
+			query = query.Where("stmt_type in (?)", stmtTypes)
+		}
 
-	if len(text) > 0 {
-		lowerText := strings.ToLower(text)
-		arr := strings.Fields(lowerText)
-		for _, v := range arr {
-			query = query.Where(
-				`LOWER(digest_text) REGEXP ?
-				 OR LOWER(digest) REGEXP ?
-				 OR LOWER(schema_name) REGEXP ?
-				 OR LOWER(table_names) REGEXP ?
-				 OR LOWER(plan) REGEXP ?`,
-				v, v, v, v, v,
-			)
+		if len(text) > 0 {
+			lowerText := strings.ToLower(text)
+			arr := strings.Fields(lowerText)
+			for _, v := range arr {
+				query = query.Where(
+					`LOWER(digest_text) REGEXP ?
+					 OR LOWER(digest) REGEXP ?
+					 OR LOWER(schema_name) REGEXP ?
+					 OR LOWER(table_names) REGEXP ?
+					 OR LOWER(plan) REGEXP ?`,
+					v, v, v, v, v,
+				)
+			}
 		}
-	}
 
-	err = query.Find(&result).Error
+		return query.Find(&result).Error
+	})
 	return
 }
 
@@ -116,12 +124,7 @@ func (s *Service) queryPlans(
 	beginTime, endTime int,
 	schemaName, digest string,
 ) (result []Model, err error) {
-	tableColumns, err := s.params.SysSchema.GetTableColumnNames(db, statementsTable)
-	if err != nil {
-		return nil, err
-	}
-
-	selectStmt, err := s.genSelectStmt(tableColumns, []string{
+	reqFields := []string{
 		"plan_digest",
 		"schema_name",
 		"digest_text",
@@ -132,29 +135,22 @@ func (s *Service) queryPlans(
 		"avg_latency",
 		"exec_count",
 		"avg_mem",
-		"max_mem"})
-	if err != nil {
-		return nil, err
-	}
-
-	query := db.
-		Select(selectStmt).
-		Table(statementsTable).
-		Where("summary_begin_time >= FROM_UNIXTIME(?) AND summary_end_time <= FROM_UNIXTIME(?)", beginTime, endTime).
-		Group("plan_digest")
-
-	if digest == "" {
-		// the evicted record's digest will be NULL
-		query.Where("digest IS NULL")
-	} else {
-		if schemaName != "" {
-			query.Where("schema_name = ?", schemaName)
+		"max_mem"}
+	err = s.selectStatements(db, beginTime, endTime, reqFields, func(query *gorm.DB, _ bool) error {
+		query = query.Group("plan_digest")
+
+		if digest == "" {
+			// the evicted record's digest will be NULL
+			query.Where("digest IS NULL")
+		} else {
+			if schemaName != "" {
+				query.Where("schema_name = ?", schemaName)
+			}
+			query.Where("digest = ?", digest)
 		}
-		query.Where("digest = ?", digest)
-	}
-
-	err = query.Find(&result).Error
 
+		return query.Find(&result).Error
+	})
 	return
 }
 
@@ -164,34 +160,83 @@ func (s *Service) queryPlanDetail(
 	schemaName, digest string,
 	plans []string,
 ) (result Model, err error) {
-	tableColumns, err := s.params.SysSchema.GetTableColumnNames(db, statementsTable)
-	if err != nil {
-		return
+	err = s.selectStatements(db, beginTime, endTime, []string{"*"}, func(query *gorm.DB, _ bool) error {
+		if digest == "" {
+			// the evicted record's digest will be NULL
+			query.Where("digest IS NULL")
+		} else {
+			if schemaName != "" {
+				query.Where("schema_name = ?", schemaName)
+			}
+			if len(plans) > 0 {
+				query = query.Where("plan_digest in (?)", plans)
+			}
+			query.Where("digest = ?", digest)
+		}
+
+		return query.Scan(&result).Error
+	})
+	return
+}
+
+// queryPlanText returns the plan text of the given plan digest in the time range. An empty string is returned if the
+// plan is not found.
+func (s *Service) queryPlanText(
+	db *gorm.DB,
+	beginTime, endTime int,
+	schemaName, digest, planDigest string,
+) (string, error) {
+	var result struct {
+		Plan string `gorm:"column:plan"`
 	}
+	err := s.withStatementWindows(db, beginTime, endTime, func(query *gorm.DB, _ bool) error {
+		query = query.
+			Select("plan").
+			Where("digest = ?", digest).
+			Where("plan_digest = ?", planDigest)
+		if schemaName != "" {
+			query = query.Where("schema_name = ?", schemaName)
+		}
+		return query.Order("summary_begin_time DESC").Limit(1).Scan(&result).Error
+	})
+	return result.Plan, err
+}
 
-	selectStmt, err := s.genSelectStmt(tableColumns, []string{"*"})
-	if err != nil {
-		return
+// filterArchivedStatements applies filters of queryStatements to the archive, using LIKE instead of REGEXP.
+func filterArchivedStatements(query *gorm.DB, schemas, stmtTypes []string, text string) *gorm.DB {
+	if len(schemas) > 0 {
+		conditions := make([]string, 0, len(schemas))
+		args := make([]interface{}, 0, len(schemas)*2)
+		for _, schema := range schemas {
+			// table_names example: "d1.a1,d2.a2"
+			conditions = append(conditions, `(table_names LIKE ? ESCAPE '\' OR table_names LIKE ? ESCAPE '\')`)
+			pattern := escapeLike(schema) + ".%"
+			args = append(args, pattern, "%,"+pattern)
+		}
+		query = query.Where(strings.Join(conditions, " OR "), args...)
 	}
 
-	query := db.
-		Select(selectStmt).
-		Table(statementsTable).
-		Where("summary_begin_time >= FROM_UNIXTIME(?) AND summary_end_time <= FROM_UNIXTIME(?)", beginTime, endTime)
+	if len(stmtTypes) > 0 {
+		query = query.Where("stmt_type in (?)", stmtTypes)
+	}
 
-	if digest == "" {
-		// the evicted record's digest will be NULL
-		query.Where("digest IS NULL")
-	} else {
-		if schemaName != "" {
-			query.Where("schema_name = ?", schemaName)
-		}
-		if len(plans) > 0 {
-			query = query.Where("plan_digest in (?)", plans)
+	if len(text) > 0 {
+		for _, v := range strings.Fields(strings.ToLower(text)) {
+			pattern := "%" + escapeLike(v) + "%"
+			query = query.Where(
+				`LOWER(digest_text) LIKE ? ESCAPE '\'
+				 OR LOWER(digest) LIKE ? ESCAPE '\'
+				 OR LOWER(schema_name) LIKE ? ESCAPE '\'
+				 OR LOWER(table_names) LIKE ? ESCAPE '\'
+				 OR LOWER(plan) LIKE ? ESCAPE '\'`,
+				pattern, pattern, pattern, pattern, pattern,
+			)
 		}
-		query.Where("digest = ?", digest)
 	}
+	return query
+}
 
-	err = query.Scan(&result).Error
-	return
+// escapeLike escapes wildcards in the LIKE pattern, using `\` as the escape character.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
 }
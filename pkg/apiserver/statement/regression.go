@@ -0,0 +1,201 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statement
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultRegressionMinExecCount = 10
+	defaultRegressionMinRatio     = 1.2
+	defaultRegressionLimit        = 100
+	maxRegressionLimit            = 1000
+
+	planDetailPath = "/statements/plan/detail"
+)
+
+type GetPlanRegressionsRequest struct {
+	BeginTime int `json:"begin_time" form:"begin_time"`
+	EndTime   int `json:"end_time" form:"end_time"`
+	// Plans executed less than this are not compared, since their latency is not stable. Default to 10.
+	MinExecCount int `json:"min_exec_count" form:"min_exec_count"`
+	// Only regressions whose latency ratio of the current plan to the best plan is at least this are returned.
+	// Default to 1.2.
+	MinRatio float64 `json:"min_ratio" form:"min_ratio"`
+	// Default to 100, at most 1000.
+	Limit int `json:"limit" form:"limit"`
+}
+
+type PlanStats struct {
+	PlanDigest       string `json:"plan_digest"`
+	ExecCount        int    `json:"exec_count"`
+	AvgLatency       int    `json:"avg_latency"`
+	AvgProcessedKeys int    `json:"avg_processed_keys"`
+	FirstSeen        int    `json:"first_seen"`
+	LastSeen         int    `json:"last_seen"`
+	// Path and query of the plan detail API of the plan, relative to the API prefix.
+	DetailURL string `json:"detail_url"`
+}
+
+type PlanRegression struct {
+	SchemaName string `json:"schema_name"`
+	Digest     string `json:"digest"`
+	DigestText string `json:"digest_text"`
+	PlanCount  int    `json:"plan_count"`
+	// The most recently used plan.
+	CurrentPlan PlanStats `json:"current_plan"`
+	// The plan with the lowest average latency.
+	BestPlan PlanStats `json:"best_plan"`
+	// Average latency of the current plan divided by the best plan.
+	LatencyRatio float64 `json:"latency_ratio"`
+	// Average processed keys of the current plan divided by the best plan, or 0 if the best plan processes no keys.
+	ProcessedKeysRatio float64 `json:"processed_keys_ratio"`
+	// The extra time spent by executions of the current plan compared with the best plan, in nanoseconds.
+	// Regressions are ranked by this.
+	ExtraLatency int64 `json:"extra_latency"`
+}
+
+// planDetailURL returns the URL of queryPlanDetail for the plan.
+func planDetailURL(beginTime, endTime int, schemaName, digest, planDigest string) string {
+	q := url.Values{}
+	q.Set("begin_time", strconv.Itoa(beginTime))
+	q.Set("end_time", strconv.Itoa(endTime))
+	q.Set("schema_name", schemaName)
+	q.Set("digest", digest)
+	q.Set("plans", planDigest)
+	return planDetailPath + "?" + q.Encode()
+}
+
+// analyzePlanRegressions finds digests whose most recently used plan is slower than another plan used in the time
+// range. Statements should be grouped by schema, digest and plan digest.
+func analyzePlanRegressions(statements []Model, req *GetPlanRegressionsRequest) []PlanRegression {
+	type digestKey struct {
+		schema string
+		digest string
+	}
+	plansByDigest := make(map[digestKey][]Model)
+	keys := make([]digestKey, 0)
+	for _, stmt := range statements {
+		// Evicted statements do not have a digest
+		if stmt.AggDigest == "" || stmt.AggExecCount < req.MinExecCount {
+			continue
+		}
+		key := digestKey{stmt.AggSchemaName, stmt.AggDigest}
+		if _, ok := plansByDigest[key]; !ok {
+			keys = append(keys, key)
+		}
+		plansByDigest[key] = append(plansByDigest[key], stmt)
+	}
+
+	toStats := func(m Model) PlanStats {
+		return PlanStats{
+			PlanDigest:       m.AggPlanDigest,
+			ExecCount:        m.AggExecCount,
+			AvgLatency:       m.AggAvgLatency,
+			AvgProcessedKeys: m.AggAvgProcessedKeys,
+			FirstSeen:        m.AggFirstSeen,
+			LastSeen:         m.AggLastSeen,
+			DetailURL:        planDetailURL(req.BeginTime, req.EndTime, m.AggSchemaName, m.AggDigest, m.AggPlanDigest),
+		}
+	}
+
+	regressions := make([]PlanRegression, 0)
+	for _, key := range keys {
+		plans := plansByDigest[key]
+		if len(plans) < 2 {
+			continue
+		}
+		current, best := plans[0], plans[0]
+		for _, p := range plans[1:] {
+			if p.AggLastSeen > current.AggLastSeen {
+				current = p
+			}
+			if p.AggAvgLatency < best.AggAvgLatency {
+				best = p
+			}
+		}
+		if current.AggPlanDigest == best.AggPlanDigest || best.AggAvgLatency <= 0 {
+			continue
+		}
+		ratio := float64(current.AggAvgLatency) / float64(best.AggAvgLatency)
+		if ratio < req.MinRatio {
+			continue
+		}
+		keysRatio := 0.0
+		if best.AggAvgProcessedKeys > 0 {
+			keysRatio = float64(current.AggAvgProcessedKeys) / float64(best.AggAvgProcessedKeys)
+		}
+		regressions = append(regressions, PlanRegression{
+			SchemaName:         key.schema,
+			Digest:             key.digest,
+			DigestText:         current.AggDigestText,
+			PlanCount:          len(plans),
+			CurrentPlan:        toStats(current),
+			BestPlan:           toStats(best),
+			LatencyRatio:       ratio,
+			ProcessedKeysRatio: keysRatio,
+			ExtraLatency:       int64(current.AggAvgLatency-best.AggAvgLatency) * int64(current.AggExecCount),
+		})
+	}
+
+	sort.SliceStable(regressions, func(i, j int) bool {
+		return regressions[i].ExtraLatency > regressions[j].ExtraLatency
+	})
+	return regressions
+}
+
+func (s *Service) queryPlanRegressions(db *gorm.DB, req *GetPlanRegressionsRequest) ([]PlanRegression, error) {
+	if req.MinExecCount <= 0 {
+		req.MinExecCount = defaultRegressionMinExecCount
+	}
+	if req.MinRatio <= 0 {
+		req.MinRatio = defaultRegressionMinRatio
+	}
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultRegressionLimit
+	} else if limit > maxRegressionLimit {
+		limit = maxRegressionLimit
+	}
+
+	reqFields := []string{
+		"schema_name",
+		"digest",
+		"digest_text",
+		"plan_digest",
+		"exec_count",
+		"avg_latency",
+		"avg_processed_keys",
+		"first_seen",
+		"last_seen",
+	}
+	var statements []Model
+	err := s.selectStatements(db, req.BeginTime, req.EndTime, reqFields, func(query *gorm.DB, _ bool) error {
+		return query.Group("schema_name, digest, plan_digest").Find(&statements).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	regressions := analyzePlanRegressions(statements, req)
+	if len(regressions) > limit {
+		regressions = regressions[:limit]
+	}
+	return regressions, nil
+}
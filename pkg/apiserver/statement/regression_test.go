@@ -0,0 +1,80 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statement
+
+import (
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testRegressionSuite{})
+
+type testRegressionSuite struct{}
+
+func testPlan(digest, planDigest string, execCount, avgLatency, avgKeys, lastSeen int) Model {
+	return Model{
+		AggSchemaName:       "test",
+		AggDigest:           digest,
+		AggDigestText:       "select ?",
+		AggPlanDigest:       planDigest,
+		AggExecCount:        execCount,
+		AggAvgLatency:       avgLatency,
+		AggAvgProcessedKeys: avgKeys,
+		AggLastSeen:         lastSeen,
+	}
+}
+
+func (t *testRegressionSuite) Test_analyzePlanRegressions(c *C) {
+	statements := []Model{
+		// Switched to a plan 3x slower
+		testPlan("d1", "p1", 100, 1000, 10, 100),
+		testPlan("d1", "p2", 50, 3000, 1000, 200),
+		// Switched to a faster plan
+		testPlan("d2", "p1", 100, 3000, 10, 100),
+		testPlan("d2", "p2", 100, 1000, 10, 200),
+		// Slightly slower
+		testPlan("d3", "p1", 100, 1000, 10, 100),
+		testPlan("d3", "p2", 100, 1100, 10, 200),
+		// The best plan is executed too few times
+		testPlan("d4", "p1", 5, 10, 10, 100),
+		testPlan("d4", "p2", 100, 1000, 10, 200),
+		// Switched to a plan 2x slower, with more executions
+		testPlan("d5", "p1", 1000, 1000, 0, 100),
+		testPlan("d5", "p2", 1000, 2000, 10, 200),
+		testPlan("d5", "p3", 1000, 1500, 10, 150),
+		// Only one plan
+		testPlan("d6", "p1", 100, 1000, 10, 100),
+		// Evicted
+		testPlan("", "", 1000, 1000, 10, 100),
+	}
+	req := &GetPlanRegressionsRequest{BeginTime: 1, EndTime: 2, MinExecCount: 10, MinRatio: 1.2}
+	regressions := analyzePlanRegressions(statements, req)
+	c.Assert(regressions, HasLen, 2)
+
+	r := regressions[0]
+	c.Assert(r.Digest, Equals, "d5")
+	c.Assert(r.PlanCount, Equals, 3)
+	c.Assert(r.CurrentPlan.PlanDigest, Equals, "p2")
+	c.Assert(r.BestPlan.PlanDigest, Equals, "p1")
+	c.Assert(r.LatencyRatio, Equals, 2.0)
+	c.Assert(r.ProcessedKeysRatio, Equals, 0.0)
+	c.Assert(r.ExtraLatency, Equals, int64(1000*1000))
+
+	r = regressions[1]
+	c.Assert(r.Digest, Equals, "d1")
+	c.Assert(r.LatencyRatio, Equals, 3.0)
+	c.Assert(r.ProcessedKeysRatio, Equals, 100.0)
+	c.Assert(r.ExtraLatency, Equals, int64(2000*50))
+	c.Assert(r.CurrentPlan.DetailURL, Equals, "/statements/plan/detail?begin_time=1&digest=d1&end_time=2&plans=p2&schema_name=test")
+	c.Assert(r.BestPlan.DetailURL, Equals, "/statements/plan/detail?begin_time=1&digest=d1&end_time=2&plans=p1&schema_name=test")
+}
@@ -14,8 +14,10 @@
 package statement
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"path"
 	"strings"
 	"time"
 
@@ -26,29 +28,55 @@ import (
 
 	"go.uber.org/fx"
 
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/audit"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
 	"github.com/pingcap/tidb-dashboard/pkg/tidb"
+	"github.com/pingcap/tidb-dashboard/pkg/tidb/plan"
 	commonUtils "github.com/pingcap/tidb-dashboard/pkg/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/utils/keyring"
 )
 
 var (
 	ErrNS     = errorx.NewNamespace("error.api.statement")
 	ErrNoData = ErrNS.NewType("export_no_data")
+
+	ErrPlanNotFound = ErrNS.NewType("plan_not_found")
 )
 
 type ServiceParams struct {
 	fx.In
-	TiDBClient *tidb.Client
-	SysSchema  *commonUtils.SysSchema
+	TiDBClient    *tidb.Client
+	SysSchema     *commonUtils.SysSchema
+	LocalStore    *dbstore.DB
+	ConfigManager *config.DynamicConfigManager
 }
 
 type Service struct {
-	params ServiceParams
+	params       ServiceParams
+	lifecycleCtx context.Context
+
+	encKey *keyring.KeyFile
 }
 
-func newService(p ServiceParams) *Service {
-	return &Service{params: p}
+func newService(p ServiceParams, lc fx.Lifecycle, config *config.Config) (*Service, error) {
+	if err := autoMigrate(p.LocalStore); err != nil {
+		return nil, err
+	}
+	s := &Service{
+		params: p,
+		encKey: keyring.NewKeyFile(path.Join(config.DataDir, "statement_archive_ek.bin")),
+	}
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			s.lifecycleCtx = ctx
+			go s.archiveLoop()
+			return nil
+		},
+	})
+	return s, nil
 }
 
 func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
@@ -57,15 +85,25 @@ func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 		endpoint.GET("/download", s.downloadHandler)
 
 		endpoint.Use(auth.MWAuthRequired())
+		endpoint.GET("/archive/config", s.archiveConfigHandler)
+		endpoint.PUT("/archive/config", auth.MWRequirePermission(utils.PermSettingsEdit), s.modifyArchiveConfigHandler)
+
 		endpoint.Use(utils.MWConnectTiDB(s.params.TiDBClient))
 		{
 			endpoint.GET("/config", s.configHandler)
-			endpoint.POST("/config", auth.MWRequireWritePriv(), s.modifyConfigHandler)
+			endpoint.POST("/config", auth.MWRequirePermission(utils.PermSettingsEdit), s.modifyConfigHandler)
 			endpoint.GET("/time_ranges", s.timeRangesHandler)
 			endpoint.GET("/stmt_types", s.stmtTypesHandler)
 			endpoint.GET("/list", s.listHandler)
 			endpoint.GET("/plans", s.plansHandler)
 			endpoint.GET("/plan/detail", s.planDetailHandler)
+			endpoint.GET("/plan/tree", s.planTreeHandler)
+			endpoint.GET("/plan/regressions", s.planRegressionsHandler)
+
+			endpoint.GET("/bindings", s.bindingsHandler)
+			endpoint.POST("/bindings", auth.MWRequirePermission(utils.PermBindingManage), s.createBindingHandler)
+			endpoint.DELETE("/bindings", auth.MWRequirePermission(utils.PermBindingManage), s.dropBindingHandler)
+			endpoint.PUT("/bindings/status", auth.MWRequirePermission(utils.PermBindingManage), s.setBindingStatusHandler)
 
 			endpoint.POST("/download/token", s.downloadTokenHandler)
 
@@ -127,6 +165,94 @@ func (s *Service) modifyConfigHandler(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// @Summary Get statement archive configurations
+// @Success 200 {object} config.StatementArchiveConfig
+// @Router /statements/archive/config [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+func (s *Service) archiveConfigHandler(c *gin.Context) {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, dc.StatementArchive)
+}
+
+type ModifyArchiveConfigRequest struct {
+	Config config.StatementArchiveConfig `json:"config"`
+	// Leave empty to keep the stored password unchanged.
+	SQLPassword string `json:"sql_password"`
+}
+
+// @Summary Update statement archive configurations
+// @Description Statements older than TiDB keeps are queried from the archive transparently, only for SQL users with the PROCESS privilege.
+// @Param request body ModifyArchiveConfigRequest true "Request body"
+// @Success 200 {object} config.StatementArchiveConfig
+// @Router /statements/archive/config [put]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Permission denied"
+func (s *Service) modifyArchiveConfigHandler(c *gin.Context) {
+	var req ModifyArchiveConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+
+	if req.Config.Enabled {
+		if req.SQLPassword == "" {
+			stored, err := s.getArchivePassword()
+			if err != nil {
+				_ = c.Error(err)
+				return
+			}
+			req.SQLPassword = stored
+		}
+		// Check whether the SQL user is able to read statements
+		db, err := s.params.TiDBClient.OpenSQLConn(req.Config.SQLUser, req.SQLPassword)
+		if err != nil {
+			_ = c.Error(err)
+			if errorx.IsOfType(err, tidb.ErrTiDBAuthFailed) {
+				c.Status(http.StatusBadRequest)
+			}
+			return
+		}
+		var rows []struct{ One int }
+		err = db.Table(statementsTable).Select("1 AS one").Limit(1).Scan(&rows).Error
+		_ = utils.CloseTiDBConnection(db)
+		if err != nil {
+			utils.MakeInvalidRequestErrorFromError(c, err)
+			return
+		}
+	}
+
+	var opt config.DynamicConfigOption = func(dc *config.DynamicConfig) {
+		dc.StatementArchive = req.Config
+	}
+	if err := s.params.ConfigManager.Modify(opt); err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, config.ErrVerificationFailed) {
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+
+	// Archived statements are kept after disabled, until they expire.
+	var err error
+	if req.Config.Enabled {
+		err = s.saveArchivePassword(req.SQLPassword)
+	} else {
+		err = s.revokeArchivePassword()
+	}
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, req.Config)
+}
+
 // @Summary Get available statement time ranges
 // @Success 200 {array} statement.TimeRange
 // @Router /statements/time_ranges [get]
@@ -134,7 +260,7 @@ func (s *Service) modifyConfigHandler(c *gin.Context) {
 // @Failure 401 {object} utils.APIError "Unauthorized failure"
 func (s *Service) timeRangesHandler(c *gin.Context) {
 	db := utils.GetTiDBConnection(c)
-	timeRanges, err := queryTimeRanges(db)
+	timeRanges, err := s.queryTimeRanges(db)
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -252,6 +378,162 @@ func (s *Service) planDetailHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
+type GetPlanTreeRequest struct {
+	GetPlansRequest
+	PlanDigest string `json:"plan_digest" form:"plan_digest"`
+}
+
+// @Summary Get the execution plan of a statement as a tree of operators
+// @Param q query GetPlanTreeRequest true "Query"
+// @Success 200 {object} plan.Tree
+// @Router /statements/plan/tree [get]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 404 {object} utils.APIError "Plan not found"
+func (s *Service) planTreeHandler(c *gin.Context) {
+	var req GetPlanTreeRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	db := utils.GetTiDBConnection(c)
+	text, err := s.queryPlanText(db, req.BeginTime, req.EndTime, req.SchemaName, req.Digest, req.PlanDigest)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if text == "" {
+		_ = c.Error(ErrPlanNotFound.New("Plan %s is not found", req.PlanDigest))
+		c.Status(http.StatusNotFound)
+		return
+	}
+	tree, err := plan.ParseText(text)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, tree)
+}
+
+// @Summary Find statements switched to slower plans in a time range
+// @Description Regressions are ranked by the extra time spent by the current plan compared with the best plan.
+// @Param q query GetPlanRegressionsRequest true "Query"
+// @Success 200 {array} PlanRegression
+// @Router /statements/plan/regressions [get]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+func (s *Service) planRegressionsHandler(c *gin.Context) {
+	var req GetPlanRegressionsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	db := utils.GetTiDBConnection(c)
+	regressions, err := s.queryPlanRegressions(db, &req)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, regressions)
+}
+
+// @Summary List global SQL plan bindings
+// @Success 200 {array} Binding
+// @Router /statements/bindings [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+func (s *Service) bindingsHandler(c *gin.Context) {
+	db := utils.GetTiDBConnection(c)
+	bindings, err := queryBindings(db)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, bindings)
+}
+
+// handleBindingError sets the status code according to the error of binding operations.
+func handleBindingError(c *gin.Context, err error) {
+	_ = c.Error(err)
+	switch {
+	case errorx.IsOfType(err, ErrInvalidDigest), errorx.IsOfType(err, ErrBindingNotSupported):
+		c.Status(http.StatusBadRequest)
+	case errorx.IsOfType(err, ErrBindingNotFound), errorx.IsOfType(err, ErrPlanNotFound):
+		c.Status(http.StatusNotFound)
+	}
+}
+
+// @Summary Bind a statement to a plan in the statement history
+// @Description Bindings are created from the plan digest since TiDB 6.5. Earlier versions fall back to `CREATE GLOBAL BINDING FOR <sample SQL> USING <sample SQL with the plan hint>`, built from the latest sample of the plan.
+// @Param request body CreateBindingRequest true "Request body"
+// @Success 204 {object} string
+// @Router /statements/bindings [post]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 404 {object} utils.APIError "Plan not found"
+func (s *Service) createBindingHandler(c *gin.Context) {
+	var req CreateBindingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	audit.AddTargets(c, "plan_digest:"+req.PlanDigest)
+	db := utils.GetTiDBConnection(c)
+	if err := createBinding(db, req.PlanDigest); err != nil {
+		handleBindingError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Drop the global SQL plan binding of a statement
+// @Param sql_digest query string true "SQL digest of the binding"
+// @Success 204 {object} string
+// @Router /statements/bindings [delete]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 404 {object} utils.APIError "Binding not found"
+func (s *Service) dropBindingHandler(c *gin.Context) {
+	sqlDigest := c.Query("sql_digest")
+	audit.AddTargets(c, "sql_digest:"+sqlDigest)
+	db := utils.GetTiDBConnection(c)
+	if err := dropBinding(db, sqlDigest); err != nil {
+		handleBindingError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Enable or disable the global SQL plan binding of a statement
+// @Param request body SetBindingStatusRequest true "Request body"
+// @Success 204 {object} string
+// @Router /statements/bindings/status [put]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 404 {object} utils.APIError "Binding not found"
+func (s *Service) setBindingStatusHandler(c *gin.Context) {
+	var req SetBindingStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	audit.AddTargets(c, "sql_digest:"+req.SQLDigest)
+	db := utils.GetTiDBConnection(c)
+	if err := setBindingStatus(db, req.SQLDigest, req.Enabled); err != nil {
+		handleBindingError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
 // @Router /statements/download/token [post]
 // @Summary Generate a download token for exported statements
 // @Produce plain
@@ -0,0 +1,76 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apitoken
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+type APITokenModel struct { //nolint
+	ID   string `gorm:"primary_key;size:40" json:"id"`
+	Name string `gorm:"size:128" json:"name"`
+	// The display name of the session that created this token.
+	Creator string `gorm:"size:256" json:"creator"`
+	// The owner key of the session that created this token, see utils.OwnerKey.
+	Owner string `gorm:"size:512;index" json:"-"`
+	// SHA256 of the token. The plain token is only returned once when it is created.
+	TokenHash string `gorm:"size:64;uniqueIndex" json:"-"`
+	// The session of the creator, encrypted by a key derived from the plain token.
+	EncryptedSession string     `gorm:"type:text" json:"-"`
+	ReadOnly         bool       `json:"read_only"`
+	RouteGroups      []string   `gorm:"-" json:"route_groups"` // Empty means all route groups
+	RawRouteGroups   string     `gorm:"column:route_groups;type:text" json:"-"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ExpireAt         *time.Time `json:"expire_at"` // Nil means never expire
+	LastUsedAt       *time.Time `json:"last_used_at"`
+}
+
+func (APITokenModel) TableName() string {
+	return "api_tokens"
+}
+
+func (m *APITokenModel) BeforeSave(tx *gorm.DB) error {
+	m.RawRouteGroups = strings.Join(m.RouteGroups, ",")
+	return nil
+}
+
+func (m *APITokenModel) AfterFind(tx *gorm.DB) error {
+	m.RouteGroups = []string{}
+	if m.RawRouteGroups != "" {
+		m.RouteGroups = strings.Split(m.RawRouteGroups, ",")
+	}
+	return nil
+}
+
+// AllowsRouteGroup checks whether the token is permitted to access the specified route group, e.g. `statements`.
+func (m *APITokenModel) AllowsRouteGroup(group string) bool {
+	if len(m.RouteGroups) == 0 {
+		return true
+	}
+	for _, g := range m.RouteGroups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+func autoMigrate(db *dbstore.DB) error {
+	return db.AutoMigrate(&APITokenModel{})
+}
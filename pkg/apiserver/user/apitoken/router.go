@@ -0,0 +1,127 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apitoken
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+)
+
+func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
+	endpoint := r.Group("/user/api_tokens")
+	// API tokens do not carry the share permission, so that a token can not be used to create other tokens.
+	endpoint.Use(auth.MWAuthRequired(), auth.MWRequirePermission(utils.PermSessionShare))
+	endpoint.GET("/list", s.listHandler)
+	endpoint.POST("/create", s.createHandler)
+	endpoint.DELETE("/:id", s.revokeHandler)
+}
+
+// @ID userAPITokenList
+// @Summary List API tokens created by current user
+// @Success 200 {array} APITokenModel
+// @Router /user/api_tokens/list [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 500 {object} utils.APIError "Internal error"
+func (s *Service) listHandler(c *gin.Context) {
+	u := utils.GetSession(c)
+	tokens, err := s.ListTokens(u.Owner)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+type CreateTokenRequest struct {
+	Name     string `json:"name" binding:"required"`
+	ReadOnly bool   `json:"read_only"`
+	// Route groups the token is permitted to access, e.g. `statements` or `slow_query`. Empty means all.
+	RouteGroups []string `json:"route_groups"`
+	// Zero means never expire.
+	ExpireInSeconds int64 `json:"expire_in_sec"`
+}
+
+type CreateTokenResponse struct {
+	// The plain token. It is only returned once and can not be retrieved later.
+	Token string        `json:"token"`
+	Info  APITokenModel `json:"info"`
+}
+
+// @ID userAPITokenCreate
+// @Summary Create an API token carrying the privileges of current session
+// @Description The token is sent as `Authorization: Bearer <token>`. Tokens carry the session, so that they must be recreated after the session schema is changed, e.g. after upgrading.
+// @Param request body CreateTokenRequest true "Request body"
+// @Success 200 {object} CreateTokenResponse
+// @Router /user/api_tokens/create [post]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 500 {object} utils.APIError "Internal error"
+func (s *Service) createHandler(c *gin.Context) {
+	var req CreateTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	if req.ExpireInSeconds < 0 {
+		utils.MakeInvalidRequestErrorWithMessage(c, "expire_in_sec must not be negative")
+		return
+	}
+
+	u := utils.GetSession(c)
+	rec, token, err := s.CreateToken(u, CreateTokenOptions{
+		Name:            req.Name,
+		ReadOnly:        req.ReadOnly,
+		RouteGroups:     req.RouteGroups,
+		ExpireInSeconds: req.ExpireInSeconds,
+	})
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, CreateTokenResponse{
+		Token: token,
+		Info:  *rec,
+	})
+}
+
+// @ID userAPITokenRevoke
+// @Summary Revoke an API token created by current user
+// @Param id path string true "Token ID"
+// @Success 200 {string} string
+// @Router /user/api_tokens/{id} [delete]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 404 {object} utils.APIError "Token not found"
+// @Failure 500 {object} utils.APIError "Internal error"
+func (s *Service) revokeHandler(c *gin.Context) {
+	u := utils.GetSession(c)
+	if err := s.RevokeToken(u.Owner, c.Param("id")); err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrNotFound) {
+			c.Status(http.StatusNotFound)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, "success")
+}
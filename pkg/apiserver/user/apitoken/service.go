@@ -0,0 +1,240 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apitoken
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gtank/cryptopasta"
+	"github.com/joomcode/errorx"
+	"go.uber.org/fx"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+const (
+	typeID utils.AuthType = 4
+
+	// All API tokens start with this prefix, so that they can be distinguished from session tokens.
+	tokenPrefix = "dashboard_at_"
+
+	// Last used time is only updated when it is older than this interval, to avoid writing on every request.
+	lastUsedUpdateInterval = time.Minute
+)
+
+var (
+	ErrNS           = errorx.NewNamespace("error.api.user.api_token")
+	ErrCreateFailed = ErrNS.NewType("create_failed")
+	ErrNotFound     = ErrNS.NewType("not_found")
+)
+
+type ServiceParams struct {
+	fx.In
+	LocalStore *dbstore.DB
+}
+
+type Service struct {
+	params ServiceParams
+}
+
+func newService(p ServiceParams) (*Service, error) {
+	if err := autoMigrate(p.LocalStore); err != nil {
+		return nil, err
+	}
+	return &Service{params: p}, nil
+}
+
+func registerTokenAuthenticator(s *Service, authService *user.AuthService) {
+	authService.RegisterTokenAuthenticator(s)
+}
+
+var Module = fx.Options(
+	fx.Provide(newService),
+	fx.Invoke(registerTokenAuthenticator, registerRouter),
+)
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// deriveSessionKey derives the key for encrypting the session from the plain token. In this way, leaking the
+// local storage does not leak the SQL credentials in the session.
+func deriveSessionKey(token string) *[32]byte {
+	mac := hmac.New(sha256.New, []byte(token))
+	_, _ = mac.Write([]byte("dashboard api token session"))
+	var key [32]byte
+	copy(key[:], mac.Sum(nil))
+	return &key
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return tokenPrefix + hex.EncodeToString(b), nil
+}
+
+// routeGroupOf extracts the route group from the full path of a route, for example,
+// `/dashboard/api/statements/list` belongs to the route group `statements`.
+func routeGroupOf(fullPath string) string {
+	p := strings.TrimPrefix(fullPath, config.APIPathPrefix)
+	return strings.SplitN(p, "/", 2)[0]
+}
+
+type CreateTokenOptions struct {
+	Name            string
+	ReadOnly        bool
+	RouteGroups     []string
+	ExpireInSeconds int64
+}
+
+// CreateToken creates an API token that carries the specified session. The plain token is returned and is not
+// stored anywhere.
+func (s *Service) CreateToken(session *utils.SessionUser, opts CreateTokenOptions) (*APITokenModel, string, error) {
+	if !session.HasPermission(utils.PermSessionShare) {
+		return nil, "", ErrCreateFailed.New("Current session cannot be used to create API tokens")
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, "", ErrCreateFailed.WrapWithNoMessage(err)
+	}
+
+	plain, err := json.Marshal(session)
+	if err != nil {
+		return nil, "", ErrCreateFailed.WrapWithNoMessage(err)
+	}
+	encrypted, err := cryptopasta.Encrypt(plain, deriveSessionKey(token))
+	if err != nil {
+		return nil, "", ErrCreateFailed.WrapWithNoMessage(err)
+	}
+
+	now := time.Now()
+	rec := &APITokenModel{
+		ID:               uuid.New().String(),
+		Name:             opts.Name,
+		Creator:          session.DisplayName,
+		Owner:            session.Owner,
+		TokenHash:        hashToken(token),
+		EncryptedSession: base64.StdEncoding.EncodeToString(encrypted),
+		ReadOnly:         opts.ReadOnly || !session.HasWritePermission(),
+		RouteGroups:      opts.RouteGroups,
+		CreatedAt:        now,
+	}
+	if opts.ExpireInSeconds > 0 {
+		expireAt := now.Add(time.Second * time.Duration(opts.ExpireInSeconds))
+		rec.ExpireAt = &expireAt
+	}
+	if rec.RouteGroups == nil {
+		rec.RouteGroups = []string{}
+	}
+	if err := s.params.LocalStore.Create(rec).Error; err != nil {
+		return nil, "", err
+	}
+	return rec, token, nil
+}
+
+func (s *Service) ListTokens(owner string) ([]APITokenModel, error) {
+	var tokens []APITokenModel
+	err := s.params.LocalStore.
+		Where("owner = ?", owner).
+		Order("created_at DESC").
+		Find(&tokens).Error
+	return tokens, err
+}
+
+func (s *Service) RevokeToken(owner string, id string) error {
+	result := s.params.LocalStore.
+		Where("owner = ? AND id = ?", owner, id).
+		Delete(&APITokenModel{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound.New("API token %s does not exist", id)
+	}
+	return nil
+}
+
+func (s *Service) IsTokenSupported(token string) bool {
+	return strings.HasPrefix(token, tokenPrefix)
+}
+
+func (s *Service) AuthenticateToken(c *gin.Context, token string) (*utils.SessionUser, error) {
+	var rec APITokenModel
+	err := s.params.LocalStore.Where("token_hash = ?", hashToken(token)).First(&rec).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, utils.ErrUnauthorized.New("Invalid API token")
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if rec.ExpireAt != nil && now.After(*rec.ExpireAt) {
+		return nil, utils.ErrUnauthorized.New("API token is expired")
+	}
+
+	encrypted, err := base64.StdEncoding.DecodeString(rec.EncryptedSession)
+	if err != nil {
+		return nil, utils.ErrUnauthorized.New("Invalid API token")
+	}
+	plain, err := cryptopasta.Decrypt(encrypted, deriveSessionKey(token))
+	if err != nil {
+		return nil, utils.ErrUnauthorized.New("Invalid API token")
+	}
+	var u utils.SessionUser
+	if err := json.Unmarshal(plain, &u); err != nil {
+		return nil, utils.ErrUnauthorized.New("Invalid API token")
+	}
+	// Tokens created before a session schema change must be recreated.
+	if u.Version != utils.SessionVersion {
+		return nil, utils.ErrUnauthorized.New("API token is outdated, please create a new one")
+	}
+
+	group := routeGroupOf(c.FullPath())
+	if !rec.AllowsRouteGroup(group) {
+		return nil, utils.ErrInsufficientPrivilege.New("API token is not permitted to access %s", group)
+	}
+
+	if rec.LastUsedAt == nil || now.Sub(*rec.LastUsedAt) > lastUsedUpdateInterval {
+		_ = s.params.LocalStore.
+			Model(&APITokenModel{}).
+			Where("id = ?", rec.ID).
+			UpdateColumn("last_used_at", now).
+			Error
+	}
+
+	u.AuthFrom = typeID
+	u.Permissions = utils.WithoutPermission(u.Permissions, utils.PermSessionShare)
+	if rec.ReadOnly {
+		u.Permissions = utils.ReadPermissionsOf(u.Permissions)
+	}
+	return &u, nil
+}
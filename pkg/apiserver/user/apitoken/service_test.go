@@ -0,0 +1,130 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apitoken
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore/dbstoretest"
+)
+
+func TestT(t *testing.T) {
+	CustomVerboseFlag = true
+	TestingT(t)
+}
+
+var _ = Suite(&testServiceSuite{})
+
+type testServiceSuite struct{}
+
+func (t *testServiceSuite) SetUpSuite(c *C) {
+	gin.SetMode(gin.TestMode)
+}
+
+func (t *testServiceSuite) newService(c *C) *Service {
+	s, err := newService(ServiceParams{LocalStore: dbstoretest.NewDB(c)})
+	c.Assert(err, IsNil)
+	return s
+}
+
+// authenticateAt authenticates the token in a request routed to the specified full path.
+func authenticateAt(s *Service, fullPath string, token string) (*utils.SessionUser, error) {
+	var u *utils.SessionUser
+	var err error
+	r := gin.New()
+	r.GET(fullPath, func(c *gin.Context) {
+		u, err = s.AuthenticateToken(c, token)
+	})
+	req, _ := http.NewRequest(http.MethodGet, fullPath, nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	return u, err
+}
+
+func testSession() *utils.SessionUser {
+	return &utils.SessionUser{
+		Version:      utils.SessionVersion,
+		HasTiDBAuth:  true,
+		TiDBUsername: "root",
+		TiDBPassword: "secret",
+		DisplayName:  "root",
+		Owner:        "0:root",
+		Permissions:  utils.AllPermissions,
+	}
+}
+
+func (t *testServiceSuite) Test_routeGroupOf(c *C) {
+	c.Assert(routeGroupOf("/dashboard/api/statements/list"), Equals, "statements")
+	c.Assert(routeGroupOf("/dashboard/api/slow_query/detail"), Equals, "slow_query")
+	c.Assert(routeGroupOf("/dashboard/api/info"), Equals, "info")
+}
+
+func (t *testServiceSuite) Test_CreateAndAuthenticate(c *C) {
+	s := t.newService(c)
+
+	rec, token, err := s.CreateToken(testSession(), CreateTokenOptions{
+		Name:        "ci",
+		ReadOnly:    true,
+		RouteGroups: []string{"statements"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.IsTokenSupported(token), IsTrue)
+	c.Assert(rec.TokenHash, Not(Equals), token)
+	c.Assert(rec.LastUsedAt, IsNil)
+
+	u, err := authenticateAt(s, "/dashboard/api/statements/list", token)
+	c.Assert(err, IsNil)
+	c.Assert(u.TiDBUsername, Equals, "root")
+	c.Assert(u.TiDBPassword, Equals, "secret")
+	c.Assert(u.HasWritePermission(), IsFalse)
+	c.Assert(u.HasPermission(utils.PermSessionShare), IsFalse)
+	c.Assert(u.HasPermission(utils.PermLogSearchDownload), IsTrue)
+	c.Assert(u.AuthFrom, Equals, typeID)
+	c.Assert(u.Owner, Equals, "0:root")
+
+	_, err = authenticateAt(s, "/dashboard/api/slow_query/list", token)
+	c.Assert(errorx.IsOfType(err, utils.ErrInsufficientPrivilege), IsTrue)
+
+	_, err = authenticateAt(s, "/dashboard/api/statements/list", token+"0")
+	c.Assert(errorx.IsOfType(err, utils.ErrUnauthorized), IsTrue)
+
+	// Users of other authentication types with the same display name do not own the token
+	tokens, err := s.ListTokens("3:root")
+	c.Assert(err, IsNil)
+	c.Assert(tokens, HasLen, 0)
+	tokens, err = s.ListTokens("0:root")
+	c.Assert(err, IsNil)
+	c.Assert(tokens, HasLen, 1)
+	c.Assert(tokens[0].RouteGroups, DeepEquals, []string{"statements"})
+	c.Assert(tokens[0].LastUsedAt, NotNil)
+
+	c.Assert(errorx.IsOfType(s.RevokeToken("3:root", rec.ID), ErrNotFound), IsTrue)
+	c.Assert(s.RevokeToken("0:root", rec.ID), IsNil)
+	_, err = authenticateAt(s, "/dashboard/api/statements/list", token)
+	c.Assert(errorx.IsOfType(err, utils.ErrUnauthorized), IsTrue)
+}
+
+func (t *testServiceSuite) Test_CreateFromUnshareableSession(c *C) {
+	s := t.newService(c)
+	session := testSession()
+	session.Permissions = utils.WithoutPermission(session.Permissions, utils.PermSessionShare)
+	_, _, err := s.CreateToken(session, CreateTokenOptions{Name: "ci"})
+	c.Assert(errorx.IsOfType(err, ErrCreateFailed), IsTrue)
+}
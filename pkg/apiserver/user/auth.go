@@ -20,6 +20,7 @@ import (
 	"net/http"
 	"os"
 	"sort"
+	"strings"
 	"time"
 
 	jwt "github.com/appleboy/gin-jwt/v2"
@@ -32,6 +33,7 @@ import (
 
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
 )
 
 var (
@@ -43,13 +45,17 @@ var (
 
 type ServiceParams struct {
 	fx.In
-	Config *config.Config
+	Config        *config.Config
+	ConfigManager *config.DynamicConfigManager
+	LocalStore    *dbstore.DB
 }
 
 type AuthService struct {
-	params         ServiceParams
-	middleware     *jwt.GinJWTMiddleware
-	authenticators map[utils.AuthType]Authenticator
+	params              ServiceParams
+	middleware          *jwt.GinJWTMiddleware
+	authenticators      map[utils.AuthType]Authenticator
+	tokenAuthenticators []TokenAuthenticator
+	lockoutListeners    []LockoutListener
 }
 
 type AuthenticateForm struct {
@@ -62,6 +68,8 @@ type AuthenticateForm struct {
 type TokenResponse struct {
 	Token  string    `json:"token"`
 	Expire time.Time `json:"expire"`
+	// Used to obtain a new token via `/user/refresh` before the session is expired.
+	RefreshToken string `json:"refresh_token,omitempty"`
 }
 
 type SignOutInfo struct {
@@ -75,6 +83,16 @@ type Authenticator interface {
 	SignOutInfo(u *utils.SessionUser, redirectURL string) (*SignOutInfo, error)
 }
 
+// TokenAuthenticator authenticates requests carrying a bearer token that is not issued by the login flow,
+// for example, long-lived API tokens used by automation clients.
+type TokenAuthenticator interface {
+	// IsTokenSupported reports whether the bearer token should be handled by this authenticator.
+	IsTokenSupported(token string) bool
+	// AuthenticateToken returns the session of the token. Errors of type `utils.ErrInsufficientPrivilege` result
+	// in a 403 response, other errors result in a 401 response.
+	AuthenticateToken(c *gin.Context, token string) (*utils.SessionUser, error)
+}
+
 type BaseAuthenticator struct{}
 
 func (a BaseAuthenticator) IsEnabled() (bool, error) {
@@ -89,7 +107,11 @@ func (a BaseAuthenticator) SignOutInfo(u *utils.SessionUser, redirectURL string)
 	return &SignOutInfo{}, nil
 }
 
-func NewAuthService(p ServiceParams) *AuthService {
+func NewAuthService(p ServiceParams) (*AuthService, error) {
+	if err := autoMigrate(p.LocalStore); err != nil {
+		return nil, err
+	}
+
 	var secret *[32]byte
 
 	secretStr := os.Getenv("DASHBOARD_SESSION_SECRET")
@@ -122,10 +144,20 @@ func NewAuthService(p ServiceParams) *AuthService {
 			if err := c.ShouldBindJSON(&form); err != nil {
 				return nil, utils.ErrInvalidRequest.WrapWithNoMessage(err)
 			}
+			attempt, err := service.reserveAttempt(loginLockKeys(c, &form))
+			if err != nil {
+				return nil, err
+			}
 			u, err := service.authForm(form)
+			service.finishAttempt(c, attempt, err)
 			if err != nil {
 				return nil, errorx.Decorate(err, "authenticate failed")
 			}
+			refreshToken, err := service.createSession(c, u)
+			if err != nil {
+				return nil, err
+			}
+			c.Set(refreshTokenKey, refreshToken)
 			return u, nil
 		},
 		PayloadFunc: func(data interface{}) jwt.MapClaims {
@@ -179,6 +211,9 @@ func NewAuthService(p ServiceParams) *AuthService {
 			if !a.ProcessSession(&user) {
 				return nil
 			}
+			if !service.verifySession(user.SessionID) {
+				return nil
+			}
 
 			return &user
 		},
@@ -206,12 +241,16 @@ func NewAuthService(p ServiceParams) *AuthService {
 			return err.Error()
 		},
 		Unauthorized: func(c *gin.Context, code int, message string) {
+			if len(c.Errors) > 0 && errorx.IsOfType(c.Errors.Last().Err, ErrSignInLocked) {
+				code = http.StatusTooManyRequests
+			}
 			c.Status(code)
 		},
 		LoginResponse: func(c *gin.Context, code int, token string, expire time.Time) {
 			c.JSON(http.StatusOK, TokenResponse{
-				Token:  token,
-				Expire: expire,
+				Token:        token,
+				Expire:       expire,
+				RefreshToken: c.GetString(refreshTokenKey),
 			})
 		},
 	})
@@ -223,7 +262,7 @@ func NewAuthService(p ServiceParams) *AuthService {
 
 	service.middleware = middleware
 
-	return service
+	return service, nil
 }
 
 func (s *AuthService) authForm(f AuthenticateForm) (*utils.SessionUser, error) {
@@ -236,6 +275,7 @@ func (s *AuthService) authForm(f AuthenticateForm) (*utils.SessionUser, error) {
 		return nil, err
 	}
 	u.AuthFrom = f.Type
+	u.Owner = utils.OwnerKey(f.Type, u)
 	return u, nil
 }
 
@@ -243,18 +283,72 @@ func RegisterRouter(r *gin.RouterGroup, s *AuthService) {
 	endpoint := r.Group("/user")
 	endpoint.GET("/login_info", s.getLoginInfoHandler)
 	endpoint.POST("/login", s.loginHandler)
+	endpoint.POST("/refresh", s.refreshHandler)
 	endpoint.GET("/sign_out_info", s.MWAuthRequired(), s.getSignOutInfoHandler)
+	endpoint.GET("/permission/list", s.MWAuthRequired(), s.listPermissionsHandler)
+	endpoint.GET("/permission/config", s.MWAuthRequired(), s.getPermissionConfigHandler)
+	endpoint.PUT("/permission/config", s.MWAuthRequired(), s.MWRequirePermission(utils.PermUserManage), s.setPermissionConfigHandler)
+
+	lockEndpoint := endpoint.Group("/locks")
+	lockEndpoint.Use(s.MWAuthRequired(), s.MWRequirePermission(utils.PermUserManage))
+	lockEndpoint.GET("/list", s.listLocksHandler)
+	lockEndpoint.POST("/unlock", s.unlockHandler)
+
+	sessionEndpoint := endpoint.Group("/sessions")
+	sessionEndpoint.Use(s.MWAuthRequired())
+	sessionEndpoint.GET("/config", s.getSessionConfigHandler)
+	sessionEndpoint.PUT("/config", s.MWRequirePermission(utils.PermUserManage), s.setSessionConfigHandler)
+	sessionEndpoint.GET("/list", s.MWRequirePermission(utils.PermUserManage), s.listSessionsHandler)
+	sessionEndpoint.DELETE("/:id", s.MWRequirePermission(utils.PermUserManage), s.revokeSessionHandler)
+	sessionEndpoint.POST("/revoke_user", s.MWRequirePermission(utils.PermUserManage), s.revokeUserSessionsHandler)
 }
 
 // MWAuthRequired creates a middleware that verifies the authentication token (JWT) in the request. If the token
 // is valid, identity information will be attached in the context. If there is no authentication token, or the
 // token is invalid, subsequent handlers will be skipped and errors will be generated.
+//
+// Bearer tokens recognized by a registered TokenAuthenticator are verified by that authenticator instead.
 func (s *AuthService) MWAuthRequired() gin.HandlerFunc {
-	return s.middleware.MiddlewareFunc()
+	jwtMiddleware := s.middleware.MiddlewareFunc()
+	return func(c *gin.Context) {
+		a, token := s.findTokenAuthenticator(c)
+		if a == nil {
+			jwtMiddleware(c)
+			return
+		}
+		u, err := a.AuthenticateToken(c, token)
+		if err != nil {
+			_ = c.Error(err)
+			if errorx.IsOfType(err, utils.ErrInsufficientPrivilege) {
+				c.Status(http.StatusForbidden)
+			} else {
+				c.Status(http.StatusUnauthorized)
+			}
+			c.Abort()
+			return
+		}
+		c.Set(utils.SessionUserKey, u)
+		c.Next()
+	}
+}
+
+func (s *AuthService) findTokenAuthenticator(c *gin.Context) (TokenAuthenticator, string) {
+	parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return nil, ""
+	}
+	token := strings.TrimSpace(parts[1])
+	for _, a := range s.tokenAuthenticators {
+		if a.IsTokenSupported(token) {
+			return a, token
+		}
+	}
+	return nil, ""
 }
 
-// TODO: Make these MWRequireXxxPriv more general to use.
-func (s *AuthService) MWRequireSharePriv() gin.HandlerFunc {
+// MWRequirePermission creates a middleware that verifies whether all specified permissions are granted to the
+// current session. It must be used after MWAuthRequired.
+func (s *AuthService) MWRequirePermission(perms ...utils.Permission) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		u := utils.GetSession(c)
 		if u == nil {
@@ -262,16 +356,19 @@ func (s *AuthService) MWRequireSharePriv() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		if !u.IsShareable {
-			utils.MakeInsufficientPrivilegeError(c)
-			c.Abort()
-			return
+		for _, p := range perms {
+			if !u.HasPermission(p) {
+				utils.MakeInsufficientPrivilegeError(c)
+				c.Abort()
+				return
+			}
 		}
 		c.Next()
 	}
 }
 
-func (s *AuthService) MWRequireWritePriv() gin.HandlerFunc {
+// MWRequireAnyPermission is similar to MWRequirePermission, but only requires any one of the permissions.
+func (s *AuthService) MWRequireAnyPermission(perms ...utils.Permission) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		u := utils.GetSession(c)
 		if u == nil {
@@ -279,12 +376,14 @@ func (s *AuthService) MWRequireWritePriv() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		if !u.IsWriteable {
-			utils.MakeInsufficientPrivilegeError(c)
-			c.Abort()
-			return
+		for _, p := range perms {
+			if u.HasPermission(p) {
+				c.Next()
+				return
+			}
 		}
-		c.Next()
+		utils.MakeInsufficientPrivilegeError(c)
+		c.Abort()
 	}
 }
 
@@ -293,6 +392,11 @@ func (s *AuthService) RegisterAuthenticator(typeID utils.AuthType, a Authenticat
 	s.authenticators[typeID] = a
 }
 
+// RegisterTokenAuthenticator registers an authenticator for bearer tokens used in MWAuthRequired.
+func (s *AuthService) RegisterTokenAuthenticator(a TokenAuthenticator) {
+	s.tokenAuthenticators = append(s.tokenAuthenticators, a)
+}
+
 type GetLoginInfoResponse struct {
 	SupportedAuthTypes []int `json:"supported_auth_types"`
 	EnableNonRootLogin bool  `json:"enable_non_root_login"`
@@ -45,5 +45,8 @@ func (a *Authenticator) Authenticate(f user.AuthenticateForm) (*utils.SessionUse
 }
 
 func (a *Authenticator) ProcessSession(user *utils.SessionUser) bool {
-	return !time.Now().After(user.SharedSessionExpireAt)
+	if time.Now().After(user.SharedSessionExpireAt) {
+		return false
+	}
+	return a.sharingCodeService.IsSharedSessionActive(user.SharedSessionID)
 }
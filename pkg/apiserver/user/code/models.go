@@ -0,0 +1,54 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package code
+
+import (
+	"time"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+type SharedSessionModel struct { //nolint
+	ID string `gorm:"primary_key;size:40" json:"id"`
+	// The display name of the session that is shared.
+	Creator string `gorm:"size:256" json:"creator"`
+	// The owner key of the session that is shared, see utils.OwnerKey.
+	Owner       string     `gorm:"size:512;index" json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpireAt    time.Time  `json:"expire_at"`
+	IsWriteable bool       `json:"is_writeable"` // Whether write permissions are kept in the shared session
+	RevokedAt   *time.Time `json:"revoked_at"`
+}
+
+func (SharedSessionModel) TableName() string {
+	return "shared_sessions"
+}
+
+func (m *SharedSessionModel) IsActive(now time.Time) bool {
+	return m.RevokedAt == nil && !now.After(m.ExpireAt)
+}
+
+type SharedSessionRedemptionModel struct { //nolint
+	ID              uint      `gorm:"primary_key" json:"id"`
+	SharedSessionID string    `gorm:"size:40;index" json:"shared_session_id"`
+	RedeemedAt      time.Time `json:"redeemed_at"`
+}
+
+func (SharedSessionRedemptionModel) TableName() string {
+	return "shared_session_redemptions"
+}
+
+func autoMigrate(db *dbstore.DB) error {
+	return db.AutoMigrate(&SharedSessionModel{}, &SharedSessionRedemptionModel{})
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
 
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
@@ -13,7 +14,10 @@ import (
 func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint := r.Group("/user/share")
 	endpoint.Use(auth.MWAuthRequired())
-	endpoint.POST("/code", auth.MWRequireSharePriv(), s.shareHandler)
+	endpoint.POST("/code", auth.MWRequirePermission(utils.PermSessionShare), s.shareHandler)
+	endpoint.GET("/list", auth.MWRequirePermission(utils.PermSessionShare), s.listHandler)
+	endpoint.GET("/code/:id/redemptions", auth.MWRequirePermission(utils.PermSessionShare), s.listRedemptionsHandler)
+	endpoint.DELETE("/code/:id", auth.MWRequirePermission(utils.PermSessionShare), s.revokeHandler)
 }
 
 type ShareRequest struct {
@@ -54,3 +58,63 @@ func (s *Service) shareHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, ShareResponse{Code: *code})
 }
+
+// @ID userListSharedSessions
+// @Summary List active shared sessions created by current user
+// @Security JwtAuth
+// @Success 200 {array} SharedSessionModel
+// @Router /user/share/list [get]
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+func (s *Service) listHandler(c *gin.Context) {
+	sessionUser := utils.GetSession(c)
+	recs, err := s.ListActiveSharedSessions(sessionUser.Owner)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, recs)
+}
+
+// @ID userListSharedSessionRedemptions
+// @Summary List redemptions of a shared session created by current user
+// @Param id path string true "Shared session ID"
+// @Security JwtAuth
+// @Success 200 {array} SharedSessionRedemptionModel
+// @Router /user/share/code/{id}/redemptions [get]
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 404 {object} utils.APIError "Shared session not found"
+func (s *Service) listRedemptionsHandler(c *gin.Context) {
+	sessionUser := utils.GetSession(c)
+	recs, err := s.ListRedemptions(sessionUser.Owner, c.Param("id"))
+	if err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrNotFound) {
+			c.Status(http.StatusNotFound)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, recs)
+}
+
+// @ID userRevokeSharedSession
+// @Summary Revoke a shared session created by current user
+// @Param id path string true "Shared session ID"
+// @Security JwtAuth
+// @Success 200 {string} string
+// @Router /user/share/code/{id} [delete]
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 404 {object} utils.APIError "Shared session not found"
+func (s *Service) revokeHandler(c *gin.Context) {
+	sessionUser := utils.GetSession(c)
+	if err := s.RevokeSharedSession(sessionUser.Owner, c.Param("id")); err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrNotFound) {
+			c.Status(http.StatusNotFound)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, "success")
+}
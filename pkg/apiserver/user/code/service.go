@@ -2,20 +2,30 @@ package code
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"path"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gtank/cryptopasta"
 	"github.com/joomcode/errorx"
+	"github.com/pingcap/log"
 	"github.com/vmihailenco/msgpack/v5"
 	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
 
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+	"github.com/pingcap/tidb-dashboard/pkg/utils/keyring"
 )
 
 var (
 	ErrNS          = errorx.NewNamespace("error.api.user.code")
 	ErrShareFailed = ErrNS.NewType("share_failed")
+	ErrNotFound    = ErrNS.NewType("not_found")
 )
 
 const (
@@ -23,20 +33,36 @@ const (
 	MaxSessionShareExpiry = time.Hour * 24 * 30
 )
 
+type ServiceParams struct {
+	fx.In
+	LocalStore *dbstore.DB
+}
+
 type Service struct {
+	params        ServiceParams
 	sharingSecret *[32]byte
 }
 
 type sharedSession struct {
+	ID              string
 	Session         *utils.SessionUser
 	ExpireAt        time.Time
 	RevokeWritePriv bool
 }
 
-func newService() *Service {
-	return &Service{
-		sharingSecret: cryptopasta.NewEncryptionKey(),
+func newService(p ServiceParams, config *config.Config) (*Service, error) {
+	if err := autoMigrate(p.LocalStore); err != nil {
+		return nil, err
+	}
+	// The sharing secret is persisted so that sharing codes are still valid after restart.
+	secret, err := keyring.NewKeyFile(path.Join(config.DataDir, "sharing_ek.bin")).GetOrCreate()
+	if err != nil {
+		return nil, err
 	}
+	return &Service{
+		params:        p,
+		sharingSecret: secret,
+	}, nil
 }
 
 var Module = fx.Options(
@@ -60,22 +86,40 @@ func (s *Service) NewSessionFromSharingCode(codeInHex string) *utils.SessionUser
 		return nil
 	}
 
-	if time.Now().After(shared.ExpireAt) {
+	now := time.Now()
+	if now.After(shared.ExpireAt) {
+		return nil
+	}
+
+	rec, err := s.getSharedSession(shared.ID)
+	if err != nil || rec == nil || !rec.IsActive(now) {
 		return nil
 	}
 
-	shared.Session.SharedSessionExpireAt = shared.ExpireAt
+	err = s.params.LocalStore.Create(&SharedSessionRedemptionModel{
+		SharedSessionID: rec.ID,
+		RedeemedAt:      now,
+	}).Error
+	if err != nil {
+		log.Warn("Failed to record shared session redemption", zap.String("id", rec.ID), zap.Error(err))
+	}
+
+	shared.Session.SharedSessionID = rec.ID
+	// Users signed in via the same sharing code are considered as the same user.
+	shared.Session.ExternalSubject = rec.ID
+	shared.Session.SharedSessionExpireAt = rec.ExpireAt
+	shared.Session.SharedBy = rec.Owner
 	shared.Session.DisplayName = fmt.Sprintf("Shared from %s", shared.Session.DisplayName)
-	shared.Session.IsShareable = false
-	if shared.RevokeWritePriv {
-		shared.Session.IsWriteable = false
+	shared.Session.Permissions = utils.WithoutPermission(shared.Session.Permissions, utils.PermSessionShare)
+	if !rec.IsWriteable {
+		shared.Session.Permissions = utils.ReadPermissionsOf(shared.Session.Permissions)
 	}
 
 	return shared.Session
 }
 
 func (s *Service) SharingCodeFromSession(session *utils.SessionUser, expireIn time.Duration, revokeWritePriv bool) *string {
-	if !session.IsShareable {
+	if !session.HasPermission(utils.PermSessionShare) {
 		return nil
 	}
 	if expireIn < 0 {
@@ -85,9 +129,11 @@ func (s *Service) SharingCodeFromSession(session *utils.SessionUser, expireIn ti
 		return nil
 	}
 
+	now := time.Now()
 	shared := sharedSession{
+		ID:              uuid.New().String(),
 		Session:         session,
-		ExpireAt:        time.Now().Add(expireIn),
+		ExpireAt:        now.Add(expireIn),
 		RevokeWritePriv: revokeWritePriv,
 	}
 
@@ -102,6 +148,85 @@ func (s *Service) SharingCodeFromSession(session *utils.SessionUser, expireIn ti
 		return nil
 	}
 
+	err = s.params.LocalStore.Create(&SharedSessionModel{
+		ID:          shared.ID,
+		Creator:     session.DisplayName,
+		Owner:       session.Owner,
+		CreatedAt:   now,
+		ExpireAt:    shared.ExpireAt,
+		IsWriteable: session.HasWritePermission() && !revokeWritePriv,
+	}).Error
+	if err != nil {
+		log.Warn("Failed to save shared session", zap.Error(err))
+		return nil
+	}
+
 	codeInHex := hex.EncodeToString(encrypted)
 	return &codeInHex
 }
+
+func (s *Service) getSharedSession(id string) (*SharedSessionModel, error) {
+	var rec SharedSessionModel
+	err := s.params.LocalStore.Where("id = ?", id).First(&rec).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// IsSharedSessionActive checks whether a shared session is neither revoked nor expired.
+func (s *Service) IsSharedSessionActive(id string) bool {
+	if id == "" {
+		return false
+	}
+	rec, err := s.getSharedSession(id)
+	if err != nil {
+		log.Warn("Failed to check shared session", zap.String("id", id), zap.Error(err))
+		return false
+	}
+	return rec != nil && rec.IsActive(time.Now())
+}
+
+// ListActiveSharedSessions lists shared sessions that are neither revoked nor expired.
+func (s *Service) ListActiveSharedSessions(owner string) ([]SharedSessionModel, error) {
+	var recs []SharedSessionModel
+	err := s.params.LocalStore.
+		Where("owner = ? AND revoked_at IS NULL AND expire_at > ?", owner, time.Now()).
+		Order("created_at DESC").
+		Find(&recs).Error
+	return recs, err
+}
+
+func (s *Service) ListRedemptions(owner string, id string) ([]SharedSessionRedemptionModel, error) {
+	rec, err := s.getSharedSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || rec.Owner != owner {
+		return nil, ErrNotFound.New("Shared session %s does not exist", id)
+	}
+	var redemptions []SharedSessionRedemptionModel
+	err = s.params.LocalStore.
+		Where("shared_session_id = ?", id).
+		Order("redeemed_at DESC").
+		Find(&redemptions).Error
+	return redemptions, err
+}
+
+// RevokeSharedSession revokes the sharing code, as well as all sessions signed in via the sharing code.
+func (s *Service) RevokeSharedSession(owner string, id string) error {
+	result := s.params.LocalStore.
+		Model(&SharedSessionModel{}).
+		Where("owner = ? AND id = ? AND revoked_at IS NULL", owner, id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound.New("Shared session %s does not exist", id)
+	}
+	return nil
+}
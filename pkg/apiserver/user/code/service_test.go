@@ -0,0 +1,109 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package code
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore/dbstoretest"
+)
+
+func TestT(t *testing.T) {
+	CustomVerboseFlag = true
+	TestingT(t)
+}
+
+var _ = Suite(&testServiceSuite{})
+
+type testServiceSuite struct {
+	dataDir string
+}
+
+func (t *testServiceSuite) SetUpTest(c *C) {
+	t.dataDir = c.MkDir()
+}
+
+// newService creates a service on the data directory of the test, so that calling it twice simulates a restart.
+func (t *testServiceSuite) newService(c *C) *Service {
+	db := dbstoretest.OpenDB(c, t.dataDir)
+	s, err := newService(ServiceParams{LocalStore: db}, &config.Config{DataDir: t.dataDir})
+	c.Assert(err, IsNil)
+	return s
+}
+
+func testSession() *utils.SessionUser {
+	return &utils.SessionUser{
+		Version:      utils.SessionVersion,
+		HasTiDBAuth:  true,
+		TiDBUsername: "root",
+		DisplayName:  "root",
+		Owner:        "0:root",
+		Permissions:  utils.AllPermissions,
+	}
+}
+
+func (t *testServiceSuite) Test_SharingCodeSurvivesRestart(c *C) {
+	code := t.newService(c).SharingCodeFromSession(testSession(), time.Hour, true)
+	c.Assert(code, NotNil)
+
+	s := t.newService(c)
+	u := s.NewSessionFromSharingCode(*code)
+	c.Assert(u, NotNil)
+	c.Assert(u.DisplayName, Equals, "Shared from root")
+	c.Assert(u.HasPermission(utils.PermSessionShare), IsFalse)
+	c.Assert(u.HasWritePermission(), IsFalse)
+	c.Assert(u.HasPermission(utils.PermLogSearchDownload), IsTrue)
+	c.Assert(s.IsSharedSessionActive(u.SharedSessionID), IsTrue)
+	// The owner is not cloned, sessions signed in via the sharing code are identified by the shared session instead
+	c.Assert(u.Owner, Equals, "")
+	c.Assert(u.ExternalSubject, Equals, u.SharedSessionID)
+	c.Assert(u.SharedBy, Equals, "0:root")
+
+	redemptions, err := s.ListRedemptions("0:root", u.SharedSessionID)
+	c.Assert(err, IsNil)
+	c.Assert(redemptions, HasLen, 1)
+}
+
+func (t *testServiceSuite) Test_RevokeSharedSession(c *C) {
+	s := t.newService(c)
+	code := s.SharingCodeFromSession(testSession(), time.Hour, false)
+	c.Assert(code, NotNil)
+
+	recs, err := s.ListActiveSharedSessions("0:root")
+	c.Assert(err, IsNil)
+	c.Assert(recs, HasLen, 1)
+	c.Assert(recs[0].IsWriteable, IsTrue)
+	id := recs[0].ID
+
+	u := s.NewSessionFromSharingCode(*code)
+	c.Assert(u, NotNil)
+	c.Assert(u.HasPermission(utils.PermConfigEdit), IsTrue)
+
+	c.Assert(errorx.IsOfType(s.RevokeSharedSession("3:root", id), ErrNotFound), IsTrue)
+	c.Assert(s.RevokeSharedSession("0:root", id), IsNil)
+	c.Assert(errorx.IsOfType(s.RevokeSharedSession("0:root", id), ErrNotFound), IsTrue)
+
+	c.Assert(s.IsSharedSessionActive(u.SharedSessionID), IsFalse)
+	c.Assert(s.NewSessionFromSharingCode(*code), IsNil)
+
+	recs, err = s.ListActiveSharedSessions("0:root")
+	c.Assert(err, IsNil)
+	c.Assert(recs, HasLen, 0)
+}
@@ -0,0 +1,171 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ldap
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	goldap "github.com/go-ldap/ldap/v3"
+
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+)
+
+const (
+	dialTimeout    = time.Second * 10
+	requestTimeout = time.Second * 30
+)
+
+// directoryConn is the subset of the LDAP connection used by the authenticator. It is implemented by
+// `*goldap.Conn` and can be replaced by an in-process directory in tests.
+type directoryConn interface {
+	Bind(username, password string) error
+	Search(searchRequest *goldap.SearchRequest) (*goldap.SearchResult, error)
+	Close()
+}
+
+type dialFunc func(cfg *config.LDAPConfig) (directoryConn, error)
+
+func dialDirectory(cfg *config.LDAPConfig) (directoryConn, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, ErrBadConfig.Wrap(err, "Invalid LDAP URL")
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		InsecureSkipVerify: cfg.InsecureSkipVerify, // #nosec
+	}
+	conn, err := goldap.DialURL(cfg.URL,
+		goldap.DialWithDialer(&net.Dialer{Timeout: dialTimeout}),
+		goldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, ErrDirectoryUnavailable.Wrap(err, "Failed to connect to the LDAP server")
+	}
+	conn.SetTimeout(requestTimeout)
+	if cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, ErrDirectoryUnavailable.Wrap(err, "Failed to start TLS with the LDAP server")
+		}
+	}
+	return conn, nil
+}
+
+type directoryUser struct {
+	DN          string
+	DisplayName string
+	Groups      []string
+}
+
+// lookupAndBind finds the directory entry of the user and verifies the password by binding as the user.
+func lookupAndBind(conn directoryConn, cfg *config.LDAPConfig, bindPassword string, userName string, password string) (*directoryUser, error) {
+	if cfg.BindDN != "" {
+		if err := conn.Bind(cfg.BindDN, bindPassword); err != nil {
+			return nil, ErrBadConfig.Wrap(err, "Failed to bind with the configured service account")
+		}
+	}
+
+	displayNameAttr := cfg.DisplayNameAttribute
+	if displayNameAttr == "" {
+		displayNameAttr = "cn"
+	}
+	req := goldap.NewSearchRequest(
+		cfg.UserSearchBase,
+		goldap.ScopeWholeSubtree,
+		goldap.NeverDerefAliases,
+		2, // We only need to know whether there is exactly one entry
+		int(requestTimeout.Seconds()),
+		false,
+		fmt.Sprintf(cfg.UserSearchFilter, goldap.EscapeFilter(userName)),
+		[]string{"dn", displayNameAttr, cfg.GroupAttribute},
+		nil,
+	)
+	result, err := conn.Search(req)
+	if err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultSizeLimitExceeded) {
+			return nil, ErrSignInInvalidCredential.New("LDAP user name is ambiguous")
+		}
+		return nil, ErrDirectoryUnavailable.Wrap(err, "Failed to search the LDAP user")
+	}
+	if len(result.Entries) != 1 {
+		// Do not distinguish not-found from wrong password to avoid user enumeration.
+		return nil, ErrSignInInvalidCredential.NewWithNoMessage()
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if goldap.IsErrorWithCode(err, goldap.LDAPResultInvalidCredentials) {
+			return nil, ErrSignInInvalidCredential.NewWithNoMessage()
+		}
+		return nil, ErrDirectoryUnavailable.Wrap(err, "Failed to verify the LDAP user")
+	}
+
+	displayName := entry.GetAttributeValue(displayNameAttr)
+	if displayName == "" {
+		displayName = userName
+	}
+	return &directoryUser{
+		DN:          entry.DN,
+		DisplayName: displayName,
+		Groups:      entry.GetEqualFoldAttributeValues(cfg.GroupAttribute),
+	}, nil
+}
+
+// groupMatches checks whether a group value from the directory (usually a DN like `cn=dba,ou=groups,dc=example`)
+// matches the configured group, which can be either a full DN or the CN only.
+func groupMatches(memberOf string, configured string) bool {
+	memberOf = strings.TrimSpace(memberOf)
+	configured = strings.TrimSpace(configured)
+	if strings.EqualFold(memberOf, configured) {
+		return true
+	}
+	cn := groupCN(memberOf)
+	return cn != "" && strings.EqualFold(cn, configured)
+}
+
+// groupCN returns the value of the first RDN of the group DN, or empty if the group is not a valid DN.
+func groupCN(memberOf string) string {
+	dn, err := goldap.ParseDN(strings.TrimSpace(memberOf))
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return ""
+	}
+	return dn.RDNs[0].Attributes[0].Value
+}
+
+func isMemberOfAny(groups []string, configuredGroups []string) bool {
+	for _, g := range groups {
+		for _, cg := range configuredGroups {
+			if groupMatches(g, cg) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveWriteable maps the directory groups of a user to the session privilege. Returns false in `ok` if the user
+// is not a member of any configured group and should not be allowed to sign in.
+func resolveWriteable(cfg *config.LDAPConfig, groups []string) (writeable bool, ok bool) {
+	if isMemberOfAny(groups, cfg.WriteableGroups) {
+		return true, true
+	}
+	if isMemberOfAny(groups, cfg.ReadOnlyGroups) {
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,206 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ldap
+
+import (
+	"fmt"
+	"path"
+	"testing"
+
+	goldap "github.com/go-ldap/ldap/v3"
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore/dbstoretest"
+	"github.com/pingcap/tidb-dashboard/pkg/utils/keyring"
+)
+
+func TestT(t *testing.T) {
+	CustomVerboseFlag = true
+	TestingT(t)
+}
+
+var _ = Suite(&testDirectorySuite{})
+
+type testDirectorySuite struct{}
+
+type fakeEntry struct {
+	uid      string
+	password string
+	attrs    map[string][]string
+}
+
+// fakeDirectory is an in-process stand-in of an LDAP server, supporting simple bind and `(uid=%s)` searches.
+type fakeDirectory struct {
+	serviceDN       string
+	servicePassword string
+	entries         map[string]*fakeEntry // by DN
+	boundDN         string
+	closed          bool
+}
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		serviceDN:       "cn=dashboard,dc=example,dc=com",
+		servicePassword: "svcpass",
+		entries: map[string]*fakeEntry{
+			"uid=alice,ou=people,dc=example,dc=com": {
+				uid:      "alice",
+				password: "alicepass",
+				attrs: map[string][]string{
+					"cn":       {"Alice"},
+					"memberOf": {"cn=dba,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+				},
+			},
+			"uid=bob,ou=people,dc=example,dc=com": {
+				uid:      "bob",
+				password: "bobpass",
+				attrs: map[string][]string{
+					"cn":       {"Bob"},
+					"memberOf": {"cn=staff,ou=groups,dc=example,dc=com"},
+				},
+			},
+			"uid=eve,ou=people,dc=example,dc=com": {
+				uid:      "eve",
+				password: "evepass",
+				attrs:    map[string][]string{},
+			},
+		},
+	}
+}
+
+func (d *fakeDirectory) Bind(username, password string) error {
+	if username == d.serviceDN && password == d.servicePassword {
+		d.boundDN = username
+		return nil
+	}
+	if e, ok := d.entries[username]; ok && e.password == password {
+		d.boundDN = username
+		return nil
+	}
+	return goldap.NewError(goldap.LDAPResultInvalidCredentials, fmt.Errorf("invalid credentials"))
+}
+
+func (d *fakeDirectory) Search(req *goldap.SearchRequest) (*goldap.SearchResult, error) {
+	if d.boundDN != d.serviceDN {
+		return nil, goldap.NewError(goldap.LDAPResultInsufficientAccessRights, fmt.Errorf("search requires bind"))
+	}
+	result := &goldap.SearchResult{}
+	for dn, e := range d.entries {
+		if req.Filter == fmt.Sprintf("(uid=%s)", goldap.EscapeFilter(e.uid)) {
+			result.Entries = append(result.Entries, goldap.NewEntry(dn, e.attrs))
+		}
+	}
+	return result, nil
+}
+
+func (d *fakeDirectory) Close() {
+	d.closed = true
+}
+
+func (t *testDirectorySuite) newService(c *C, dir *fakeDirectory) *Service {
+	return &Service{
+		params: ServiceParams{LocalStore: dbstoretest.NewDB(c, autoMigrate)},
+		dial: func(cfg *config.LDAPConfig) (directoryConn, error) {
+			return dir, nil
+		},
+		encKey: keyring.NewKeyFile(path.Join(c.MkDir(), "ldap_ek.bin")),
+	}
+}
+
+func testLDAPConfig() *config.LDAPConfig {
+	return &config.LDAPConfig{
+		Enabled:          true,
+		URL:              "ldap://127.0.0.1:389",
+		BindDN:           "cn=dashboard,dc=example,dc=com",
+		UserSearchBase:   "ou=people,dc=example,dc=com",
+		UserSearchFilter: config.DefaultLDAPUserSearchFilter,
+		GroupAttribute:   config.DefaultLDAPGroupAttribute,
+		WriteableGroups:  []string{"dba"},
+		ReadOnlyGroups:   []string{"cn=staff,ou=groups,dc=example,dc=com"},
+		SQLUser:          "root",
+	}
+}
+
+func (t *testDirectorySuite) Test_groupMatches(c *C) {
+	c.Assert(groupMatches("cn=dba,ou=groups,dc=example,dc=com", "dba"), IsTrue)
+	c.Assert(groupMatches("cn=dba,ou=groups,dc=example,dc=com", "DBA"), IsTrue)
+	c.Assert(groupMatches("CN=DBA,OU=Groups,DC=example,DC=com", "cn=dba,ou=groups,dc=example,dc=com"), IsTrue)
+	c.Assert(groupMatches("cn=dba,ou=groups,dc=example,dc=com", "groups"), IsFalse)
+	c.Assert(groupMatches("dba", "dba"), IsTrue)
+	c.Assert(groupMatches("not a dn", "dba"), IsFalse)
+}
+
+func (t *testDirectorySuite) Test_resolveWriteable(c *C) {
+	cfg := testLDAPConfig()
+
+	writeable, ok := resolveWriteable(cfg, []string{"cn=staff,ou=groups,dc=example,dc=com", "cn=dba,ou=groups,dc=example,dc=com"})
+	c.Assert(ok, IsTrue)
+	c.Assert(writeable, IsTrue)
+
+	writeable, ok = resolveWriteable(cfg, []string{"cn=staff,ou=groups,dc=example,dc=com"})
+	c.Assert(ok, IsTrue)
+	c.Assert(writeable, IsFalse)
+
+	_, ok = resolveWriteable(cfg, []string{"cn=guest,ou=groups,dc=example,dc=com"})
+	c.Assert(ok, IsFalse)
+
+	_, ok = resolveWriteable(cfg, nil)
+	c.Assert(ok, IsFalse)
+}
+
+func (t *testDirectorySuite) Test_authenticateDirectoryUser(c *C) {
+	dir := newFakeDirectory()
+	s := t.newService(c, dir)
+	c.Assert(s.encryptAndSaveSecret(SecretBindPassword, dir.servicePassword), IsNil)
+	cfg := testLDAPConfig()
+
+	u, writeable, err := s.authenticateDirectoryUser(cfg, "alice", "alicepass")
+	c.Assert(err, IsNil)
+	c.Assert(u.DN, Equals, "uid=alice,ou=people,dc=example,dc=com")
+	c.Assert(u.DisplayName, Equals, "Alice")
+	c.Assert(writeable, IsTrue)
+	c.Assert(dir.closed, IsTrue)
+
+	_, writeable, err = s.authenticateDirectoryUser(cfg, "bob", "bobpass")
+	c.Assert(err, IsNil)
+	c.Assert(writeable, IsFalse)
+
+	_, _, err = s.authenticateDirectoryUser(cfg, "bob", "wrong")
+	c.Assert(errorx.IsOfType(err, ErrSignInInvalidCredential), IsTrue)
+
+	// Empty password must not result in an unauthenticated bind
+	_, _, err = s.authenticateDirectoryUser(cfg, "bob", "")
+	c.Assert(errorx.IsOfType(err, ErrSignInInvalidCredential), IsTrue)
+
+	_, _, err = s.authenticateDirectoryUser(cfg, "mallory", "x")
+	c.Assert(errorx.IsOfType(err, ErrSignInInvalidCredential), IsTrue)
+
+	// Filter injection must not match other entries
+	_, _, err = s.authenticateDirectoryUser(cfg, "*", "alicepass")
+	c.Assert(errorx.IsOfType(err, ErrSignInInvalidCredential), IsTrue)
+
+	_, _, err = s.authenticateDirectoryUser(cfg, "eve", "evepass")
+	c.Assert(errorx.IsOfType(err, ErrSignInNoGroup), IsTrue)
+}
+
+func (t *testDirectorySuite) Test_authenticateDirectoryUser_badServiceAccount(c *C) {
+	dir := newFakeDirectory()
+	s := t.newService(c, dir)
+	c.Assert(s.encryptAndSaveSecret(SecretBindPassword, "wrong"), IsNil)
+
+	_, _, err := s.authenticateDirectoryUser(testLDAPConfig(), "alice", "alicepass")
+	c.Assert(errorx.IsOfType(err, ErrBadConfig), IsTrue)
+}
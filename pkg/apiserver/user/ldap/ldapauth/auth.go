@@ -0,0 +1,39 @@
+package ldapauth
+
+import (
+	"go.uber.org/fx"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/ldap"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+)
+
+const typeID utils.AuthType = 3
+
+type Authenticator struct {
+	user.BaseAuthenticator
+	ldapService *ldap.Service
+}
+
+func newAuthenticator(ldapService *ldap.Service) *Authenticator {
+	return &Authenticator{
+		ldapService: ldapService,
+	}
+}
+
+func registerAuthenticator(a *Authenticator, authService *user.AuthService) {
+	authService.RegisterAuthenticator(typeID, a)
+}
+
+var Module = fx.Options(
+	fx.Provide(newAuthenticator),
+	fx.Invoke(registerAuthenticator),
+)
+
+func (a *Authenticator) Authenticate(f user.AuthenticateForm) (*utils.SessionUser, error) {
+	return a.ldapService.NewSessionFromLDAP(f.Username, f.Password)
+}
+
+func (a *Authenticator) IsEnabled() (bool, error) {
+	return a.ldapService.IsEnabled()
+}
@@ -0,0 +1,39 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ldap
+
+import (
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+type SecretName string
+
+const (
+	SecretBindPassword SecretName = "bind_password"
+	SecretSQLPassword  SecretName = "sql_password"
+)
+
+type LDAPSecretModel struct { //nolint
+	Name SecretName `gorm:"primary_key;size:32"`
+	// Encrypted by the key in `ldap_ek.bin`, see the keyring package.
+	EncryptedValue string `gorm:"type:text"`
+}
+
+func (LDAPSecretModel) TableName() string {
+	return "ldap_secrets"
+}
+
+func autoMigrate(db *dbstore.DB) error {
+	return db.AutoMigrate(&LDAPSecretModel{})
+}
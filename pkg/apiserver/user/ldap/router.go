@@ -0,0 +1,131 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ldap
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+)
+
+func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
+	endpoint := r.Group("/user/ldap")
+	endpoint.Use(auth.MWAuthRequired())
+	endpoint.GET("/config", s.getConfig)
+	endpoint.PUT("/config", auth.MWRequirePermission(utils.PermUserManage), s.setConfig)
+}
+
+// @ID userLDAPGetConfig
+// @Summary Get LDAP config
+// @Success 200 {object} config.LDAPConfig
+// @Router /user/ldap/config [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 500 {object} utils.APIError
+func (s *Service) getConfig(c *gin.Context) {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, dc.LDAP)
+}
+
+type SetConfigRequest struct {
+	Config config.LDAPConfig `json:"config"`
+	// Leave empty to keep the stored password unchanged.
+	BindPassword string `json:"bind_password"`
+	// Leave empty to keep the stored password unchanged.
+	SQLPassword string `json:"sql_password"`
+}
+
+// @ID userLDAPSetConfig
+// @Summary Set LDAP config
+// @Param request body SetConfigRequest true "Request body"
+// @Success 200 {object} config.LDAPConfig
+// @Router /user/ldap/config [put]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 500 {object} utils.APIError "Internal error"
+func (s *Service) setConfig(c *gin.Context) {
+	var req SetConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+
+	if req.Config.UserSearchFilter == "" {
+		req.Config.UserSearchFilter = config.DefaultLDAPUserSearchFilter
+	}
+	if req.Config.GroupAttribute == "" {
+		req.Config.GroupAttribute = config.DefaultLDAPGroupAttribute
+	}
+
+	if req.Config.Enabled {
+		if req.SQLPassword == "" {
+			stored, err := s.getAndDecryptSecret(SecretSQLPassword)
+			if err != nil {
+				_ = c.Error(err)
+				return
+			}
+			req.SQLPassword = stored
+		}
+		// Check whether the impersonated user can access dashboard
+		if _, err := verifyImpersonation(s.params.TiDBClient, req.Config.SQLUser, req.SQLPassword); err != nil {
+			_ = c.Error(err)
+			if errorx.IsOfType(err, ErrInvalidImpersonateCredential) {
+				c.Status(http.StatusBadRequest)
+			}
+			return
+		}
+	}
+
+	var opt config.DynamicConfigOption = func(dc *config.DynamicConfig) {
+		dc.LDAP = req.Config
+	}
+	if err := s.params.ConfigManager.Modify(opt); err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, config.ErrVerificationFailed) {
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+
+	if !req.Config.Enabled {
+		if err := s.revokeAllSecrets(); err != nil {
+			_ = c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, req.Config)
+		return
+	}
+
+	if err := s.encryptAndSaveSecret(SecretSQLPassword, req.SQLPassword); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if req.BindPassword != "" {
+		if err := s.encryptAndSaveSecret(SecretBindPassword, req.BindPassword); err != nil {
+			_ = c.Error(err)
+			return
+		}
+	}
+	c.JSON(http.StatusOK, req.Config)
+}
@@ -0,0 +1,213 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ldap
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/joomcode/errorx"
+	"github.com/pingcap/log"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+	"github.com/pingcap/tidb-dashboard/pkg/tidb"
+	"github.com/pingcap/tidb-dashboard/pkg/utils/keyring"
+)
+
+var (
+	ErrNS                           = errorx.NewNamespace("error.api.user.ldap")
+	ErrBadConfig                    = ErrNS.NewType("bad_config")
+	ErrDirectoryUnavailable         = ErrNS.NewType("directory_unavailable")
+	ErrInvalidImpersonateCredential = ErrNS.NewType("invalid_impersonate_credential")
+	ErrSignInInvalidCredential      = user.ErrNSSignIn.NewType("invalid_ldap_credential")
+	ErrSignInNoGroup                = user.ErrNSSignIn.NewType("ldap_no_group") // The directory user is not in any configured group
+)
+
+type ServiceParams struct {
+	fx.In
+	LocalStore    *dbstore.DB
+	TiDBClient    *tidb.Client
+	ConfigManager *config.DynamicConfigManager
+}
+
+type Service struct {
+	params ServiceParams
+	dial   dialFunc
+
+	encKey *keyring.KeyFile
+}
+
+func newService(p ServiceParams, config *config.Config) (*Service, error) {
+	if err := autoMigrate(p.LocalStore); err != nil {
+		return nil, err
+	}
+	return &Service{
+		params: p,
+		dial:   dialDirectory,
+		encKey: keyring.NewKeyFile(path.Join(config.DataDir, "ldap_ek.bin")),
+	}, nil
+}
+
+var Module = fx.Options(
+	fx.Provide(newService),
+	fx.Invoke(registerRouter),
+)
+
+func (s *Service) getAndDecryptSecret(name SecretName) (string, error) {
+	var rec LDAPSecretModel
+	err := s.params.LocalStore.Where("name = ?", name).First(&rec).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil
+		}
+		return "", fmt.Errorf("bad record: %v", err)
+	}
+	key, err := s.encKey.Get()
+	if err != nil {
+		return "", fmt.Errorf("bad encryption key: %v", err)
+	}
+	decrypted, err := keyring.DecryptHex(rec.EncryptedValue, key)
+	if err != nil {
+		return "", err
+	}
+	return string(decrypted), nil
+}
+
+func (s *Service) encryptAndSaveSecret(name SecretName, value string) error {
+	key, err := s.encKey.GetOrCreate()
+	if err != nil {
+		return err
+	}
+	encrypted, err := keyring.EncryptToHex([]byte(value), key)
+	if err != nil {
+		return err
+	}
+	return s.params.LocalStore.Save(&LDAPSecretModel{
+		Name:           name,
+		EncryptedValue: encrypted,
+	}).Error
+}
+
+func (s *Service) revokeAllSecrets() error {
+	sqlStr := fmt.Sprintf("DELETE FROM `%s`", LDAPSecretModel{}.TableName()) // #nosec
+	return s.params.LocalStore.
+		Exec(sqlStr).
+		Error
+}
+
+func (s *Service) IsEnabled() (bool, error) {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		return false, err
+	}
+	return dc.LDAP.Enabled, nil
+}
+
+func verifyImpersonation(tidbClient *tidb.Client, sqlUser string, sqlPassword string) (bool, error) {
+	privs, err := user.VerifySQLUser(tidbClient, sqlUser, sqlPassword)
+	if err != nil {
+		if errorx.IsOfType(err, tidb.ErrTiDBAuthFailed) {
+			return false, ErrInvalidImpersonateCredential.Wrap(err, "Invalid SQL credential")
+		}
+		if errorx.IsOfType(err, user.ErrInsufficientPrivs) {
+			return false, ErrInvalidImpersonateCredential.Wrap(err, "Insufficient privileges")
+		}
+		return false, err
+	}
+	return privs.Writeable, nil
+}
+
+// authenticateDirectoryUser verifies the user against the directory and maps its groups to the session privilege.
+func (s *Service) authenticateDirectoryUser(cfg *config.LDAPConfig, userName string, password string) (*directoryUser, bool, error) {
+	// An empty password results in an unauthenticated bind, which succeeds in most LDAP servers.
+	if userName == "" || password == "" {
+		return nil, false, ErrSignInInvalidCredential.NewWithNoMessage()
+	}
+
+	bindPassword, err := s.getAndDecryptSecret(SecretBindPassword)
+	if err != nil {
+		return nil, false, ErrBadConfig.Wrap(err, "LDAP service account password is not available")
+	}
+
+	conn, err := s.dial(cfg)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+
+	u, err := lookupAndBind(conn, cfg, bindPassword, userName, password)
+	if err != nil {
+		return nil, false, err
+	}
+	writeable, ok := resolveWriteable(cfg, u.Groups)
+	if !ok {
+		return nil, false, ErrSignInNoGroup.New("LDAP user %s is not a member of any permitted group", userName)
+	}
+	return u, writeable, nil
+}
+
+// NewSessionFromLDAP authenticates the user against the LDAP directory and creates a session that impersonates
+// the configured SQL user.
+func (s *Service) NewSessionFromLDAP(userName string, password string) (*utils.SessionUser, error) {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		return nil, err
+	}
+	if !dc.LDAP.Enabled {
+		return nil, ErrBadConfig.New("LDAP is not enabled")
+	}
+
+	u, writeable, err := s.authenticateDirectoryUser(&dc.LDAP, userName, password)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlPassword, err := s.getAndDecryptSecret(SecretSQLPassword)
+	if err != nil {
+		return nil, ErrBadConfig.Wrap(err, "Impersonated SQL user password is not available")
+	}
+	sqlWriteable, err := verifyImpersonation(s.params.TiDBClient, dc.LDAP.SQLUser, sqlPassword)
+	if err != nil {
+		return nil, ErrBadConfig.Wrap(err, "LDAP is not configured correctly")
+	}
+
+	subjects := make([]string, 0, len(u.Groups)*2)
+	for _, group := range u.Groups {
+		subjects = append(subjects, user.Subject(user.SubjectKindLDAPGroup, group))
+		if cn := groupCN(group); cn != "" {
+			subjects = append(subjects, user.Subject(user.SubjectKindLDAPGroup, cn))
+		}
+	}
+	perms := user.ResolvePermissions(&dc.Permission, writeable && sqlWriteable, subjects)
+
+	log.Info("New session via LDAP", zap.String("dn", u.DN), zap.Any("permissions", perms))
+
+	return &utils.SessionUser{
+		Version:         utils.SessionVersion,
+		HasTiDBAuth:     true,
+		TiDBUsername:    dc.LDAP.SQLUser,
+		TiDBPassword:    sqlPassword,
+		DisplayName:     u.DisplayName,
+		ExternalSubject: u.DN,
+		Permissions:     perms,
+		Subjects:        subjects,
+	}, nil
+}
@@ -0,0 +1,354 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package user
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/tidb"
+)
+
+var (
+	ErrSignInLocked   = ErrNSSignIn.NewType("locked")
+	ErrLockNotFound   = ErrNS.NewType("lock_not_found")
+	ErrInvalidLockKey = ErrNS.NewType("invalid_lock_key")
+)
+
+type LockKind string
+
+const (
+	LockKindUser     LockKind = "user"
+	LockKindClientIP LockKind = "ip"
+)
+
+const (
+	// Number of failures allowed before locking. Client IPs are allowed more failures since multiple users may share
+	// the same IP via proxies.
+	userFailuresBeforeLock     = 5
+	clientIPFailuresBeforeLock = 20
+
+	// The lock duration doubles for every further failure, starting from baseLockDuration.
+	baseLockDuration = 30 * time.Second
+	maxLockDuration  = time.Hour
+
+	// Failures are forgotten after this duration without new failures.
+	failureWindow = 24 * time.Hour
+)
+
+type LoginAttemptModel struct {
+	Kind          LockKind   `gorm:"primary_key;size:8" json:"kind"`
+	Value         string     `gorm:"primary_key;size:256" json:"value"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+}
+
+func (LoginAttemptModel) TableName() string {
+	return "login_attempts"
+}
+
+// LockoutListener is notified when a user or a client IP is locked because of too many sign in failures.
+type LockoutListener func(c *gin.Context, kind LockKind, value string, until time.Time)
+
+// RegisterLockoutListener registers a listener to be notified when sign in is locked.
+func (s *AuthService) RegisterLockoutListener(l LockoutListener) {
+	s.lockoutListeners = append(s.lockoutListeners, l)
+}
+
+type lockKey struct {
+	Kind  LockKind
+	Value string
+}
+
+// loginLockKeys returns keys to be checked for the sign in request. The client IP is the remote address of the
+// connection, since headers like X-Forwarded-For can be forged to bypass the limit or to lock other clients.
+func loginLockKeys(c *gin.Context, f *AuthenticateForm) []lockKey {
+	var keys []lockKey
+	if ip := remoteIP(c.Request); ip != "" {
+		keys = append(keys, lockKey{Kind: LockKindClientIP, Value: ip})
+	}
+	if f.Username != "" {
+		keys = append(keys, lockKey{Kind: LockKindUser, Value: f.Username})
+	}
+	return keys
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return strings.TrimSpace(r.RemoteAddr)
+	}
+	return host
+}
+
+func failuresBeforeLock(kind LockKind) int {
+	if kind == LockKindClientIP {
+		return clientIPFailuresBeforeLock
+	}
+	return userFailuresBeforeLock
+}
+
+// lockDuration returns the lock duration after the specified number of failures, or 0 if not locked.
+func lockDuration(kind LockKind, failures int) time.Duration {
+	exceeded := failures - failuresBeforeLock(kind)
+	if exceeded < 0 {
+		return 0
+	}
+	d := baseLockDuration
+	for i := 0; i < exceeded; i++ {
+		d *= 2
+		if d >= maxLockDuration {
+			return maxLockDuration
+		}
+	}
+	return d
+}
+
+// isCredentialFailure returns whether the sign in error is caused by bad credentials supplied by the client.
+// Failures caused by unavailable services are not counted.
+func isCredentialFailure(err error) bool {
+	if errorx.IsOfType(err, tidb.ErrTiDBAuthFailed) || errorx.IsOfType(err, ErrInsufficientPrivs) {
+		return true
+	}
+	e := errorx.Cast(err)
+	if e == nil {
+		return false
+	}
+	return ErrNSSignIn.IsNamespaceOf(e.Type()) && !e.IsOfType(ErrSignInOther) && !e.IsOfType(ErrSignInLocked)
+}
+
+// loginAttempt is a sign in attempt counted as a failure in advance, see reserveAttempt.
+type loginAttempt struct {
+	keys []lockKey
+	// Keys locked when counting this attempt. They are unlocked if the attempt turns out not to be a failure.
+	lockedUntil map[lockKey]time.Time
+}
+
+// reserveAttempt counts a sign in attempt as a failure of all keys before verifying the credential, and returns
+// ErrSignInLocked if any of the keys is locked. As counters are increased atomically in advance, parallel attempts
+// cannot exceed the limit. The result must be passed to finishAttempt after verifying the credential.
+func (s *AuthService) reserveAttempt(keys []lockKey) (*loginAttempt, error) {
+	attempt := &loginAttempt{lockedUntil: map[lockKey]time.Time{}}
+	now := time.Now()
+	err := s.params.LocalStore.Transaction(func(tx *gorm.DB) error {
+		for _, key := range keys {
+			lockedUntil, err := reserveKey(tx, key, now)
+			if err != nil {
+				return err
+			}
+			if lockedUntil != nil {
+				attempt.lockedUntil[key] = *lockedUntil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if errorx.IsOfType(err, ErrSignInLocked) {
+			return nil, err
+		}
+		// Sign in is still allowed when counters are not available.
+		log.Warn("Failed to save login attempts", zap.Error(err))
+		return &loginAttempt{}, nil
+	}
+	attempt.keys = keys
+	return attempt, nil
+}
+
+// reserveKey increases the failure counter of the key, and returns the lock time if the key is locked by this
+// attempt.
+func reserveKey(tx *gorm.DB, key lockKey, now time.Time) (*time.Time, error) {
+	// Counters are updated before being read, so that the transaction holds the write lock when reading them.
+	result := tx.Model(&LoginAttemptModel{}).
+		Where("kind = ? AND value = ? AND (locked_until IS NULL OR locked_until <= ?)", key.Kind, key.Value, now).
+		Updates(map[string]interface{}{
+			// Failures are forgotten after the window without new failures.
+			"failures":        gorm.Expr("CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END", now.Add(-failureWindow)),
+			"last_failure_at": now,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		result = tx.
+			Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&LoginAttemptModel{Kind: key.Kind, Value: key.Value, Failures: 1, LastFailureAt: now})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+	}
+
+	var rec LoginAttemptModel
+	if err := tx.Where("kind = ? AND value = ?", key.Kind, key.Value).First(&rec).Error; err != nil {
+		return nil, err
+	}
+	if result.RowsAffected == 0 {
+		// The key exists but is not updated, which means it is locked.
+		retryAfter := rec.LockedUntil.Sub(now).Round(time.Second)
+		if retryAfter < time.Second {
+			retryAfter = time.Second
+		}
+		return nil, ErrSignInLocked.New("Too many failed sign in attempts, please retry after %s", retryAfter)
+	}
+	d := lockDuration(key.Kind, rec.Failures)
+	if d == 0 {
+		return nil, nil
+	}
+	until := now.Add(d)
+	if err := tx.Model(&rec).Update("locked_until", until).Error; err != nil {
+		return nil, err
+	}
+	return &until, nil
+}
+
+// finishAttempt keeps the failure counted by reserveAttempt if the sign in error is a credential failure. Otherwise
+// the counted failure is reverted. For successful attempts, the counter of the user is also reset, but counters of
+// the client IP are kept, otherwise attackers can reset the counter by signing in with a known account.
+func (s *AuthService) finishAttempt(c *gin.Context, attempt *loginAttempt, signInErr error) {
+	if signInErr != nil && isCredentialFailure(signInErr) {
+		for _, key := range attempt.keys {
+			until, ok := attempt.lockedUntil[key]
+			if !ok {
+				continue
+			}
+			log.Warn("Sign in is locked because of too many failures",
+				zap.String("kind", string(key.Kind)),
+				zap.String("value", key.Value),
+				zap.Time("until", until))
+			for _, l := range s.lockoutListeners {
+				l(c, key.Kind, key.Value, until)
+			}
+		}
+		return
+	}
+
+	err := s.params.LocalStore.Transaction(func(tx *gorm.DB) error {
+		for _, key := range attempt.keys {
+			query := tx.Where("kind = ? AND value = ?", key.Kind, key.Value)
+			if signInErr == nil && key.Kind == LockKindUser {
+				if err := query.Delete(&LoginAttemptModel{}).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			updates := map[string]interface{}{
+				"failures": gorm.Expr("CASE WHEN failures > 0 THEN failures - 1 ELSE 0 END"),
+			}
+			if _, ok := attempt.lockedUntil[key]; ok {
+				updates["locked_until"] = nil
+			}
+			if err := query.Model(&LoginAttemptModel{}).Updates(updates).Error; err != nil {
+				return err
+			}
+			err := tx.
+				Where("kind = ? AND value = ? AND failures = 0", key.Kind, key.Value).
+				Delete(&LoginAttemptModel{}).
+				Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warn("Failed to save login attempts", zap.Error(err))
+	}
+}
+
+func (s *AuthService) listLocks() ([]LoginAttemptModel, error) {
+	var recs []LoginAttemptModel
+	err := s.params.LocalStore.
+		Where("locked_until > ?", time.Now()).
+		Order("locked_until DESC").
+		Find(&recs).Error
+	if err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+func (s *AuthService) unlock(kind LockKind, value string) error {
+	result := s.params.LocalStore.
+		Where("kind = ? AND value = ?", kind, value).
+		Delete(&LoginAttemptModel{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLockNotFound.New("%s %s is not locked", kind, value)
+	}
+	return nil
+}
+
+// @ID userListLocks
+// @Summary List users and client IPs that are locked because of too many sign in failures
+// @Success 200 {array} LoginAttemptModel
+// @Router /user/locks/list [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+func (s *AuthService) listLocksHandler(c *gin.Context) {
+	recs, err := s.listLocks()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, recs)
+}
+
+type UnlockRequest struct {
+	Kind  LockKind `json:"kind" binding:"required"`
+	Value string   `json:"value" binding:"required"`
+}
+
+// @ID userUnlock
+// @Summary Unlock a user or a client IP and reset its failure counter
+// @Param request body UnlockRequest true "Request body"
+// @Success 200 {string} string
+// @Router /user/locks/unlock [post]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 404 {object} utils.APIError "Not locked"
+func (s *AuthService) unlockHandler(c *gin.Context) {
+	var req UnlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	if req.Kind != LockKindUser && req.Kind != LockKindClientIP {
+		_ = c.Error(ErrInvalidLockKey.New("Unknown lock kind %s", req.Kind))
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	if err := s.unlock(req.Kind, req.Value); err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrLockNotFound) {
+			c.Status(http.StatusNotFound)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, "success")
+}
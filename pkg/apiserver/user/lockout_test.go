@@ -0,0 +1,151 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package user
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore/dbstoretest"
+	"github.com/pingcap/tidb-dashboard/pkg/tidb"
+)
+
+var _ = Suite(&testLockoutSuite{})
+
+type testLockoutSuite struct{}
+
+func (t *testLockoutSuite) SetUpSuite(c *C) {
+	gin.SetMode(gin.TestMode)
+}
+
+func (t *testLockoutSuite) newService(c *C) *AuthService {
+	return &AuthService{params: ServiceParams{LocalStore: dbstoretest.NewDB(c, autoMigrate)}}
+}
+
+func (t *testLockoutSuite) Test_lockDuration(c *C) {
+	c.Assert(lockDuration(LockKindUser, userFailuresBeforeLock-1), Equals, time.Duration(0))
+	c.Assert(lockDuration(LockKindUser, userFailuresBeforeLock), Equals, baseLockDuration)
+	c.Assert(lockDuration(LockKindUser, userFailuresBeforeLock+2), Equals, 4*baseLockDuration)
+	c.Assert(lockDuration(LockKindUser, userFailuresBeforeLock+100), Equals, maxLockDuration)
+	c.Assert(lockDuration(LockKindClientIP, userFailuresBeforeLock), Equals, time.Duration(0))
+}
+
+func (t *testLockoutSuite) Test_isCredentialFailure(c *C) {
+	c.Assert(isCredentialFailure(tidb.ErrTiDBAuthFailed.New("bad password")), IsTrue)
+	c.Assert(isCredentialFailure(errorx.Decorate(tidb.ErrTiDBAuthFailed.New("bad password"), "authenticate failed")), IsTrue)
+	c.Assert(isCredentialFailure(ErrNSSignIn.NewType("test_invalid").NewWithNoMessage()), IsTrue)
+	c.Assert(isCredentialFailure(tidb.ErrNoAliveTiDB.NewWithNoMessage()), IsFalse)
+	c.Assert(isCredentialFailure(ErrSignInOther.NewWithNoMessage()), IsFalse)
+	c.Assert(isCredentialFailure(ErrSignInLocked.NewWithNoMessage()), IsFalse)
+}
+
+func (t *testLockoutSuite) Test_loginLockKeys(c *C) {
+	ctx := newTestContext()
+	// Forwarded headers are not trusted
+	ctx.Request.Header.Set("X-Forwarded-For", "10.0.0.2")
+	ctx.Request.Header.Set("X-Real-IP", "10.0.0.3")
+	c.Assert(loginLockKeys(ctx, &AuthenticateForm{Username: "alice"}), DeepEquals, []lockKey{
+		{Kind: LockKindClientIP, Value: "10.0.0.1"},
+		{Kind: LockKindUser, Value: "alice"},
+	})
+}
+
+func failAttempt(s *AuthService, ctx *gin.Context, keys []lockKey) error {
+	attempt, err := s.reserveAttempt(keys)
+	if err != nil {
+		return err
+	}
+	s.finishAttempt(ctx, attempt, tidb.ErrTiDBAuthFailed.New("bad password"))
+	return nil
+}
+
+func (t *testLockoutSuite) Test_lockAndUnlock(c *C) {
+	s := t.newService(c)
+	var locked []string
+	s.RegisterLockoutListener(func(c *gin.Context, kind LockKind, value string, until time.Time) {
+		locked = append(locked, string(kind)+":"+value)
+	})
+
+	ctx := newTestContext()
+	aliceKeys := loginLockKeys(ctx, &AuthenticateForm{Username: "alice"})
+	c.Assert(aliceKeys, HasLen, 2)
+	bobKeys := loginLockKeys(ctx, &AuthenticateForm{Username: "bob"})
+
+	for i := 0; i < userFailuresBeforeLock; i++ {
+		c.Assert(failAttempt(s, ctx, aliceKeys), IsNil)
+	}
+	c.Assert(locked, DeepEquals, []string{"user:alice"})
+	_, err := s.reserveAttempt(aliceKeys)
+	c.Assert(errorx.IsOfType(err, ErrSignInLocked), IsTrue)
+	// Other users from the same client IP are not locked yet
+	attempt, err := s.reserveAttempt(bobKeys)
+	c.Assert(err, IsNil)
+	s.finishAttempt(ctx, attempt, tidb.ErrNoAliveTiDB.NewWithNoMessage())
+
+	locks, err := s.listLocks()
+	c.Assert(err, IsNil)
+	c.Assert(locks, HasLen, 1)
+	c.Assert(locks[0].Value, Equals, "alice")
+
+	c.Assert(s.unlock(LockKindUser, "alice"), IsNil)
+	attempt, err = s.reserveAttempt(aliceKeys)
+	c.Assert(err, IsNil)
+	s.finishAttempt(ctx, attempt, tidb.ErrNoAliveTiDB.NewWithNoMessage())
+	c.Assert(errorx.IsOfType(s.unlock(LockKindUser, "alice"), ErrLockNotFound), IsTrue)
+
+	// Success resets the user counter but not the client IP counter
+	c.Assert(failAttempt(s, ctx, bobKeys), IsNil)
+	attempt, err = s.reserveAttempt(bobKeys)
+	c.Assert(err, IsNil)
+	s.finishAttempt(ctx, attempt, nil)
+	var rec LoginAttemptModel
+	c.Assert(s.params.LocalStore.Where("kind = ?", LockKindClientIP).First(&rec).Error, IsNil)
+	c.Assert(rec.Failures, Equals, userFailuresBeforeLock+1)
+	err = s.params.LocalStore.Where("kind = ? AND value = ?", LockKindUser, "bob").First(&rec).Error
+	c.Assert(err, Equals, gorm.ErrRecordNotFound)
+}
+
+func (t *testLockoutSuite) Test_parallelAttempts(c *C) {
+	s := t.newService(c)
+	ctx := newTestContext()
+	keys := loginLockKeys(ctx, &AuthenticateForm{Username: "alice"})
+
+	// Attempts in progress are counted, so that parallel attempts cannot exceed the limit
+	var attempts []*loginAttempt
+	for i := 0; i < userFailuresBeforeLock; i++ {
+		attempt, err := s.reserveAttempt(keys)
+		c.Assert(err, IsNil)
+		attempts = append(attempts, attempt)
+	}
+	_, err := s.reserveAttempt(keys)
+	c.Assert(errorx.IsOfType(err, ErrSignInLocked), IsTrue)
+
+	// The lock is released if the attempt locking the user succeeds
+	for _, attempt := range attempts[:len(attempts)-1] {
+		s.finishAttempt(ctx, attempt, tidb.ErrTiDBAuthFailed.New("bad password"))
+	}
+	s.finishAttempt(ctx, attempts[len(attempts)-1], nil)
+	attempt, err := s.reserveAttempt(keys)
+	c.Assert(err, IsNil)
+	s.finishAttempt(ctx, attempt, nil)
+
+	var rec LoginAttemptModel
+	c.Assert(s.params.LocalStore.Where("kind = ?", LockKindClientIP).First(&rec).Error, IsNil)
+	c.Assert(rec.Failures, Equals, userFailuresBeforeLock-1)
+	c.Assert(rec.LockedUntil, IsNil)
+}
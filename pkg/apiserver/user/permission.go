@@ -0,0 +1,173 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package user
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+)
+
+// Kinds of subjects in permission rules.
+const (
+	SubjectKindSQLUser   = "sql_user"
+	SubjectKindSQLRole   = "sql_role"
+	SubjectKindSSOEmail  = "sso_email"
+	SubjectKindSSOGroup  = "sso_group"
+	SubjectKindLDAPGroup = "ldap_group"
+)
+
+func Subject(kind string, name string) string {
+	return kind + ":" + name
+}
+
+// SQLUserSubjects returns the subjects of a SQL user, which are used to match permission rules.
+func SQLUserSubjects(userName string, roles []string) []string {
+	subjects := []string{Subject(SubjectKindSQLUser, userName)}
+	for _, role := range roles {
+		subjects = append(subjects, Subject(SubjectKindSQLRole, role))
+	}
+	return subjects
+}
+
+// ResolvePermissions resolves permissions of a session from the permission rules matching any of the subjects.
+// Write permissions are only granted when the SQL user of the session is writeable.
+func ResolvePermissions(cfg *config.PermissionConfig, writeable bool, subjects []string) []utils.Permission {
+	granted := map[utils.Permission]struct{}{}
+	if !cfg.Enabled {
+		for _, p := range utils.AllPermissions {
+			granted[p] = struct{}{}
+		}
+	} else {
+		subjectSet := map[string]struct{}{}
+		for _, subject := range subjects {
+			subjectSet[subject] = struct{}{}
+		}
+		for _, rule := range cfg.Rules {
+			if _, ok := subjectSet[rule.Subject]; !ok {
+				continue
+			}
+			for _, p := range rule.Permissions {
+				granted[utils.Permission(p)] = struct{}{}
+			}
+		}
+	}
+
+	// Keep permissions in a stable order.
+	perms := make([]utils.Permission, 0, len(granted))
+	for _, p := range utils.AllPermissions {
+		if _, ok := granted[p]; !ok {
+			continue
+		}
+		if p.IsWrite() && !writeable {
+			continue
+		}
+		perms = append(perms, p)
+	}
+	return perms
+}
+
+// ResolvePermissionsFromConfig is similar to ResolvePermissions, but reads rules from the dynamic config.
+func ResolvePermissionsFromConfig(configManager *config.DynamicConfigManager, writeable bool, subjects []string) ([]utils.Permission, error) {
+	dc, err := configManager.Get()
+	if err != nil {
+		return nil, err
+	}
+	return ResolvePermissions(&dc.Permission, writeable, subjects), nil
+}
+
+// @ID userListPermissions
+// @Summary List all permissions
+// @Success 200 {array} string
+// @Router /user/permission/list [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+func (s *AuthService) listPermissionsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, utils.AllPermissions)
+}
+
+// @ID userGetPermissionConfig
+// @Summary Get permission rules
+// @Success 200 {object} config.PermissionConfig
+// @Router /user/permission/config [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 500 {object} utils.APIError
+func (s *AuthService) getPermissionConfigHandler(c *gin.Context) {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, dc.Permission)
+}
+
+// @ID userSetPermissionConfig
+// @Summary Set permission rules
+// @Description Permissions are resolved when signing in, so that existing session tokens and API tokens keep their old permissions until they expire. Rules not granting user.manage to the current user are rejected.
+// @Param request body config.PermissionConfig true "Request body"
+// @Success 200 {object} config.PermissionConfig
+// @Router /user/permission/config [put]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 500 {object} utils.APIError "Internal error"
+func (s *AuthService) setPermissionConfigHandler(c *gin.Context) {
+	var req config.PermissionConfig
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+
+	canManage := false
+	for _, rule := range req.Rules {
+		for _, p := range rule.Permissions {
+			if !utils.Permission(p).IsValid() {
+				utils.MakeInvalidRequestErrorWithMessage(c, "Unknown permission %s", p)
+				return
+			}
+			if utils.Permission(p) == utils.PermUserManage {
+				canManage = true
+			}
+		}
+	}
+	// Avoid locking everyone out of the permission settings.
+	if req.Enabled && !canManage {
+		utils.MakeInvalidRequestErrorWithMessage(c, "At least one rule must grant %s", utils.PermUserManage)
+		return
+	}
+	// Avoid locking the current user out. The session is writeable, since it holds user.manage.
+	resolved := utils.SessionUser{Permissions: ResolvePermissions(&req, true, utils.GetSession(c).Subjects)}
+	if !resolved.HasPermission(utils.PermUserManage) {
+		utils.MakeInvalidRequestErrorWithMessage(c, "The rules must keep granting %s to the current user", utils.PermUserManage)
+		return
+	}
+
+	var opt config.DynamicConfigOption = func(dc *config.DynamicConfig) {
+		dc.Permission = req
+	}
+	if err := s.params.ConfigManager.Modify(opt); err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, config.ErrVerificationFailed) {
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
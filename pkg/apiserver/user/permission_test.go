@@ -0,0 +1,89 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package user
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+)
+
+var _ = Suite(&testPermissionSuite{})
+
+type testPermissionSuite struct{}
+
+func (t *testPermissionSuite) Test_ResolvePermissions_disabled(c *C) {
+	cfg := &config.PermissionConfig{}
+	c.Assert(ResolvePermissions(cfg, true, nil), DeepEquals, utils.AllPermissions)
+	c.Assert(ResolvePermissions(cfg, false, nil), DeepEquals, utils.ReadPermissionsOf(utils.AllPermissions))
+}
+
+func (t *testPermissionSuite) Test_ResolvePermissions_rules(c *C) {
+	cfg := &config.PermissionConfig{
+		Enabled: true,
+		Rules: []config.PermissionRule{
+			{Subject: "sql_role:app_read", Permissions: []string{"session.share"}},
+			{Subject: "sql_role:dba", Permissions: []string{"config.edit", "logsearch.download"}},
+			{Subject: "sso_group:dba", Permissions: []string{"queryeditor.run"}},
+		},
+	}
+
+	subjects := SQLUserSubjects("test", []string{"app_read", "dba"})
+	c.Assert(subjects, DeepEquals, []string{"sql_user:test", "sql_role:app_read", "sql_role:dba"})
+	c.Assert(ResolvePermissions(cfg, true, subjects), DeepEquals, []utils.Permission{
+		utils.PermSessionShare,
+		utils.PermLogSearchDownload,
+		utils.PermConfigEdit,
+	})
+	// Write permissions require a writeable SQL user
+	c.Assert(ResolvePermissions(cfg, false, subjects), DeepEquals, []utils.Permission{
+		utils.PermSessionShare,
+		utils.PermLogSearchDownload,
+	})
+	c.Assert(ResolvePermissions(cfg, true, []string{"sql_user:other"}), HasLen, 0)
+}
+
+func (t *testPermissionSuite) Test_setPermissionConfigHandler_lockOut(c *C) {
+	gin.SetMode(gin.TestMode)
+	s := &AuthService{params: ServiceParams{ConfigManager: &config.DynamicConfigManager{}}}
+	set := func(subjects []string, rules string) *gin.Context {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		body := `{"enabled": true, "rules": [` + rules + `]}`
+		ctx.Request, _ = http.NewRequest(http.MethodPut, "/user/permission/config", strings.NewReader(body))
+		ctx.Request.Header.Set("Content-Type", "application/json")
+		ctx.Set(utils.SessionUserKey, &utils.SessionUser{Subjects: subjects})
+		s.setPermissionConfigHandler(ctx)
+		return ctx
+	}
+	subjects := SQLUserSubjects("alice", []string{"dba"})
+
+	// Rules granting user.manage only to other users are rejected
+	ctx := set(subjects, `{"subject": "sql_user:bob", "permissions": ["user.manage"]}`)
+	c.Assert(ctx.Writer.Status(), Equals, http.StatusBadRequest)
+	c.Assert(errorx.IsOfType(ctx.Errors.Last().Err, utils.ErrInvalidRequest), IsTrue)
+	// Sessions created before subjects are recorded can not keep user.manage either
+	ctx = set(nil, `{"subject": "sql_role:dba", "permissions": ["user.manage"]}`)
+	c.Assert(ctx.Writer.Status(), Equals, http.StatusBadRequest)
+
+	// The check passes when a role of the user keeps user.manage, then the config is saved
+	ctx = set(subjects, `{"subject": "sql_role:dba", "permissions": ["user.manage"]}`)
+	c.Assert(errorx.IsOfType(ctx.Errors.Last().Err, config.ErrNotReady), IsTrue)
+}
@@ -0,0 +1,56 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package saml
+
+import (
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+// SAMLConsumedAssertionModel records assertions that have been used to sign in, so that they cannot be replayed even
+// after Dashboard is restarted.
+type SAMLConsumedAssertionModel struct { //nolint
+	AssertionID string    `gorm:"primary_key;size:256"`
+	ExpireAt    time.Time `gorm:"index"`
+}
+
+func (SAMLConsumedAssertionModel) TableName() string {
+	return "saml_consumed_assertions"
+}
+
+func autoMigrate(db *dbstore.DB) error {
+	return db.AutoMigrate(&SAMLConsumedAssertionModel{})
+}
+
+// markAssertionConsumed records the assertion and returns false if it has been consumed before. Records are kept
+// until the assertion expires, allowing clock skews.
+func markAssertionConsumed(db *dbstore.DB, assertionID string, expireAt time.Time, now time.Time) (bool, error) {
+	err := db.
+		Where("expire_at < ?", now.Add(-maxClockSkew)).
+		Delete(&SAMLConsumedAssertionModel{}).
+		Error
+	if err != nil {
+		return false, err
+	}
+	result := db.
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&SAMLConsumedAssertionModel{AssertionID: assertionID, ExpireAt: expireAt})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
@@ -0,0 +1,278 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	statusSuccess         = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	subjectConfirmBearer  = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	maxClockSkew          = 3 * time.Minute
+	maxResponseSizeBytes  = 256 * 1024
+	signatureElementLocal = "Signature"
+)
+
+// Elements are matched by local names only. Namespace declarations may be lost when an assertion is detached from
+// the response for signature validation.
+
+type xmlResponse struct {
+	XMLName      xml.Name `xml:"Response"`
+	ID           string   `xml:"ID,attr"`
+	InResponseTo string   `xml:"InResponseTo,attr"`
+	Destination  string   `xml:"Destination,attr"`
+	Issuer       string   `xml:"Issuer"`
+	Status       struct {
+		StatusCode struct {
+			Value string `xml:"Value,attr"`
+		} `xml:"StatusCode"`
+		StatusMessage string `xml:"StatusMessage"`
+	} `xml:"Status"`
+}
+
+type xmlAssertion struct {
+	XMLName xml.Name `xml:"Assertion"`
+	ID      string   `xml:"ID,attr"`
+	Issuer  string   `xml:"Issuer"`
+	Subject struct {
+		NameID               string `xml:"NameID"`
+		SubjectConfirmations []struct {
+			Method string `xml:"Method,attr"`
+			Data   struct {
+				Recipient    string    `xml:"Recipient,attr"`
+				NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+				InResponseTo string    `xml:"InResponseTo,attr"`
+			} `xml:"SubjectConfirmationData"`
+		} `xml:"SubjectConfirmation"`
+	} `xml:"Subject"`
+	Conditions struct {
+		NotBefore            time.Time `xml:"NotBefore,attr"`
+		NotOnOrAfter         time.Time `xml:"NotOnOrAfter,attr"`
+		AudienceRestrictions []struct {
+			Audiences []string `xml:"Audience"`
+		} `xml:"AudienceRestriction"`
+	} `xml:"Conditions"`
+	AttributeStatement struct {
+		Attributes []struct {
+			Name   string   `xml:"Name,attr"`
+			Values []string `xml:"AttributeValue"`
+		} `xml:"Attribute"`
+	} `xml:"AttributeStatement"`
+}
+
+func (a *xmlAssertion) attributeValues(name string) []string {
+	if name == "" {
+		return nil
+	}
+	for _, attr := range a.AttributeStatement.Attributes {
+		if attr.Name == name {
+			return attr.Values
+		}
+	}
+	return nil
+}
+
+// identity is the user identity extracted from a validated assertion.
+type identity struct {
+	Subject     string
+	Email       string
+	Groups      []string
+	AssertionID string
+	// The assertion cannot be used after this time, used to expire the replay cache.
+	ExpireAt time.Time
+}
+
+// expectation contains values that must be matched by the response.
+type expectation struct {
+	IdPEntityID     string
+	IdPCertificate  *x509.Certificate
+	SPEntityID      string
+	ACSURL          string
+	RequestID       string
+	EmailAttribute  string
+	GroupsAttribute string
+	Now             time.Time
+}
+
+func parseCertificate(pemStr string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil, ErrInvalidResponse.New("IdP certificate is not PEM encoded")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func hasSignature(el *etree.Element) bool {
+	for _, child := range el.ChildElements() {
+		if child.Tag == signatureElementLocal {
+			return true
+		}
+	}
+	return false
+}
+
+func unmarshalElement(el *etree.Element, v interface{}) error {
+	doc := etree.NewDocument()
+	doc.SetRoot(el.Copy())
+	b, err := doc.WriteToBytes()
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(b, v)
+}
+
+// parseResponse verifies the signature and conditions of a base64 encoded SAML response posted to the ACS, and
+// extracts the user identity. Only the content covered by a valid signature is trusted.
+func parseResponse(encoded string, exp *expectation) (*identity, error) {
+	if len(encoded) > maxResponseSizeBytes {
+		return nil, ErrInvalidResponse.New("Response is too large")
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidResponse.Wrap(err, "Response is not base64 encoded")
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(raw); err != nil {
+		return nil, ErrInvalidResponse.Wrap(err, "Response is not a valid XML")
+	}
+	root := doc.Root()
+	if root == nil || root.Tag != "Response" {
+		return nil, ErrInvalidResponse.New("Response element is missing")
+	}
+
+	validator := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{exp.IdPCertificate},
+	})
+	validator.Clock = dsig.NewFakeClockAt(exp.Now)
+
+	responseSigned := hasSignature(root)
+	if responseSigned {
+		root, err = validator.Validate(root)
+		if err != nil {
+			return nil, ErrInvalidResponse.Wrap(err, "Response signature is invalid")
+		}
+	}
+
+	var resp xmlResponse
+	if err := unmarshalElement(root, &resp); err != nil {
+		return nil, ErrInvalidResponse.Wrap(err, "Failed to parse response")
+	}
+	if resp.Status.StatusCode.Value != statusSuccess {
+		return nil, ErrInvalidResponse.New("IdP returns status %s: %s", resp.Status.StatusCode.Value, resp.Status.StatusMessage)
+	}
+	if resp.Destination != "" && resp.Destination != exp.ACSURL {
+		return nil, ErrInvalidResponse.New("Response destination %s does not match %s", resp.Destination, exp.ACSURL)
+	}
+	if resp.InResponseTo != "" && resp.InResponseTo != exp.RequestID {
+		return nil, ErrInvalidResponse.New("Response is not for the current sign in request")
+	}
+
+	if root.FindElement("./EncryptedAssertion") != nil {
+		return nil, ErrInvalidResponse.New("Encrypted assertions are not supported")
+	}
+	assertionEls := root.SelectElements("Assertion")
+	if len(assertionEls) != 1 {
+		return nil, ErrInvalidResponse.New("Response must contain exactly one assertion")
+	}
+	assertionEl := assertionEls[0]
+	if hasSignature(assertionEl) {
+		assertionEl, err = validator.Validate(assertionEl)
+		if err != nil {
+			return nil, ErrInvalidResponse.Wrap(err, "Assertion signature is invalid")
+		}
+	} else if !responseSigned {
+		return nil, ErrInvalidResponse.New("Neither the response nor the assertion is signed")
+	}
+
+	var assertion xmlAssertion
+	if err := unmarshalElement(assertionEl, &assertion); err != nil {
+		return nil, ErrInvalidResponse.Wrap(err, "Failed to parse assertion")
+	}
+	if err := verifyAssertion(&assertion, exp); err != nil {
+		return nil, err
+	}
+
+	id := &identity{
+		Subject:     assertion.Subject.NameID,
+		Email:       assertion.Subject.NameID,
+		Groups:      assertion.attributeValues(exp.GroupsAttribute),
+		AssertionID: assertion.ID,
+		ExpireAt:    assertion.Conditions.NotOnOrAfter,
+	}
+	if emails := assertion.attributeValues(exp.EmailAttribute); len(emails) > 0 && emails[0] != "" {
+		id.Email = emails[0]
+	}
+	if id.ExpireAt.IsZero() {
+		id.ExpireAt = exp.Now.Add(maxClockSkew)
+	}
+	return id, nil
+}
+
+func verifyAssertion(a *xmlAssertion, exp *expectation) error {
+	if a.ID == "" {
+		return ErrInvalidResponse.New("Assertion ID is missing")
+	}
+	if a.Issuer != exp.IdPEntityID {
+		return ErrInvalidResponse.New("Assertion issuer %s does not match %s", a.Issuer, exp.IdPEntityID)
+	}
+	if a.Subject.NameID == "" {
+		return ErrInvalidResponse.New("Assertion subject is missing")
+	}
+
+	cond := &a.Conditions
+	if !cond.NotBefore.IsZero() && exp.Now.Add(maxClockSkew).Before(cond.NotBefore) {
+		return ErrInvalidResponse.New("Assertion is not yet valid")
+	}
+	if !cond.NotOnOrAfter.IsZero() && !exp.Now.Add(-maxClockSkew).Before(cond.NotOnOrAfter) {
+		return ErrInvalidResponse.New("Assertion is expired")
+	}
+	for _, restriction := range cond.AudienceRestrictions {
+		matched := false
+		for _, audience := range restriction.Audiences {
+			if audience == exp.SPEntityID {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return ErrInvalidResponse.New("Assertion audience does not match %s", exp.SPEntityID)
+		}
+	}
+
+	// At least one bearer confirmation must be satisfied, see SAML Profiles 4.1.4.2.
+	for _, sc := range a.Subject.SubjectConfirmations {
+		if sc.Method != subjectConfirmBearer {
+			continue
+		}
+		if sc.Data.Recipient != exp.ACSURL {
+			continue
+		}
+		if sc.Data.NotOnOrAfter.IsZero() || !exp.Now.Add(-maxClockSkew).Before(sc.Data.NotOnOrAfter) {
+			continue
+		}
+		if sc.Data.InResponseTo != exp.RequestID {
+			continue
+		}
+		return nil
+	}
+	return ErrInvalidResponse.New("Assertion does not contain a valid bearer subject confirmation")
+}
@@ -0,0 +1,278 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package saml
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+	dsig "github.com/russellhaering/goxmldsig"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore/dbstoretest"
+)
+
+func TestT(t *testing.T) {
+	CustomVerboseFlag = true
+	TestingT(t)
+}
+
+var _ = Suite(&testResponseSuite{})
+
+type testResponseSuite struct {
+	idpKey  *rsa.PrivateKey
+	idpCert *x509.Certificate
+	now     time.Time
+}
+
+const (
+	testIdPEntityID = "https://idp.example.com/metadata"
+	testSPEntityID  = "https://dashboard.example.com/dashboard/api/user/saml/metadata"
+	testACSURL      = "https://dashboard.example.com/dashboard/api/user/saml/acs"
+	testRequestID   = "_request1"
+)
+
+func generateKeyPair(c *C, now time.Time) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	return key, cert
+}
+
+func (t *testResponseSuite) SetUpSuite(c *C) {
+	t.now = time.Now().UTC().Truncate(time.Second)
+	t.idpKey, t.idpCert = generateKeyPair(c, t.now)
+}
+
+type assertionOptions struct {
+	assertionID  string
+	audience     string
+	recipient    string
+	notOnOrAfter time.Time
+}
+
+func (t *testResponseSuite) defaultOptions() assertionOptions {
+	return assertionOptions{
+		assertionID:  "_assertion1",
+		audience:     testSPEntityID,
+		recipient:    testACSURL,
+		notOnOrAfter: t.now.Add(5 * time.Minute),
+	}
+}
+
+func (t *testResponseSuite) buildAssertion(opt assertionOptions) *etree.Element {
+	ts := func(tm time.Time) string { return tm.Format(time.RFC3339) }
+	a := etree.NewElement("saml:Assertion")
+	a.CreateAttr("xmlns:saml", "urn:oasis:names:tc:SAML:2.0:assertion")
+	a.CreateAttr("ID", opt.assertionID)
+	a.CreateAttr("Version", "2.0")
+	a.CreateAttr("IssueInstant", ts(t.now))
+	a.CreateElement("saml:Issuer").SetText(testIdPEntityID)
+
+	subject := a.CreateElement("saml:Subject")
+	subject.CreateElement("saml:NameID").SetText("user-001")
+	sc := subject.CreateElement("saml:SubjectConfirmation")
+	sc.CreateAttr("Method", subjectConfirmBearer)
+	scd := sc.CreateElement("saml:SubjectConfirmationData")
+	scd.CreateAttr("Recipient", opt.recipient)
+	scd.CreateAttr("NotOnOrAfter", ts(opt.notOnOrAfter))
+	scd.CreateAttr("InResponseTo", testRequestID)
+
+	cond := a.CreateElement("saml:Conditions")
+	cond.CreateAttr("NotBefore", ts(t.now.Add(-time.Minute)))
+	cond.CreateAttr("NotOnOrAfter", ts(opt.notOnOrAfter))
+	cond.CreateElement("saml:AudienceRestriction").CreateElement("saml:Audience").SetText(opt.audience)
+
+	attrs := a.CreateElement("saml:AttributeStatement")
+	email := attrs.CreateElement("saml:Attribute")
+	email.CreateAttr("Name", "mail")
+	email.CreateElement("saml:AttributeValue").SetText("alice@example.com")
+	groups := attrs.CreateElement("saml:Attribute")
+	groups.CreateAttr("Name", "memberOf")
+	groups.CreateElement("saml:AttributeValue").SetText("dba")
+	groups.CreateElement("saml:AttributeValue").SetText("dev")
+	return a
+}
+
+func (t *testResponseSuite) sign(c *C, el *etree.Element) *etree.Element {
+	ctx := dsig.NewDefaultSigningContext(dsig.TLSCertKeyStore(tls.Certificate{
+		Certificate: [][]byte{t.idpCert.Raw},
+		PrivateKey:  t.idpKey,
+	}))
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	signed, err := ctx.SignEnveloped(el)
+	c.Assert(err, IsNil)
+	return signed
+}
+
+func (t *testResponseSuite) buildResponse(assertion *etree.Element) *etree.Element {
+	r := etree.NewElement("samlp:Response")
+	r.CreateAttr("xmlns:samlp", "urn:oasis:names:tc:SAML:2.0:protocol")
+	r.CreateAttr("ID", "_response1")
+	r.CreateAttr("Version", "2.0")
+	r.CreateAttr("Destination", testACSURL)
+	r.CreateAttr("InResponseTo", testRequestID)
+	r.CreateElement("samlp:Status").
+		CreateElement("samlp:StatusCode").
+		CreateAttr("Value", statusSuccess)
+	r.AddChild(assertion)
+	return r
+}
+
+func encode(c *C, el *etree.Element) string {
+	doc := etree.NewDocument()
+	doc.SetRoot(el)
+	b, err := doc.WriteToBytes()
+	c.Assert(err, IsNil)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func (t *testResponseSuite) expectation() *expectation {
+	return &expectation{
+		IdPEntityID:     testIdPEntityID,
+		IdPCertificate:  t.idpCert,
+		SPEntityID:      testSPEntityID,
+		ACSURL:          testACSURL,
+		RequestID:       testRequestID,
+		EmailAttribute:  "mail",
+		GroupsAttribute: "memberOf",
+		Now:             t.now,
+	}
+}
+
+func (t *testResponseSuite) Test_signedAssertion(c *C) {
+	resp := t.buildResponse(t.sign(c, t.buildAssertion(t.defaultOptions())))
+	id, err := parseResponse(encode(c, resp), t.expectation())
+	c.Assert(err, IsNil)
+	c.Assert(id.Subject, Equals, "user-001")
+	c.Assert(id.Email, Equals, "alice@example.com")
+	c.Assert(id.Groups, DeepEquals, []string{"dba", "dev"})
+	c.Assert(id.AssertionID, Equals, "_assertion1")
+
+	// NameID is used when the email attribute is not configured
+	exp := t.expectation()
+	exp.EmailAttribute = ""
+	id, err = parseResponse(encode(c, resp), exp)
+	c.Assert(err, IsNil)
+	c.Assert(id.Email, Equals, "user-001")
+}
+
+func (t *testResponseSuite) Test_signedResponse(c *C) {
+	resp := t.sign(c, t.buildResponse(t.buildAssertion(t.defaultOptions())))
+	id, err := parseResponse(encode(c, resp), t.expectation())
+	c.Assert(err, IsNil)
+	c.Assert(id.Email, Equals, "alice@example.com")
+}
+
+func (t *testResponseSuite) Test_invalidResponses(c *C) {
+	assertInvalid := func(encoded string, exp *expectation, msg string) {
+		_, err := parseResponse(encoded, exp)
+		c.Assert(err, NotNil)
+		c.Assert(errorx.IsOfType(err, ErrInvalidResponse), IsTrue)
+		c.Assert(strings.Contains(err.Error(), msg), IsTrue, Commentf("%v", err))
+	}
+
+	// Not signed
+	assertInvalid(encode(c, t.buildResponse(t.buildAssertion(t.defaultOptions()))), t.expectation(), "Neither")
+
+	// Tampered after signing
+	signed := t.sign(c, t.buildAssertion(t.defaultOptions()))
+	signed.FindElement("./Subject/NameID").SetText("admin")
+	assertInvalid(encode(c, t.buildResponse(signed)), t.expectation(), "signature is invalid")
+
+	// Signed by an unknown IdP
+	_, otherCert := generateKeyPair(c, t.now)
+	exp := t.expectation()
+	exp.IdPCertificate = otherCert
+	resp := t.buildResponse(t.sign(c, t.buildAssertion(t.defaultOptions())))
+	assertInvalid(encode(c, resp), exp, "signature is invalid")
+
+	// Wrong audience
+	opt := t.defaultOptions()
+	opt.audience = "https://other.example.com"
+	assertInvalid(encode(c, t.buildResponse(t.sign(c, t.buildAssertion(opt)))), t.expectation(), "audience")
+
+	// Wrong recipient
+	opt = t.defaultOptions()
+	opt.recipient = "https://other.example.com/acs"
+	assertInvalid(encode(c, t.buildResponse(t.sign(c, t.buildAssertion(opt)))), t.expectation(), "subject confirmation")
+
+	// Expired
+	opt = t.defaultOptions()
+	opt.notOnOrAfter = t.now.Add(-10 * time.Minute)
+	assertInvalid(encode(c, t.buildResponse(t.sign(c, t.buildAssertion(opt)))), t.expectation(), "expired")
+
+	// Not for the current request
+	exp = t.expectation()
+	exp.RequestID = "_request2"
+	resp = t.buildResponse(t.sign(c, t.buildAssertion(t.defaultOptions())))
+	assertInvalid(encode(c, resp), exp, "current sign in request")
+
+	// Wrong issuer
+	exp = t.expectation()
+	exp.IdPEntityID = "https://other-idp.example.com"
+	assertInvalid(encode(c, resp), exp, "issuer")
+
+	assertInvalid("not base64!", t.expectation(), "base64")
+}
+
+func (t *testResponseSuite) Test_consumeResponse_replay(c *C) {
+	dataDir := c.MkDir()
+	s, err := newService(ServiceParams{LocalStore: dbstoretest.OpenDB(c, dataDir)})
+	c.Assert(err, IsNil)
+	expireAt := t.now.Add(time.Minute)
+	fresh, err := markAssertionConsumed(s.params.LocalStore, "_a", expireAt, t.now)
+	c.Assert(err, IsNil)
+	c.Assert(fresh, IsTrue)
+	fresh, err = markAssertionConsumed(s.params.LocalStore, "_a", expireAt, t.now)
+	c.Assert(err, IsNil)
+	c.Assert(fresh, IsFalse)
+
+	// Consumed assertions survive restarts, until they expire
+	s, err = newService(ServiceParams{LocalStore: dbstoretest.OpenDB(c, dataDir)})
+	c.Assert(err, IsNil)
+	fresh, err = markAssertionConsumed(s.params.LocalStore, "_a", expireAt, t.now)
+	c.Assert(err, IsNil)
+	c.Assert(fresh, IsFalse)
+	fresh, err = markAssertionConsumed(s.params.LocalStore, "_b", expireAt, t.now.Add(time.Hour))
+	c.Assert(err, IsNil)
+	c.Assert(fresh, IsTrue)
+	var count int64
+	c.Assert(s.params.LocalStore.Model(&SAMLConsumedAssertionModel{}).Count(&count).Error, IsNil)
+	c.Assert(count, Equals, int64(1))
+
+	_, err = s.takePendingRequest("unknown")
+	c.Assert(errorx.IsOfType(err, ErrInvalidRelayState), IsTrue)
+	_, err = s.NewSessionFromLoginCode("unknown")
+	c.Assert(errorx.IsOfType(err, ErrSignInInvalidCode), IsTrue)
+}
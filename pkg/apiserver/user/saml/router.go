@@ -0,0 +1,165 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package saml
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joomcode/errorx"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+)
+
+func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
+	endpoint := r.Group("/user/saml")
+	endpoint.GET("/auth_url", s.getAuthURLHandler)
+	endpoint.GET("/metadata", s.metadataHandler)
+	endpoint.POST("/acs", s.acsHandler)
+	endpoint.GET("/config", auth.MWAuthRequired(), s.getConfig)
+	endpoint.PUT("/config", auth.MWAuthRequired(), auth.MWRequirePermission(utils.PermUserManage), s.setConfig)
+}
+
+type GetAuthURLRequest struct {
+	// The Dashboard page to be redirected after the IdP posts back, with `saml_code` or `saml_error` in the query.
+	RedirectURL string `json:"redirect_url" form:"redirect_url"`
+}
+
+// @ID userSAMLGetAuthURL
+// @Summary Get SAML Auth URL
+// @Param q query GetAuthURLRequest true "Query"
+// @Success 200 {string} string
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Router /user/saml/auth_url [get]
+func (s *Service) getAuthURLHandler(c *gin.Context) {
+	var req GetAuthURLRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	authURL, err := s.buildAuthURL(req.RedirectURL)
+	if err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrInvalidRedirectURL) {
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+	c.String(http.StatusOK, authURL)
+}
+
+// @ID userSAMLGetMetadata
+// @Summary Get SAML service provider metadata
+// @Produce application/xml
+// @Success 200 {string} string
+// @Router /user/saml/metadata [get]
+func (s *Service) metadataHandler(c *gin.Context) {
+	md, err := s.buildMetadata()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.Data(http.StatusOK, "application/samlmetadata+xml", md)
+}
+
+func redirectWithQuery(c *gin.Context, redirectURL string, key string, value string) {
+	u, err := url.Parse(redirectURL)
+	if err != nil {
+		_ = c.Error(ErrInvalidRedirectURL.Wrap(err, "Invalid redirect URL"))
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	c.Redirect(http.StatusSeeOther, u.String())
+}
+
+// @ID userSAMLAssertionConsumerService
+// @Summary SAML assertion consumer service, which receives responses from the IdP via HTTP-POST binding
+// @Accept x-www-form-urlencoded
+// @Param SAMLResponse formData string true "SAML response"
+// @Param RelayState formData string true "Relay state"
+// @Success 303 {string} string
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Router /user/saml/acs [post]
+func (s *Service) acsHandler(c *gin.Context) {
+	req, err := s.takePendingRequest(c.PostForm("RelayState"))
+	if err != nil {
+		_ = c.Error(err)
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	code, err := s.consumeResponse(req, c.PostForm("SAMLResponse"))
+	if err != nil {
+		log.Warn("Rejected SAML response", zap.Error(err))
+		redirectWithQuery(c, req.RedirectURL, "saml_error", err.Error())
+		return
+	}
+	redirectWithQuery(c, req.RedirectURL, "saml_code", code)
+}
+
+// @ID userSAMLGetConfig
+// @Summary Get SAML config
+// @Success 200 {object} config.SAMLConfig
+// @Router /user/saml/config [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 500 {object} utils.APIError
+func (s *Service) getConfig(c *gin.Context) {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, dc.SAML)
+}
+
+type SetConfigRequest struct {
+	Config config.SAMLConfig `json:"config"`
+}
+
+// @ID userSAMLSetConfig
+// @Summary Set SAML config
+// @Param request body SetConfigRequest true "Request body"
+// @Success 200 {object} config.SAMLConfig
+// @Router /user/saml/config [put]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 500 {object} utils.APIError "Internal error"
+func (s *Service) setConfig(c *gin.Context) {
+	var req SetConfigRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	var opt config.DynamicConfigOption = func(dc *config.DynamicConfig) {
+		dc.SAML = req.Config
+	}
+	if err := s.params.ConfigManager.Modify(opt); err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, config.ErrVerificationFailed) {
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, req.Config)
+}
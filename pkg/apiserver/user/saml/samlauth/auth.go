@@ -0,0 +1,51 @@
+package samlauth
+
+import (
+	"encoding/json"
+
+	"go.uber.org/fx"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/saml"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+)
+
+const typeID utils.AuthType = 5
+
+type Authenticator struct {
+	user.BaseAuthenticator
+	samlService *saml.Service
+}
+
+func newAuthenticator(samlService *saml.Service) *Authenticator {
+	return &Authenticator{
+		samlService: samlService,
+	}
+}
+
+func registerAuthenticator(a *Authenticator, authService *user.AuthService) {
+	authService.RegisterAuthenticator(typeID, a)
+}
+
+var Module = fx.Options(
+	fx.Provide(newAuthenticator),
+	fx.Invoke(registerAuthenticator),
+)
+
+type SAMLExtra struct {
+	// The one-time login code passed to the redirect URL by the assertion consumer service.
+	Code string `json:"code"`
+}
+
+func (a *Authenticator) Authenticate(f user.AuthenticateForm) (*utils.SessionUser, error) {
+	var extra SAMLExtra
+	err := json.Unmarshal([]byte(f.Extra), &extra)
+	if err != nil {
+		return nil, utils.ErrInvalidRequest.Wrap(err, "Invalid extra payload")
+	}
+	return a.samlService.NewSessionFromLoginCode(extra.Code)
+}
+
+func (a *Authenticator) IsEnabled() (bool, error) {
+	return a.samlService.IsEnabled()
+}
@@ -0,0 +1,338 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joomcode/errorx"
+	"go.uber.org/fx"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user/sso"
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+var (
+	ErrNS                 = errorx.NewNamespace("error.api.user.saml")
+	ErrBadConfig          = ErrNS.NewType("bad_config")
+	ErrInvalidResponse    = ErrNS.NewType("invalid_response")
+	ErrInvalidRelayState  = ErrNS.NewType("invalid_relay_state")
+	ErrInvalidRedirectURL = ErrNS.NewType("invalid_redirect_url")
+	ErrSignInInvalidCode  = user.ErrNSSignIn.NewType("invalid_saml_code")
+	ErrReplayedAssertion  = ErrNS.NewType("replayed_assertion")
+)
+
+const (
+	// Sign in requests must be completed at the IdP within this duration.
+	pendingRequestTTL = 10 * time.Minute
+	// The login code must be exchanged for a session within this duration after the IdP posts back.
+	loginCodeTTL = time.Minute
+
+	acsPath      = "/api/user/saml/acs"
+	metadataPath = "/api/user/saml/metadata"
+)
+
+type ServiceParams struct {
+	fx.In
+	SSOService    *sso.Service
+	ConfigManager *config.DynamicConfigManager
+	LocalStore    *dbstore.DB
+}
+
+type pendingRequest struct {
+	RequestID   string
+	RedirectURL string
+	ExpireAt    time.Time
+}
+
+type loginCode struct {
+	Identity *identity
+	ExpireAt time.Time
+}
+
+// Service is a SAML 2.0 service provider.
+//
+// Sign in requests and login codes live for minutes only, so they are kept in memory and sign in must be completed
+// within the same Dashboard instance. They are lost after a restart, which only fails the sign in in progress.
+// Replaying a response to another instance or after a restart is still rejected, since the response must be in
+// response to a sign in request of this instance. Consumed assertions are additionally persisted in the local store
+// until they expire.
+type Service struct {
+	params ServiceParams
+
+	mu         sync.Mutex
+	pending    map[string]*pendingRequest // Keyed by the relay state
+	loginCodes map[string]*loginCode
+}
+
+func newService(p ServiceParams) (*Service, error) {
+	if err := autoMigrate(p.LocalStore); err != nil {
+		return nil, err
+	}
+	return &Service{
+		params:     p,
+		pending:    make(map[string]*pendingRequest),
+		loginCodes: make(map[string]*loginCode),
+	}, nil
+}
+
+var Module = fx.Options(
+	fx.Provide(newService),
+	fx.Invoke(registerRouter),
+)
+
+func randomID() string {
+	b := make([]byte, 20)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func spEntityID(cfg *config.SAMLConfig) string {
+	return strings.TrimSuffix(cfg.PublicURL, "/") + metadataPath
+}
+
+func acsURL(cfg *config.SAMLConfig) string {
+	return strings.TrimSuffix(cfg.PublicURL, "/") + acsPath
+}
+
+func (s *Service) getEnabledConfig() (*config.SAMLConfig, error) {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		return nil, err
+	}
+	if !dc.SAML.Enabled {
+		return nil, ErrBadConfig.New("SAML is not enabled")
+	}
+	return &dc.SAML, nil
+}
+
+func (s *Service) IsEnabled() (bool, error) {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		return false, err
+	}
+	return dc.SAML.Enabled, nil
+}
+
+// gcLocked removes expired entries. The lock must be held.
+func (s *Service) gcLocked(now time.Time) {
+	for k, v := range s.pending {
+		if now.After(v.ExpireAt) {
+			delete(s.pending, k)
+		}
+	}
+	for k, v := range s.loginCodes {
+		if now.After(v.ExpireAt) {
+			delete(s.loginCodes, k)
+		}
+	}
+}
+
+// verifyRedirectURL ensures that browsers are only redirected to Dashboard itself after sign in.
+func verifyRedirectURL(cfg *config.SAMLConfig, redirectURL string) error {
+	target, err := url.Parse(redirectURL)
+	if err != nil {
+		return ErrInvalidRedirectURL.Wrap(err, "Invalid redirect URL")
+	}
+	public, err := url.Parse(cfg.PublicURL)
+	if err != nil {
+		return ErrBadConfig.Wrap(err, "Invalid public URL")
+	}
+	if target.Scheme != public.Scheme || target.Host != public.Host {
+		return ErrInvalidRedirectURL.New("Redirect URL must be within %s://%s", public.Scheme, public.Host)
+	}
+	return nil
+}
+
+type xmlAuthnRequest struct {
+	XMLName                     xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string   `xml:"ID,attr"`
+	Version                     string   `xml:"Version,attr"`
+	IssueInstant                string   `xml:"IssueInstant,attr"`
+	Destination                 string   `xml:"Destination,attr"`
+	ProtocolBinding             string   `xml:"ProtocolBinding,attr"`
+	AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+	Issuer                      struct {
+		XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+		Value   string   `xml:",chardata"`
+	}
+}
+
+// buildAuthURL builds the URL of the IdP to be redirected by the browser, using HTTP-Redirect binding.
+func (s *Service) buildAuthURL(redirectURL string) (string, error) {
+	cfg, err := s.getEnabledConfig()
+	if err != nil {
+		return "", err
+	}
+	if err := verifyRedirectURL(cfg, redirectURL); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	req := xmlAuthnRequest{
+		// ID must start with a letter or underscore.
+		ID:                          "_" + randomID(),
+		Version:                     "2.0",
+		IssueInstant:                now.UTC().Format(time.RFC3339),
+		Destination:                 cfg.IdPSSOURL,
+		ProtocolBinding:             "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST",
+		AssertionConsumerServiceURL: acsURL(cfg),
+	}
+	req.Issuer.Value = spEntityID(cfg)
+	reqXML, err := xml.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	_, _ = w.Write(reqXML)
+	_ = w.Close()
+
+	u, err := url.Parse(cfg.IdPSSOURL)
+	if err != nil {
+		return "", ErrBadConfig.Wrap(err, "Invalid IdP SSO URL")
+	}
+	relayState := randomID()
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(buf.Bytes()))
+	q.Set("RelayState", relayState)
+	u.RawQuery = q.Encode()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gcLocked(now)
+	s.pending[relayState] = &pendingRequest{
+		RequestID:   req.ID,
+		RedirectURL: redirectURL,
+		ExpireAt:    now.Add(pendingRequestTTL),
+	}
+	return u.String(), nil
+}
+
+// takePendingRequest returns and removes the sign in request of the relay state, so that it can only be used once.
+func (s *Service) takePendingRequest(relayState string) (*pendingRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gcLocked(time.Now())
+	req, ok := s.pending[relayState]
+	if !ok {
+		return nil, ErrInvalidRelayState.New("Sign in request is unknown or expired")
+	}
+	delete(s.pending, relayState)
+	return req, nil
+}
+
+// consumeResponse validates the response posted by the IdP and returns a one-time login code, which can be exchanged
+// for a session via the sign in API.
+func (s *Service) consumeResponse(req *pendingRequest, samlResponse string) (string, error) {
+	cfg, err := s.getEnabledConfig()
+	if err != nil {
+		return "", err
+	}
+	cert, err := parseCertificate(cfg.IdPCertificate)
+	if err != nil {
+		return "", ErrBadConfig.Wrap(err, "Invalid IdP certificate")
+	}
+	now := time.Now()
+	id, err := parseResponse(samlResponse, &expectation{
+		IdPEntityID:     cfg.IdPEntityID,
+		IdPCertificate:  cert,
+		SPEntityID:      spEntityID(cfg),
+		ACSURL:          acsURL(cfg),
+		RequestID:       req.RequestID,
+		EmailAttribute:  cfg.EmailAttribute,
+		GroupsAttribute: cfg.GroupsAttribute,
+		Now:             now,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	fresh, err := markAssertionConsumed(s.params.LocalStore, id.AssertionID, id.ExpireAt, now)
+	if err != nil {
+		return "", err
+	}
+	if !fresh {
+		return "", ErrReplayedAssertion.New("Assertion %s has been used", id.AssertionID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gcLocked(now)
+	code := randomID()
+	s.loginCodes[code] = &loginCode{
+		Identity: id,
+		ExpireAt: now.Add(loginCodeTTL),
+	}
+	return code, nil
+}
+
+func (s *Service) NewSessionFromLoginCode(code string) (*utils.SessionUser, error) {
+	s.mu.Lock()
+	c, ok := s.loginCodes[code]
+	delete(s.loginCodes, code)
+	s.mu.Unlock()
+	if !ok || time.Now().After(c.ExpireAt) {
+		return nil, ErrSignInInvalidCode.New("Login code is invalid or expired")
+	}
+	// The email attribute is released from the directory of the identity provider in the signed assertion, which is
+	// not editable by users, so that it is treated as verified
+	return s.params.SSOService.NewSessionFromExternalIdentity(c.Identity.Subject, c.Identity.Email, true, c.Identity.Groups)
+}
+
+type xmlEntityDescriptor struct {
+	XMLName         xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string   `xml:"entityID,attr"`
+	SPSSODescriptor struct {
+		AuthnRequestsSigned        bool   `xml:"AuthnRequestsSigned,attr"`
+		WantAssertionsSigned       bool   `xml:"WantAssertionsSigned,attr"`
+		ProtocolSupportEnumeration string `xml:"protocolSupportEnumeration,attr"`
+		AssertionConsumerService   struct {
+			Binding  string `xml:"Binding,attr"`
+			Location string `xml:"Location,attr"`
+			Index    int    `xml:"index,attr"`
+		}
+	}
+}
+
+func (s *Service) buildMetadata() ([]byte, error) {
+	cfg, err := s.getEnabledConfig()
+	if err != nil {
+		return nil, err
+	}
+	var md xmlEntityDescriptor
+	md.EntityID = spEntityID(cfg)
+	md.SPSSODescriptor.WantAssertionsSigned = true
+	md.SPSSODescriptor.ProtocolSupportEnumeration = "urn:oasis:names:tc:SAML:2.0:protocol"
+	md.SPSSODescriptor.AssertionConsumerService.Binding = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	md.SPSSODescriptor.AssertionConsumerService.Location = acsURL(cfg)
+	b, err := xml.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
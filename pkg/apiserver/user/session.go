@@ -0,0 +1,492 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gtank/cryptopasta"
+	"github.com/joomcode/errorx"
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+var (
+	ErrSessionNotFound       = ErrNS.NewType("session_not_found")
+	ErrInvalidRefreshToken   = ErrNS.NewType("invalid_refresh_token")
+	ErrSessionCreationFailed = ErrNS.NewType("session_creation_failed")
+)
+
+const (
+	// The key that attached the refresh token of a new session in the gin Context.
+	refreshTokenKey = "refresh_token"
+
+	// Last active time is only updated when it is older than this interval, to avoid writing on every request.
+	sessionTouchInterval = 30 * time.Second
+
+	// Expired sessions are kept for a while so that they can still be listed for investigation.
+	expiredSessionRetention = 7 * 24 * time.Hour
+)
+
+type SessionModel struct { //nolint
+	ID string `gorm:"primary_key;size:40" json:"id"`
+	// Identifies the user of the session, see utils.OwnerKey. Sessions signed in via sharing codes are owned by the
+	// user sharing them.
+	Owner string `gorm:"size:512;index" json:"owner"`
+	// Only for presentation, since display names are not unique across authentication types.
+	DisplayName  string         `gorm:"size:256" json:"display_name"`
+	AuthType     utils.AuthType `json:"auth_type"`
+	ClientIP     string         `gorm:"size:64" json:"client_ip"`
+	UserAgent    string         `gorm:"size:512" json:"user_agent"`
+	CreatedAt    time.Time      `json:"created_at"`
+	LastActiveAt time.Time      `json:"last_active_at"`
+	// Sessions are expired at this time regardless of activities.
+	ExpireAt  time.Time  `gorm:"index" json:"expire_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	// SHA256 of the current refresh token. Refresh tokens are rotated on every refresh.
+	RefreshTokenHash string `gorm:"size:64;index" json:"-"`
+	// The session content, encrypted by a key derived from the current refresh token.
+	EncryptedSession string `gorm:"type:text" json:"-"`
+}
+
+func (SessionModel) TableName() string {
+	return "user_sessions"
+}
+
+func (m *SessionModel) isActive(now time.Time, cfg *config.SessionConfig) bool {
+	if m.RevokedAt != nil || now.After(m.ExpireAt) {
+		return false
+	}
+	if cfg.IdleTimeoutSecs > 0 && now.Sub(m.LastActiveAt) > time.Duration(cfg.IdleTimeoutSecs)*time.Second {
+		return false
+	}
+	return true
+}
+
+func autoMigrate(db *dbstore.DB) error {
+	return db.AutoMigrate(&SessionModel{}, &LoginAttemptModel{})
+}
+
+func (s *AuthService) sessionConfig() *config.SessionConfig {
+	dc, err := s.params.ConfigManager.Get()
+	if err != nil {
+		// Dynamic config is not loaded yet.
+		return &config.SessionConfig{AbsoluteTimeoutSecs: config.DefaultSessionAbsoluteTimeoutSecs}
+	}
+	return &dc.Session
+}
+
+func hashRefreshToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// sealSession encrypts the session by a key derived from the refresh token, so that the TiDB credential in the
+// session can only be recovered by the holder of the refresh token.
+func sealSession(u *utils.SessionUser, refreshToken string) (string, error) {
+	plain, err := json.Marshal(u)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, []byte(refreshToken))
+	_, _ = mac.Write([]byte("dashboard session"))
+	var key [32]byte
+	copy(key[:], mac.Sum(nil))
+	encrypted, err := cryptopasta.Encrypt(plain, &key)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+func unsealSession(sealed string, refreshToken string) (*utils.SessionUser, error) {
+	encrypted, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, []byte(refreshToken))
+	_, _ = mac.Write([]byte("dashboard session"))
+	var key [32]byte
+	copy(key[:], mac.Sum(nil))
+	plain, err := cryptopasta.Decrypt(encrypted, &key)
+	if err != nil {
+		return nil, err
+	}
+	var u utils.SessionUser
+	if err := json.Unmarshal(plain, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// sessionOwner returns the owner of the session in the registry, so that sessions signed in via sharing codes are
+// listed and revoked together with sessions of the user sharing them.
+func sessionOwner(u *utils.SessionUser) string {
+	if u.SharedBy != "" {
+		return u.SharedBy
+	}
+	return u.Owner
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// createSession registers a new session for the signed in user and returns the refresh token of the session.
+func (s *AuthService) createSession(c *gin.Context, u *utils.SessionUser) (string, error) {
+	now := time.Now()
+	cfg := s.sessionConfig()
+
+	u.SessionID = uuid.New().String()
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return "", ErrSessionCreationFailed.WrapWithNoMessage(err)
+	}
+	sealed, err := sealSession(u, refreshToken)
+	if err != nil {
+		return "", ErrSessionCreationFailed.WrapWithNoMessage(err)
+	}
+
+	rec := &SessionModel{
+		ID:               u.SessionID,
+		Owner:            sessionOwner(u),
+		DisplayName:      u.DisplayName,
+		AuthType:         u.AuthFrom,
+		ClientIP:         c.ClientIP(),
+		UserAgent:        c.Request.UserAgent(),
+		CreatedAt:        now,
+		LastActiveAt:     now,
+		ExpireAt:         now.Add(time.Duration(cfg.AbsoluteTimeoutSecs) * time.Second),
+		RefreshTokenHash: hashRefreshToken(refreshToken),
+		EncryptedSession: sealed,
+	}
+	if err := s.params.LocalStore.Create(rec).Error; err != nil {
+		return "", ErrSessionCreationFailed.WrapWithNoMessage(err)
+	}
+
+	// Opportunistically remove sessions that are expired for a long time.
+	_ = s.params.LocalStore.
+		Where("expire_at < ?", now.Add(-expiredSessionRetention)).
+		Delete(&SessionModel{}).
+		Error
+
+	return refreshToken, nil
+}
+
+// verifySession checks whether the session is still active and records the activity.
+func (s *AuthService) verifySession(id string) bool {
+	if id == "" {
+		return false
+	}
+	var rec SessionModel
+	if err := s.params.LocalStore.Where("id = ?", id).First(&rec).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Warn("Failed to read session", zap.String("id", id), zap.Error(err))
+		}
+		return false
+	}
+	now := time.Now()
+	if !rec.isActive(now, s.sessionConfig()) {
+		return false
+	}
+	if now.Sub(rec.LastActiveAt) > sessionTouchInterval {
+		_ = s.params.LocalStore.
+			Model(&SessionModel{}).
+			Where("id = ?", id).
+			UpdateColumn("last_active_at", now).
+			Error
+	}
+	return true
+}
+
+func (s *AuthService) listActiveSessions(owner string) ([]SessionModel, error) {
+	var recs []SessionModel
+	db := s.params.LocalStore.Where("revoked_at IS NULL AND expire_at > ?", time.Now())
+	if owner != "" {
+		db = db.Where("owner = ?", owner)
+	}
+	if err := db.Order("last_active_at DESC").Find(&recs).Error; err != nil {
+		return nil, err
+	}
+
+	// Idle timeout depends on the current config, thus it is not filtered in SQL.
+	cfg := s.sessionConfig()
+	now := time.Now()
+	active := make([]SessionModel, 0, len(recs))
+	for _, rec := range recs {
+		if rec.isActive(now, cfg) {
+			active = append(active, rec)
+		}
+	}
+	return active, nil
+}
+
+func (s *AuthService) revokeSession(id string) error {
+	result := s.params.LocalStore.
+		Model(&SessionModel{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound.New("Session %s does not exist", id)
+	}
+	return nil
+}
+
+func (s *AuthService) revokeUserSessions(owner string) (int64, error) {
+	result := s.params.LocalStore.
+		Model(&SessionModel{}).
+		Where("owner = ? AND revoked_at IS NULL", owner).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// refreshSession rotates the refresh token and returns the session user to issue a new token.
+func (s *AuthService) refreshSession(refreshToken string) (*utils.SessionUser, string, error) {
+	var rec SessionModel
+	err := s.params.LocalStore.Where("refresh_token_hash = ?", hashRefreshToken(refreshToken)).First(&rec).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrInvalidRefreshToken.NewWithNoMessage()
+		}
+		return nil, "", err
+	}
+	now := time.Now()
+	if !rec.isActive(now, s.sessionConfig()) {
+		return nil, "", ErrInvalidRefreshToken.New("Session is expired or revoked")
+	}
+	u, err := unsealSession(rec.EncryptedSession, refreshToken)
+	if err != nil {
+		return nil, "", ErrInvalidRefreshToken.NewWithNoMessage()
+	}
+	// The schema of the session may be changed after upgrade.
+	if u.Version != utils.SessionVersion {
+		return nil, "", ErrInvalidRefreshToken.New("Session is outdated")
+	}
+
+	newRefreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+	sealed, err := sealSession(u, newRefreshToken)
+	if err != nil {
+		return nil, "", err
+	}
+	// Only the holder of the current refresh token can rotate it, which prevents reusing a refresh token.
+	result := s.params.LocalStore.
+		Model(&SessionModel{}).
+		Where("id = ? AND refresh_token_hash = ?", rec.ID, rec.RefreshTokenHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash": hashRefreshToken(newRefreshToken),
+			"encrypted_session":  sealed,
+			"last_active_at":     now,
+		})
+	if result.Error != nil {
+		return nil, "", result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, "", ErrInvalidRefreshToken.NewWithNoMessage()
+	}
+	return u, newRefreshToken, nil
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// @ID userRefresh
+// @Summary Obtain a new token using the refresh token, as long as the session is not expired or revoked
+// @Param request body RefreshRequest true "Request body"
+// @Success 200 {object} TokenResponse
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Router /user/refresh [post]
+func (s *AuthService) refreshHandler(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	u, refreshToken, err := s.refreshSession(req.RefreshToken)
+	if err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrInvalidRefreshToken) {
+			c.Status(http.StatusUnauthorized)
+		}
+		return
+	}
+	token, expire, err := s.middleware.TokenGenerator(u)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, TokenResponse{
+		Token:        token,
+		Expire:       expire,
+		RefreshToken: refreshToken,
+	})
+}
+
+// @ID userGetSessionConfig
+// @Summary Get session timeout config
+// @Success 200 {object} config.SessionConfig
+// @Router /user/sessions/config [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+func (s *AuthService) getSessionConfigHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, s.sessionConfig())
+}
+
+// @ID userSetSessionConfig
+// @Summary Set session timeout config
+// @Param request body config.SessionConfig true "Request body"
+// @Success 200 {object} config.SessionConfig
+// @Router /user/sessions/config [put]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 500 {object} utils.APIError "Internal error"
+func (s *AuthService) setSessionConfigHandler(c *gin.Context) {
+	var req config.SessionConfig
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	var opt config.DynamicConfigOption = func(dc *config.DynamicConfig) {
+		dc.Session = req
+	}
+	if err := s.params.ConfigManager.Modify(opt); err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, config.ErrVerificationFailed) {
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+type ListSessionsRequest struct {
+	// The owner of sessions, e.g. the `owner` field of a listed session. Empty to list sessions of all users.
+	Owner string `json:"owner" form:"owner"`
+}
+
+type SessionInfo struct {
+	SessionModel
+	IsCurrent bool `json:"is_current"`
+}
+
+// @ID userListSessions
+// @Summary List active sessions
+// @Param q query ListSessionsRequest true "Query"
+// @Success 200 {array} SessionInfo
+// @Router /user/sessions/list [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+func (s *AuthService) listSessionsHandler(c *gin.Context) {
+	var req ListSessionsRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	recs, err := s.listActiveSessions(req.Owner)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	current := utils.GetSession(c).SessionID
+	resp := make([]SessionInfo, 0, len(recs))
+	for _, rec := range recs {
+		resp = append(resp, SessionInfo{
+			SessionModel: rec,
+			IsCurrent:    rec.ID == current,
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// @ID userRevokeSession
+// @Summary Force sign out a session
+// @Param id path string true "Session ID"
+// @Success 200 {string} string
+// @Router /user/sessions/{id} [delete]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 404 {object} utils.APIError "Session not found"
+func (s *AuthService) revokeSessionHandler(c *gin.Context) {
+	if err := s.revokeSession(c.Param("id")); err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrSessionNotFound) {
+			c.Status(http.StatusNotFound)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, "success")
+}
+
+type RevokeUserSessionsRequest struct {
+	// The owner of sessions, e.g. the `owner` field of a listed session.
+	Owner string `json:"owner" binding:"required"`
+}
+
+type RevokeUserSessionsResponse struct {
+	Revoked int64 `json:"revoked"`
+}
+
+// @ID userRevokeUserSessions
+// @Summary Force sign out all sessions of a user
+// @Description Sessions signed in via sharing codes of the user are also signed out.
+// @Param request body RevokeUserSessionsRequest true "Request body"
+// @Success 200 {object} RevokeUserSessionsResponse
+// @Router /user/sessions/revoke_user [post]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+func (s *AuthService) revokeUserSessionsHandler(c *gin.Context) {
+	var req RevokeUserSessionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	n, err := s.revokeUserSessions(req.Owner)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, RevokeUserSessionsResponse{Revoked: n})
+}
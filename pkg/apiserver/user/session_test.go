@@ -0,0 +1,147 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package user
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore/dbstoretest"
+)
+
+var _ = Suite(&testSessionSuite{})
+
+type testSessionSuite struct{}
+
+func (t *testSessionSuite) SetUpSuite(c *C) {
+	gin.SetMode(gin.TestMode)
+}
+
+func (t *testSessionSuite) newService(c *C) *AuthService {
+	db := dbstoretest.NewDB(c, autoMigrate)
+	// The dynamic config is never loaded, so that default session config is used.
+	return &AuthService{params: ServiceParams{LocalStore: db, ConfigManager: &config.DynamicConfigManager{}}}
+}
+
+func newTestContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest(http.MethodPost, "/user/login", nil)
+	c.Request.RemoteAddr = "10.0.0.1:34567"
+	return c
+}
+
+func (t *testSessionSuite) Test_createAndVerify(c *C) {
+	s := t.newService(c)
+	u := &utils.SessionUser{Version: utils.SessionVersion, DisplayName: "alice", Owner: "0:alice"}
+	refreshToken, err := s.createSession(newTestContext(), u)
+	c.Assert(err, IsNil)
+	c.Assert(refreshToken, Not(Equals), "")
+	c.Assert(u.SessionID, Not(Equals), "")
+
+	c.Assert(s.verifySession(u.SessionID), IsTrue)
+	c.Assert(s.verifySession(""), IsFalse)
+	c.Assert(s.verifySession("not-exist"), IsFalse)
+
+	sessions, err := s.listActiveSessions("0:alice")
+	c.Assert(err, IsNil)
+	c.Assert(sessions, HasLen, 1)
+	c.Assert(sessions[0].ID, Equals, u.SessionID)
+	c.Assert(sessions[0].DisplayName, Equals, "alice")
+
+	c.Assert(s.revokeSession(u.SessionID), IsNil)
+	c.Assert(s.verifySession(u.SessionID), IsFalse)
+	// Revoking twice reports not found
+	c.Assert(s.revokeSession(u.SessionID), NotNil)
+
+	sessions, err = s.listActiveSessions("")
+	c.Assert(err, IsNil)
+	c.Assert(sessions, HasLen, 0)
+}
+
+func (t *testSessionSuite) Test_revokeUserSessions(c *C) {
+	s := t.newService(c)
+	users := []*utils.SessionUser{
+		{DisplayName: "root", Owner: "0:root"},
+		{DisplayName: "root", Owner: "0:root"},
+		// Users of other authentication types with the same display name
+		{DisplayName: "root", Owner: "3:cn=root,dc=example,dc=org"},
+		// Shared from the SQL user root
+		{DisplayName: "Shared from root", Owner: "1:share-1", SharedSessionID: "share-1", SharedBy: "0:root"},
+	}
+	for _, u := range users {
+		u.Version = utils.SessionVersion
+		_, err := s.createSession(newTestContext(), u)
+		c.Assert(err, IsNil)
+	}
+
+	sessions, err := s.listActiveSessions("0:root")
+	c.Assert(err, IsNil)
+	c.Assert(sessions, HasLen, 3)
+
+	n, err := s.revokeUserSessions("0:root")
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(3))
+	c.Assert(s.verifySession(users[0].SessionID), IsFalse)
+	c.Assert(s.verifySession(users[1].SessionID), IsFalse)
+	c.Assert(s.verifySession(users[2].SessionID), IsTrue)
+	c.Assert(s.verifySession(users[3].SessionID), IsFalse)
+}
+
+func (t *testSessionSuite) Test_timeouts(c *C) {
+	s := t.newService(c)
+	u := &utils.SessionUser{Version: utils.SessionVersion, DisplayName: "alice"}
+	_, err := s.createSession(newTestContext(), u)
+	c.Assert(err, IsNil)
+
+	var rec SessionModel
+	c.Assert(s.params.LocalStore.Where("id = ?", u.SessionID).First(&rec).Error, IsNil)
+	now := time.Now()
+	c.Assert(rec.isActive(now, &config.SessionConfig{IdleTimeoutSecs: 60}), IsTrue)
+	c.Assert(rec.isActive(now.Add(2*time.Minute), &config.SessionConfig{IdleTimeoutSecs: 60}), IsFalse)
+	c.Assert(rec.isActive(now.Add(2*time.Minute), &config.SessionConfig{}), IsTrue)
+	c.Assert(rec.isActive(rec.ExpireAt.Add(time.Second), &config.SessionConfig{}), IsFalse)
+}
+
+func (t *testSessionSuite) Test_refreshSession(c *C) {
+	s := t.newService(c)
+	u := &utils.SessionUser{
+		Version:      utils.SessionVersion,
+		DisplayName:  "alice",
+		HasTiDBAuth:  true,
+		TiDBUsername: "alice",
+		TiDBPassword: "pass",
+	}
+	refreshToken, err := s.createSession(newTestContext(), u)
+	c.Assert(err, IsNil)
+
+	refreshed, newRefreshToken, err := s.refreshSession(refreshToken)
+	c.Assert(err, IsNil)
+	c.Assert(newRefreshToken, Not(Equals), refreshToken)
+	c.Assert(refreshed.SessionID, Equals, u.SessionID)
+	c.Assert(refreshed.TiDBPassword, Equals, "pass")
+
+	// Refresh tokens are rotated and cannot be reused
+	_, _, err = s.refreshSession(refreshToken)
+	c.Assert(err, NotNil)
+
+	c.Assert(s.revokeSession(u.SessionID), IsNil)
+	_, _, err = s.refreshSession(newRefreshToken)
+	c.Assert(err, NotNil)
+}
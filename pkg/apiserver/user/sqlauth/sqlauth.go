@@ -6,6 +6,7 @@ import (
 
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
+	"github.com/pingcap/tidb-dashboard/pkg/config"
 	"github.com/pingcap/tidb-dashboard/pkg/tidb"
 )
 
@@ -13,12 +14,14 @@ const typeID utils.AuthType = 0
 
 type Authenticator struct {
 	user.BaseAuthenticator
-	tidbClient *tidb.Client
+	tidbClient    *tidb.Client
+	configManager *config.DynamicConfigManager
 }
 
-func newAuthenticator(tidbClient *tidb.Client) *Authenticator {
+func newAuthenticator(tidbClient *tidb.Client, configManager *config.DynamicConfigManager) *Authenticator {
 	return &Authenticator{
-		tidbClient: tidbClient,
+		tidbClient:    tidbClient,
+		configManager: configManager,
 	}
 }
 
@@ -32,7 +35,7 @@ var Module = fx.Options(
 )
 
 func (a *Authenticator) Authenticate(f user.AuthenticateForm) (*utils.SessionUser, error) {
-	writeable, err := user.VerifySQLUser(a.tidbClient, f.Username, f.Password)
+	privs, err := user.VerifySQLUser(a.tidbClient, f.Username, f.Password)
 	if err != nil {
 		if errorx.Cast(err) == nil {
 			return nil, user.ErrSignInOther.WrapWithNoMessage(err)
@@ -46,13 +49,19 @@ func (a *Authenticator) Authenticate(f user.AuthenticateForm) (*utils.SessionUse
 		return nil, err
 	}
 
+	subjects := user.SQLUserSubjects(f.Username, privs.Roles)
+	perms, err := user.ResolvePermissionsFromConfig(a.configManager, privs.Writeable, subjects)
+	if err != nil {
+		return nil, err
+	}
+
 	return &utils.SessionUser{
 		Version:      utils.SessionVersion,
 		HasTiDBAuth:  true,
 		TiDBUsername: f.Username,
 		TiDBPassword: f.Password,
 		DisplayName:  f.Username,
-		IsShareable:  true,
-		IsWriteable:  writeable,
+		Permissions:  perms,
+		Subjects:     subjects,
 	}, nil
 }
@@ -0,0 +1,305 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sso
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/utils/keyring"
+)
+
+var ErrKeyRotationFailed = ErrNS.NewType("key_rotation_failed")
+
+const (
+	// ExternalKeyVersion is the key version of impersonations encrypted by the key specified via environment variables.
+	ExternalKeyVersion = -1
+
+	// The local key of version 1 uses the file name before keys are versioned.
+	legacyKeyFileName = "dbek.bin"
+
+	// The external key can be specified as a hex string, or a file containing either 32 raw bytes or a hex string.
+	externalKeyEnv     = "DASHBOARD_SSO_ENCRYPTION_KEY"
+	externalKeyFileEnv = "DASHBOARD_SSO_ENCRYPTION_KEY_FILE"
+)
+
+var keyFileNameRegexp = regexp.MustCompile(`^dbek\.v(\d+)\.bin$`)
+
+func keyFileName(version int) string {
+	if version == 1 {
+		return legacyKeyFileName
+	}
+	return fmt.Sprintf("dbek.v%d.bin", version)
+}
+
+func decodeKey(b []byte) (*[32]byte, error) {
+	if len(b) != 32 {
+		decoded, err := hex.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil || len(decoded) != 32 {
+			return nil, fmt.Errorf("encryption key must be 32 bytes or 64 hex characters")
+		}
+		b = decoded
+	}
+	var fixedLenKey [32]byte
+	copy(fixedLenKey[:], b)
+	return &fixedLenKey, nil
+}
+
+// loadExternalKey loads the encryption key specified via environment variables. Returns nil if not specified.
+func loadExternalKey() (*[32]byte, error) {
+	if v := os.Getenv(externalKeyEnv); v != "" {
+		key, err := decodeKey([]byte(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", externalKeyEnv, err)
+		}
+		log.Info("SSO encryption key is overridden from env var")
+		return key, nil
+	}
+	if p := os.Getenv(externalKeyFileEnv); p != "" {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", externalKeyFileEnv, err)
+		}
+		key, err := decodeKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", externalKeyFileEnv, err)
+		}
+		log.Info("SSO encryption key is loaded from file", zap.String("path", p))
+		return key, nil
+	}
+	return nil, nil
+}
+
+// localKeyVersions returns versions of local keys in ascending order.
+func (s *Service) localKeyVersions() ([]int, error) {
+	files, err := ioutil.ReadDir(s.encKeyDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var versions []int
+	for _, f := range files {
+		if f.Name() == legacyKeyFileName {
+			versions = append(versions, 1)
+			continue
+		}
+		if m := keyFileNameRegexp.FindStringSubmatch(f.Name()); m != nil {
+			if v, err := strconv.Atoi(m[1]); err == nil && v > 1 {
+				versions = append(versions, v)
+			}
+		}
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+// getEncKey returns the key of the version, or nil if the key does not exist.
+func (s *Service) getEncKey(version int) (*[32]byte, error) {
+	if version == ExternalKeyVersion {
+		return s.externalKey, nil
+	}
+	return keyring.ReadKeyFile(path.Join(s.encKeyDir, keyFileName(version)))
+}
+
+func (s *Service) createLocalEncKey(version int) (*[32]byte, error) {
+	return keyring.CreateKeyFile(path.Join(s.encKeyDir, keyFileName(version)))
+}
+
+// getOrCreateCurrentEncKey returns the key to encrypt new secrets, which is the external key if specified, or the
+// latest local key. This function is thread-safe.
+func (s *Service) getOrCreateCurrentEncKey() (int, *[32]byte, error) {
+	if s.externalKey != nil {
+		return ExternalKeyVersion, s.externalKey, nil
+	}
+
+	s.encKeyLock.Lock()
+	defer s.encKeyLock.Unlock()
+
+	versions, err := s.localKeyVersions()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(versions) > 0 {
+		version := versions[len(versions)-1]
+		key, err := s.getEncKey(version)
+		if err != nil {
+			return 0, nil, err
+		}
+		if key != nil {
+			return version, key, nil
+		}
+	}
+
+	// Try to create a key otherwise
+	key, err := s.createLocalEncKey(1)
+	if err != nil {
+		return 0, nil, err
+	}
+	return 1, key, nil
+}
+
+func (s *Service) encryptSecret(plain string) (int, string, error) {
+	version, key, err := s.getOrCreateCurrentEncKey()
+	if err != nil {
+		return 0, "", err
+	}
+	encrypted, err := keyring.EncryptToHex([]byte(plain), key)
+	if err != nil {
+		return 0, "", err
+	}
+	return version, encrypted, nil
+}
+
+func (s *Service) decryptSecret(version int, encryptedInHex string) (string, error) {
+	key, err := s.getEncKey(version)
+	if err != nil {
+		return "", fmt.Errorf("bad encryption key: %v", err)
+	}
+	if key == nil {
+		return "", fmt.Errorf("encryption key of version %d is missing", version)
+	}
+	decrypted, err := keyring.DecryptHex(encryptedInHex, key)
+	if err != nil {
+		return "", err
+	}
+	return string(decrypted), nil
+}
+
+type EncKeyStatus struct {
+	CurrentVersion int  `json:"current_version"`
+	IsExternal     bool `json:"is_external"`
+	// Number of impersonations encrypted by each key version.
+	ImpersonationsByVersion map[int]int `json:"impersonations_by_version"`
+}
+
+func (s *Service) getEncKeyStatus() (*EncKeyStatus, error) {
+	status := &EncKeyStatus{
+		IsExternal:              s.externalKey != nil,
+		ImpersonationsByVersion: map[int]int{},
+	}
+	if s.externalKey != nil {
+		status.CurrentVersion = ExternalKeyVersion
+	} else {
+		versions, err := s.localKeyVersions()
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) > 0 {
+			status.CurrentVersion = versions[len(versions)-1]
+		}
+	}
+	var imps []SSOImpersonationModel
+	if err := s.params.LocalStore.Find(&imps).Error; err != nil {
+		return nil, err
+	}
+	for _, imp := range imps {
+		status.ImpersonationsByVersion[imp.KeyVersion]++
+	}
+	return status, nil
+}
+
+// rotateEncKey re-encrypts all impersonations by a new local key, or by the external key if it is specified.
+// Records are updated atomically, and local keys that are no longer used are removed afterwards.
+func (s *Service) rotateEncKey() (*EncKeyStatus, error) {
+	s.createImpersonationLock.Lock()
+	defer s.createImpersonationLock.Unlock()
+	s.encKeyLock.Lock()
+	defer s.encKeyLock.Unlock()
+
+	var imps []SSOImpersonationModel
+	if err := s.params.LocalStore.Find(&imps).Error; err != nil {
+		return nil, err
+	}
+	plains := make([]string, len(imps))
+	for i, imp := range imps {
+		plain, err := s.decryptSecret(imp.KeyVersion, imp.EncryptedPass)
+		if err != nil {
+			return nil, ErrKeyRotationFailed.Wrap(err, "Failed to decrypt the impersonation of %s", imp.SQLUser)
+		}
+		plains[i] = plain
+	}
+
+	oldLocalVersions, err := s.localKeyVersions()
+	if err != nil {
+		return nil, err
+	}
+	newVersion := ExternalKeyVersion
+	newKey := s.externalKey
+	if newKey == nil {
+		newVersion = 1
+		if len(oldLocalVersions) > 0 {
+			newVersion = oldLocalVersions[len(oldLocalVersions)-1] + 1
+		}
+		newKey, err = s.createLocalEncKey(newVersion)
+		if err != nil {
+			return nil, ErrKeyRotationFailed.Wrap(err, "Failed to create a new key")
+		}
+	}
+
+	err = s.params.LocalStore.Transaction(func(tx *gorm.DB) error {
+		for i, imp := range imps {
+			encrypted, err := keyring.EncryptToHex([]byte(plains[i]), newKey)
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&SSOImpersonationModel{}).
+				Where("sql_user = ?", imp.SQLUser).
+				Updates(map[string]interface{}{
+					"encrypted_pass": encrypted,
+					"key_version":    newVersion,
+				}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if newVersion != ExternalKeyVersion {
+			_ = os.Remove(path.Join(s.encKeyDir, keyFileName(newVersion)))
+		}
+		return nil, ErrKeyRotationFailed.Wrap(err, "Failed to re-encrypt impersonations")
+	}
+
+	for _, version := range oldLocalVersions {
+		p := path.Join(s.encKeyDir, keyFileName(version))
+		if err := os.Remove(p); err != nil {
+			log.Warn("Failed to remove the retired SSO encryption key", zap.String("path", p), zap.Error(err))
+		}
+	}
+	log.Info("SSO encryption key is rotated", zap.Int("version", newVersion), zap.Int("impersonations", len(imps)))
+
+	status := &EncKeyStatus{
+		CurrentVersion:          newVersion,
+		IsExternal:              newVersion == ExternalKeyVersion,
+		ImpersonationsByVersion: map[int]int{},
+	}
+	if len(imps) > 0 {
+		status.ImpersonationsByVersion[newVersion] = len(imps)
+	}
+	return status, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sso
+
+import (
+	"encoding/hex"
+	"os"
+	"path"
+
+	"github.com/gtank/cryptopasta"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore/dbstoretest"
+)
+
+var _ = Suite(&testKeyringSuite{})
+
+type testKeyringSuite struct {
+	dir string
+}
+
+func (t *testKeyringSuite) SetUpTest(c *C) {
+	t.dir = c.MkDir()
+}
+
+// newService creates a service on the data directory of the test, so that calling it twice simulates a restart.
+func (t *testKeyringSuite) newService(c *C) *Service {
+	db := dbstoretest.OpenDB(c, t.dir, autoMigrate)
+	return &Service{params: ServiceParams{LocalStore: db}, encKeyDir: t.dir}
+}
+
+func (t *testKeyringSuite) insertImpersonation(c *C, s *Service, sqlUser string, password string) {
+	version, encrypted, err := s.encryptSecret(password)
+	c.Assert(err, IsNil)
+	err = s.params.LocalStore.Create(&SSOImpersonationModel{
+		SQLUser:       sqlUser,
+		EncryptedPass: encrypted,
+		KeyVersion:    version,
+	}).Error
+	c.Assert(err, IsNil)
+}
+
+func (t *testKeyringSuite) assertPassword(c *C, s *Service, sqlUser string, password string) {
+	plain, err := s.getAndDecryptImpersonation(sqlUser)
+	c.Assert(err, IsNil)
+	c.Assert(plain, Equals, password)
+}
+
+func (t *testKeyringSuite) Test_decodeKey(c *C) {
+	key := cryptopasta.NewEncryptionKey()
+	decoded, err := decodeKey(key[:])
+	c.Assert(err, IsNil)
+	c.Assert(*decoded, Equals, *key)
+	decoded, err = decodeKey([]byte(hex.EncodeToString(key[:]) + "\n"))
+	c.Assert(err, IsNil)
+	c.Assert(*decoded, Equals, *key)
+	_, err = decodeKey([]byte("abcd"))
+	c.Assert(err, NotNil)
+}
+
+func (t *testKeyringSuite) Test_rotateLocalKey(c *C) {
+	s := t.newService(c)
+	t.insertImpersonation(c, s, "root", "pass1")
+	t.insertImpersonation(c, s, "dba", "pass2")
+	versions, err := s.localKeyVersions()
+	c.Assert(err, IsNil)
+	c.Assert(versions, DeepEquals, []int{1})
+	_, err = os.Stat(path.Join(t.dir, legacyKeyFileName))
+	c.Assert(err, IsNil)
+
+	status, err := s.rotateEncKey()
+	c.Assert(err, IsNil)
+	c.Assert(status.CurrentVersion, Equals, 2)
+	c.Assert(status.ImpersonationsByVersion, DeepEquals, map[int]int{2: 2})
+	t.assertPassword(c, s, "root", "pass1")
+	t.assertPassword(c, s, "dba", "pass2")
+
+	// The retired key is removed
+	versions, err = s.localKeyVersions()
+	c.Assert(err, IsNil)
+	c.Assert(versions, DeepEquals, []int{2})
+
+	// New impersonations are encrypted by the new key
+	t.insertImpersonation(c, s, "dev", "pass3")
+	status, err = s.getEncKeyStatus()
+	c.Assert(err, IsNil)
+	c.Assert(status.ImpersonationsByVersion, DeepEquals, map[int]int{2: 3})
+}
+
+func (t *testKeyringSuite) Test_rotateToExternalKey(c *C) {
+	s := t.newService(c)
+	t.insertImpersonation(c, s, "root", "pass1")
+
+	s.externalKey = cryptopasta.NewEncryptionKey()
+	status, err := s.rotateEncKey()
+	c.Assert(err, IsNil)
+	c.Assert(status.CurrentVersion, Equals, ExternalKeyVersion)
+	c.Assert(status.IsExternal, IsTrue)
+	t.assertPassword(c, s, "root", "pass1")
+
+	versions, err := s.localKeyVersions()
+	c.Assert(err, IsNil)
+	c.Assert(versions, HasLen, 0)
+}
+
+func (t *testKeyringSuite) Test_rotateWithMissingKey(c *C) {
+	s := t.newService(c)
+	t.insertImpersonation(c, s, "root", "pass1")
+	c.Assert(os.Remove(path.Join(t.dir, legacyKeyFileName)), IsNil)
+
+	_, err := s.rotateEncKey()
+	c.Assert(err, NotNil)
+	// Nothing is changed when rotation fails
+	var imp SSOImpersonationModel
+	c.Assert(s.params.LocalStore.First(&imp).Error, IsNil)
+	c.Assert(imp.KeyVersion, Equals, 1)
+	versions, err := s.localKeyVersions()
+	c.Assert(err, IsNil)
+	c.Assert(versions, HasLen, 0)
+}
@@ -28,7 +28,9 @@ const (
 type SSOImpersonationModel struct { //nolint
 	SQLUser string `gorm:"primary_key;size:128" json:"sql_user"`
 	// The encryption key is placed somewhere else in the FS, to avoid being collected by diagnostics collecting tools.
-	EncryptedPass         string             `gorm:"type:text" json:"-"`
+	EncryptedPass string `gorm:"type:text" json:"-"`
+	// Version of the key encrypting the password, see keyring.go.
+	KeyVersion            int                `gorm:"default:1" json:"key_version"`
 	LastImpersonateStatus *ImpersonateStatus `gorm:"size:32" json:"last_impersonate_status"`
 }
 
@@ -36,6 +38,60 @@ func (SSOImpersonationModel) TableName() string {
 	return "sso_impersonation"
 }
 
+type ImpersonationMatchType string
+
+const (
+	// Matches the domain part of the email claim, e.g. `example.com`. The email must be verified by the identity
+	// provider, i.e. the `email_verified` claim is true.
+	ImpersonationMatchEmailDomain ImpersonationMatchType = "email_domain"
+	// Matches one of the values in the `groups` claim.
+	ImpersonationMatchGroup ImpersonationMatchType = "group"
+	// Matches the `sub` claim.
+	ImpersonationMatchSubject ImpersonationMatchType = "subject"
+	// Matches all users.
+	ImpersonationMatchAny ImpersonationMatchType = "any"
+)
+
+// SSOImpersonationRuleModel maps SSO users to an impersonated SQL user. Rules are evaluated in the order of
+// `Priority` and the first matched rule takes effect.
+type SSOImpersonationRuleModel struct { //nolint
+	ID         uint                   `gorm:"primary_key" json:"id"`
+	Priority   int                    `gorm:"index" json:"priority"`
+	MatchType  ImpersonationMatchType `gorm:"size:32" json:"match_type"`
+	MatchValue string                 `gorm:"size:256" json:"match_value"` // Ignored when match type is `any`
+	SQLUser    string                 `gorm:"size:128" json:"sql_user"`
+	// Forces a read-only session even if the impersonated SQL user is writeable.
+	IsReadOnly bool `json:"is_read_only"`
+}
+
+func (SSOImpersonationRuleModel) TableName() string {
+	return "sso_impersonation_rules"
+}
+
 func autoMigrate(db *dbstore.DB) error {
-	return db.AutoMigrate(&SSOImpersonationModel{})
+	// The rules table is the marker of the migration, so that rules removed by admins are never seeded again
+	hasRules := db.Migrator().HasTable(&SSOImpersonationRuleModel{})
+	if err := db.AutoMigrate(&SSOImpersonationModel{}, &SSOImpersonationRuleModel{}); err != nil {
+		return err
+	}
+	if hasRules {
+		return nil
+	}
+	return seedDefaultRule(db)
+}
+
+// seedDefaultRule creates a catch-all rule when there is only one impersonation, so that SSO users are not locked
+// out after upgrading from versions without rules. It only runs once, when the rules table is created.
+func seedDefaultRule(db *dbstore.DB) error {
+	var imps []SSOImpersonationModel
+	if err := db.Find(&imps).Error; err != nil {
+		return err
+	}
+	if len(imps) != 1 {
+		return nil
+	}
+	return db.Create(&SSOImpersonationRuleModel{
+		MatchType: ImpersonationMatchAny,
+		SQLUser:   imps[0].SQLUser,
+	}).Error
 }
@@ -17,9 +17,14 @@ func registerRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint.Use(auth.MWAuthRequired())
 	// TODO: Forbid modifying config when signed in as SSO.
 	endpoint.GET("/impersonations/list", s.listImpersonationHandler)
-	endpoint.POST("/impersonation", auth.MWRequireWritePriv(), s.createImpersonationHandler)
+	endpoint.POST("/impersonation", auth.MWRequirePermission(utils.PermUserManage), s.createImpersonationHandler)
+	endpoint.DELETE("/impersonation/:sql_user", auth.MWRequirePermission(utils.PermUserManage), s.revokeImpersonationHandler)
+	endpoint.GET("/impersonation_rules/list", s.listRulesHandler)
+	endpoint.PUT("/impersonation_rules", auth.MWRequirePermission(utils.PermUserManage), s.replaceRulesHandler)
+	endpoint.GET("/encryption_key", s.getEncKeyStatusHandler)
+	endpoint.POST("/encryption_key/rotate", auth.MWRequirePermission(utils.PermUserManage), s.rotateEncKeyHandler)
 	endpoint.GET("/config", s.getConfig)
-	endpoint.PUT("/config", auth.MWRequireWritePriv(), s.setConfig)
+	endpoint.PUT("/config", auth.MWRequirePermission(utils.PermUserManage), s.setConfig)
 }
 
 type GetAuthURLRequest struct {
@@ -96,6 +101,104 @@ func (s *Service) createImpersonationHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, rec)
 }
 
+// @ID userSSORevokeImpersonation
+// @Summary Revoke an impersonation. Impersonations used by rules cannot be revoked.
+// @Param sql_user path string true "SQL user"
+// @Success 200 {string} string
+// @Router /user/sso/impersonation/{sql_user} [delete]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 500 {object} utils.APIError "Internal error"
+func (s *Service) revokeImpersonationHandler(c *gin.Context) {
+	if err := s.revokeImpersonation(c.Param("sql_user")); err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrInvalidRule) {
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, "success")
+}
+
+// @ID userSSOListImpersonationRules
+// @Summary List impersonation rules in the order of evaluation
+// @Success 200 {array} SSOImpersonationRuleModel
+// @Router /user/sso/impersonation_rules/list [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+func (s *Service) listRulesHandler(c *gin.Context) {
+	rules, err := s.listRules()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+type ReplaceRulesRequest struct {
+	Rules []ImpersonationRule `json:"rules"`
+}
+
+// @ID userSSOReplaceImpersonationRules
+// @Summary Replace all impersonation rules. Rules are evaluated in the given order and the first matched rule takes effect.
+// @Param request body ReplaceRulesRequest true "Request body"
+// @Success 200 {array} SSOImpersonationRuleModel
+// @Router /user/sso/impersonation_rules [put]
+// @Security JwtAuth
+// @Failure 400 {object} utils.APIError "Bad request"
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 500 {object} utils.APIError "Internal error"
+func (s *Service) replaceRulesHandler(c *gin.Context) {
+	var req ReplaceRulesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.MakeInvalidRequestErrorFromError(c, err)
+		return
+	}
+	rules, err := s.replaceRules(req.Rules)
+	if err != nil {
+		_ = c.Error(err)
+		if errorx.IsOfType(err, ErrInvalidRule) {
+			c.Status(http.StatusBadRequest)
+		}
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// @ID userSSOGetEncryptionKeyStatus
+// @Summary Get the status of the key encrypting impersonations
+// @Success 200 {object} EncKeyStatus
+// @Router /user/sso/encryption_key [get]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 500 {object} utils.APIError "Internal error"
+func (s *Service) getEncKeyStatusHandler(c *gin.Context) {
+	status, err := s.getEncKeyStatus()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// @ID userSSORotateEncryptionKey
+// @Summary Re-encrypt all impersonations by a new key. The key specified via env var is used if present.
+// @Success 200 {object} EncKeyStatus
+// @Router /user/sso/encryption_key/rotate [post]
+// @Security JwtAuth
+// @Failure 401 {object} utils.APIError "Unauthorized failure"
+// @Failure 403 {object} utils.APIError "Insufficient privilege"
+// @Failure 500 {object} utils.APIError "Internal error"
+func (s *Service) rotateEncKeyHandler(c *gin.Context) {
+	status, err := s.rotateEncKey()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
 // @ID userSSOGetConfig
 // @Summary Get SSO config
 // @Success 200 {object} config.SSOCoreConfig
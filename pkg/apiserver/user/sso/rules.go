@@ -0,0 +1,126 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sso
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidRule = ErrNS.NewType("invalid_impersonation_rule")
+
+func (r *SSOImpersonationRuleModel) matches(info *oAuthUserInfo) bool {
+	switch r.MatchType {
+	case ImpersonationMatchAny:
+		return true
+	case ImpersonationMatchEmailDomain:
+		// Unverified emails may be set to any address by the user in some identity providers
+		if !info.EmailVerified {
+			return false
+		}
+		idx := strings.LastIndex(info.Email, "@")
+		if idx < 0 {
+			return false
+		}
+		return strings.EqualFold(info.Email[idx+1:], strings.TrimPrefix(r.MatchValue, "@"))
+	case ImpersonationMatchGroup:
+		for _, group := range info.Groups {
+			if group == r.MatchValue {
+				return true
+			}
+		}
+		return false
+	case ImpersonationMatchSubject:
+		return info.Subject != "" && info.Subject == r.MatchValue
+	default:
+		return false
+	}
+}
+
+// matchRule returns the first rule matching the user, or nil if no rule matches. Rules must be sorted by priority.
+func matchRule(rules []SSOImpersonationRuleModel, info *oAuthUserInfo) *SSOImpersonationRuleModel {
+	for i := range rules {
+		if rules[i].matches(info) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+func (s *Service) listRules() ([]SSOImpersonationRuleModel, error) {
+	var rules []SSOImpersonationRuleModel
+	err := s.params.LocalStore.Order("priority ASC, id ASC").Find(&rules).Error
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+type ImpersonationRule struct {
+	MatchType  ImpersonationMatchType `json:"match_type"`
+	MatchValue string                 `json:"match_value"`
+	SQLUser    string                 `json:"sql_user"`
+	IsReadOnly bool                   `json:"is_read_only"`
+}
+
+// replaceRules replaces all rules. The priority of the rules follows the order in the list.
+func (s *Service) replaceRules(rules []ImpersonationRule) ([]SSOImpersonationRuleModel, error) {
+	var imps []SSOImpersonationModel
+	if err := s.params.LocalStore.Find(&imps).Error; err != nil {
+		return nil, err
+	}
+	sqlUsers := make(map[string]struct{}, len(imps))
+	for _, imp := range imps {
+		sqlUsers[imp.SQLUser] = struct{}{}
+	}
+
+	records := make([]SSOImpersonationRuleModel, 0, len(rules))
+	for i, rule := range rules {
+		switch rule.MatchType {
+		case ImpersonationMatchAny:
+			rule.MatchValue = ""
+		case ImpersonationMatchEmailDomain, ImpersonationMatchGroup, ImpersonationMatchSubject:
+			if rule.MatchValue == "" {
+				return nil, ErrInvalidRule.New("rule #%d: match value is required for match type %s", i+1, rule.MatchType)
+			}
+		default:
+			return nil, ErrInvalidRule.New("rule #%d: unknown match type %s", i+1, rule.MatchType)
+		}
+		if _, ok := sqlUsers[rule.SQLUser]; !ok {
+			return nil, ErrInvalidRule.New("rule #%d: SQL user %s is not authorized for impersonation", i+1, rule.SQLUser)
+		}
+		records = append(records, SSOImpersonationRuleModel{
+			Priority:   i,
+			MatchType:  rule.MatchType,
+			MatchValue: rule.MatchValue,
+			SQLUser:    rule.SQLUser,
+			IsReadOnly: rule.IsReadOnly,
+		})
+	}
+
+	err := s.params.LocalStore.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&SSOImpersonationRuleModel{}).Error; err != nil {
+			return err
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sso
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore/dbstoretest"
+)
+
+func TestT(t *testing.T) {
+	CustomVerboseFlag = true
+	TestingT(t)
+}
+
+var _ = Suite(&testRulesSuite{})
+
+type testRulesSuite struct{}
+
+func (t *testRulesSuite) Test_matchRule(c *C) {
+	rules := []SSOImpersonationRuleModel{
+		{MatchType: ImpersonationMatchSubject, MatchValue: "u-001", SQLUser: "root"},
+		{MatchType: ImpersonationMatchGroup, MatchValue: "dba", SQLUser: "dba"},
+		{MatchType: ImpersonationMatchEmailDomain, MatchValue: "example.com", SQLUser: "reader", IsReadOnly: true},
+	}
+
+	c.Assert(matchRule(rules, &oAuthUserInfo{Subject: "u-001", Email: "a@example.com"}).SQLUser, Equals, "root")
+	c.Assert(matchRule(rules, &oAuthUserInfo{Email: "b@example.com", Groups: []string{"dev", "dba"}}).SQLUser, Equals, "dba")
+	c.Assert(matchRule(rules, &oAuthUserInfo{Email: "c@EXAMPLE.com", EmailVerified: true}).SQLUser, Equals, "reader")
+	c.Assert(matchRule(rules, &oAuthUserInfo{Email: "c@example.com"}), IsNil)
+	c.Assert(matchRule(rules, &oAuthUserInfo{Email: "d@example.com.evil.org", EmailVerified: true}), IsNil)
+	c.Assert(matchRule(rules, &oAuthUserInfo{Email: "e@other.com", EmailVerified: true, Groups: []string{"dev"}}), IsNil)
+
+	rules = append(rules, SSOImpersonationRuleModel{MatchType: ImpersonationMatchAny, SQLUser: "guest"})
+	c.Assert(matchRule(rules, &oAuthUserInfo{Email: "e@other.com"}).SQLUser, Equals, "guest")
+}
+
+func (t *testRulesSuite) Test_claimBool(c *C) {
+	for data, expected := range map[string]bool{`{"email_verified":true}`: true, `{"email_verified":"true"}`: true, `{"email_verified":false}`: false, `{}`: false} {
+		var info oAuthUserInfo
+		c.Assert(json.Unmarshal([]byte(data), &info), IsNil)
+		c.Assert(bool(info.EmailVerified), Equals, expected, Commentf("data %s", data))
+	}
+	var info oAuthUserInfo
+	c.Assert(json.Unmarshal([]byte(`{"email_verified":"yes"}`), &info), NotNil)
+}
+
+func (t *testRulesSuite) Test_seedDefaultRule(c *C) {
+	// Upgrading from the schema without rules
+	db := dbstoretest.NewDB(c)
+	c.Assert(db.AutoMigrate(&SSOImpersonationModel{}), IsNil)
+	c.Assert(db.Create(&SSOImpersonationModel{SQLUser: "root"}).Error, IsNil)
+
+	c.Assert(autoMigrate(db), IsNil)
+	s := &Service{params: ServiceParams{LocalStore: db}}
+	rules, err := s.listRules()
+	c.Assert(err, IsNil)
+	c.Assert(rules, HasLen, 1)
+	c.Assert(rules[0].MatchType, Equals, ImpersonationMatchAny)
+	c.Assert(rules[0].SQLUser, Equals, "root")
+
+	// Migrating again does not create more rules
+	c.Assert(autoMigrate(db), IsNil)
+	rules, err = s.listRules()
+	c.Assert(err, IsNil)
+	c.Assert(rules, HasLen, 1)
+
+	// Rules removed by admins are not seeded again after restarting, so that unmatched users are still rejected
+	_, err = s.replaceRules(nil)
+	c.Assert(err, IsNil)
+	c.Assert(autoMigrate(db), IsNil)
+	rules, err = s.listRules()
+	c.Assert(err, IsNil)
+	c.Assert(rules, HasLen, 0)
+	c.Assert(matchRule(rules, &oAuthUserInfo{Subject: "u-001", Email: "a@example.com", EmailVerified: true}), IsNil)
+}
+
+func (t *testRulesSuite) Test_seedDefaultRuleFreshInstall(c *C) {
+	db := dbstoretest.NewDB(c, autoMigrate)
+	c.Assert(db.Create(&SSOImpersonationModel{SQLUser: "root"}).Error, IsNil)
+	c.Assert(autoMigrate(db), IsNil)
+	s := &Service{params: ServiceParams{LocalStore: db}}
+	rules, err := s.listRules()
+	c.Assert(err, IsNil)
+	c.Assert(rules, HasLen, 0)
+}
+
+func (t *testRulesSuite) Test_replaceRules(c *C) {
+	db := dbstoretest.NewDB(c)
+	c.Assert(autoMigrate(db), IsNil)
+	c.Assert(db.Create(&SSOImpersonationModel{SQLUser: "root"}).Error, IsNil)
+	c.Assert(db.Create(&SSOImpersonationModel{SQLUser: "reader"}).Error, IsNil)
+	s := &Service{params: ServiceParams{LocalStore: db}}
+
+	_, err := s.replaceRules([]ImpersonationRule{{MatchType: ImpersonationMatchGroup, SQLUser: "root"}})
+	c.Assert(errorx.IsOfType(err, ErrInvalidRule), IsTrue)
+	_, err = s.replaceRules([]ImpersonationRule{{MatchType: ImpersonationMatchAny, SQLUser: "nobody"}})
+	c.Assert(errorx.IsOfType(err, ErrInvalidRule), IsTrue)
+
+	_, err = s.replaceRules([]ImpersonationRule{
+		{MatchType: ImpersonationMatchGroup, MatchValue: "dba", SQLUser: "root"},
+		{MatchType: ImpersonationMatchAny, MatchValue: "ignored", SQLUser: "reader", IsReadOnly: true},
+	})
+	c.Assert(err, IsNil)
+	rules, err := s.listRules()
+	c.Assert(err, IsNil)
+	c.Assert(rules, HasLen, 2)
+	c.Assert(rules[0].SQLUser, Equals, "root")
+	c.Assert(rules[1].MatchValue, Equals, "")
+
+	// Impersonations used by rules cannot be revoked
+	c.Assert(errorx.IsOfType(s.revokeImpersonation("reader"), ErrInvalidRule), IsTrue)
+}
@@ -17,12 +17,8 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
-	"encoding/hex"
 	"fmt"
-	"io/ioutil"
 	"net/url"
-	"os"
-	"path"
 	"strings"
 	"sync"
 	"time"
@@ -69,8 +65,9 @@ type Service struct {
 	lifecycleCtx     context.Context
 	oauthStateSecret []byte
 
-	encKeyPath string
-	encKeyLock sync.Mutex
+	encKeyDir   string
+	encKeyLock  sync.Mutex
+	externalKey *[32]byte
 
 	createImpersonationLock sync.Mutex
 }
@@ -79,11 +76,16 @@ func newService(p ServiceParams, lc fx.Lifecycle, config *config.Config) (*Servi
 	if err := autoMigrate(p.LocalStore); err != nil {
 		return nil, err
 	}
+	externalKey, err := loadExternalKey()
+	if err != nil {
+		return nil, err
+	}
 	s := &Service{
 		params:                  p,
 		oauthStateSecret:        cryptopasta.NewHMACKey()[:],
-		encKeyPath:              path.Join(config.DataDir, "dbek.bin"),
+		encKeyDir:               config.DataDir,
 		encKeyLock:              sync.Mutex{},
+		externalKey:             externalKey,
 		createImpersonationLock: sync.Mutex{},
 	}
 	lc.Append(fx.Hook{
@@ -100,69 +102,17 @@ var Module = fx.Options(
 	fx.Invoke(registerRouter),
 )
 
-func (s *Service) getMasterEncKey() (*[32]byte, error) {
-	b, err := ioutil.ReadFile(s.encKeyPath)
-	if err != nil {
-		// Key does not exist
-		if os.IsNotExist(err) {
-			return nil, nil
-		}
-		return nil, err
-	}
-	if len(b) != 32 {
-		return nil, fmt.Errorf("encryption key is broken")
-	}
-
-	var fixedLenKey [32]byte
-	copy(fixedLenKey[:], b)
-
-	return &fixedLenKey, nil
-}
-
-// This function is thread-safe.
-func (s *Service) getOrCreateMasterEncKey() (*[32]byte, error) {
-	s.encKeyLock.Lock()
-	defer s.encKeyLock.Unlock()
-
-	key, _ := s.getMasterEncKey()
-	if key != nil {
-		return key, nil
-	}
-
-	// Try to create a key otherwise
-	key = cryptopasta.NewEncryptionKey()
-	err := ioutil.WriteFile(s.encKeyPath, key[:], 0400) // read only for owner
-	if err != nil {
-		return nil, fmt.Errorf("persist key failed: %v", err)
-	}
-	return key, nil
-}
-
-// getAndDecryptImpersonation reads the impersonation record from local Sqlite and decrypt the record to get the
-// plain SQL password. Currently this function only reads `root` user impersonation.
-func (s *Service) getAndDecryptImpersonation() (string, string, error) {
+// getAndDecryptImpersonation reads the impersonation record of the SQL user from local Sqlite and decrypt the record
+// to get the plain SQL password.
+func (s *Service) getAndDecryptImpersonation(sqlUser string) (string, error) {
 	var imp SSOImpersonationModel
 	err := s.params.LocalStore.
+		Where("sql_user = ?", sqlUser).
 		First(&imp).Error
 	if err != nil {
-		return "", "", fmt.Errorf("bad record: %v", err)
-	}
-	key, err := s.getMasterEncKey()
-	if err != nil {
-		return "", "", fmt.Errorf("bad encryption key: %v", err)
-	}
-	if key == nil {
-		return "", "", fmt.Errorf("encryption key is missing")
-	}
-	encrypted, err := hex.DecodeString(imp.EncryptedPass)
-	if err != nil {
-		return "", "", fmt.Errorf("bad record: %v", err)
-	}
-	decryptedPass, err := cryptopasta.Decrypt(encrypted, key)
-	if err != nil {
-		return "", "", fmt.Errorf("bad record: %v", err)
+		return "", fmt.Errorf("bad record: %v", err)
 	}
-	return imp.SQLUser, string(decryptedPass), nil
+	return s.decryptSecret(imp.KeyVersion, imp.EncryptedPass)
 }
 
 func (s *Service) updateImpersonationStatus(user string, status ImpersonateStatus) error {
@@ -173,20 +123,31 @@ func (s *Service) updateImpersonationStatus(user string, status ImpersonateStatu
 		Error
 }
 
-// newSessionFromImpersonation creates a new session from the impersonation records.
+// newSessionFromImpersonation creates a new session from the impersonation record selected by the first matched
+// impersonation rule. Users not matching any rule are rejected.
 func (s *Service) newSessionFromImpersonation(userInfo *oAuthUserInfo, idToken string) (*utils.SessionUser, error) {
 	dc, err := s.params.ConfigManager.Get()
 	if err != nil {
 		return nil, err
 	}
 
-	userName, password, err := s.getAndDecryptImpersonation()
+	rules, err := s.listRules()
+	if err != nil {
+		return nil, err
+	}
+	rule := matchRule(rules, userInfo)
+	if rule == nil {
+		return nil, ErrUnsupportedUser.New("User %s is not allowed to sign in via SSO", userInfo.Email)
+	}
+
+	userName := rule.SQLUser
+	password, err := s.getAndDecryptImpersonation(userName)
 	if err != nil {
 		return nil, err
 	}
 
 	// Check whether this user can access dashboard
-	writeable, err := user.VerifySQLUser(s.params.TiDBClient, userName, password)
+	privs, err := user.VerifySQLUser(s.params.TiDBClient, userName, password)
 	if err != nil {
 		if errorx.IsOfType(err, tidb.ErrTiDBAuthFailed) {
 			_ = s.updateImpersonationStatus(userName, ImpersonateStatusAuthFail)
@@ -200,18 +161,45 @@ func (s *Service) newSessionFromImpersonation(userInfo *oAuthUserInfo, idToken s
 	}
 	_ = s.updateImpersonationStatus(userName, ImpersonateStatusSuccess)
 
+	subjects := []string{user.Subject(user.SubjectKindSSOEmail, userInfo.Email)}
+	for _, group := range userInfo.Groups {
+		subjects = append(subjects, user.Subject(user.SubjectKindSSOGroup, group))
+	}
+	writeable := privs.Writeable && !rule.IsReadOnly && !dc.SSO.CoreConfig.IsReadOnly
+	perms := user.ResolvePermissions(&dc.Permission, writeable, subjects)
+
 	return &utils.SessionUser{
-		Version:      utils.SessionVersion,
-		HasTiDBAuth:  true,
-		TiDBUsername: userName,
-		TiDBPassword: password,
-		DisplayName:  userInfo.Email,
-		IsShareable:  true,
-		IsWriteable:  writeable && !dc.SSO.CoreConfig.IsReadOnly,
-		OIDCIDToken:  idToken,
+		Version:         utils.SessionVersion,
+		HasTiDBAuth:     true,
+		TiDBUsername:    userName,
+		TiDBPassword:    password,
+		DisplayName:     userInfo.Email,
+		ExternalSubject: userInfo.Subject,
+		Permissions:     perms,
+		Subjects:        subjects,
+		OIDCIDToken:     idToken,
 	}, nil
 }
 
+// NewSessionFromExternalIdentity creates a new session for a user authenticated by other identity providers, e.g. SAML,
+// using the same impersonation rules as OIDC users. Email domain rules only match emails verified by the provider.
+func (s *Service) NewSessionFromExternalIdentity(subject string, email string, emailVerified bool, groups []string) (*utils.SessionUser, error) {
+	info := &oAuthUserInfo{
+		Subject:       subject,
+		Email:         email,
+		EmailVerified: claimBool(emailVerified),
+		Groups:        groups,
+	}
+	u, err := s.newSessionFromImpersonation(info, "")
+	if err != nil {
+		if errorx.IsOfType(err, ErrUnsupportedUser) {
+			return nil, err
+		}
+		return nil, ErrBadConfig.Wrap(err, "SSO impersonation is not configured correctly")
+	}
+	return u, nil
+}
+
 func (s *Service) createImpersonation(userName string, password string) (*SSOImpersonationModel, error) {
 	{
 		// Check whether this user can access dashboard
@@ -226,38 +214,53 @@ func (s *Service) createImpersonation(userName string, password string) (*SSOImp
 			return nil, err
 		}
 	}
-	key, err := s.getOrCreateMasterEncKey()
-	if err != nil {
-		return nil, err
-	}
-	encrypted, err := cryptopasta.Encrypt([]byte(password), key)
+	// Encrypt inside the lock, so that the record is not encrypted by a key being rotated
+	s.createImpersonationLock.Lock()
+	defer s.createImpersonationLock.Unlock()
+
+	keyVersion, encryptedInHex, err := s.encryptSecret(password)
 	if err != nil {
 		return nil, err
 	}
-	encryptedInHex := hex.EncodeToString(encrypted)
-
 	record := &SSOImpersonationModel{
 		SQLUser:               userName,
 		EncryptedPass:         encryptedInHex,
+		KeyVersion:            keyVersion,
 		LastImpersonateStatus: nil,
 	}
-	// currently, we only support to authorize one sql user
-	s.createImpersonationLock.Lock()
-	defer s.createImpersonationLock.Unlock()
 
-	err = s.revokeAllImpersonations()
+	// Overwrite the existing record of the same SQL user
+	err = s.params.LocalStore.Save(record).Error
 	if err != nil {
 		return nil, err
 	}
-	err = s.params.LocalStore.Create(&record).Error
+	return record, nil
+}
+
+func (s *Service) revokeImpersonation(userName string) error {
+	var refCount int64
+	err := s.params.LocalStore.
+		Model(&SSOImpersonationRuleModel{}).
+		Where("sql_user = ?", userName).
+		Count(&refCount).Error
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return record, nil
+	if refCount > 0 {
+		return ErrInvalidRule.New("SQL user %s is still used by %d impersonation rules", userName, refCount)
+	}
+	return s.params.LocalStore.
+		Where("sql_user = ?", userName).
+		Delete(&SSOImpersonationModel{}).
+		Error
 }
 
 func (s *Service) revokeAllImpersonations() error {
 	sqlStr := fmt.Sprintf("DELETE FROM `%s`", SSOImpersonationModel{}.TableName()) // #nosec
+	if err := s.params.LocalStore.Exec(sqlStr).Error; err != nil {
+		return err
+	}
+	sqlStr = fmt.Sprintf("DELETE FROM `%s`", SSOImpersonationRuleModel{}.TableName()) // #nosec
 	return s.params.LocalStore.
 		Exec(sqlStr).
 		Error
@@ -395,8 +398,26 @@ func (s *Service) exchangeOAuthCode(redirectURL string, code string, codeVerifie
 }
 
 type oAuthUserInfo struct {
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	Subject       string    `json:"sub"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	EmailVerified claimBool `json:"email_verified"`
+	Groups        []string  `json:"groups"` // Only presents when the identity provider is configured to release the groups claim
+}
+
+// claimBool is a boolean claim, which is sent as a string by some identity providers, e.g. `"true"`.
+type claimBool bool
+
+func (b *claimBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	case "false", "null", "":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean claim %s", data)
+	}
+	return nil
 }
 
 func (s *Service) oAuthGetUserInfo(accessToken string) (*oAuthUserInfo, error) {
@@ -439,6 +460,9 @@ func (s *Service) NewSessionFromOAuthExchange(redirectURL string, code string, c
 
 	u, err := s.newSessionFromImpersonation(info, idToken)
 	if err != nil {
+		if errorx.IsOfType(err, ErrUnsupportedUser) {
+			return nil, err
+		}
 		return nil, ErrBadConfig.Wrap(err, "SSO is not configured correctly")
 	}
 	return u, nil
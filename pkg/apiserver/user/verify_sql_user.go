@@ -18,6 +18,8 @@ import (
 	"regexp"
 	"strings"
 
+	"gorm.io/gorm"
+
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/pkg/tidb"
 )
@@ -41,10 +43,17 @@ type tidbSEMConfig struct {
 	EnableSEM bool `json:"enable-sem"`
 }
 
-func VerifySQLUser(tidbClient *tidb.Client, userName, password string) (writeable bool, err error) {
+type SQLUserPrivileges struct {
+	// Whether the SQL user is able to modify the cluster.
+	Writeable bool
+	// Roles granted to the SQL user.
+	Roles []string
+}
+
+func VerifySQLUser(tidbClient *tidb.Client, userName, password string) (*SQLUserPrivileges, error) {
 	db, err := tidbClient.OpenSQLConn(userName, password)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	defer utils.CloseTiDBConnection(db) //nolint:errcheck
 
@@ -52,26 +61,41 @@ func VerifySQLUser(tidbClient *tidb.Client, userName, password string) (writeabl
 	// 1. Check whether TiDB SEM is enabled
 	resData, err := tidbClient.SendGetRequest("/config")
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	var config tidbSecurityConfig
 	err = json.Unmarshal(resData, &config)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	// 2. Get grants
 	var grantRows []string
 	err = db.Raw("show grants for current_user()").Find(&grantRows).Error
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	grants := parseUserGrants(grantRows)
 	// 3. Check
 	if !checkDashboardPriv(grants, config.Security.EnableSEM) {
-		return false, ErrInsufficientPrivs.NewWithNoMessage()
+		return nil, ErrInsufficientPrivs.NewWithNoMessage()
 	}
 
-	return checkWriteablePriv(grants), nil
+	return &SQLUserPrivileges{
+		Writeable: checkWriteablePriv(grants),
+		Roles:     parseUserRoles(grantRows),
+	}, nil
+}
+
+// VerifyProcessPriv checks whether the SQL user of the connection has the PROCESS privilege, i.e. is able to see
+// statements and processes of all users in INFORMATION_SCHEMA.
+func VerifyProcessPriv(db *gorm.DB) (bool, error) {
+	var grantRows []string
+	err := db.Raw("show grants for current_user()").Find(&grantRows).Error
+	if err != nil {
+		return false, err
+	}
+	grants := parseUserGrants(grantRows)
+	return hasPriv("ALL PRIVILEGES", grants) || hasPriv("SUPER", grants) || hasPriv("PROCESS", grants), nil
 }
 
 var grantRegex = regexp.MustCompile(`GRANT (.+) ON`)
@@ -100,6 +124,34 @@ func parseUserGrants(grantRows []string) map[string]struct{} {
 	return grants
 }
 
+var roleGrantRegex = regexp.MustCompile(`^GRANT (.+) TO `)
+
+// parseUserRoles extracts role names from grant rows in the form of `GRANT [roles] TO [user]`.
+// Example:
+// - GRANT `app_read`@`%`,`app_write`@`%` TO `test`@`%`
+func parseUserRoles(grantRows []string) []string {
+	roles := []string{}
+
+	for _, row := range grantRows {
+		if grantRegex.MatchString(row) {
+			continue
+		}
+		m := roleGrantRegex.FindStringSubmatch(row)
+		if len(m) != 2 {
+			continue
+		}
+		for _, role := range strings.Split(m[1], ",") {
+			name := strings.SplitN(strings.TrimSpace(role), "@", 2)[0]
+			name = strings.Trim(name, "`'\"")
+			if name != "" {
+				roles = append(roles, name)
+			}
+		}
+	}
+
+	return roles
+}
+
 // To access TiDB Dashboard, following base privileges are required
 // - ALL PRIVILEGES
 // - or
@@ -230,3 +230,34 @@ func (t *testVerifySQLUserSuite) Test_checkWriteablePriv(c *C) {
 		c.Assert(actual, DeepEquals, v.expected, Commentf("check %s (index: %d) failed", v.desc, i))
 	}
 }
+
+func (t *testVerifySQLUserSuite) Test_parseUserRoles(c *C) {
+	cases := []struct {
+		desc     string
+		input    []string
+		expected []string
+	}{
+		// 0
+		{
+			desc: "no roles",
+			input: []string{
+				"GRANT ALL PRIVILEGES ON *.* TO 'root'@'%' WITH GRANT OPTION",
+			},
+			expected: []string{},
+		},
+		// 1
+		{
+			desc: "roles",
+			input: []string{
+				"GRANT PROCESS,CONFIG ON *.* TO 'test'@'%'",
+				"GRANT `app_read`@`%`,`app_write`@`%` TO `test`@`%`",
+			},
+			expected: []string{"app_read", "app_write"},
+		},
+	}
+
+	for i, v := range cases {
+		actual := parseUserRoles(v.input)
+		c.Assert(actual, DeepEquals, v.expected, Commentf("parse %s (index: %d) failed", v.desc, i))
+	}
+}
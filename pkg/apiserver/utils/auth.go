@@ -14,6 +14,7 @@
 package utils
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -21,32 +22,75 @@ import (
 
 type AuthType int
 
-const SessionVersion = 2
+const SessionVersion = 4
 
 // The content of this structure will be encrypted and stored as both Session Token and Sharing Token.
 // For fields that don't need to be cloned during session sharing, mark fields as `msgpack:"-"`.
 type SessionUser struct {
-	// Must be 2. This field is used to invalidate outdated sessions after schema change.
+	// Must be 4. This field is used to invalidate outdated sessions after schema change.
 	Version int
 
 	DisplayName string
 
+	// The ID of the server-side session record. Each sign in creates a new session.
+	SessionID string `msgpack:"-" json:",omitempty"`
+
 	HasTiDBAuth  bool
 	TiDBUsername string
 	TiDBPassword string
 
-	// This field only exists for CodeAuth.
+	// These fields only exist for CodeAuth.
+	SharedSessionID       string    `msgpack:"-" json:",omitempty"`
 	SharedSessionExpireAt time.Time `msgpack:"-" json:",omitempty"`
+	// The owner key of the user sharing the session, see OwnerKey.
+	SharedBy string `msgpack:"-" json:",omitempty"`
 
 	// This field only exists for SSOAuth
 	OIDCIDToken string `json:",omitempty"`
 
+	// The ID of the user in the identity provider, e.g. the DN of the LDAP user or the subject of the SSO user.
+	// Empty for SQL users, who are identified by TiDBUsername.
+	ExternalSubject string `msgpack:"-" json:",omitempty"`
+
 	// These fields should not be updated by individual authenticators.
 	AuthFrom AuthType `msgpack:"-" json:",omitempty"`
+	// Identifies the user across sessions, see OwnerKey.
+	Owner string `msgpack:"-" json:",omitempty"`
+
+	// Permissions granted to this session.
+	Permissions []Permission
+	// The subjects of the user matching permission rules when the session was created.
+	Subjects []string `json:",omitempty"`
+}
 
-	// TODO: Make them table fields
-	IsShareable bool
-	IsWriteable bool
+// OwnerKey returns the key identifying the user signed in via the authentication type, which is used as the owner of
+// resources created by the user, e.g. API tokens and saved queries. Display names are not used, since they are not
+// unique across authentication types.
+func OwnerKey(authType AuthType, u *SessionUser) string {
+	subject := u.ExternalSubject
+	if subject == "" {
+		subject = u.TiDBUsername
+	}
+	return fmt.Sprintf("%d:%s", authType, subject)
+}
+
+func (u *SessionUser) HasPermission(p Permission) bool {
+	for _, granted := range u.Permissions {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// HasWritePermission checks whether any write permission is granted to this session.
+func (u *SessionUser) HasWritePermission() bool {
+	for _, granted := range u.Permissions {
+		if granted.IsWrite() {
+			return true
+		}
+	}
+	return false
 }
 
 const (
@@ -70,31 +70,52 @@ func GenerateCSVFromRaw(rawData []interface{}, fields []string, timeFields []str
 	return
 }
 
-// TODO: Better to be a streaming interface.
 func ExportCSV(data [][]string, filename, tokenNamespace string) (token string, err error) {
-	csvFile, err := ioutil.TempFile("", filename)
+	return ExportFile(filename, tokenNamespace, func(w io.Writer) error {
+		return csv.NewWriter(w).WriteAll(data)
+	})
+}
+
+// ExportFile streams the content produced by write into an encrypted temporary file, and returns a token for
+// downloading it via DownloadByToken. The content is never held in memory as a whole.
+//
+// It serves the same purpose as util/rest/fileswap, which is not used here since the util module is not a dependency
+// of this module yet.
+func ExportFile(filename, tokenNamespace string, write func(w io.Writer) error) (token string, err error) {
+	file, err := ioutil.TempFile("", filename)
 	if err != nil {
 		return
 	}
-	defer csvFile.Close() // #nosec
+	defer func() {
+		_ = file.Close()
+		if err != nil {
+			_ = os.Remove(file.Name())
+		}
+	}()
 
 	// generate encryption key
 	secretKey := *cryptopasta.NewEncryptionKey()
 
 	pr, pw := io.Pipe()
+	writeErr := make(chan error, 1)
 	go func() {
-		csvwriter := csv.NewWriter(pw)
-		_ = csvwriter.WriteAll(data)
-		_ = pw.Close()
+		err := write(pw)
+		_ = pw.CloseWithError(err)
+		writeErr <- err
 	}()
-	err = aesctr.Encrypt(pr, csvFile, secretKey[0:16], secretKey[16:])
+	err = aesctr.Encrypt(pr, file, secretKey[0:16], secretKey[16:])
+	// unblock the writer if encryption stops early
+	_ = pr.CloseWithError(io.ErrClosedPipe)
+	if wErr := <-writeErr; wErr != nil {
+		err = wErr
+	}
 	if err != nil {
 		return
 	}
 
 	// generate token by filepath and secretKey
 	secretKeyStr := base64.StdEncoding.EncodeToString(secretKey[:])
-	token, err = NewJWTString(tokenNamespace, secretKeyStr+" "+csvFile.Name())
+	token, err = NewJWTString(tokenNamespace, secretKeyStr+" "+file.Name())
 	return
 }
 
@@ -128,11 +149,18 @@ func DownloadByToken(token, tokenNamespace string, c *gin.Context) {
 		return
 	}
 
-	c.Writer.Header().Set("Content-type", "text/csv")
+	contentType := "text/csv"
+	switch filepath.Ext(filePath) {
+	case ".jsonl":
+		contentType = "application/x-ndjson"
+	case ".tsv":
+		contentType = "text/tab-separated-values"
+	}
+	c.Writer.Header().Set("Content-type", contentType)
 	c.Writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileInfo.Name()))
 	err = aesctr.Decrypt(f, c.Writer, secretKey[0:16], secretKey[16:])
 	if err != nil {
-		log.Error("decrypt csv failed", zap.Error(err))
+		log.Error("decrypt file failed", zap.Error(err))
 	}
 	// delete it anyway
 	_ = f.Close()
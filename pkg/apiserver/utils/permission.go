@@ -0,0 +1,119 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+// Permission is a capability of a feature that can be granted to a session.
+type Permission string
+
+const (
+	// Share the session via sharing codes or API tokens.
+	PermSessionShare Permission = "session.share"
+	// Download searched logs.
+	PermLogSearchDownload Permission = "logsearch.download"
+
+	// Import and delete slow log files for offline analysis.
+	PermSlowQueryImport Permission = "slowquery.import"
+	// Start, cancel and delete profiling.
+	PermProfilingStart Permission = "profiling.start"
+	// Remove TiDB instances from the topology.
+	PermTopologyDelete Permission = "topology.delete"
+	// Send requests to component debug APIs.
+	PermDebugAPIRequest Permission = "debugapi.request"
+	// Generate diagnose reports.
+	PermDiagnoseGenerate Permission = "diagnose.generate"
+	// Edit configurations of cluster components.
+	PermConfigEdit Permission = "config.edit"
+	// Edit settings of dashboard features, e.g. profiling, key visualizer and statement settings.
+	PermSettingsEdit Permission = "settings.edit"
+	// Run read-only SQL statements in the query editor, e.g. SELECT, SHOW and EXPLAIN.
+	PermQueryEditorRead Permission = "queryeditor.read"
+	// Run arbitrary SQL statements in the query editor.
+	PermQueryEditorRun Permission = "queryeditor.run"
+	// Manage SSO, LDAP and permission settings.
+	PermUserManage Permission = "user.manage"
+	// View and export audit logs.
+	PermAuditView Permission = "audit.view"
+	// Create, drop, enable and disable SQL plan bindings.
+	PermBindingManage Permission = "binding.manage"
+)
+
+// Write permissions are only granted to sessions whose SQL user is able to modify the cluster.
+var writePermissions = map[Permission]struct{}{
+	PermSlowQueryImport:  {},
+	PermProfilingStart:   {},
+	PermTopologyDelete:   {},
+	PermDebugAPIRequest:  {},
+	PermDiagnoseGenerate: {},
+	PermConfigEdit:       {},
+	PermSettingsEdit:     {},
+	PermQueryEditorRun:   {},
+	PermUserManage:       {},
+	PermBindingManage:    {},
+	// Audit logs contain actions of all users, so that they are only visible to privileged users.
+	PermAuditView: {},
+}
+
+// AllPermissions lists all known permissions.
+var AllPermissions = []Permission{
+	PermSessionShare,
+	PermLogSearchDownload,
+	PermSlowQueryImport,
+	PermProfilingStart,
+	PermTopologyDelete,
+	PermDebugAPIRequest,
+	PermDiagnoseGenerate,
+	PermConfigEdit,
+	PermSettingsEdit,
+	PermQueryEditorRead,
+	PermQueryEditorRun,
+	PermUserManage,
+	PermAuditView,
+	PermBindingManage,
+}
+
+func (p Permission) IsValid() bool {
+	for _, known := range AllPermissions {
+		if p == known {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Permission) IsWrite() bool {
+	_, ok := writePermissions[p]
+	return ok
+}
+
+// ReadPermissionsOf returns permissions in the list that are not write permissions.
+func ReadPermissionsOf(perms []Permission) []Permission {
+	result := make([]Permission, 0, len(perms))
+	for _, p := range perms {
+		if !p.IsWrite() {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// WithoutPermission returns permissions in the list except for the specified one.
+func WithoutPermission(perms []Permission, excluded Permission) []Permission {
+	result := make([]Permission, 0, len(perms))
+	for _, p := range perms {
+		if p != excluded {
+			result = append(result, p)
+		}
+	}
+	return result
+}
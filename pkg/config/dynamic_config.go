@@ -14,6 +14,10 @@
 package config
 
 import (
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/model"
 )
 
@@ -26,6 +30,21 @@ const (
 	DefaultProfilingAutoCollectionDurationSecs = 30
 	MaxProfilingAutoCollectionDurationSecs     = 120
 	DefaultProfilingAutoCollectionIntervalSecs = 3600
+
+	DefaultLDAPUserSearchFilter = "(uid=%s)"
+	DefaultLDAPGroupAttribute   = "memberOf"
+
+	DefaultAuditRetentionDays = 90
+	MaxAuditRetentionDays     = 3650
+
+	DefaultStatementArchiveIntervalSecs  = 30 * 60
+	MinStatementArchiveIntervalSecs      = 60
+	DefaultStatementArchiveRetentionDays = 30
+	MaxStatementArchiveRetentionDays     = 365
+
+	DefaultSessionAbsoluteTimeoutSecs = 24 * 60 * 60
+	MaxSessionAbsoluteTimeoutSecs     = 30 * 24 * 60 * 60
+	MinSessionTimeoutSecs             = 60
 )
 
 var (
@@ -70,16 +89,188 @@ type SSOConfig struct {
 	SignOutURL  string        `json:"sign_out_url"`
 }
 
+type LDAPConfig struct {
+	Enabled            bool   `json:"enabled"`
+	URL                string `json:"url"` // ldap://host:389 or ldaps://host:636
+	StartTLS           bool   `json:"start_tls"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	// BindDN is the service account used to look up users. Leave empty to search anonymously.
+	// The password of the service account is stored encrypted in the local storage.
+	BindDN               string `json:"bind_dn"`
+	UserSearchBase       string `json:"user_search_base"`
+	UserSearchFilter     string `json:"user_search_filter"` // %s will be replaced by the escaped user name
+	DisplayNameAttribute string `json:"display_name_attribute"`
+	GroupAttribute       string `json:"group_attribute"`
+	// Members of these groups get a writeable session. Groups can be specified as a full DN or the CN only.
+	WriteableGroups []string `json:"writeable_groups"`
+	// Members of these groups get a read-only session. Directory users not in any group are rejected.
+	ReadOnlyGroups []string `json:"read_only_groups"`
+	// The SQL user to impersonate. Its password is stored encrypted in the local storage.
+	SQLUser string `json:"sql_user"`
+}
+
+func (c *LDAPConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if !strings.HasPrefix(c.URL, "ldap://") && !strings.HasPrefix(c.URL, "ldaps://") {
+		return ErrVerificationFailed.New("ldap url must start with ldap:// or ldaps://")
+	}
+	if c.StartTLS && strings.HasPrefix(c.URL, "ldaps://") {
+		return ErrVerificationFailed.New("ldap start_tls cannot be used with ldaps://")
+	}
+	if c.UserSearchBase == "" {
+		return ErrVerificationFailed.New("ldap user_search_base cannot be empty")
+	}
+	if strings.Count(c.UserSearchFilter, "%s") != 1 {
+		return ErrVerificationFailed.New("ldap user_search_filter must contain exactly one %%s")
+	}
+	if len(c.WriteableGroups) == 0 && len(c.ReadOnlyGroups) == 0 {
+		return ErrVerificationFailed.New("ldap requires at least one writeable or read-only group")
+	}
+	if c.SQLUser == "" {
+		return ErrVerificationFailed.New("ldap sql_user cannot be empty")
+	}
+	return nil
+}
+
+type SAMLConfig struct {
+	Enabled bool `json:"enabled"`
+	// The URL that browsers use to access TiDB Dashboard, e.g. `https://example.com:2379/dashboard`. The SP entity ID
+	// and the assertion consumer service URL are derived from it.
+	PublicURL   string `json:"public_url"`
+	IdPEntityID string `json:"idp_entity_id"`
+	// The single sign-on service URL of the IdP, using HTTP-Redirect binding.
+	IdPSSOURL string `json:"idp_sso_url"`
+	// PEM encoded certificate of the IdP, used to verify signatures of responses and assertions.
+	IdPCertificate string `json:"idp_certificate"`
+	// The attribute containing the email of the user. NameID is used when the attribute is empty or not presented.
+	EmailAttribute  string `json:"email_attribute"`
+	GroupsAttribute string `json:"groups_attribute"`
+}
+
+func (c *SAMLConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if !strings.HasPrefix(c.PublicURL, "http://") && !strings.HasPrefix(c.PublicURL, "https://") {
+		return ErrVerificationFailed.New("saml public_url must start with http:// or https://")
+	}
+	if c.IdPEntityID == "" {
+		return ErrVerificationFailed.New("saml idp_entity_id cannot be empty")
+	}
+	if !strings.HasPrefix(c.IdPSSOURL, "http://") && !strings.HasPrefix(c.IdPSSOURL, "https://") {
+		return ErrVerificationFailed.New("saml idp_sso_url must start with http:// or https://")
+	}
+	block, _ := pem.Decode([]byte(c.IdPCertificate))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return ErrVerificationFailed.New("saml idp_certificate must be a PEM encoded certificate")
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return ErrVerificationFailed.Wrap(err, "saml idp_certificate is invalid")
+	}
+	return nil
+}
+
+type PermissionRule struct {
+	// The subject that the rule applies to, in the form of `kind:name`. Supported kinds are `sql_user`,
+	// `sql_role`, `sso_email`, `sso_group` and `ldap_group`, e.g. `sql_role:app_read` or `sso_group:dba`.
+	Subject     string   `json:"subject"`
+	Permissions []string `json:"permissions"`
+}
+
+type PermissionConfig struct {
+	// When disabled, SQL users that are able to modify the cluster are granted all permissions and other users
+	// are granted all read permissions. When enabled, permissions are only granted via rules.
+	Enabled bool             `json:"enabled"`
+	Rules   []PermissionRule `json:"rules"`
+}
+
+func (c *PermissionConfig) validate() error {
+	for _, rule := range c.Rules {
+		if !strings.Contains(rule.Subject, ":") {
+			return ErrVerificationFailed.New("permission rule subject %s must be in the form of kind:name", rule.Subject)
+		}
+	}
+	return nil
+}
+
+func (c *PermissionConfig) clone() PermissionConfig {
+	newCfg := *c
+	newCfg.Rules = make([]PermissionRule, len(c.Rules))
+	for i, rule := range c.Rules {
+		newCfg.Rules[i] = PermissionRule{
+			Subject:     rule.Subject,
+			Permissions: append([]string(nil), rule.Permissions...),
+		}
+	}
+	return newCfg
+}
+
+type AuditConfig struct {
+	// Audit logs older than this are removed.
+	RetentionDays int `json:"retention_days"`
+}
+
+type StatementArchiveConfig struct {
+	// Whether statement summary history is periodically copied into the local archive.
+	Enabled bool `json:"enabled"`
+	// The SQL user to read statement summary history. Its password is stored encrypted in the local storage.
+	SQLUser      string `json:"sql_user"`
+	IntervalSecs int    `json:"interval_secs"`
+	// Archived statements older than this are removed.
+	RetentionDays int `json:"retention_days"`
+}
+
+func (c *StatementArchiveConfig) validate() error {
+	if c.Enabled && c.SQLUser == "" {
+		return ErrVerificationFailed.New("statement archive sql_user cannot be empty")
+	}
+	if c.IntervalSecs < MinStatementArchiveIntervalSecs {
+		return ErrVerificationFailed.New("statement archive interval_secs must be at least %d", MinStatementArchiveIntervalSecs)
+	}
+	if c.RetentionDays <= 0 || c.RetentionDays > MaxStatementArchiveRetentionDays {
+		return ErrVerificationFailed.New("statement archive retention_days must be between 1 and %d", MaxStatementArchiveRetentionDays)
+	}
+	return nil
+}
+
+type SessionConfig struct {
+	// Sessions without any activity for this duration are signed out. 0 means no idle timeout.
+	IdleTimeoutSecs int `json:"idle_timeout_secs"`
+	// Sessions are signed out after this duration since sign in, regardless of activities.
+	AbsoluteTimeoutSecs int `json:"absolute_timeout_secs"`
+}
+
+func (c *SessionConfig) validate() error {
+	if c.IdleTimeoutSecs < 0 || (c.IdleTimeoutSecs > 0 && c.IdleTimeoutSecs < MinSessionTimeoutSecs) {
+		return ErrVerificationFailed.New("session idle_timeout_secs must be 0 or at least %d", MinSessionTimeoutSecs)
+	}
+	if c.AbsoluteTimeoutSecs < MinSessionTimeoutSecs || c.AbsoluteTimeoutSecs > MaxSessionAbsoluteTimeoutSecs {
+		return ErrVerificationFailed.New("session absolute_timeout_secs must be between %d and %d", MinSessionTimeoutSecs, MaxSessionAbsoluteTimeoutSecs)
+	}
+	return nil
+}
+
 type DynamicConfig struct {
-	KeyVisual KeyVisualConfig `json:"keyvisual"`
-	Profiling ProfilingConfig `json:"profiling"`
-	SSO       SSOConfig       `json:"sso"`
+	KeyVisual        KeyVisualConfig        `json:"keyvisual"`
+	Profiling        ProfilingConfig        `json:"profiling"`
+	SSO              SSOConfig              `json:"sso"`
+	LDAP             LDAPConfig             `json:"ldap"`
+	SAML             SAMLConfig             `json:"saml"`
+	Permission       PermissionConfig       `json:"permission"`
+	Audit            AuditConfig            `json:"audit"`
+	Session          SessionConfig          `json:"session"`
+	StatementArchive StatementArchiveConfig `json:"statement_archive"`
 }
 
 func (c *DynamicConfig) Clone() *DynamicConfig {
 	newCfg := *c
 	newCfg.Profiling.AutoCollectionTargets = make([]model.RequestTargetNode, len(c.Profiling.AutoCollectionTargets))
 	copy(newCfg.Profiling.AutoCollectionTargets, c.Profiling.AutoCollectionTargets)
+	newCfg.LDAP.WriteableGroups = append([]string(nil), c.LDAP.WriteableGroups...)
+	newCfg.LDAP.ReadOnlyGroups = append([]string(nil), c.LDAP.ReadOnlyGroups...)
+	newCfg.Permission = c.Permission.clone()
 	return &newCfg
 }
 
@@ -109,6 +300,30 @@ func (c *DynamicConfig) Validate() error {
 		}
 	}
 
+	if err := c.LDAP.validate(); err != nil {
+		return err
+	}
+
+	if err := c.SAML.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Permission.validate(); err != nil {
+		return err
+	}
+
+	if c.Audit.RetentionDays <= 0 || c.Audit.RetentionDays > MaxAuditRetentionDays {
+		return ErrVerificationFailed.New("audit retention_days must be between 1 and %d", MaxAuditRetentionDays)
+	}
+
+	if err := c.Session.validate(); err != nil {
+		return err
+	}
+
+	if err := c.StatementArchive.validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -134,4 +349,32 @@ func (c *DynamicConfig) Adjust() {
 		c.Profiling.AutoCollectionDurationSecs = 0
 		c.Profiling.AutoCollectionIntervalSecs = 0
 	}
+
+	if c.LDAP.UserSearchFilter == "" {
+		c.LDAP.UserSearchFilter = DefaultLDAPUserSearchFilter
+	}
+	if c.LDAP.GroupAttribute == "" {
+		c.LDAP.GroupAttribute = DefaultLDAPGroupAttribute
+	}
+
+	if c.Audit.RetentionDays <= 0 {
+		c.Audit.RetentionDays = DefaultAuditRetentionDays
+	}
+	if c.Audit.RetentionDays > MaxAuditRetentionDays {
+		c.Audit.RetentionDays = MaxAuditRetentionDays
+	}
+
+	if c.Session.AbsoluteTimeoutSecs <= 0 {
+		c.Session.AbsoluteTimeoutSecs = DefaultSessionAbsoluteTimeoutSecs
+	}
+
+	if c.StatementArchive.IntervalSecs <= 0 {
+		c.StatementArchive.IntervalSecs = DefaultStatementArchiveIntervalSecs
+	}
+	if c.StatementArchive.RetentionDays <= 0 {
+		c.StatementArchive.RetentionDays = DefaultStatementArchiveRetentionDays
+	}
+	if c.StatementArchive.RetentionDays > MaxStatementArchiveRetentionDays {
+		c.StatementArchive.RetentionDays = MaxStatementArchiveRetentionDays
+	}
 }
@@ -0,0 +1,42 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dbstoretest provides the local storage for tests.
+package dbstoretest
+
+import (
+	"path"
+
+	"github.com/pingcap/check"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
+)
+
+// NewDB opens an empty local storage in a temporary directory, which is removed after the test suite finishes.
+// Tables are created by the migrate functions, e.g. `autoMigrate` of services.
+func NewDB(c *check.C, migrate ...func(*dbstore.DB) error) *dbstore.DB {
+	return OpenDB(c, c.MkDir(), migrate...)
+}
+
+// OpenDB opens the local storage in the data directory. Opening the same directory again simulates a restart.
+func OpenDB(c *check.C, dataDir string, migrate ...func(*dbstore.DB) error) *dbstore.DB {
+	gormDB, err := gorm.Open(sqlite.Open(path.Join(dataDir, "dashboard.sqlite.db")), &gorm.Config{})
+	c.Assert(err, check.IsNil)
+	db := &dbstore.DB{DB: gormDB}
+	for _, fn := range migrate {
+		c.Assert(fn(db), check.IsNil)
+	}
+	return db
+}
@@ -30,6 +30,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/pingcap/tidb-dashboard/pkg/apiserver/user"
+	apiutils "github.com/pingcap/tidb-dashboard/pkg/apiserver/utils"
 	"github.com/pingcap/tidb-dashboard/pkg/config"
 	"github.com/pingcap/tidb-dashboard/pkg/dbstore"
 	"github.com/pingcap/tidb-dashboard/pkg/keyvisual/decorator"
@@ -118,7 +119,7 @@ func RegisterRouter(r *gin.RouterGroup, auth *user.AuthService, s *Service) {
 	endpoint.Use(auth.MWAuthRequired())
 
 	endpoint.GET("/config", s.getDynamicConfig)
-	endpoint.PUT("/config", auth.MWRequireWritePriv(), s.setDynamicConfig)
+	endpoint.PUT("/config", auth.MWRequirePermission(apiutils.PermSettingsEdit), s.setDynamicConfig)
 
 	endpoint.Use(s.status.MWHandleStopped(stoppedHandler))
 	endpoint.GET("/heatmaps", s.heatmaps)
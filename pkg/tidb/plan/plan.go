@@ -0,0 +1,279 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package plan parses TiDB execution plans, either in the text format shown in statement summary and slow query, or
+// in the result of `EXPLAIN` and `EXPLAIN ANALYZE`, into a tree of operators.
+package plan
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/joomcode/errorx"
+)
+
+var (
+	ErrNS          = errorx.NewNamespace("error.plan")
+	ErrInvalidPlan = ErrNS.NewType("invalid_plan")
+)
+
+const (
+	// An operator is reported as a mismatch when one of estRows and actRows is at least MismatchRatio times the
+	// other, and they differ by at least MismatchMinRows rows, so that small tables do not produce noises.
+	MismatchRatio   = 10
+	MismatchMinRows = 100
+)
+
+// Column names, in lower case, of the plan text and EXPLAIN results.
+const (
+	colID            = "id"
+	colTask          = "task"
+	colEstRows       = "estrows"
+	colCount         = "count" // the name of estRows in old TiDB versions
+	colActRows       = "actrows"
+	colAccessObject  = "access object"
+	colOperatorInfo  = "operator info"
+	colExecutionInfo = "execution info"
+	colMemory        = "memory"
+	colDisk          = "disk"
+)
+
+// defaultTextColumns is the column order of plan texts without a header line.
+var defaultTextColumns = []string{colID, colTask, colEstRows, colOperatorInfo, colActRows, colExecutionInfo, colMemory, colDisk}
+
+type Operator struct {
+	// The ID with the tree prefix removed, e.g. `TableReader_6`.
+	ID string `json:"id"`
+	// The operator name without the ID suffix, e.g. `TableReader`.
+	Name string `json:"name"`
+	// The role of the operator in its parent, e.g. `Build` and `Probe`. Empty if not available.
+	Label string `json:"label"`
+	// e.g. `root`, `cop[tikv]`, `mpp[tiflash]`.
+	TaskType     string `json:"task_type"`
+	AccessObject string `json:"access_object"`
+	OperatorInfo string `json:"operator_info"`
+	// Null if not available, e.g. `N/A` or absent.
+	EstRows *float64 `json:"est_rows"`
+	ActRows *float64 `json:"act_rows"`
+	// The raw execution info, e.g. `time:1.2ms, loops:2, ...`.
+	ExecutionInfo string `json:"execution_info"`
+	// The `time` in the execution info, in milliseconds. Null if not available.
+	ExecutionTimeMs *float64 `json:"execution_time_ms"`
+	// The raw memory and disk usage, e.g. `1.23 KB` and `N/A`.
+	Memory      string `json:"memory"`
+	Disk        string `json:"disk"`
+	MemoryBytes *int64 `json:"memory_bytes"`
+	DiskBytes   *int64 `json:"disk_bytes"`
+	// Whether estRows and actRows are significantly different, see MismatchRatio.
+	EstimationMismatch bool        `json:"estimation_mismatch"`
+	Children           []*Operator `json:"children"`
+}
+
+type Tree struct {
+	// Usually there is only one root.
+	Roots []*Operator `json:"roots"`
+	// Whether runtime statistics, i.e. actRows, are available.
+	Analyzed bool `json:"analyzed"`
+	// IDs of operators whose estRows and actRows are significantly different.
+	Mismatches []string `json:"mismatches"`
+}
+
+// ParseText parses a plan in the text format, as decoded by `tidb_decode_plan`. Each line is an operator with
+// tab-separated fields. The first line may be a header naming the fields.
+func ParseText(text string) (*Tree, error) {
+	var columns []string
+	var rows [][]string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Split(strings.TrimRight(line, "\r"), "\t")
+		// Lines start with a tab
+		if len(fields) > 1 && strings.TrimSpace(fields[0]) == "" {
+			fields = fields[1:]
+		}
+		if columns == nil && len(rows) == 0 && strings.EqualFold(strings.TrimSpace(fields[0]), colID) {
+			columns = fields
+			continue
+		}
+		rows = append(rows, fields)
+	}
+	if columns == nil {
+		columns = defaultTextColumns
+	}
+	return ParseRows(columns, rows)
+}
+
+// ParseRows parses the result of `EXPLAIN` or `EXPLAIN ANALYZE`. Columns are recognized by names, so that results
+// of different TiDB versions are supported.
+func ParseRows(columns []string, rows [][]string) (*Tree, error) {
+	index := make(map[string]int, len(columns))
+	for i, col := range columns {
+		index[strings.ToLower(strings.TrimSpace(col))] = i
+	}
+	if _, ok := index[colID]; !ok {
+		return nil, ErrInvalidPlan.New("Column `id` is not found in the plan")
+	}
+	if _, ok := index[colEstRows]; !ok {
+		if i, ok := index[colCount]; ok {
+			index[colEstRows] = i
+		}
+	}
+	get := func(row []string, col string) string {
+		i, ok := index[col]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	tree := &Tree{Roots: make([]*Operator, 0, 1), Mismatches: make([]string, 0)}
+	// stack[d] is the last operator at depth d
+	var stack []*Operator
+	for _, row := range rows {
+		if len(row) <= index[colID] {
+			continue
+		}
+		// The tree prefix may start with spaces
+		depth, id := splitTreePrefix(strings.TrimRight(row[index[colID]], " "))
+		if id == "" {
+			continue
+		}
+		op := newOperator(id)
+		op.TaskType = get(row, colTask)
+		op.AccessObject = get(row, colAccessObject)
+		op.OperatorInfo = get(row, colOperatorInfo)
+		op.EstRows = parseRows(get(row, colEstRows))
+		op.ActRows = parseRows(get(row, colActRows))
+		op.ExecutionInfo = get(row, colExecutionInfo)
+		op.ExecutionTimeMs = parseExecutionTime(op.ExecutionInfo)
+		op.Memory = get(row, colMemory)
+		op.Disk = get(row, colDisk)
+		op.MemoryBytes = parseBytes(op.Memory)
+		op.DiskBytes = parseBytes(op.Disk)
+		if op.ActRows != nil {
+			tree.Analyzed = true
+		}
+		if isMismatch(op.EstRows, op.ActRows) {
+			op.EstimationMismatch = true
+			tree.Mismatches = append(tree.Mismatches, op.ID)
+		}
+
+		if depth > len(stack) {
+			return nil, ErrInvalidPlan.New("Operator %s has no parent", id)
+		}
+		stack = append(stack[:depth], op)
+		if depth == 0 {
+			tree.Roots = append(tree.Roots, op)
+		} else {
+			parent := stack[depth-1]
+			parent.Children = append(parent.Children, op)
+		}
+	}
+	if len(tree.Roots) == 0 {
+		return nil, ErrInvalidPlan.New("The plan is empty")
+	}
+	return tree, nil
+}
+
+// splitTreePrefix splits an ID like `│ └─TableFullScan_5` into the depth and the ID. Each level of the tree prefix
+// takes two characters.
+func splitTreePrefix(s string) (int, string) {
+	id := strings.TrimLeft(s, " │├└─")
+	prefixLen := utf8.RuneCountInString(s[:len(s)-len(id)])
+	return (prefixLen + 1) / 2, id
+}
+
+func newOperator(id string) *Operator {
+	op := &Operator{ID: id, Children: make([]*Operator, 0)}
+	name := id
+	// e.g. IndexRangeScan_8(Build)
+	if i := strings.IndexByte(name, '('); i > 0 && strings.HasSuffix(name, ")") {
+		op.Label = name[i+1 : len(name)-1]
+		name = name[:i]
+		op.ID = name
+	}
+	if i := strings.LastIndexByte(name, '_'); i > 0 {
+		if _, err := strconv.Atoi(name[i+1:]); err == nil {
+			name = name[:i]
+		}
+	}
+	op.Name = name
+	return op
+}
+
+func parseRows(s string) *float64 {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+func isMismatch(est, act *float64) bool {
+	if est == nil || act == nil {
+		return false
+	}
+	if math.Abs(*est-*act) < MismatchMinRows {
+		return false
+	}
+	lo, hi := math.Min(*est, *act), math.Max(*est, *act)
+	return hi >= math.Max(lo, 1)*MismatchRatio
+}
+
+// parseExecutionTime parses the leading `time:1.2ms` of the execution info.
+func parseExecutionTime(info string) *float64 {
+	const prefix = "time:"
+	if !strings.HasPrefix(info, prefix) {
+		return nil
+	}
+	s := info[len(prefix):]
+	if i := strings.IndexAny(s, ", "); i >= 0 {
+		s = s[:i]
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil
+	}
+	ms := float64(d) / float64(time.Millisecond)
+	return &ms
+}
+
+var byteUnits = map[string]float64{
+	"bytes": 1,
+	"kb":    1 << 10,
+	"mb":    1 << 20,
+	"gb":    1 << 30,
+	"tb":    1 << 40,
+}
+
+// parseBytes parses sizes like `1.23 KB` and `345 Bytes`, in 1024-based units as formatted by TiDB.
+func parseBytes(s string) *int64 {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return nil
+	}
+	unit, ok := byteUnits[strings.ToLower(fields[1])]
+	if !ok {
+		return nil
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil
+	}
+	n := int64(math.Round(v * unit))
+	return &n
+}
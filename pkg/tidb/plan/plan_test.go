@@ -0,0 +1,100 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package plan
+
+import (
+	"testing"
+
+	"github.com/joomcode/errorx"
+	. "github.com/pingcap/check"
+)
+
+func TestT(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&testPlanSuite{})
+
+type testPlanSuite struct{}
+
+const decodedPlan = "\tid                     \ttask     \testRows\toperator info                              \tactRows\texecution info                                  \tmemory   \tdisk\n" +
+	"\tHashJoin_8             \troot     \t12.49  \tinner join, equal:[eq(test.t.a, test.s.a)]\t2000   \ttime:3.5ms, loops:3, build_hash_table:{total:1ms}\t25.5 KB  \t0 Bytes\n" +
+	"\t├─TableReader_15(Build)\troot     \t9.99   \tdata:Selection_14                          \t10     \ttime:1ms, loops:2                               \t1.2 KB   \tN/A\n" +
+	"\t│ └─Selection_14       \tcop[tikv]\t9.99   \tnot(isnull(test.s.a))                      \t10     \ttikv_task:{time:0s, loops:1}                    \tN/A      \tN/A\n" +
+	"\t│   └─TableFullScan_13 \tcop[tikv]\t10000  \ttable:s, keep order:false, stats:pseudo   \t10     \ttikv_task:{time:0s, loops:1}                    \tN/A      \tN/A\n" +
+	"\t└─TableReader_12(Probe)\troot     \t9.99   \tdata:Selection_11                          \t2000   \ttime:2ms, loops:2                               \t10 KB    \tN/A\n" +
+	"\t  └─Selection_11       \tcop[tikv]\t9.99   \tnot(isnull(test.t.a))                      \t2000   \ttikv_task:{time:1ms, loops:2}                   \tN/A      \tN/A\n"
+
+func (t *testPlanSuite) TestParseText(c *C) {
+	tree, err := ParseText(decodedPlan)
+	c.Assert(err, IsNil)
+	c.Assert(tree.Analyzed, IsTrue)
+	c.Assert(tree.Roots, HasLen, 1)
+
+	join := tree.Roots[0]
+	c.Assert(join.ID, Equals, "HashJoin_8")
+	c.Assert(join.Name, Equals, "HashJoin")
+	c.Assert(*join.EstRows, Equals, 12.49)
+	c.Assert(*join.ActRows, Equals, float64(2000))
+	c.Assert(*join.ExecutionTimeMs, Equals, 3.5)
+	c.Assert(*join.MemoryBytes, Equals, int64(25.5*1024))
+	c.Assert(*join.DiskBytes, Equals, int64(0))
+	c.Assert(join.Children, HasLen, 2)
+
+	build := join.Children[0]
+	c.Assert(build.ID, Equals, "TableReader_15")
+	c.Assert(build.Label, Equals, "Build")
+	c.Assert(build.DiskBytes, IsNil)
+	c.Assert(build.Children, HasLen, 1)
+	scan := build.Children[0].Children[0]
+	c.Assert(scan.ID, Equals, "TableFullScan_13")
+	c.Assert(scan.TaskType, Equals, "cop[tikv]")
+	c.Assert(scan.OperatorInfo, Equals, "table:s, keep order:false, stats:pseudo")
+	c.Assert(scan.ExecutionTimeMs, IsNil)
+	c.Assert(scan.EstimationMismatch, IsTrue)
+
+	probe := join.Children[1]
+	c.Assert(probe.Label, Equals, "Probe")
+	c.Assert(probe.Children[0].ID, Equals, "Selection_11")
+	c.Assert(tree.Mismatches, DeepEquals, []string{"HashJoin_8", "TableFullScan_13", "TableReader_12", "Selection_11"})
+}
+
+func (t *testPlanSuite) TestParseRows(c *C) {
+	// EXPLAIN of TiDB 4.0
+	columns := []string{"id", "estRows", "task", "access object", "operator info"}
+	rows := [][]string{
+		{"Projection_4", "10000.00", "root", "", "test.t.a"},
+		{"└─IndexReader_7", "10000.00", "root", "", "index:IndexFullScan_6"},
+		{"  └─IndexFullScan_6", "10000.00", "cop[tikv]", "table:t, index:a(a)", "keep order:false"},
+	}
+	tree, err := ParseRows(columns, rows)
+	c.Assert(err, IsNil)
+	c.Assert(tree.Analyzed, IsFalse)
+	c.Assert(tree.Mismatches, HasLen, 0)
+	scan := tree.Roots[0].Children[0].Children[0]
+	c.Assert(scan.AccessObject, Equals, "table:t, index:a(a)")
+	c.Assert(scan.ActRows, IsNil)
+
+	// Old versions name estRows as count
+	tree, err = ParseRows([]string{"id", "count", "task", "operator info"}, [][]string{{"TableDual_1", "1.00", "root", "rows:1"}})
+	c.Assert(err, IsNil)
+	c.Assert(*tree.Roots[0].EstRows, Equals, float64(1))
+
+	_, err = ParseRows(columns, [][]string{{"  └─IndexFullScan_6", "1", "cop[tikv]", "", ""}})
+	c.Assert(errorx.IsOfType(err, ErrInvalidPlan), IsTrue)
+	_, err = ParseRows([]string{"a"}, rows)
+	c.Assert(errorx.IsOfType(err, ErrInvalidPlan), IsTrue)
+	_, err = ParseText("")
+	c.Assert(errorx.IsOfType(err, ErrInvalidPlan), IsTrue)
+}
@@ -0,0 +1,105 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keyring manages keys encrypting secrets in the local storage. Keys are placed in separate files of the
+// data directory, to avoid being collected together with the local storage by diagnostics collecting tools.
+package keyring
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/gtank/cryptopasta"
+)
+
+// ReadKeyFile reads the key from the file, or returns nil if the file does not exist.
+func ReadKeyFile(keyPath string) (*[32]byte, error) {
+	b, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(b) != 32 {
+		return nil, fmt.Errorf("encryption key %s is broken", keyPath)
+	}
+	var key [32]byte
+	copy(key[:], b)
+	return &key, nil
+}
+
+// CreateKeyFile generates a new key and persists it to the file.
+func CreateKeyFile(keyPath string) (*[32]byte, error) {
+	key := cryptopasta.NewEncryptionKey()
+	if err := ioutil.WriteFile(keyPath, key[:], 0400); err != nil { // read only for owner
+		return nil, fmt.Errorf("persist key failed: %v", err)
+	}
+	return key, nil
+}
+
+// KeyFile is a key persisted in a single file, which is created when it is used for the first time.
+type KeyFile struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewKeyFile(keyPath string) *KeyFile {
+	return &KeyFile{path: keyPath}
+}
+
+// Get returns the key, or nil if it has not been created. This function is thread-safe.
+func (k *KeyFile) Get() (*[32]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return ReadKeyFile(k.path)
+}
+
+// GetOrCreate returns the key, which is created if not exist. This function is thread-safe.
+func (k *KeyFile) GetOrCreate() (*[32]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	key, err := ReadKeyFile(k.path)
+	if err != nil || key != nil {
+		return key, err
+	}
+	return CreateKeyFile(k.path)
+}
+
+// EncryptToHex encrypts the secret and encodes it in hex, so that it can be stored in text columns.
+func EncryptToHex(plain []byte, key *[32]byte) (string, error) {
+	encrypted, err := cryptopasta.Encrypt(plain, key)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(encrypted), nil
+}
+
+// DecryptHex decrypts the secret produced by EncryptToHex.
+func DecryptHex(encryptedInHex string, key *[32]byte) ([]byte, error) {
+	if key == nil {
+		return nil, fmt.Errorf("encryption key is missing")
+	}
+	encrypted, err := hex.DecodeString(encryptedInHex)
+	if err != nil {
+		return nil, fmt.Errorf("bad record: %v", err)
+	}
+	decrypted, err := cryptopasta.Decrypt(encrypted, key)
+	if err != nil {
+		return nil, fmt.Errorf("bad record: %v", err)
+	}
+	return decrypted, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keyring
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+
+	. "github.com/pingcap/check"
+)
+
+func TestT(t *testing.T) {
+	CustomVerboseFlag = true
+	TestingT(t)
+}
+
+var _ = Suite(&testKeyringSuite{})
+
+type testKeyringSuite struct{}
+
+func (t *testKeyringSuite) Test_KeyFile(c *C) {
+	keyPath := path.Join(c.MkDir(), "test_ek.bin")
+	k := NewKeyFile(keyPath)
+	key, err := k.Get()
+	c.Assert(err, IsNil)
+	c.Assert(key, IsNil)
+	_, err = DecryptHex("00", key)
+	c.Assert(err, ErrorMatches, "encryption key is missing")
+
+	key, err = k.GetOrCreate()
+	c.Assert(err, IsNil)
+	c.Assert(key, NotNil)
+	encrypted, err := EncryptToHex([]byte("secret"), key)
+	c.Assert(err, IsNil)
+
+	// The key is persisted, so that secrets can still be decrypted after restart
+	key, err = NewKeyFile(keyPath).GetOrCreate()
+	c.Assert(err, IsNil)
+	plain, err := DecryptHex(encrypted, key)
+	c.Assert(err, IsNil)
+	c.Assert(string(plain), Equals, "secret")
+	_, err = DecryptHex("not hex", key)
+	c.Assert(err, ErrorMatches, "bad record.*")
+
+	brokenPath := path.Join(c.MkDir(), "broken_ek.bin")
+	c.Assert(ioutil.WriteFile(brokenPath, []byte("short"), 0600), IsNil)
+	_, err = NewKeyFile(brokenPath).GetOrCreate()
+	c.Assert(err, ErrorMatches, "encryption key .* is broken")
+}